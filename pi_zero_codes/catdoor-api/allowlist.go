@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// parsePrefixes parses a comma-separated list of CIDRs such as
+// "192.168.1.0/24,10.0.0.5". A bare address stands for itself alone. An
+// empty list returns nil.
+func parsePrefixes(value string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if !strings.Contains(item, "/") {
+			addr, err := netip.ParseAddr(item)
+			if err != nil {
+				return nil, fmt.Errorf("invalid CIDR %q", item)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q", item)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// containsAddr reports whether any of prefixes holds addr
+func containsAddr(prefixes []netip.Prefix, addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, p := range prefixes {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientAddr returns the address r came from. X-Forwarded-For is only
+// believed when the peer is one of the trusted proxies, and then read from
+// the right, skipping further trusted proxies, so a client can't prepend
+// an address of its choosing. ok is false when no address can be made out,
+// e.g. on a unix socket.
+func (s *server) clientAddr(r *http.Request) (addr netip.Addr, ok bool) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err = netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	if !containsAddr(s.trustedProxies, addr) {
+		return addr.Unmap(), true
+	}
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		addr = hop
+		if !containsAddr(s.trustedProxies, hop) {
+			break
+		}
+	}
+	return addr.Unmap(), true
+}
+
+// allowDetectors answers 403 unless the request comes from one of the
+// CATDOOR_DETECT_ALLOW_CIDRS networks. This is on top of the token check,
+// not instead of it. With no networks configured next is returned
+// unchanged.
+func (s *server) allowDetectors(next http.HandlerFunc) http.HandlerFunc {
+	if len(s.detectAllow) == 0 {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		addr, ok := s.clientAddr(r)
+		if !ok || !containsAddr(s.detectAllow, addr) {
+			s.log.Warn("detection refused: source not in CATDOOR_DETECT_ALLOW_CIDRS", "remote", r.RemoteAddr, "client", addr)
+			writeError(w, r, http.StatusForbidden, errCodeForbidden, "detections are not accepted from this address")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// prefixStrings formats prefixes for /config, as an empty list rather than
+// null when there are none
+func prefixStrings(prefixes []netip.Prefix) []string {
+	out := make([]string, 0, len(prefixes))
+	for _, p := range prefixes {
+		out = append(out, p.String())
+	}
+	return out
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestParsePrefixes(t *testing.T) {
+	got, err := parsePrefixes(" 192.168.1.7/24, 10.0.0.5 ,,fd00::/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"192.168.1.0/24", "10.0.0.5/32", "fd00::/8"}
+	if len(got) != len(want) {
+		t.Fatalf("prefixes = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i].String() != want[i] {
+			t.Errorf("prefix %d = %s, want %s", i, got[i], want[i])
+		}
+	}
+	for _, bad := range []string{"192.168.1.0/33", "camera", "10.0.0.1/"} {
+		if _, err := parsePrefixes(bad); err == nil {
+			t.Errorf("parsePrefixes(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestAllowDetectors(t *testing.T) {
+	s := newTestServer(t, startFakeController(t))
+	s.detectAllow = []netip.Prefix{netip.MustParsePrefix("192.168.1.0/24")}
+	s.trustedProxies = []netip.Prefix{netip.MustParsePrefix("10.0.0.1/32")}
+	h := s.allowDetectors(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) })
+
+	tests := []struct {
+		name, remote, forwarded string
+		want                    int
+	}{
+		{"listed camera", "192.168.1.50:4000", "", http.StatusNoContent},
+		{"ipv4-mapped camera", "[::ffff:192.168.1.50]:4000", "", http.StatusNoContent},
+		{"other host", "192.168.2.50:4000", "", http.StatusForbidden},
+		{"forwarded by an untrusted peer", "192.168.2.50:4000", "192.168.1.50", http.StatusForbidden},
+		{"listed peer forwarding for another", "192.168.1.50:4000", "203.0.113.9", http.StatusNoContent},
+		{"forwarded by the proxy", "10.0.0.1:4000", "192.168.1.50", http.StatusNoContent},
+		{"spoofed hop before the proxy", "10.0.0.1:4000", "192.168.1.50, 203.0.113.9", http.StatusForbidden},
+		{"proxy without the header", "10.0.0.1:4000", "", http.StatusForbidden},
+		{"unix socket", "@", "", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/detected", nil)
+			r.RemoteAddr = tt.remote
+			if tt.forwarded != "" {
+				r.Header.Set("X-Forwarded-For", tt.forwarded)
+			}
+			rec := httptest.NewRecorder()
+			h(rec, r)
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.want)
+			}
+			if tt.want == http.StatusForbidden {
+				if got := decodeError(t, rec); got.Code != errCodeForbidden {
+					t.Errorf("error = %+v", got)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// auditTailBytes is how much of an existing audit file is read back to find
+// its last record. Records are far shorter.
+const auditTailBytes = 64 << 10
+
+// AuditRecord is one mutating API call as written to the audit log. Prev is
+// the SHA-256 of the previous line, so an edited or removed line breaks the
+// chain at the record after it.
+type AuditRecord struct {
+	Timestamp  time.Time `json:"timestamp"`
+	Method     string    `json:"method"` // "WS" for a command sent over /ws`
+	Path       string    `json:"path"`
+	Query      string    `json:"query,omitempty"`
+	Remote     string    `json:"remote"`
+	Principal  string    `json:"principal,omitempty"` // "api-token" once the bearer token checked out; empty without auth
+	Status     int       `json:"status"`
+	DurationMs int64     `json:"duration_ms"`
+	Prev       string    `json:"prev"` // empty for the file's first record
+}
+
+// auditLog appends AuditRecords to the file set by CATDOOR_AUDIT_LOG. It is
+// never rotated or trimmed, and the file is created readable by the
+// service's user only. It is safe for concurrent use.
+type auditLog struct {
+	path string
+
+	mu   sync.Mutex
+	prev string // hash of the last line written
+}
+
+// openAuditLog picks up the hash chain of an existing audit file at path,
+// which need not exist yet
+func openAuditLog(path string) (*auditLog, error) {
+	a := &auditLog{path: path}
+	last, err := lastLine(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	if len(last) > 0 {
+		a.prev = lineHash(last)
+	}
+	return a, nil
+}
+
+// lastLine returns the last non-empty line of the file at path
+func lastLine(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	start := max(0, info.Size()-auditTailBytes)
+	tail := make([]byte, info.Size()-start)
+	if _, err := f.ReadAt(tail, start); err != nil && err != io.EOF {
+		return nil, err
+	}
+	tail = bytes.TrimRight(tail, "\n")
+	if i := bytes.LastIndexByte(tail, '\n'); i >= 0 {
+		tail = tail[i+1:]
+	}
+	return tail, nil
+}
+
+// lineHash returns the hex SHA-256 of an audit line, without its newline
+func lineHash(line []byte) string {
+	sum := sha256.Sum256(line)
+	return hex.EncodeToString(sum[:])
+}
+
+// append chains rec onto the previous record and writes it as one line
+func (a *auditLog) append(rec AuditRecord) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	rec.Prev = a.prev
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(a.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	a.prev = lineHash(line)
+	return nil
+}
+
+// audited writes every request except GET, HEAD and OPTIONS to the audit
+// log once it has been answered, including those refused for a missing
+// token. /ws is a GET, so wsRun records each of its commands instead. With
+// no audit log next is returned unchanged.
+func (s *server) audited(next http.Handler) http.Handler {
+	if s.audit == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+			return
+		}
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		status := rec.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		s.writeAudit(r, r.Method, r.URL.RawQuery, status, start)
+	})
+}
+
+// writeAudit appends the record of r, answered with status after start.
+// The remote is the client as the allowlist sees it, behind trusted
+// proxies too.
+func (s *server) writeAudit(r *http.Request, method, query string, status int, start time.Time) {
+	remote := r.RemoteAddr
+	if addr, ok := s.clientAddr(r); ok {
+		remote = addr.String()
+	}
+	var principal string
+	if s.apiToken != "" && s.authorized(r) {
+		principal = "api-token"
+	}
+	err := s.audit.append(AuditRecord{
+		Timestamp:  s.now(),
+		Method:     method,
+		Path:       r.URL.Path,
+		Query:      query,
+		Remote:     remote,
+		Principal:  principal,
+		Status:     status,
+		DurationMs: time.Since(start).Milliseconds(),
+	})
+	if err != nil {
+		s.log.Error("failed to write the audit log", "method", method, "path", r.URL.Path, "error", err)
+	}
+}
+
+// auditPath returns the audit file for /config, or "" without one
+func (s *server) auditPath() string {
+	if s.audit == nil {
+		return ""
+	}
+	return s.audit.path
+}
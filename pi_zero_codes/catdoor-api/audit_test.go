@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func readAuditRecords(t *testing.T, path string) ([]AuditRecord, []string) {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var records []AuditRecord
+	var lines []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var rec AuditRecord
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			t.Fatalf("line %q: %v", sc.Text(), err)
+		}
+		records = append(records, rec)
+		lines = append(lines, sc.Text())
+	}
+	return records, lines
+}
+
+func TestAuditLogRecordsMutations(t *testing.T) {
+	s := newTestServer(t, startFakeController(t))
+	s.apiToken = "secret"
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	audit, err := openAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.audit = audit
+	h := s.audited(http.HandlerFunc(s.requireAuth(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	})))
+
+	for _, req := range []struct{ method, target, token string }{
+		{http.MethodPost, "/lock?duration=5m", "secret"},
+		{http.MethodGet, "/status", "secret"},
+		{http.MethodPost, "/reset", "wrong"},
+	} {
+		r := httptest.NewRequest(req.method, req.target, nil)
+		r.RemoteAddr = "192.0.2.7:5000"
+		r.Header.Set("Authorization", "Bearer "+req.token)
+		h.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	records, lines := readAuditRecords(t, path)
+	if len(records) != 2 {
+		t.Fatalf("records = %+v, want the two POSTs", records)
+	}
+	first, second := records[0], records[1]
+	if first.Method != http.MethodPost || first.Path != "/lock" || first.Query != "duration=5m" ||
+		first.Remote != "192.0.2.7" || first.Principal != "api-token" || first.Status != http.StatusAccepted || first.Prev != "" {
+		t.Errorf("first record = %+v", first)
+	}
+	if second.Path != "/reset" || second.Principal != "" || second.Status != http.StatusUnauthorized {
+		t.Errorf("second record = %+v", second)
+	}
+	if second.Prev != lineHash([]byte(lines[0])) {
+		t.Errorf("second prev = %q, want the first line's hash", second.Prev)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("audit file mode = %o, want 600", perm)
+	}
+
+	// A restart carries the chain on from the last line.
+	if s.audit, err = openAuditLog(path); err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodPost, "/unlock", nil)
+	h = s.audited(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	records, lines = readAuditRecords(t, path)
+	if len(records) != 3 || records[2].Prev != lineHash([]byte(lines[1])) || records[2].Status != http.StatusOK {
+		t.Errorf("after reopening: records = %+v", records)
+	}
+}
+
+func TestAuditLogRecordsWSCommands(t *testing.T) {
+	s := newTestServer(t, startFakeController(t))
+	s.trustedProxies = []netip.Prefix{netip.MustParsePrefix("127.0.0.1/32")}
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	audit, err := openAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.audit = audit
+	conn := dialWS(t, startWSServer(t, s), http.Header{"X-Forwarded-For": {"198.51.100.9"}})
+	readWS(t, conn, nil) // the initial status
+
+	for _, cmd := range []string{"green", "bogus"} {
+		if err := conn.WriteJSON(map[string]string{"cmd": cmd}); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		for readWS(t, conn, nil) != "result" {
+		}
+	}
+
+	records, _ := readAuditRecords(t, path)
+	if len(records) != 2 {
+		t.Fatalf("records = %+v, want one per command", records)
+	}
+	if r := records[0]; r.Method != "WS" || r.Path != "/ws" || r.Query != "cmd=green" || r.Remote != "198.51.100.9" || r.Status != http.StatusOK {
+		t.Errorf("first record = %+v", r)
+	}
+	if r := records[1]; r.Query != "cmd=bogus" || r.Status != http.StatusBadRequest {
+		t.Errorf("second record = %+v", r)
+	}
+}
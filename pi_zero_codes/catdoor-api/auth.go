@@ -0,0 +1,82 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// envToken returns the API token, read once at startup from the file named
+// by CATDOOR_API_TOKEN_FILE or else taken from CATDOOR_API_TOKEN. The file
+// keeps the token out of process listings and unit files, so it wins when
+// both are set. Surrounding whitespace, such as a trailing newline, is
+// trimmed.
+func envToken() (string, error) {
+	path, err := envOrDefault("CATDOOR_API_TOKEN_FILE", "")
+	if err != nil {
+		return "", err
+	}
+	if path == "" {
+		return envOrDefault("CATDOOR_API_TOKEN", "")
+	}
+	if path, err = expandHome(path); err != nil {
+		return "", fmt.Errorf("invalid CATDOOR_API_TOKEN_FILE: %w", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("CATDOOR_API_TOKEN_FILE: %w", err)
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", fmt.Errorf("CATDOOR_API_TOKEN_FILE %s is empty", path)
+	}
+	return token, nil
+}
+
+// requireAuth wraps a mutating handler so that, when an API token is
+// configured, requests must carry "Authorization: Bearer <token>". Without a token
+// configured the handler is served as-is.
+func (s *server) requireAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.apiToken != "" && !s.authorized(r) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="catdoor"`)
+			writeError(w, r, http.StatusUnauthorized, errCodeUnauthorized, "unauthorized")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// readAuth wraps a read-only handler, requiring the token only when
+// CATDOOR_AUTH_READS is enabled.
+func (s *server) readAuth(next http.HandlerFunc) http.HandlerFunc {
+	if !s.authReads {
+		return next
+	}
+	return s.requireAuth(next)
+}
+
+// authorized reports whether r carries the configured bearer token. The
+// comparison is constant-time so the token can't be guessed byte by byte.
+func (s *server) authorized(r *http.Request) bool {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(strings.TrimSpace(token)), []byte(s.apiToken)) == 1
+}
+
+// methodAuth wraps a handler serving both reads and writes: GET and HEAD are
+// treated as reads, every other method as a mutation.
+func (s *server) methodAuth(next http.HandlerFunc) http.HandlerFunc {
+	read, write := s.readAuth(next), s.requireAuth(next)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			read(w, r)
+			return
+		}
+		write(w, r)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRequireAuth(t *testing.T) {
+	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) }
+
+	tests := []struct {
+		name   string
+		token  string
+		header string
+		want   int
+	}{
+		{"auth disabled", "", "", http.StatusNoContent},
+		{"missing header", "s3cret", "", http.StatusUnauthorized},
+		{"wrong token", "s3cret", "Bearer nope", http.StatusUnauthorized},
+		{"wrong scheme", "s3cret", "Basic s3cret", http.StatusUnauthorized},
+		{"prefix of token", "s3cret", "Bearer s3cre", http.StatusUnauthorized},
+		{"valid token", "s3cret", "Bearer s3cret", http.StatusNoContent},
+		{"lowercase scheme", "s3cret", "bearer s3cret", http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &server{apiToken: tt.token}
+			req := httptest.NewRequest(http.MethodPost, "/mode/red", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			rec := httptest.NewRecorder()
+			s.requireAuth(ok)(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadAuth(t *testing.T) {
+	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) }
+
+	for _, authReads := range []bool{false, true} {
+		s := &server{apiToken: "s3cret", authReads: authReads}
+		rec := httptest.NewRecorder()
+		s.readAuth(ok)(rec, httptest.NewRequest(http.MethodGet, "/status", nil))
+
+		want := http.StatusNoContent
+		if authReads {
+			want = http.StatusUnauthorized
+		}
+		if rec.Code != want {
+			t.Errorf("authReads=%v: status = %d, want %d", authReads, rec.Code, want)
+		}
+	}
+}
+
+func TestEnvToken(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(path, []byte("  from-file\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CATDOOR_API_TOKEN", "inline")
+
+	if token, err := envToken(); err != nil || token != "inline" {
+		t.Errorf("without a file: token = %q (%v), want inline", token, err)
+	}
+	t.Setenv("CATDOOR_API_TOKEN_FILE", path)
+	if token, err := envToken(); err != nil || token != "from-file" {
+		t.Errorf("with a file: token = %q (%v), want from-file", token, err)
+	}
+
+	if err := os.WriteFile(path, []byte("\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := envToken(); err == nil {
+		t.Error("empty token file: expected an error")
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Defaults for the controller circuit breaker (CATDOOR_BREAKER_THRESHOLD,
+// CATDOOR_BREAKER_COOLDOWN)
+const (
+	defaultBreakerThreshold = 5
+	defaultBreakerCooldown  = 30 * time.Second
+)
+
+// Circuit breaker states as reported by /status and /healthz
+const (
+	breakerClosed   = "closed"
+	breakerOpen     = "open"
+	breakerHalfOpen = "half-open"
+)
+
+// errCircuitOpen is returned without contacting the controller while the
+// breaker is open. It is answered with 503, like a busy controller.
+var errCircuitOpen = errors.New("controller unreachable: circuit breaker open")
+
+// BreakerStatus is the circuit breaker as reported by /status and /healthz
+type BreakerStatus struct {
+	State      string `json:"state"`                 // closed, open or half-open
+	Failures   int    `json:"failures"`              // consecutive connection failures
+	RetryAfter string `json:"retry_after,omitempty"` // while open: when a probe will be let through
+}
+
+// circuitBreaker stops commands going to a controller that keeps failing to
+// answer. After threshold consecutive connection failures it opens and
+// commands fail at once with errCircuitOpen; once cooldown has passed it is
+// half-open and lets a single command through as a probe. The probe closes
+// it again on success or reopens it for another cooldown on failure. Any
+// reply, even ERR, proves the controller is up, so it counts as a success.
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+
+	mu       sync.Mutex
+	state    string
+	failures int
+	openedAt time.Time
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{threshold: threshold, cooldown: cooldown, now: time.Now, state: breakerClosed}
+}
+
+// allow reports whether a command may go to the controller now
+func (b *circuitBreaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case breakerOpen:
+		if b.now().Sub(b.openedAt) < b.cooldown {
+			return fmt.Errorf("%w until %s", errCircuitOpen, b.openedAt.Add(b.cooldown).Format(time.RFC3339))
+		}
+		b.state = breakerHalfOpen
+		return nil
+	case breakerHalfOpen:
+		return errCircuitOpen
+	}
+	return nil
+}
+
+// record notes the outcome of a command allow let through. It returns
+// true when that changed the state, for logging. A probe turned away by a
+// full queue proves nothing, so the next command probes instead.
+func (b *circuitBreaker) record(err error) (changed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	before := b.state
+	if errors.Is(err, errControllerBusy) {
+		if b.state == breakerHalfOpen {
+			b.state = breakerOpen
+		}
+		return false
+	}
+	if err == nil || !breakerFailure(err) {
+		b.state, b.failures = breakerClosed, 0
+		return b.state != before
+	}
+	b.failures++
+	if b.state == breakerHalfOpen || b.failures >= b.threshold {
+		b.state, b.openedAt = breakerOpen, b.now()
+	}
+	return b.state != before
+}
+
+// breakerFailure reports whether err means the controller couldn't be
+// reached or hung up, as opposed to answering with something unwelcome
+func breakerFailure(err error) bool {
+	return isRetryable(err) || errors.Is(err, io.EOF)
+}
+
+// status returns the breaker's state for /status and /healthz
+func (b *circuitBreaker) status() *BreakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	st := &BreakerStatus{State: b.state, Failures: b.failures}
+	if b.state == breakerOpen {
+		st.RetryAfter = b.openedAt.Add(b.cooldown).Format(time.RFC3339)
+	}
+	return st
+}
+
+// breakerReporter is implemented by clients with a circuit breaker
+type breakerReporter interface {
+	breakerStatus() *BreakerStatus
+}
+
+// controllerBreaker returns c's breaker state, or nil when it has none
+func controllerBreaker(c ControllerClient) *BreakerStatus {
+	if br, ok := c.(breakerReporter); ok {
+		return br.breakerStatus()
+	}
+	return nil
+}
+
+// breakerStatus returns the controller's circuit breaker with its times in
+// the configured zone, or nil when it has none
+func (s *server) breakerStatus() *BreakerStatus {
+	st := controllerBreaker(s.controller)
+	if st != nil {
+		st.RetryAfter = s.inZone(st.RetryAfter)
+	}
+	return st
+}
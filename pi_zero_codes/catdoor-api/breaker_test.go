@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakerTransitions(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	b := newCircuitBreaker(3, time.Minute)
+	b.now = func() time.Time { return now }
+	down := fmt.Errorf("cannot connect to controller: %w", &net.OpError{Op: "dial", Err: errors.New("connection refused")})
+
+	// ERR replies and a full queue don't count against the controller.
+	b.record(&controllerError{msg: "ERR jammed"})
+	b.record(errControllerBusy)
+	for i := 0; i < 2; i++ {
+		if err := b.allow(); err != nil {
+			t.Fatalf("allow while closed: %v", err)
+		}
+		b.record(down)
+	}
+	if st := b.status(); st.State != breakerClosed || st.Failures != 2 {
+		t.Fatalf("after 2 failures: %+v", st)
+	}
+	if !b.record(down) {
+		t.Error("third failure didn't report a state change")
+	}
+	if st := b.status(); st.State != breakerOpen || st.RetryAfter != "2026-03-01T12:01:00Z" {
+		t.Fatalf("after 3 failures: %+v", st)
+	}
+	if err := b.allow(); !errors.Is(err, errCircuitOpen) {
+		t.Fatalf("allow while open = %v", err)
+	}
+
+	// After the cooldown one probe goes through; a failed probe reopens.
+	now = now.Add(time.Minute)
+	if err := b.allow(); err != nil {
+		t.Fatalf("probe refused: %v", err)
+	}
+	if err := b.allow(); !errors.Is(err, errCircuitOpen) {
+		t.Errorf("second command during the probe = %v", err)
+	}
+	b.record(down)
+	if st := b.status(); st.State != breakerOpen || st.RetryAfter != "2026-03-01T12:02:00Z" {
+		t.Fatalf("after a failed probe: %+v", st)
+	}
+
+	// A probe turned away by a full queue leaves the next command to probe.
+	now = now.Add(time.Minute)
+	b.allow()
+	b.record(errControllerBusy)
+	if err := b.allow(); err != nil {
+		t.Fatalf("probe after a busy one refused: %v", err)
+	}
+	if !b.record(nil) {
+		t.Error("successful probe didn't report a state change")
+	}
+	if st := b.status(); st.State != breakerClosed || st.Failures != 0 {
+		t.Errorf("after a good probe: %+v", st)
+	}
+}
+
+func TestControllerBreakerFailsFast(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	deadAddr := ln.Addr().String()
+	ln.Close()
+
+	s := newTestServer(t, startFakeController(t))
+	c := newTCPController(deadAddr, 0, discardLogger())
+	c.breaker = newCircuitBreaker(2, time.Minute)
+	now := time.Now()
+	c.breaker.now = func() time.Time { return now }
+	s.controller = &instrumentedController{next: c, metrics: s.metrics}
+
+	for i := 0; i < 2; i++ {
+		if _, err := s.controller.Send("STATUS"); err == nil || errors.Is(err, errCircuitOpen) {
+			t.Fatalf("send %d: %v, want a connection error", i, err)
+		}
+	}
+	if _, err := s.controller.Send("STATUS"); !errors.Is(err, errCircuitOpen) {
+		t.Fatalf("send with the breaker open = %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	s.statusHandler(rec, httptest.NewRequest(http.MethodGet, "/status", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("/status = %d, want 503", rec.Code)
+	}
+	if got := decodeError(t, rec); got.Code != errCodeControllerDown {
+		t.Errorf("/status error = %+v, want code %s", got, errCodeControllerDown)
+	}
+	if got := controllerErrorCode(errControllerBusy); got != errCodeControllerBusy {
+		t.Errorf("busy code = %q, want %q", got, errCodeControllerBusy)
+	}
+	rec = httptest.NewRecorder()
+	s.healthzHandler(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	var health struct {
+		Status  string         `json:"status"`
+		Breaker *BreakerStatus `json:"breaker"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&health); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusServiceUnavailable || health.Breaker == nil || health.Breaker.State != breakerOpen || health.Breaker.Failures != 2 {
+		t.Errorf("/healthz = %d %+v", rec.Code, health.Breaker)
+	}
+
+	// Once the controller is back the probe after the cooldown closes it.
+	c.addr = startFakeController(t).addr
+	now = now.Add(time.Minute)
+	if _, err := s.controller.Send("STATUS"); err != nil {
+		t.Fatalf("probe: %v", err)
+	}
+	if got := s.statusFrom("MODE GREEN\n").Breaker; got == nil || got.State != breakerClosed {
+		t.Errorf("status breaker = %+v", got)
+	}
+}
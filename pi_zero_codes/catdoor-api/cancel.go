@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// detectionCancelHandler handles POST /detections/{id}/cancel. When that
+// detection's lock is still the active one it unlocks at once, cancels the
+// pending auto-unlock and marks the detection cancelled in the history, for
+// a false positive spotted on review. A later detection or a manual lock
+// takes over the lock, and an unlock ends it; cancelling then answers 409.
+func (s *server) detectionCancelHandler(w http.ResponseWriter, r *http.Request) {
+	id, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/detections/"), "/")
+	if id == "" || action != "cancel" {
+		writeError(w, r, http.StatusNotFound, errCodeNotFound, "not found (use POST /detections/{id}/cancel)")
+		return
+	}
+
+	s.detectMu.Lock()
+	defer s.detectMu.Unlock()
+
+	ev, ok, err := s.history.find(id)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, errCodeInternal, "failed to read detection history: "+err.Error())
+		return
+	}
+	if !ok {
+		writeError(w, r, http.StatusNotFound, errCodeNotFound, fmt.Sprintf("no detection %q", id))
+		return
+	}
+	if ev.CancelledAt != nil {
+		writeError(w, r, http.StatusConflict, errCodeConflict, fmt.Sprintf("detection %s was already cancelled", id))
+		return
+	}
+	config, err := s.config.load()
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, errCodeInternal, "failed to load config: "+err.Error())
+		return
+	}
+	if config.LockedBy != id || remainingUntil(config.LockedUntil, s.now()) <= 0 {
+		writeError(w, r, http.StatusConflict, errCodeConflict, fmt.Sprintf("detection %s is no longer the active lock", id))
+		return
+	}
+
+	var resp string
+	cancelled, err := s.unlock.stopWith(func() error {
+		var err error
+		resp, err = s.setMode("GREEN", "cancel")
+		return err
+	})
+	if err != nil {
+		writeControllerError(w, r, "failed to unlock catflap: ", err)
+		return
+	}
+	s.unlockFailure.clear()
+
+	if _, err := s.config.updateState(func(config *Config) {
+		config.LockedUntil = ""
+		config.LockedBy = ""
+	}); err != nil {
+		s.log.Warn("failed to save config", "error", err)
+	}
+	now := s.now().Truncate(time.Second)
+	if _, err := s.history.update(id, func(ev *DetectionEvent) { ev.CancelledAt = &now }); err != nil {
+		s.log.Warn("failed to mark the detection cancelled", "id", id, "error", err)
+	}
+	s.log.Info("detection cancelled, catflap unlocked", "id", id, "detected_at", ev.Timestamp.Format(time.RFC3339), "cancelled_timers", cancelled)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":           "cancelled",
+		"id":               id,
+		"cancelled_timers": cancelled,
+		"controller":       strings.TrimSpace(resp),
+	})
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// detectID posts a detection and returns the ID it was given
+func detectID(t *testing.T, s *server) string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	s.detectedHandler(rec, httptest.NewRequest(http.MethodPost, "/detected", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("detect: status = %d, body = %s", rec.Code, rec.Body)
+	}
+	var body struct {
+		ID string `json:"id"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil || body.ID == "" {
+		t.Fatalf("detect: body = %+v (%v)", body, err)
+	}
+	return body.ID
+}
+
+func cancelDetection(s *server, id string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	s.detectionCancelHandler(rec, httptest.NewRequest(http.MethodPost, "/detections/"+id+"/cancel", nil))
+	return rec
+}
+
+func TestDetectionCancel(t *testing.T) {
+	s := newTestServer(t, startFakeController(t))
+	defer s.unlock.stop()
+	id := detectID(t, s)
+
+	rec := cancelDetection(s, id)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body)
+	}
+	var body struct {
+		Status          string `json:"status"`
+		ID              string `json:"id"`
+		CancelledTimers int    `json:"cancelled_timers"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil || body.Status != "cancelled" || body.ID != id || body.CancelledTimers != 1 {
+		t.Errorf("body = %+v (%v)", body, err)
+	}
+	if s.unlock.pending() {
+		t.Error("auto-unlock still pending after cancel")
+	}
+	config, err := s.config.load()
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+	if config.LockedUntil != "" || config.LockedBy != "" {
+		t.Errorf("locked_until = %q, locked_by = %q, want both cleared", config.LockedUntil, config.LockedBy)
+	}
+	ev, ok, err := s.history.find(id)
+	if err != nil || !ok || ev.CancelledAt == nil {
+		t.Errorf("history event = %+v (found %v, %v), want cancelled_at set", ev, ok, err)
+	}
+
+	if rec := cancelDetection(s, id); rec.Code != http.StatusConflict {
+		t.Errorf("second cancel: status = %d, want 409", rec.Code)
+	}
+}
+
+func TestDetectionCancelReplacedLock(t *testing.T) {
+	s := newTestServer(t, startFakeController(t))
+	defer s.unlock.stop()
+	first := detectID(t, s)
+	second := detectID(t, s)
+
+	if rec := cancelDetection(s, first); rec.Code != http.StatusConflict {
+		t.Errorf("cancel replaced detection: status = %d, want 409", rec.Code)
+	}
+	if !s.unlock.pending() {
+		t.Error("the replacing lock was released")
+	}
+	if rec := cancelDetection(s, second); rec.Code != http.StatusOK {
+		t.Errorf("cancel active detection: status = %d, body = %s", rec.Code, rec.Body)
+	}
+}
+
+func TestDetectionCancelNotFound(t *testing.T) {
+	s := newTestServer(t, startFakeController(t))
+	for _, path := range []string{"/detections/nope/cancel", "/detections/", "/detections/abc"} {
+		rec := httptest.NewRecorder()
+		s.detectionCancelHandler(rec, httptest.NewRequest(http.MethodPost, path, nil))
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("%s: status = %d, want 404", path, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a Clock that only moves when advance is called
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+type fakeTimer struct {
+	clock *fakeClock
+	at    time.Time
+	c     chan time.Time
+	done  bool // fired or stopped
+}
+
+func newFakeClock(now time.Time) *fakeClock {
+	return &fakeClock{now: now}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	return c.NewTimer(d).C()
+}
+
+func (c *fakeClock) NewTimer(d time.Duration) Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTimer{clock: c, at: c.now.Add(d), c: make(chan time.Time, 1)}
+	c.timers = append(c.timers, t)
+	c.fireLocked()
+	return t
+}
+
+// advance moves the clock on by d, firing every timer that comes due
+func (c *fakeClock) advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	c.fireLocked()
+}
+
+func (c *fakeClock) fireLocked() {
+	pending := c.timers[:0]
+	for _, t := range c.timers {
+		switch {
+		case t.done:
+		case !t.at.After(c.now):
+			t.done = true
+			t.c <- t.at
+		default:
+			pending = append(pending, t)
+		}
+	}
+	c.timers = pending
+}
+
+// waiters returns how many timers are armed
+func (c *fakeClock) waiters() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.timers)
+}
+
+func (t *fakeTimer) C() <-chan time.Time { return t.c }
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	if t.done {
+		return false
+	}
+	t.done = true
+	return true
+}
+
+func TestLockAutoUnlockCycleFakeClock(t *testing.T) {
+	base := time.Date(2024, 1, 1, 22, 0, 0, 0, time.UTC)
+	clock := newFakeClock(base)
+	client := &fakeClient{}
+	s := newTestServer(t, nil)
+	s.controller = client
+	s.clock = clock
+	s.unlock.clock = clock
+	s.unlockBackoff = time.Second
+	defer s.unlock.stop()
+
+	rec := httptest.NewRecorder()
+	s.detectedHandler(rec, httptest.NewRequest(http.MethodPost, "/detected", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body)
+	}
+	var body struct {
+		LockedUntil string `json:"locked_until"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if want := base.Add(s.lockDuration).Format(time.RFC3339); body.LockedUntil != want {
+		t.Errorf("locked_until = %s, want %s", body.LockedUntil, want)
+	}
+
+	clock.advance(s.lockDuration - time.Second)
+	if !s.unlock.pending() {
+		t.Fatal("unlocked before the lock ended")
+	}
+	if body := scrapeMetrics(t, s); !strings.Contains(body, "catdoor_unlock_seconds_remaining 1\n") {
+		t.Error("unlock_seconds_remaining doesn't follow the clock")
+	}
+
+	// The first GREEN fails, so the auto-unlock waits out its backoff on
+	// the clock before trying again.
+	client.mu.Lock()
+	client.fails = 1
+	client.mu.Unlock()
+	clock.advance(time.Second)
+	waitFor(t, func() bool { return clock.waiters() == 1 })
+	if got := client.commands(); !reflect.DeepEqual(got, []string{"RED", "GREEN"}) {
+		t.Errorf("before the retry: commands = %v", got)
+	}
+	clock.advance(s.unlockBackoff)
+	waitFor(t, func() bool { return s.unlock.inFlight() == 0 })
+
+	if got := client.commands(); !reflect.DeepEqual(got, []string{"RED", "GREEN", "GREEN"}) {
+		t.Errorf("commands = %v, want RED then GREEN twice", got)
+	}
+	config, err := s.config.load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.LockedUntil != "" {
+		t.Errorf("locked_until = %q after the auto-unlock", config.LockedUntil)
+	}
+}
+
+func TestUnlockTimerStopFakeClock(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	u := unlockTimer{clock: clock}
+	fired := make(chan struct{}, 1)
+	u.schedule(time.Minute, func() { fired <- struct{}{} })
+	if !u.stop() {
+		t.Fatal("stop found no pending unlock")
+	}
+	clock.advance(time.Hour)
+	select {
+	case <-fired:
+		t.Error("a stopped unlock fired")
+	case <-time.After(20 * time.Millisecond):
+	}
+	if got := u.inFlight(); got != 0 {
+		t.Errorf("in flight = %d, want 0", got)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// maxBatchCommands bounds the length of a POST /commands sequence
+	maxBatchCommands = 16
+	// maxBatchWait bounds the WAIT steps of one sequence, which hold the
+	// command lock
+	maxBatchWait = 10 * time.Second
+)
+
+// batchStep is one parsed entry of a POST /commands body: a mode, or a
+// pause when wait is set
+type batchStep struct {
+	command string
+	wait    time.Duration
+}
+
+// parseBatch validates every entry of a sequence before any is run. Entries
+// are modes (any case) or "WAIT <duration>".
+func parseBatch(entries []string) ([]batchStep, error) {
+	if len(entries) == 0 || len(entries) > maxBatchCommands {
+		return nil, fmt.Errorf("send between 1 and %d commands", maxBatchCommands)
+	}
+	steps := make([]batchStep, 0, len(entries))
+	var waited time.Duration
+	for i, entry := range entries {
+		fields := strings.Fields(strings.ToUpper(entry))
+		switch {
+		case len(fields) == 1 && validMode(fields[0]):
+			steps = append(steps, batchStep{command: fields[0]})
+		case len(fields) == 2 && fields[0] == "WAIT":
+			d, err := time.ParseDuration(strings.ToLower(fields[1]))
+			if err != nil || d <= 0 {
+				return nil, fmt.Errorf("command %d: invalid wait %q", i, entry)
+			}
+			waited += d
+			steps = append(steps, batchStep{command: "WAIT " + d.String(), wait: d})
+		default:
+			return nil, fmt.Errorf("command %d: %q is not a mode (green|yellow|red) or WAIT <duration>", i, entry)
+		}
+	}
+	if waited > maxBatchWait {
+		return nil, fmt.Errorf("waits add up to %s (max %s)", waited, maxBatchWait)
+	}
+	return steps, nil
+}
+
+// batchResult reports one step of a POST /commands sequence
+type batchResult struct {
+	Command string `json:"command"`
+	Status  string `json:"status"` // ok, failed or skipped
+	Reply   string `json:"reply,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// commandsHandler handles POST /commands with a JSON array such as
+// ["RED", "WAIT 2s", "YELLOW"]. The whole sequence runs under the command
+// lock, so no other mode change can land between its steps, and stops at
+// the first failure. The response lists every step; steps after a failure
+// are skipped.
+func (s *server) commandsHandler(w http.ResponseWriter, r *http.Request) {
+	var entries []string
+	if err := json.NewDecoder(r.Body).Decode(&entries); err != nil {
+		writeBodyError(w, r, fmt.Errorf("invalid JSON body (want an array of commands): %w", err))
+		return
+	}
+	steps, err := parseBatch(entries)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, errCodeInvalidRequest, err.Error())
+		return
+	}
+
+	s.cmdMu.Lock()
+	results := make([]batchResult, len(steps))
+	var failed error
+	for i, step := range steps {
+		results[i] = batchResult{Command: step.command, Status: "skipped"}
+		if failed != nil {
+			continue
+		}
+		if step.wait > 0 {
+			time.Sleep(step.wait)
+			results[i].Status = "ok"
+			continue
+		}
+		resp, err := s.setModeLocked(step.command, "batch")
+		if err != nil {
+			failed = err
+			results[i].Status, results[i].Error = "failed", err.Error()
+			continue
+		}
+		results[i].Status, results[i].Reply = "ok", strings.TrimSpace(resp)
+		if step.command == "GREEN" {
+			s.unlockFailure.clear()
+		}
+	}
+	s.cmdMu.Unlock()
+
+	response := map[string]interface{}{"ok": failed == nil, "results": results}
+	w.Header().Set("Content-Type", "application/json")
+	if failed != nil {
+		s.log.Error("command sequence failed", "error", failed)
+		response["error"] = apiError{Code: controllerErrorCode(failed), Message: failed.Error()}
+		w.WriteHeader(controllerErrorStatus(failed))
+	}
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func postCommands(t *testing.T, s *server, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	s.commandsHandler(rec, httptest.NewRequest(http.MethodPost, "/commands", strings.NewReader(body)))
+	return rec
+}
+
+func TestCommandsHandler(t *testing.T) {
+	client := &fakeClient{}
+	s := newTestServer(t, startFakeController(t))
+	s.controller = client
+
+	start := time.Now()
+	rec := postCommands(t, s, `["red", "WAIT 20ms", "Yellow"]`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body)
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Errorf("took %s, want the 20ms wait", elapsed)
+	}
+	var body struct {
+		OK      bool          `json:"ok"`
+		Results []batchResult `json:"results"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if !body.OK || len(body.Results) != 3 || body.Results[2].Command != "YELLOW" || body.Results[2].Reply != "OK YELLOW" {
+		t.Errorf("body = %+v", body)
+	}
+	if got := strings.Join(client.commands(), ","); got != "RED,YELLOW" {
+		t.Errorf("controller commands = %q, want RED,YELLOW", got)
+	}
+	if transitions := s.modes.recent(1); len(transitions) != 1 || transitions[0].Source != "batch" {
+		t.Errorf("last transition = %+v, want source batch", transitions)
+	}
+}
+
+func TestCommandsHandlerStopsAtFailure(t *testing.T) {
+	client := &fakeClient{}
+	s := newTestServer(t, startFakeController(t))
+	s.controller = client
+
+	client.err = errFakeFailure
+	rec := postCommands(t, s, `["RED", "GREEN"]`)
+	if rec.Code != http.StatusBadGateway {
+		t.Fatalf("status = %d, want 502", rec.Code)
+	}
+	var body struct {
+		OK      bool          `json:"ok"`
+		Results []batchResult `json:"results"`
+		Error   apiError      `json:"error"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if body.OK || body.Error.Code != errCodeControllerError {
+		t.Errorf("ok = %v, error = %+v", body.OK, body.Error)
+	}
+	if len(body.Results) != 2 || body.Results[0].Status != "failed" || body.Results[1].Status != "skipped" {
+		t.Errorf("results = %+v, want failed then skipped", body.Results)
+	}
+	if cmds := client.commands(); len(cmds) != 1 {
+		t.Errorf("controller commands = %v, want only the first", cmds)
+	}
+}
+
+func TestCommandsHandlerValidatesFirst(t *testing.T) {
+	client := &fakeClient{}
+	s := newTestServer(t, startFakeController(t))
+	s.controller = client
+
+	for _, body := range []string{
+		`[]`,
+		`"RED"`,
+		`["RED", "STATUS"]`,
+		`["RED", "WAIT soon"]`,
+		`["RED", "WAIT 6s", "WAIT 6s"]`,
+		`["RED","RED","RED","RED","RED","RED","RED","RED","RED","RED","RED","RED","RED","RED","RED","RED","RED"]`,
+	} {
+		if rec := postCommands(t, s, body); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", body, rec.Code)
+		}
+	}
+	if cmds := client.commands(); len(cmds) != 0 {
+		t.Errorf("controller commands = %v, want none", cmds)
+	}
+}
+
+func TestCommandsHandlerHoldsCommandLock(t *testing.T) {
+	client := &fakeClient{}
+	s := newTestServer(t, startFakeController(t))
+	s.controller = client
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		postCommands(t, s, `["RED", "WAIT 50ms", "YELLOW"]`)
+	}()
+	waitFor(t, func() bool { return len(client.commands()) == 1 })
+	if _, err := s.setMode("GREEN", "manual"); err != nil {
+		t.Fatalf("setMode: %v", err)
+	}
+	<-done
+	if got := strings.Join(client.commands(), ","); got != "RED,YELLOW,GREEN" {
+		t.Errorf("controller commands = %q, want the sequence uninterrupted", got)
+	}
+}
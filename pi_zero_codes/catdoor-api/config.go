@@ -0,0 +1,277 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Config represents the catdoor configuration
+type Config struct {
+	LastDetected    string           `json:"last_detected"`
+	LockedUntil     string           `json:"locked_until,omitempty"`
+	LockedBy        string           `json:"locked_by,omitempty"` // ID of the detection whose lock locked_until ends
+	SnoozedUntil    string           `json:"snoozed_until,omitempty"`
+	CooldownUntil   string           `json:"cooldown_until,omitempty"` // end of the cooldown after an auto-unlock
+	Schedule        []ScheduleWindow `json:"schedule,omitempty"`
+	Settings        *Settings        `json:"settings,omitempty"`
+	Maintenance     *Maintenance     `json:"maintenance,omitempty"` // set while maintenance mode is on
+	DetectionsToday *DailyCount      `json:"detections_today,omitempty"`
+
+	PendingDetections []PendingDetection `json:"pending_detections,omitempty"` // waiting for the controller to come back
+}
+
+// defaultStateFlushDelay is how long a change made with updateLater may stay
+// in memory before it is written to the config file
+const defaultStateFlushDelay = 2 * time.Second
+
+// State holds the config in memory so handlers and unlock timers don't
+// re-read and re-write the file on every request. The file is read on first
+// use; edits made to it while the process runs are not picked up.
+//
+// Changes through update and save are user edits, such as settings and the
+// schedule, and are written through; a failed write rejects them. Lock
+// state changes made with updateState are written through too, but stay in
+// effect in memory when the write fails, so callers can still report that
+// the lock won't survive a restart. Bookkeeping that nobody reports, made
+// with updateLater, is written by a background flush at most flushDelay
+// later, coalescing bursts into one write to spare the SD card. close
+// flushes whatever is still pending.
+type State struct {
+	path       string
+	flushDelay time.Duration
+
+	flushMu sync.Mutex // serializes file writes; taken before mu
+	mu      sync.RWMutex
+	config  *Config     // nil until read from the file
+	dirty   bool        // config has changes not yet written
+	timer   *time.Timer // pending background flush
+	closed  bool        // after close every change is written through
+}
+
+func newState(path string) *State {
+	return &State{path: path, flushDelay: defaultStateFlushDelay}
+}
+
+// load returns a copy of the current config
+func (st *State) load() (*Config, error) {
+	st.mu.RLock()
+	if st.config != nil {
+		config := st.config.clone()
+		st.mu.RUnlock()
+		return config, nil
+	}
+	st.mu.RUnlock()
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if err := st.readLocked(); err != nil {
+		return nil, err
+	}
+	return st.config.clone(), nil
+}
+
+// readLocked reads the file the first time the config is needed. st.mu must
+// be held for writing.
+func (st *State) readLocked() error {
+	if st.config != nil {
+		return nil
+	}
+	config, err := loadConfig(st.path)
+	if err != nil {
+		return err
+	}
+	st.config = config
+	return nil
+}
+
+// save replaces the config and writes it through
+func (st *State) save(config *Config) error {
+	_, err := st.update(func(c *Config) { *c = *config.clone() })
+	return err
+}
+
+// update applies fn to the current config and writes the result through,
+// returning the updated config. Nothing changes if the write fails.
+func (st *State) update(fn func(*Config)) (*Config, error) {
+	st.flushMu.Lock()
+	defer st.flushMu.Unlock()
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if err := st.readLocked(); err != nil {
+		return nil, err
+	}
+	next := st.config.clone()
+	fn(next)
+	// The write includes any lock state still waiting for the flush.
+	if err := saveConfig(st.path, next); err != nil {
+		return nil, err
+	}
+	st.config, st.dirty = next, false
+	return next.clone(), nil
+}
+
+// updateState applies fn to the lock state in memory, where it is in effect
+// at once, and writes it through. The updated config is returned with the
+// error of that write; the change then stays in memory, and is retried by
+// the next write.
+func (st *State) updateState(fn func(*Config)) (*Config, error) {
+	config, err := st.apply(fn)
+	if err != nil {
+		return nil, err
+	}
+	return config, st.flush()
+}
+
+// updateLater applies fn in memory like updateState but leaves the write to
+// the background flush, for changes whose callers don't report it
+func (st *State) updateLater(fn func(*Config)) error {
+	if _, err := st.apply(fn); err != nil {
+		return err
+	}
+	st.mu.Lock()
+	closed := st.closed
+	if !closed && st.timer == nil {
+		st.timer = time.AfterFunc(st.flushDelay, st.flushPending)
+	}
+	st.mu.Unlock()
+
+	if closed {
+		return st.flush()
+	}
+	return nil
+}
+
+// apply applies fn to the config in memory and marks it unsaved
+func (st *State) apply(fn func(*Config)) (*Config, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if err := st.readLocked(); err != nil {
+		return nil, err
+	}
+	fn(st.config)
+	st.dirty = true
+	return st.config.clone(), nil
+}
+
+// flushPending is the background flush armed by updateLater
+func (st *State) flushPending() {
+	st.mu.Lock()
+	st.timer = nil
+	st.mu.Unlock()
+	st.flush()
+}
+
+// flush writes unsaved changes to the file. A failed write leaves them in
+// memory for the next flush.
+func (st *State) flush() error {
+	st.flushMu.Lock()
+	defer st.flushMu.Unlock()
+
+	st.mu.Lock()
+	if !st.dirty {
+		st.mu.Unlock()
+		return nil
+	}
+	config := st.config.clone()
+	st.dirty = false
+	st.mu.Unlock()
+
+	err := saveConfig(st.path, config)
+	st.mu.Lock()
+	if err != nil {
+		st.dirty = true
+	}
+	st.mu.Unlock()
+	return err
+}
+
+// close cancels the pending background flush and flushes now. Later changes
+// are written through.
+func (st *State) close() error {
+	st.mu.Lock()
+	if st.timer != nil {
+		st.timer.Stop()
+		st.timer = nil
+	}
+	st.closed = true
+	st.mu.Unlock()
+	return st.flush()
+}
+
+// clone returns a deep copy of c, so callers can't change the State's copy
+func (c *Config) clone() *Config {
+	config := *c
+	config.Schedule = slices.Clone(c.Schedule)
+	config.PendingDetections = slices.Clone(c.PendingDetections)
+	if c.Settings != nil {
+		settings := *c.Settings
+		settings.DetectionWindows = slices.Clone(c.Settings.DetectionWindows)
+		config.Settings = &settings
+	}
+	return &config
+}
+
+// checkWritable reports whether the config file can be saved, by creating
+// and removing a temporary file next to it the way saveConfig does
+func (st *State) checkWritable() error {
+	tmp, err := os.CreateTemp(filepath.Dir(st.path), filepath.Base(st.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmp.Close()
+	return os.Remove(tmp.Name())
+}
+
+// loadConfig reads the config file
+func loadConfig(configPath string) (*Config, error) {
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &Config{}, nil
+		}
+		return nil, err
+	}
+
+	var config Config
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+// saveConfig writes the config file
+func saveConfig(configPath string, config *Config) error {
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(configPath, data)
+}
+
+// writeFileAtomic writes data to a temporary file first and renames it into
+// place, so a crash mid-write can't leave a truncated file.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStateMissingFile(t *testing.T) {
+	store := newState(filepath.Join(t.TempDir(), "missing.json"))
+
+	config, err := store.load()
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if !reflect.DeepEqual(*config, Config{}) {
+		t.Errorf("config = %+v, want zero value", config)
+	}
+}
+
+func TestStateConcurrentUpdates(t *testing.T) {
+	dir := t.TempDir()
+	store := newState(filepath.Join(dir, "config.json"))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := store.update(func(config *Config) {
+				config.LastDetected = fmt.Sprint(i)
+			})
+			if err != nil {
+				t.Errorf("update: %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if _, err := store.load(); err != nil {
+		t.Fatalf("config unreadable after concurrent updates: %v", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("read dir: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("found %d files in config dir, want only the config", len(entries))
+	}
+}
+
+// unwritableConfigPaths returns config paths that can be read (as empty) but
+// not saved
+func unwritableConfigPaths(t *testing.T) map[string]string {
+	t.Helper()
+	readOnly := filepath.Join(t.TempDir(), "ro")
+	if err := os.Mkdir(readOnly, 0555); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	t.Cleanup(func() { os.Chmod(readOnly, 0755) })
+	paths := map[string]string{"missing dir": filepath.Join(t.TempDir(), "gone", "config.json")}
+	if newState(filepath.Join(readOnly, "config.json")).checkWritable() != nil {
+		paths["read-only dir"] = filepath.Join(readOnly, "config.json")
+	} else {
+		t.Log("directory permissions aren't enforced for this user; skipping the read-only case")
+	}
+	return paths
+}
+
+func TestStateUnwritable(t *testing.T) {
+	for name, path := range unwritableConfigPaths(t) {
+		t.Run(name, func(t *testing.T) {
+			store := newState(path)
+			if err := store.checkWritable(); err == nil {
+				t.Fatal("checkWritable succeeded")
+			}
+
+			// update rejects the change; updateState keeps it in memory.
+			if _, err := store.update(func(c *Config) { c.SnoozedUntil = "x" }); err == nil {
+				t.Fatal("update succeeded")
+			}
+			config, err := store.updateState(func(c *Config) { c.LockedUntil = "2024-01-01T12:00:00Z" })
+			if err == nil || config == nil || config.LockedUntil == "" {
+				t.Fatalf("updateState = %+v, %v; want the config and an error", config, err)
+			}
+			config, err = store.load()
+			if err != nil || config.LockedUntil != "2024-01-01T12:00:00Z" || config.SnoozedUntil != "" {
+				t.Errorf("load = %+v, %v; want the in-memory lock only", config, err)
+			}
+		})
+	}
+}
+
+func TestStateFlushesInBackground(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	store := newState(path)
+	store.flushDelay = 20 * time.Millisecond
+
+	for _, until := range []string{"2024-01-01T12:00:00Z", "2024-01-01T12:05:00Z"} {
+		if err := store.updateLater(func(c *Config) { c.LockedUntil = until }); err != nil {
+			t.Fatalf("updateLater: %v", err)
+		}
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("config written before the flush delay (stat: %v)", err)
+	}
+	if config, _ := store.load(); config.LockedUntil != "2024-01-01T12:05:00Z" {
+		t.Errorf("in-memory locked_until = %q", config.LockedUntil)
+	}
+
+	// Both changes land in a single write.
+	waitFor(t, func() bool {
+		config, err := loadConfig(path)
+		return err == nil && config.LockedUntil == "2024-01-01T12:05:00Z"
+	})
+}
+
+func TestStateUpdateWritesThroughPendingState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	store := newState(path)
+	store.flushDelay = time.Hour
+
+	store.updateLater(func(c *Config) { c.LockedUntil = "2024-01-01T12:00:00Z" })
+	if _, err := store.update(func(c *Config) { c.Schedule = []ScheduleWindow{{Start: "22:00", End: "06:00", Mode: "RED"}} }); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	config, err := loadConfig(path)
+	if err != nil || len(config.Schedule) != 1 || config.LockedUntil != "2024-01-01T12:00:00Z" {
+		t.Errorf("file = %+v, %v; want the schedule and the pending lock", config, err)
+	}
+
+	// Copies handed out can't change the state.
+	config, _ = store.load()
+	config.Schedule[0].Mode = "GREEN"
+	if again, _ := store.load(); again.Schedule[0].Mode != "RED" {
+		t.Errorf("schedule mode = %q after editing a loaded copy", again.Schedule[0].Mode)
+	}
+}
+
+func TestStateClose(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	store := newState(path)
+	store.flushDelay = time.Hour
+
+	store.updateLater(func(c *Config) { c.LockedUntil = "2024-01-01T12:00:00Z" })
+	if err := store.close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if config, err := loadConfig(path); err != nil || config.LockedUntil != "2024-01-01T12:00:00Z" {
+		t.Errorf("file after close = %+v, %v", config, err)
+	}
+
+	// After close a change is written at once.
+	store.updateLater(func(c *Config) { c.LockedUntil = "" })
+	if config, err := loadConfig(path); err != nil || config.LockedUntil != "" {
+		t.Errorf("file after a change past close = %+v, %v", config, err)
+	}
+}
+
+func TestDetectedHandlerReportsUnpersistedLock(t *testing.T) {
+	for name, path := range unwritableConfigPaths(t) {
+		t.Run(name, func(t *testing.T) {
+			s := newTestServer(t, startFakeController(t))
+			s.controller = &fakeClient{}
+			s.config = newState(path)
+
+			rec := httptest.NewRecorder()
+			s.detectedHandler(rec, httptest.NewRequest(http.MethodPost, "/detected", nil))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, body = %s", rec.Code, rec.Body)
+			}
+			var body struct {
+				Persisted *bool `json:"persisted"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			if body.Persisted == nil || *body.Persisted {
+				t.Errorf("persisted = %v, want false", body.Persisted)
+			}
+			if !s.unlock.pending() {
+				t.Error("auto-unlock not scheduled")
+			}
+			if remaining := lockRemaining(s.config, time.Now()); remaining <= 0 {
+				t.Error("lock not visible in memory")
+			}
+
+			// The auto-unlock still clears the in-memory lock.
+			s.unlock.stop()
+			s.autoUnlock()
+			if config, _ := s.config.load(); config.LockedUntil != "" {
+				t.Errorf("locked_until = %q after auto-unlock", config.LockedUntil)
+			}
+		})
+	}
+}
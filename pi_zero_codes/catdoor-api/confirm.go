@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultConfirmTimeout bounds how long POST /detected?confirm=true polls
+// STATUS for the lock (CATDOOR_CONFIRM_TIMEOUT)
+const defaultConfirmTimeout = 3 * time.Second
+
+// confirmPollInterval is the pause between those STATUS polls
+const confirmPollInterval = 200 * time.Millisecond
+
+// confirmRequested parses the ?confirm query parameter
+func confirmRequested(value string) (bool, error) {
+	if value = strings.TrimSpace(value); value == "" {
+		return false, nil
+	}
+	confirm, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid confirm %q", value)
+	}
+	return confirm, nil
+}
+
+// confirmMode polls STATUS until the controller reports mode, giving up
+// after s.confirmTimeout. The error says what the controller last reported,
+// or why it couldn't be asked.
+func (s *server) confirmMode(mode string) error {
+	deadline := s.clock.Now().Add(s.confirmTimeout)
+	for {
+		resp, err := s.controller.Send("STATUS")
+		reported := parseModeReply(resp)
+		if err == nil && reported == mode {
+			return nil
+		}
+		if s.clock.Now().Add(confirmPollInterval).After(deadline) {
+			if err != nil {
+				return fmt.Errorf("%s not confirmed within %s: %w", mode, s.confirmTimeout, err)
+			}
+			return fmt.Errorf("%s not confirmed within %s: controller reports %s", mode, s.confirmTimeout, reported)
+		}
+		<-s.clock.After(confirmPollInterval)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// slowLockClient accepts every mode at once but only reports it in STATUS
+// after lag further polls, like a bolt that takes a while to move. A
+// negative lag never reports it.
+type slowLockClient struct {
+	mu      sync.Mutex
+	mode    string
+	pending string
+	lag     int
+	polls   int
+}
+
+func (c *slowLockClient) Send(cmd string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if cmd != "STATUS" {
+		c.pending, c.polls = cmd, 0
+		return "OK " + cmd + "\n", nil
+	}
+	c.polls++
+	if c.pending != "" && c.lag >= 0 && c.polls > c.lag {
+		c.mode, c.pending = c.pending, ""
+	}
+	return "MODE " + c.mode + "\n", nil
+}
+
+func TestDetectedConfirmsLock(t *testing.T) {
+	client := &slowLockClient{mode: "GREEN", lag: 2}
+	s := newTestServer(t, startFakeController(t))
+	s.controller = client
+	s.confirmTimeout = 2 * time.Second
+	defer s.unlock.stop()
+
+	rec := httptest.NewRecorder()
+	s.detectedHandler(rec, httptest.NewRequest(http.MethodPost, "/detected?confirm=true", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body)
+	}
+	var body struct {
+		Status    string `json:"status"`
+		Confirmed bool   `json:"confirmed"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil || body.Status != "locked" || !body.Confirmed {
+		t.Errorf("body = %+v (%v)", body, err)
+	}
+	if client.polls != 3 {
+		t.Errorf("STATUS polls = %d, want 3", client.polls)
+	}
+}
+
+func TestDetectedConfirmTimesOut(t *testing.T) {
+	client := &slowLockClient{mode: "GREEN", lag: -1}
+	s := newTestServer(t, startFakeController(t))
+	s.controller = client
+	s.confirmTimeout = 3 * confirmPollInterval
+	defer s.unlock.stop()
+
+	rec := httptest.NewRecorder()
+	s.detectedHandler(rec, httptest.NewRequest(http.MethodPost, "/detected?confirm=true", nil))
+	if rec.Code != http.StatusBadGateway {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body)
+	}
+	if got := decodeError(t, rec); got.Code != errCodeControllerError {
+		t.Errorf("error = %+v", got)
+	}
+	// The lock was sent all the same, so its unlock is still armed.
+	if !s.unlock.pending() {
+		t.Error("no auto-unlock scheduled after an unconfirmed lock")
+	}
+	config, err := s.config.load()
+	if err != nil || config.LockedUntil == "" {
+		t.Errorf("locked_until not saved: %+v (%v)", config, err)
+	}
+}
+
+func TestDetectedWithoutConfirmDoesNotPoll(t *testing.T) {
+	client := &slowLockClient{mode: "GREEN", lag: -1}
+	s := newTestServer(t, startFakeController(t))
+	s.controller = client
+	defer s.unlock.stop()
+
+	rec := httptest.NewRecorder()
+	s.detectedHandler(rec, httptest.NewRequest(http.MethodPost, "/detected", nil))
+	if rec.Code != http.StatusOK || client.polls != 0 {
+		t.Errorf("status = %d, polls = %d", rec.Code, client.polls)
+	}
+
+	rec = httptest.NewRecorder()
+	s.detectedHandler(rec, httptest.NewRequest(http.MethodPost, "/detected?confirm=maybe", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("confirm=maybe: status = %d", rec.Code)
+	}
+}
@@ -0,0 +1,434 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defaultControllerRetries is how many times a failed command is retried
+const defaultControllerRetries = 2
+
+// defaultRetryBackoff is the delay before the first retry; it doubles after
+// each further attempt.
+const defaultRetryBackoff = 250 * time.Millisecond
+
+// defaultDialTimeout bounds connecting to the controller for a command
+const defaultDialTimeout = 2 * time.Second
+
+// defaultReadTimeout bounds waiting for the reply to a command
+const defaultReadTimeout = 2 * time.Second
+
+// defaultAckTimeout bounds the wait for a command's completion line after
+// its first reply (CATDOOR_ACK_TIMEOUT). Driving the bolt takes a few seconds.
+const defaultAckTimeout = 10 * time.Second
+
+// maxQueuedCommands bounds how many commands may wait behind the one in flight
+const maxQueuedCommands = 8
+
+// errControllerBusy is returned when too many commands are already queued
+var errControllerBusy = errors.New("controller busy: too many commands queued")
+
+// ControllerClient sends commands to the catflap controller and returns its
+// raw reply. Handlers depend on this rather than on the TCP transport so
+// tests and dry runs can substitute their own.
+type ControllerClient interface {
+	Send(cmd string) (string, error)
+}
+
+// prober is implemented by clients that support a quick health check which
+// doesn't wait behind queued commands.
+type prober interface {
+	Probe(timeout time.Duration) (string, error)
+}
+
+// probeController runs a health check against c, using its Probe when it
+// has one and a plain STATUS otherwise.
+func probeController(c ControllerClient, timeout time.Duration) (string, error) {
+	if p, ok := c.(prober); ok {
+		return p.Probe(timeout)
+	}
+	return c.Send("STATUS")
+}
+
+// closeController releases any connection c keeps open between commands
+func closeController(c ControllerClient) {
+	if cl, ok := c.(io.Closer); ok {
+		cl.Close()
+	}
+}
+
+// tcpController sends commands to the Python TCP controller one at a time,
+// so overlapping requests (e.g. a manual /mode/red and an auto-unlock GREEN)
+// can't race each other and the last command sent is the final state.
+type tcpController struct {
+	log       *slog.Logger
+	addr      string
+	retries   int
+	backoff   time.Duration
+	keepAlive bool    // reuse one connection across commands
+	framing   framing // how commands and replies are delimited
+
+	dialTimeout time.Duration
+	readTimeout time.Duration
+
+	acks       map[string]string // command -> completion line it waits for
+	ackTimeout time.Duration
+
+	breaker *circuitBreaker // nil sends every command regardless
+
+	mu      sync.Mutex // held while a command is on the wire; guards conn
+	waiting atomic.Int32
+	conn    net.Conn // persistent connection when keepAlive is set
+	rd      *bufio.Reader
+}
+
+func newTCPController(addr string, retries int, log *slog.Logger) *tcpController {
+	return &tcpController{
+		log:         log,
+		addr:        addr,
+		retries:     retries,
+		backoff:     defaultRetryBackoff,
+		framing:     lineFraming{},
+		dialTimeout: defaultDialTimeout,
+		readTimeout: defaultReadTimeout,
+		ackTimeout:  defaultAckTimeout,
+	}
+}
+
+// ackFor returns what cmd waits for after its first reply
+func (c *tcpController) ackFor(cmd string) ackWait {
+	if pattern := c.acks[cmd]; pattern != "" {
+		return ackWait{pattern: pattern, timeout: c.ackTimeout}
+	}
+	return ackWait{}
+}
+
+// Send sends cmd unless the circuit breaker is open, in which case it fails
+// at once with errCircuitOpen.
+func (c *tcpController) Send(cmd string) (string, error) {
+	if c.breaker == nil {
+		return c.send(cmd)
+	}
+	if err := c.breaker.allow(); err != nil {
+		return "", err
+	}
+	resp, err := c.send(cmd)
+	if c.breaker.record(err) {
+		st := c.breaker.status()
+		if st.State == breakerOpen {
+			c.log.Warn("controller circuit breaker open: failing commands fast", "failures", st.Failures, "retry_after", st.RetryAfter, "error", err)
+		} else {
+			c.log.Info("controller circuit breaker closed")
+		}
+	}
+	return resp, err
+}
+
+// breakerStatus reports the circuit breaker, or nil without one
+func (c *tcpController) breakerStatus() *BreakerStatus {
+	if c.breaker == nil {
+		return nil
+	}
+	return c.breaker.status()
+}
+
+// send waits for any in-flight command to finish, then sends cmd. It fails
+// with errControllerBusy rather than queueing without bound.
+func (c *tcpController) send(cmd string) (string, error) {
+	if c.waiting.Add(1) > maxQueuedCommands {
+		c.waiting.Add(-1)
+		return "", errControllerBusy
+	}
+	c.mu.Lock()
+	c.waiting.Add(-1)
+	defer c.mu.Unlock()
+
+	backoff := c.backoff
+	for attempt := 0; ; attempt++ {
+		resp, err := c.roundTrip(cmd)
+		if err == nil || attempt >= c.retries || !isRetryable(err) {
+			return resp, err
+		}
+		c.log.Warn("controller command failed, retrying",
+			"cmd", cmd, "attempt", attempt+1, "attempts", c.retries+1, "backoff", backoff, "error", err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// roundTrip sends cmd over the persistent connection with keep-alive, or
+// over a fresh one otherwise. A reused connection that turns out to be dead
+// is redialled once straight away rather than counting as a failed attempt.
+// c.mu must be held.
+func (c *tcpController) roundTrip(cmd string) (string, error) {
+	if !c.keepAlive {
+		return sendToController(c.addr, cmd, c.framing, c.dialTimeout, c.readTimeout, c.ackFor(cmd))
+	}
+	reused := c.conn != nil && c.connHealthy()
+	if !reused {
+		c.closeConn()
+		if err := c.dial(); err != nil {
+			return "", err
+		}
+	}
+	resp, err := c.exchange(cmd)
+	if err != nil && reused {
+		c.log.Debug("controller connection went stale, reconnecting", "error", err)
+		if err := c.dial(); err != nil {
+			return "", err
+		}
+		resp, err = c.exchange(cmd)
+	}
+	if err != nil {
+		return "", err
+	}
+	if err := checkReply(resp); err != nil {
+		return resp, err
+	}
+	resp, err = awaitAck(c.conn, c.rd, c.framing, resp, c.ackFor(cmd))
+	if err != nil {
+		// Whatever the controller sends next may belong to this command.
+		c.closeConn()
+	}
+	return resp, err
+}
+
+// dial opens the persistent connection. c.mu must be held.
+func (c *tcpController) dial() error {
+	conn, err := net.DialTimeout("tcp", c.addr, c.dialTimeout)
+	if err != nil {
+		return fmt.Errorf("cannot connect to controller: %w", err)
+	}
+	c.conn, c.rd = conn, bufio.NewReader(conn)
+	return nil
+}
+
+// exchange writes cmd on the persistent connection and reads one reply.
+// The connection is dropped on any error so the next command reconnects.
+// c.mu must be held.
+func (c *tcpController) exchange(cmd string) (string, error) {
+	_ = c.conn.SetDeadline(time.Now().Add(c.readTimeout))
+	if _, err := c.conn.Write(c.framing.encode(cmd)); err != nil {
+		c.closeConn()
+		return "", fmt.Errorf("failed to send command: %w", err)
+	}
+	resp, err := c.framing.decode(c.rd)
+	if err != nil {
+		c.closeConn()
+		return "", fmt.Errorf("failed to read response: %w", err)
+	}
+	return resp, nil
+}
+
+// connHealthy checks that the idle persistent connection hasn't been closed
+// by the controller and has no stray bytes waiting. c.mu must be held.
+func (c *tcpController) connHealthy() bool {
+	_ = c.conn.SetReadDeadline(time.Now())
+	_, err := c.rd.Peek(1)
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// closeConn drops the persistent connection, if any. c.mu must be held.
+func (c *tcpController) closeConn() {
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn, c.rd = nil, nil
+	}
+}
+
+// Close drops the persistent connection
+func (c *tcpController) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closeConn()
+	return nil
+}
+
+// Probe sends a STATUS with the given timeout, bypassing the command queue
+// and retries so a health check never waits behind a slow command.
+func (c *tcpController) Probe(timeout time.Duration) (string, error) {
+	return sendToController(c.addr, "STATUS", c.framing, timeout, timeout, ackWait{})
+}
+
+// isRetryable reports whether err is a connection or timeout failure that
+// may succeed on another attempt.
+func isRetryable(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// dryRunController answers commands the way the Python controller would,
+// without touching the hardware (CATDOOR_DRY_RUN).
+type dryRunController struct {
+	log *slog.Logger
+
+	mu   sync.Mutex
+	mode string
+}
+
+func newDryRunController(log *slog.Logger) *dryRunController {
+	return &dryRunController{log: log, mode: "GREEN"}
+}
+
+func (c *dryRunController) Send(cmd string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.log.Info("dry run: not sending command to controller", "cmd", cmd)
+	switch cmd {
+	case "GREEN", "YELLOW", "RED":
+		c.mode = cmd
+		return "OK " + cmd + "\n", nil
+	case "STATUS":
+		return "MODE " + c.mode + "\n", nil
+	case "PING":
+		return "OK PONG\n", nil
+	case "VERSION":
+		return "VERSION dry-run\n", nil
+	}
+	return "ERR UNKNOWN\n", &controllerError{msg: "UNKNOWN"}
+}
+
+// controllerError is an ERR reply from the controller, e.g. a jammed motor.
+// It is not retried: the controller answered, it just couldn't comply.
+type controllerError struct {
+	msg string
+}
+
+func (e *controllerError) Error() string {
+	return "controller replied ERR " + e.msg
+}
+
+// checkReply validates a reply against the controller protocol. Each command
+// gets a single line back: "OK <mode>" after a mode change, "MODE <mode>"
+// or key=value pairs for STATUS, "VERSION <version>" for VERSION, or
+// "ERR <message>" when the command failed.
+func checkReply(resp string) error {
+	line := strings.TrimSpace(resp)
+	word, rest, _ := strings.Cut(line, " ")
+	switch word {
+	case "OK", "MODE", "VERSION":
+		return nil
+	case "ERR", "ERR:":
+		return &controllerError{msg: strings.TrimSpace(rest)}
+	case "":
+		return errors.New("empty reply from controller")
+	}
+	if strings.Contains(word, "=") {
+		return nil
+	}
+	return fmt.Errorf("unexpected reply from controller: %q", line)
+}
+
+// controllerErrorStatus maps a controller error to an HTTP status code
+func controllerErrorStatus(err error) int {
+	if errors.Is(err, errControllerBusy) || errors.Is(err, errCircuitOpen) {
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusBadGateway
+}
+
+// sendToController connects to the Python TCP controller and sends a command
+// framed by f. dialTimeout bounds the connect and readTimeout the wait for
+// the reply; with ack set it then waits for the completion line too.
+func sendToController(controllerAddr, cmd string, f framing, dialTimeout, readTimeout time.Duration, ack ackWait) (string, error) {
+	conn, err := net.DialTimeout("tcp", controllerAddr, dialTimeout)
+	if err != nil {
+		return "", fmt.Errorf("cannot connect to controller: %w", err)
+	}
+	defer conn.Close()
+
+	_, err = conn.Write(f.encode(cmd))
+	if err != nil {
+		return "", fmt.Errorf("failed to send command: %w", err)
+	}
+	// Half-close so the controller knows no more commands are coming on
+	// this connection and closes its end after replying.
+	if tc, ok := conn.(*net.TCPConn); ok {
+		_ = tc.CloseWrite()
+	}
+
+	// The reply is a single frame, so stop at its end rather than waiting
+	// for the controller to close; a reply cut short by EOF is still used.
+	_ = conn.SetReadDeadline(time.Now().Add(readTimeout))
+	rd := bufio.NewReader(conn)
+	resp, err := f.decode(rd)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", fmt.Errorf("failed to read response: %w", err)
+	}
+
+	if err := checkReply(resp); err != nil {
+		return resp, err
+	}
+	return awaitAck(conn, rd, f, resp, ack)
+}
+
+// ackWait is the completion line a command waits for after its first reply,
+// for firmware that answers "OK RED" when the motor starts and "DONE" once
+// the flap is actually locked. The zero value doesn't wait.
+type ackWait struct {
+	pattern string // a reply starting with this ends the wait
+	timeout time.Duration
+}
+
+// parseAcks parses CATDOOR_CONTROLLER_ACK: the completion line each command
+// waits for, e.g. "RED=DONE,YELLOW=DONE". Commands not listed return after
+// their first reply.
+func parseAcks(value string) (map[string]string, error) {
+	acks := map[string]string{}
+	for _, entry := range strings.Split(value, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		cmd, pattern, ok := strings.Cut(entry, "=")
+		cmd, pattern = strings.ToUpper(strings.TrimSpace(cmd)), strings.TrimSpace(pattern)
+		if !ok || cmd == "" || pattern == "" {
+			return nil, fmt.Errorf("invalid entry %q (use COMMAND=REPLY)", strings.TrimSpace(entry))
+		}
+		acks[cmd] = pattern
+	}
+	return acks, nil
+}
+
+// awaitAck reads on past resp, the first reply, until one starting with
+// ack.pattern and returns all the replies, one per line. An ERR reply fails
+// the command instead. Running out of ack.timeout is not retried: the
+// controller has taken the command, so sending it again won't help.
+func awaitAck(conn net.Conn, rd *bufio.Reader, f framing, resp string, ack ackWait) (string, error) {
+	if ack.pattern == "" || strings.HasPrefix(strings.TrimSpace(resp), ack.pattern) {
+		return resp, nil
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(ack.timeout))
+	for {
+		reply, err := f.decode(rd)
+		if reply != "" {
+			if !strings.HasSuffix(resp, "\n") {
+				resp += "\n"
+			}
+			resp += reply
+		}
+		if strings.HasPrefix(strings.TrimSpace(reply), ack.pattern) {
+			return resp, nil
+		}
+		var cerr *controllerError
+		if errors.As(checkReply(reply), &cerr) {
+			return resp, cerr
+		}
+		if errors.Is(err, io.EOF) {
+			return resp, fmt.Errorf("controller closed the connection before replying %s", ack.pattern)
+		}
+		if err != nil {
+			return resp, fmt.Errorf("no %s from controller within %s: %v", ack.pattern, ack.timeout, err)
+		}
+	}
+}
@@ -0,0 +1,404 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestControllerSerializesCommands(t *testing.T) {
+	fc := startFakeController(t)
+	fc.delay = 20 * time.Millisecond
+	c := newTCPController(fc.addr, 0, discardLogger())
+
+	var wg sync.WaitGroup
+	for _, cmd := range []string{"RED", "GREEN", "YELLOW", "GREEN"} {
+		wg.Add(1)
+		go func(cmd string) {
+			defer wg.Done()
+			if _, err := c.Send(cmd); err != nil {
+				t.Errorf("send %s: %v", cmd, err)
+			}
+		}(cmd)
+	}
+	wg.Wait()
+
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	if fc.maxActive != 1 {
+		t.Errorf("controller saw %d concurrent commands, want 1", fc.maxActive)
+	}
+	if len(fc.cmds) != 4 {
+		t.Errorf("controller received %d commands, want 4", len(fc.cmds))
+	}
+}
+
+func TestControllerBusy(t *testing.T) {
+	fc := startFakeController(t)
+	fc.delay = 200 * time.Millisecond
+	c := newTCPController(fc.addr, 0, discardLogger())
+
+	var wg sync.WaitGroup
+	errs := make(chan error, maxQueuedCommands+2)
+	for i := 0; i < maxQueuedCommands+2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := c.Send("STATUS")
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	busy := 0
+	for err := range errs {
+		if errors.Is(err, errControllerBusy) {
+			busy++
+		} else if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+	if busy == 0 {
+		t.Error("expected at least one command to be rejected as busy")
+	}
+}
+
+func TestControllerRetriesUntilListening(t *testing.T) {
+	// Reserve a port, then free it so the first dial is refused.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	c := newTCPController(addr, 3, discardLogger())
+	c.backoff = 50 * time.Millisecond
+
+	go func() {
+		time.Sleep(25 * time.Millisecond)
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			t.Errorf("relisten: %v", err)
+			return
+		}
+		defer ln.Close()
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		bufio.NewReader(conn).ReadString('\n')
+		conn.Write([]byte("OK RED\n"))
+	}()
+
+	resp, err := c.Send("RED")
+	if err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	if resp != "OK RED\n" {
+		t.Errorf("resp = %q", resp)
+	}
+}
+
+func TestControllerGivesUpAfterRetries(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	c := newTCPController(addr, 2, discardLogger())
+	c.backoff = time.Millisecond
+	if _, err := c.Send("RED"); err == nil {
+		t.Fatal("expected an error with nothing listening")
+	}
+}
+
+func TestDryRunController(t *testing.T) {
+	c := newDryRunController(discardLogger())
+
+	for _, tt := range []struct{ cmd, want string }{
+		{"STATUS", "MODE GREEN\n"},
+		{"RED", "OK RED\n"},
+		{"STATUS", "MODE RED\n"},
+		{"PING", "OK PONG\n"},
+		{"BOGUS", "ERR UNKNOWN\n"},
+	} {
+		got, err := c.Send(tt.cmd)
+		if err != nil && tt.cmd != "BOGUS" {
+			t.Fatalf("Send %s: %v", tt.cmd, err)
+		}
+		if got != tt.want {
+			t.Errorf("Send %s = %q, want %q", tt.cmd, got, tt.want)
+		}
+	}
+	if got, err := probeController(c, time.Second); err != nil || got != "MODE RED\n" {
+		t.Errorf("probeController = %q, %v", got, err)
+	}
+}
+
+func TestCheckReply(t *testing.T) {
+	for _, tt := range []struct {
+		resp    string
+		wantErr string
+	}{
+		{"OK RED\n", ""},
+		{"MODE GREEN\n", ""},
+		{"ERR UNKNOWN\n", "controller replied ERR UNKNOWN"},
+		{"ERR: hardware fault\n", "controller replied ERR hardware fault"},
+		{"", "empty reply from controller"},
+		{"garbage\n", `unexpected reply from controller: "garbage"`},
+	} {
+		err := checkReply(tt.resp)
+		switch {
+		case tt.wantErr == "" && err != nil:
+			t.Errorf("checkReply(%q) = %v, want nil", tt.resp, err)
+		case tt.wantErr != "" && (err == nil || err.Error() != tt.wantErr):
+			t.Errorf("checkReply(%q) = %v, want %q", tt.resp, err, tt.wantErr)
+		}
+	}
+}
+
+func TestControllerErrReplyNotRetried(t *testing.T) {
+	fc := startFakeController(t)
+	fc.reply = "ERR hardware fault\n"
+	c := newTCPController(fc.addr, 2, discardLogger())
+	c.backoff = time.Millisecond
+
+	_, err := c.Send("RED")
+	var cerr *controllerError
+	if !errors.As(err, &cerr) || cerr.msg != "hardware fault" {
+		t.Fatalf("Send = %v, want controller error", err)
+	}
+	if n := len(fc.commands()); n != 1 {
+		t.Errorf("controller received %d commands, want 1", n)
+	}
+}
+
+func TestControllerKeepAlive(t *testing.T) {
+	fc := startFakeController(t)
+	c := newTCPController(fc.addr, 0, discardLogger())
+	c.keepAlive = true
+	defer c.Close()
+
+	for _, cmd := range []string{"RED", "STATUS", "GREEN"} {
+		if _, err := c.Send(cmd); err != nil {
+			t.Fatalf("send %s: %v", cmd, err)
+		}
+	}
+	if n := fc.connections(); n != 1 {
+		t.Errorf("controller accepted %d connections, want 1", n)
+	}
+
+	// A controller restart drops the connection; the next command reconnects.
+	fc.dropConns()
+	time.Sleep(10 * time.Millisecond)
+	if resp, err := c.Send("YELLOW"); err != nil || resp != "OK YELLOW\n" {
+		t.Fatalf("send after drop = %q, %v", resp, err)
+	}
+	if n := fc.connections(); n != 2 {
+		t.Errorf("controller accepted %d connections, want 2", n)
+	}
+	if got := strings.Join(fc.commands(), ","); got != "RED,STATUS,GREEN,YELLOW" {
+		t.Errorf("commands = %q", got)
+	}
+}
+
+func TestControllerWithoutKeepAliveDialsPerCommand(t *testing.T) {
+	fc := startFakeController(t)
+	c := newTCPController(fc.addr, 0, discardLogger())
+
+	for _, cmd := range []string{"RED", "GREEN"} {
+		if _, err := c.Send(cmd); err != nil {
+			t.Fatalf("send %s: %v", cmd, err)
+		}
+	}
+	if n := fc.connections(); n != 2 {
+		t.Errorf("controller accepted %d connections, want 2", n)
+	}
+}
+
+// BenchmarkControllerBurst sends a burst of mode changes with and without a
+// persistent connection.
+func BenchmarkControllerBurst(b *testing.B) {
+	for _, keepAlive := range []bool{false, true} {
+		b.Run(fmt.Sprintf("keepalive=%v", keepAlive), func(b *testing.B) {
+			fc := startFakeController(b)
+			c := newTCPController(fc.addr, 0, discardLogger())
+			c.keepAlive = keepAlive
+			defer c.Close()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				for _, cmd := range []string{"RED", "YELLOW", "GREEN", "RED", "GREEN"} {
+					if _, err := c.Send(cmd); err != nil {
+						b.Fatalf("send %s: %v", cmd, err)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestSendToControllerStopsAtNewline(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+	release := make(chan struct{})
+	defer close(release)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		bufio.NewReader(conn).ReadString('\n')
+		// Reply in two pieces, then hold the connection open.
+		conn.Write([]byte("OK "))
+		time.Sleep(20 * time.Millisecond)
+		conn.Write([]byte("RED\n"))
+		<-release
+	}()
+
+	start := time.Now()
+	resp, err := sendToController(ln.Addr().String(), "RED", lineFraming{}, time.Second, 2*time.Second, ackWait{})
+	if err != nil || resp != "OK RED\n" {
+		t.Fatalf("sendToController = %q, %v", resp, err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("took %s, want it to return as soon as the newline arrived", elapsed)
+	}
+}
+
+func TestControllerReadTimeout(t *testing.T) {
+	fc := startFakeController(t)
+	fc.delay = 500 * time.Millisecond
+	for _, keepAlive := range []bool{false, true} {
+		c := newTCPController(fc.addr, 0, discardLogger())
+		c.keepAlive = keepAlive
+		c.readTimeout = 50 * time.Millisecond
+
+		start := time.Now()
+		_, err := c.Send("RED")
+		var netErr net.Error
+		if !errors.As(err, &netErr) || !netErr.Timeout() {
+			t.Errorf("keepalive=%v: Send = %v, want a timeout", keepAlive, err)
+		}
+		if elapsed := time.Since(start); elapsed > 400*time.Millisecond {
+			t.Errorf("keepalive=%v: took %s, want the 50ms read timeout", keepAlive, elapsed)
+		}
+		c.Close()
+	}
+}
+
+// startAckController accepts one command per connection and answers it with
+// "OK <cmd>" straight away, then with done after delay.
+func startAckController(t *testing.T, done string, delay time.Duration) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				rd := bufio.NewReader(conn)
+				for {
+					line, err := rd.ReadString('\n')
+					if err != nil {
+						return
+					}
+					conn.Write([]byte("OK " + strings.TrimSpace(line) + "\n"))
+					time.Sleep(delay)
+					conn.Write([]byte(done + "\n"))
+				}
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestControllerWaitsForAck(t *testing.T) {
+	addr := startAckController(t, "DONE", 50*time.Millisecond)
+	for _, keepAlive := range []bool{false, true} {
+		c := newTCPController(addr, 0, discardLogger())
+		c.keepAlive = keepAlive
+		c.acks = map[string]string{"RED": "DONE"}
+
+		start := time.Now()
+		resp, err := c.Send("RED")
+		if err != nil || resp != "OK RED\nDONE\n" {
+			t.Errorf("keepalive=%v: Send(RED) = %q, %v", keepAlive, resp, err)
+		}
+		if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+			t.Errorf("keepalive=%v: returned after %s, before the completion line", keepAlive, elapsed)
+		}
+
+		// Without an ack configured GREEN returns on its first reply.
+		if resp, err := c.Send("GREEN"); err != nil || resp != "OK GREEN\n" {
+			t.Errorf("keepalive=%v: Send(GREEN) = %q, %v", keepAlive, resp, err)
+		}
+		c.Close()
+	}
+}
+
+func TestControllerAckFailures(t *testing.T) {
+	tests := map[string]struct {
+		done    string
+		timeout time.Duration
+		want    string
+	}{
+		"timeout": {done: "DONE", timeout: 20 * time.Millisecond, want: "no DONE from controller within 20ms"},
+		"err":     {done: "ERR JAMMED", timeout: time.Second, want: "controller replied ERR JAMMED"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			fc := startAckController(t, tt.done, 200*time.Millisecond)
+			c := newTCPController(fc, 2, discardLogger())
+			c.acks = map[string]string{"RED": "DONE"}
+			c.ackTimeout = tt.timeout
+
+			start := time.Now()
+			_, err := c.Send("RED")
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("Send = %v, want %q", err, tt.want)
+			}
+			if isRetryable(err) {
+				t.Errorf("%v is retried", err)
+			}
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("took %s", elapsed)
+			}
+		})
+	}
+}
+
+func TestParseAcks(t *testing.T) {
+	acks, err := parseAcks(" red=DONE, yellow = LOCKED ,")
+	if err != nil {
+		t.Fatalf("parseAcks: %v", err)
+	}
+	if len(acks) != 2 || acks["RED"] != "DONE" || acks["YELLOW"] != "LOCKED" {
+		t.Errorf("acks = %v", acks)
+	}
+	for _, value := range []string{"RED", "RED=", "=DONE"} {
+		if _, err := parseAcks(value); err == nil {
+			t.Errorf("parseAcks(%q): expected an error", value)
+		}
+	}
+}
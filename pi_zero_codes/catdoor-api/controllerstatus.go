@@ -0,0 +1,71 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// ControllerStatus is a STATUS reply split into fields. The controller
+// answers either the original "MODE RED" or key=value pairs such as
+// "mode=RED battery=87 reed=closed"; the two can be mixed, as in
+// "MODE RED battery=87". Fields it leaves out stay empty, and keys this
+// service doesn't know, or values it can't make sense of, land in Extra
+// as sent.
+type ControllerStatus struct {
+	Mode    string            `json:"mode"`              // upper case; "UNKNOWN" when the reply has none
+	Battery *int              `json:"battery,omitempty"` // charge in percent
+	Reed    string            `json:"reed,omitempty"`    // door contact: open or closed
+	Extra   map[string]string `json:"extra,omitempty"`
+}
+
+// parseControllerStatus parses a STATUS reply. It never fails: a reply it
+// can't read at all just has mode UNKNOWN.
+func parseControllerStatus(resp string) ControllerStatus {
+	st := ControllerStatus{Mode: "UNKNOWN"}
+	fields := strings.Fields(resp)
+	if len(fields) >= 2 && fields[0] == "MODE" && !strings.Contains(fields[1], "=") {
+		st.Mode = strings.ToUpper(fields[1])
+		fields = fields[2:]
+	}
+	for _, field := range fields {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok || key == "" {
+			continue
+		}
+		key = strings.ToLower(key)
+		if !st.set(key, value) {
+			if st.Extra == nil {
+				st.Extra = map[string]string{}
+			}
+			st.Extra[key] = value
+		}
+	}
+	return st
+}
+
+// set stores a known field, reporting false for unknown keys and values
+// that don't parse
+func (st *ControllerStatus) set(key, value string) bool {
+	switch key {
+	case "mode":
+		if value == "" {
+			return false
+		}
+		st.Mode = strings.ToUpper(value)
+	case "battery":
+		percent, err := strconv.Atoi(strings.TrimSuffix(value, "%"))
+		if err != nil || percent < 0 || percent > 100 {
+			return false
+		}
+		st.Battery = &percent
+	case "reed":
+		value = strings.ToLower(value)
+		if value != "open" && value != "closed" {
+			return false
+		}
+		st.Reed = value
+	default:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseControllerStatus(t *testing.T) {
+	battery := func(percent int) *int { return &percent }
+	tests := map[string]ControllerStatus{
+		"MODE RED\n": {Mode: "RED"},
+		"mode=RED battery=87 reed=closed": {
+			Mode: "RED", Battery: battery(87), Reed: "closed",
+		},
+		"MODE green battery=12% reed=OPEN": {
+			Mode: "GREEN", Battery: battery(12), Reed: "open",
+		},
+		"battery=100 mode=yellow temp=21.5 Fw=1.2": {
+			Mode: "YELLOW", Battery: battery(100), Extra: map[string]string{"temp": "21.5", "fw": "1.2"},
+		},
+		"mode=RED battery=low reed=ajar": {
+			Mode: "RED", Extra: map[string]string{"battery": "low", "reed": "ajar"},
+		},
+		"battery=150 =x stray mode=": {
+			Mode: "UNKNOWN", Extra: map[string]string{"battery": "150", "mode": ""},
+		},
+		"reed=open": {Mode: "UNKNOWN", Reed: "open"},
+		"OK RED":    {Mode: "UNKNOWN"},
+		"":          {Mode: "UNKNOWN"},
+	}
+	for resp, want := range tests {
+		if got := parseControllerStatus(resp); !reflect.DeepEqual(got, want) {
+			t.Errorf("parseControllerStatus(%q) = %+v, want %+v", resp, got, want)
+		}
+	}
+}
+
+func TestCheckReplyAcceptsKeyValueStatus(t *testing.T) {
+	for _, resp := range []string{"mode=RED battery=87", "battery=87 mode=RED\n"} {
+		if err := checkReply(resp); err != nil {
+			t.Errorf("checkReply(%q) = %v", resp, err)
+		}
+	}
+}
@@ -0,0 +1,19 @@
+package main
+
+import "time"
+
+// cooldownUntil returns the end of the cooldown started by the last
+// auto-unlock, in the configured zone, if it is still in the future. During
+// it detections are recorded but don't lock again, so the cat can finish
+// coming in.
+func (s *server) cooldownUntil(now time.Time) (time.Time, bool) {
+	config, err := s.config.load()
+	if err != nil || config.CooldownUntil == "" {
+		return time.Time{}, false
+	}
+	until, err := time.Parse(time.RFC3339, config.CooldownUntil)
+	if err != nil || !until.After(now) {
+		return time.Time{}, false
+	}
+	return until.In(s.loc), true
+}
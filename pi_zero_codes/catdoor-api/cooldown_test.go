@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCooldownAfterAutoUnlockSkipsLocking(t *testing.T) {
+	client := &fakeClient{}
+	s := newTestServer(t, startFakeController(t))
+	s.controller = client
+	s.cooldown = time.Minute
+
+	before := time.Now()
+	s.autoUnlock()
+	config, err := s.config.load()
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+	until, err := time.Parse(time.RFC3339, config.CooldownUntil)
+	if err != nil || until.Sub(before) < time.Minute-time.Second || until.Sub(before) > time.Minute+time.Second {
+		t.Fatalf("cooldown_until = %q, want ~1m from now", config.CooldownUntil)
+	}
+
+	rec := httptest.NewRecorder()
+	s.detectedHandler(rec, httptest.NewRequest(http.MethodPost, "/detected", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("detected status = %d, body = %s", rec.Code, rec.Body)
+	}
+	var body struct {
+		Status   string `json:"status"`
+		Acted    bool   `json:"acted"`
+		Cooldown bool   `json:"cooldown"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil || body.Status != "cooldown" || body.Acted || !body.Cooldown {
+		t.Errorf("response = %+v (%v), want an unacted cooldown", body, err)
+	}
+	if cmds := client.commands(); len(cmds) != 1 || cmds[0] != "GREEN" {
+		t.Errorf("controller commands = %v, want only the auto-unlock GREEN", cmds)
+	}
+	if s.unlock.pending() {
+		t.Error("unlock scheduled during the cooldown")
+	}
+	events, err := s.history.recent(0)
+	if err != nil || len(events) != 1 || !events[0].Cooldown || !events[0].lockedUntil().IsZero() {
+		t.Errorf("history = %+v (%v), want one cooldown detection", events, err)
+	}
+}
+
+func TestCooldownExpires(t *testing.T) {
+	client := &fakeClient{}
+	s := newTestServer(t, startFakeController(t))
+	s.controller = client
+	writeConfig(t, s, &Config{CooldownUntil: time.Now().Add(-time.Second).Format(time.RFC3339)})
+
+	rec := httptest.NewRecorder()
+	s.detectedHandler(rec, httptest.NewRequest(http.MethodPost, "/detected", nil))
+	defer s.unlock.stop()
+	if rec.Code != http.StatusOK {
+		t.Fatalf("detected status = %d, body = %s", rec.Code, rec.Body)
+	}
+	if cmds := client.commands(); len(cmds) != 1 || cmds[0] != "RED" {
+		t.Errorf("controller commands = %v, want RED after the cooldown", cmds)
+	}
+}
+
+func TestNoCooldownByDefault(t *testing.T) {
+	s := newTestServer(t, startFakeController(t))
+	s.controller = &fakeClient{}
+
+	s.autoUnlock()
+	if config, _ := s.config.load(); config.CooldownUntil != "" {
+		t.Errorf("cooldown_until = %q without CATDOOR_COOLDOWN", config.CooldownUntil)
+	}
+}
+
+func TestResetEndsCooldown(t *testing.T) {
+	s := newTestServer(t, startFakeController(t))
+	s.controller = &fakeClient{}
+	writeConfig(t, s, &Config{CooldownUntil: time.Now().Add(time.Minute).Format(time.RFC3339)})
+
+	rec := httptest.NewRecorder()
+	s.resetHandler(rec, httptest.NewRequest(http.MethodPost, "/reset", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("reset status = %d, body = %s", rec.Code, rec.Body)
+	}
+	if _, ok := s.cooldownUntil(time.Now()); ok {
+		t.Error("cooldown still active after reset")
+	}
+}
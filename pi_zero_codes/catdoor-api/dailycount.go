@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DailyCount is the detections-today counter as saved in the config file
+type DailyCount struct {
+	Date  string `json:"date"` // local "2006-01-02"
+	Count int64  `json:"count"`
+}
+
+// dailyCounter counts the detections recorded since local midnight. The
+// count is atomic so /status and /metrics read it without a lock; mu only
+// serializes increments against the midnight rollover.
+type dailyCounter struct {
+	count atomic.Int64
+
+	mu   sync.Mutex
+	date string // the day count belongs to
+}
+
+// dayOf returns t's local date as saved in DailyCount
+func dayOf(t time.Time) string {
+	return t.Format(time.DateOnly)
+}
+
+// nextMidnight returns the start of the local day after t. Days of 23 or
+// 25 hours come out right from time.Date. Where a DST change skips midnight
+// itself, time.Date lands an hour early, still on t's day, and the day
+// really starts at the change.
+func nextMidnight(t time.Time) time.Time {
+	next := time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+	if dayOf(next) == dayOf(t) {
+		if _, change := next.ZoneBounds(); !change.IsZero() {
+			next = change
+		}
+	}
+	return next
+}
+
+// inc counts a detection at now. A counter still on an earlier day starts
+// over first, so a detection just after midnight never lands on yesterday's
+// count even if the reset hasn't run yet.
+func (c *dailyCounter) inc(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rollLocked(now)
+	c.count.Add(1)
+}
+
+// snapshot returns the count and its day for saving
+func (c *dailyCounter) snapshot() *DailyCount {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return &DailyCount{Date: c.date, Count: c.count.Load()}
+}
+
+// value returns the count for now's day
+func (c *dailyCounter) value(now time.Time) int64 {
+	c.mu.Lock()
+	stale := c.date != "" && c.date != dayOf(now)
+	c.mu.Unlock()
+	if stale {
+		return 0
+	}
+	return c.count.Load()
+}
+
+// roll starts a new day's count if now is past the counter's day, reporting
+// whether it did
+func (c *dailyCounter) roll(now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.rollLocked(now)
+}
+
+func (c *dailyCounter) rollLocked(now time.Time) bool {
+	today := dayOf(now)
+	if c.date == today {
+		return false
+	}
+	c.date = today
+	c.count.Store(0)
+	return true
+}
+
+// restore picks up a count saved earlier today. A count from another day
+// is dropped.
+func (c *dailyCounter) restore(saved *DailyCount, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rollLocked(now)
+	if saved != nil && saved.Date == c.date {
+		c.count.Store(saved.Count)
+	}
+}
+
+// restoreDetectionsToday loads the count saved before a restart
+func (s *server) restoreDetectionsToday(now time.Time) error {
+	config, err := s.config.load()
+	if err != nil {
+		return err
+	}
+	s.detectionsToday.restore(config.DetectionsToday, now)
+	return nil
+}
+
+// countDetection adds a recorded detection to today's count
+func (s *server) countDetection(now time.Time) {
+	s.detectionsToday.inc(now)
+	s.saveDetectionsToday()
+}
+
+// saveDetectionsToday saves the count. The snapshot is taken inside the
+// update, so concurrent saves can't write an older count over a newer one.
+func (s *server) saveDetectionsToday() {
+	if err := s.config.updateLater(func(config *Config) { config.DetectionsToday = s.detectionsToday.snapshot() }); err != nil {
+		s.log.Warn("failed to save the daily detection count", "error", err)
+	}
+}
+
+// runDailyReset zeroes the detections-today count at each local midnight
+// until ctx is cancelled.
+func (s *server) runDailyReset(ctx context.Context) {
+	for {
+		now := s.now()
+		timer := time.NewTimer(nextMidnight(now).Sub(now))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		// A timer that fires a moment early just waits again.
+		now = s.now()
+		if !s.detectionsToday.roll(now) {
+			continue
+		}
+		s.saveDetectionsToday()
+		s.log.Info("daily detection count reset", "date", dayOf(now))
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDailyCounterRollsOver(t *testing.T) {
+	var c dailyCounter
+	day := time.Date(2025, 6, 1, 23, 59, 0, 0, time.UTC)
+	c.inc(day)
+	c.inc(day)
+	if got := c.value(day); got != 2 {
+		t.Fatalf("value = %d, want 2", got)
+	}
+
+	// Past midnight the old count reads as 0 even before the reset runs,
+	// and the next detection starts the new day at 1.
+	next := day.Add(2 * time.Minute)
+	if got := c.value(next); got != 0 {
+		t.Errorf("value after midnight = %d, want 0", got)
+	}
+	c.inc(next)
+	if got := c.value(next); got != 1 {
+		t.Errorf("value = %d, want 1", got)
+	}
+	if c.roll(next) {
+		t.Error("roll on the same day reported a reset")
+	}
+	if !c.roll(next.AddDate(0, 0, 1)) || c.value(next.AddDate(0, 0, 1)) != 0 {
+		t.Error("roll on the next day didn't reset the count")
+	}
+}
+
+func TestDailyCounterConcurrent(t *testing.T) {
+	var c dailyCounter
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.inc(now)
+			c.value(now)
+		}()
+	}
+	wg.Wait()
+	if got := c.value(now); got != 50 {
+		t.Errorf("value = %d, want 50", got)
+	}
+}
+
+func TestNextMidnightDST(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("no tzdata: %v", err)
+	}
+	santiago, err := time.LoadLocation("America/Santiago")
+	if err != nil {
+		t.Skipf("no tzdata: %v", err)
+	}
+	tests := []struct {
+		name string
+		now  time.Time
+		want time.Duration
+	}{
+		{"spring forward", time.Date(2025, 3, 9, 0, 0, 0, 0, ny), 23 * time.Hour},
+		{"fall back", time.Date(2025, 11, 2, 0, 0, 0, 0, ny), 25 * time.Hour},
+		{"plain day", time.Date(2025, 6, 1, 0, 0, 0, 0, ny), 24 * time.Hour},
+		// Chile skips from 00:00 to 01:00, so that day starts at 01:00.
+		{"skipped midnight", time.Date(2024, 9, 7, 0, 0, 0, 0, santiago), 24 * time.Hour},
+	}
+	for _, tt := range tests {
+		next := nextMidnight(tt.now)
+		if got := next.Sub(tt.now); got != tt.want {
+			t.Errorf("%s: next midnight %s is %s away, want %s", tt.name, next, got, tt.want)
+		}
+		if dayOf(next) == dayOf(tt.now) {
+			t.Errorf("%s: next midnight %s is on the same day", tt.name, next)
+		}
+	}
+}
+
+func TestDetectionsTodayStatusAndMetrics(t *testing.T) {
+	s := newTestServer(t, startFakeController(t))
+	s.controller = &fakeClient{}
+
+	for i := 0; i < 2; i++ {
+		s.detectedHandler(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/detected", nil))
+	}
+	defer s.unlock.stop()
+
+	rec := httptest.NewRecorder()
+	s.statusHandler(rec, httptest.NewRequest(http.MethodGet, "/status", nil))
+	var status Status
+	if err := json.NewDecoder(rec.Body).Decode(&status); err != nil || status.DetectionsToday != 2 {
+		t.Errorf("status detections_today = %d (%v), want 2", status.DetectionsToday, err)
+	}
+	if body := scrapeMetrics(t, s); !strings.Contains(body, "catdoor_detections_today 2") {
+		t.Errorf("metrics missing catdoor_detections_today 2:\n%s", body)
+	}
+}
+
+func TestDetectionsTodaySurvivesRestart(t *testing.T) {
+	s := newTestServer(t, startFakeController(t))
+	now := s.now()
+	s.countDetection(now)
+	s.countDetection(now)
+	if err := s.config.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	restarted := newTestServer(t, startFakeController(t))
+	restarted.config = newState(s.config.path)
+	if err := restarted.restoreDetectionsToday(now); err != nil {
+		t.Fatal(err)
+	}
+	if got := restarted.detectionsToday.value(now); got != 2 {
+		t.Errorf("restored count = %d, want 2", got)
+	}
+
+	// A count saved on an earlier day is dropped.
+	tomorrow := newTestServer(t, startFakeController(t))
+	tomorrow.config = newState(s.config.path)
+	if err := tomorrow.restoreDetectionsToday(now.AddDate(0, 0, 1)); err != nil {
+		t.Fatal(err)
+	}
+	if got := tomorrow.detectionsToday.value(now.AddDate(0, 0, 1)); got != 0 {
+		t.Errorf("count restored from yesterday = %d, want 0", got)
+	}
+}
+
+func TestRunDailyResetStops(t *testing.T) {
+	s := newTestServer(t, startFakeController(t))
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.runDailyReset(ctx)
+		close(done)
+	}()
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("runDailyReset did not return after cancel")
+	}
+}
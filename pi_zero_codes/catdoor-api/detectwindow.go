@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// maxDetectionWindows bounds the number of detection windows in the settings
+const maxDetectionWindows = 32
+
+// DetectionWindow changes how a detection locks during a recurring daily
+// window, e.g. a longer lock at night. Start, End and Weekdays work as in
+// ScheduleWindow. An empty LockDuration or DetectMode keeps the global one.
+type DetectionWindow struct {
+	Start        string   `json:"start"`
+	End          string   `json:"end"`
+	Weekdays     []string `json:"weekdays,omitempty"`
+	LockDuration string   `json:"lock_duration,omitempty"`
+	DetectMode   string   `json:"detect_mode,omitempty"`
+
+	lockDuration time.Duration
+}
+
+// clock returns the window's times as a ScheduleWindow to share its matching
+func (w DetectionWindow) clock() ScheduleWindow {
+	return ScheduleWindow{Start: w.Start, End: w.End, Weekdays: w.Weekdays, Mode: scheduleDefaultMode}
+}
+
+// normalize validates w against the lock bounds and returns it with
+// canonical casing and its duration parsed
+func (w DetectionWindow) normalize(maxLock time.Duration) (DetectionWindow, error) {
+	c, err := w.clock().normalize()
+	if err != nil {
+		return w, err
+	}
+	w.Start, w.End, w.Weekdays = c.Start, c.End, c.Weekdays
+	if w.LockDuration == "" && w.DetectMode == "" {
+		return w, fmt.Errorf("window %s-%s sets neither lock_duration nor detect_mode", w.Start, w.End)
+	}
+	if w.LockDuration != "" {
+		d, err := time.ParseDuration(strings.TrimSpace(w.LockDuration))
+		if err != nil {
+			return w, fmt.Errorf("invalid lock_duration %q", w.LockDuration)
+		}
+		if d < minLockDuration || d > maxLock {
+			return w, fmt.Errorf("lock_duration must be between %s and %s", minLockDuration, maxLock)
+		}
+		w.LockDuration, w.lockDuration = d.String(), d
+	}
+	if w.DetectMode != "" {
+		w.DetectMode = strings.ToUpper(strings.TrimSpace(w.DetectMode))
+		if w.DetectMode != "RED" && w.DetectMode != "YELLOW" {
+			return w, fmt.Errorf("invalid detect_mode %q (use red|yellow)", w.DetectMode)
+		}
+	}
+	return w, nil
+}
+
+// normalizeDetectionWindows validates every window in order
+func normalizeDetectionWindows(windows []DetectionWindow, maxLock time.Duration) ([]DetectionWindow, error) {
+	if len(windows) > maxDetectionWindows {
+		return nil, fmt.Errorf("too many detection_windows (max %d)", maxDetectionWindows)
+	}
+	out := make([]DetectionWindow, len(windows))
+	for i, w := range windows {
+		normalized, err := w.normalize(maxLock)
+		if err != nil {
+			return nil, fmt.Errorf("detection_windows %d: %w", i, err)
+		}
+		out[i] = normalized
+	}
+	return out, nil
+}
+
+// detectionProfile is how a detection at a given time locks
+type detectionProfile struct {
+	lockDuration time.Duration
+	detectMode   string
+	window       *DetectionWindow // nil outside every window
+}
+
+// resolveDetection returns the lock duration and mode in force at t: those
+// of the first window containing t, falling back field by field to the
+// global settings. Callers hold detectMu.
+func (s *server) resolveDetection(t time.Time) detectionProfile {
+	p := detectionProfile{lockDuration: s.lockDuration, detectMode: s.detectMode}
+	for i := range s.detectionWindows {
+		w := &s.detectionWindows[i]
+		if !w.clock().contains(t) {
+			continue
+		}
+		if w.lockDuration > 0 {
+			p.lockDuration = min(w.lockDuration, s.maxLock)
+		}
+		if w.DetectMode != "" {
+			p.detectMode = w.DetectMode
+		}
+		matched := *w
+		p.window = &matched
+		break
+	}
+	return p
+}
+
+// detectionWindowsOrEmpty returns windows, or an empty list for JSON
+func detectionWindowsOrEmpty(windows []DetectionWindow) []DetectionWindow {
+	if windows == nil {
+		return []DetectionWindow{}
+	}
+	return windows
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestResolveDetection(t *testing.T) {
+	s := newTestServer(t, startFakeController(t))
+	windows, err := normalizeDetectionWindows([]DetectionWindow{
+		{Start: "22:00", End: "06:00", LockDuration: "30m"},
+		{Start: "12:00", End: "13:00", DetectMode: "yellow"},
+		{Start: "12:00", End: "14:00", LockDuration: "45m", DetectMode: "red"},
+		{Start: "18:00", End: "19:00", LockDuration: "1h", Weekdays: []string{"tue"}},
+	}, s.maxLock)
+	if err != nil {
+		t.Fatalf("normalize: %v", err)
+	}
+	s.detectionWindows = windows
+
+	// 2024-01-01 was a Monday.
+	at := func(day int, hhmm string) time.Time {
+		clock, _ := time.Parse("15:04", hhmm)
+		return time.Date(2024, 1, day, clock.Hour(), clock.Minute(), 0, 0, time.Local)
+	}
+	tests := []struct {
+		name     string
+		t        time.Time
+		duration time.Duration
+		mode     string
+		window   string
+	}{
+		{"outside every window", at(1, "09:00"), 10 * time.Minute, "RED", ""},
+		{"start is inclusive", at(1, "22:00"), 30 * time.Minute, "RED", "22:00"},
+		{"before start", at(1, "21:59"), 10 * time.Minute, "RED", ""},
+		{"overnight morning", at(2, "05:59"), 30 * time.Minute, "RED", "22:00"},
+		{"end is exclusive", at(2, "06:00"), 10 * time.Minute, "RED", ""},
+		{"mode only keeps the default duration", at(1, "12:30"), 10 * time.Minute, "YELLOW", "12:00"},
+		{"first window wins", at(1, "12:59"), 10 * time.Minute, "YELLOW", "12:00"},
+		{"next window after the first ends", at(1, "13:00"), 45 * time.Minute, "RED", "12:00"},
+		{"weekday mismatch", at(1, "18:30"), 10 * time.Minute, "RED", ""},
+		{"weekday match", at(2, "18:30"), time.Hour, "RED", "18:00"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := s.resolveDetection(tt.t)
+			if p.lockDuration != tt.duration || p.detectMode != tt.mode {
+				t.Errorf("resolved %s, %s; want %s, %s", p.lockDuration, p.detectMode, tt.duration, tt.mode)
+			}
+			start := ""
+			if p.window != nil {
+				start = p.window.Start
+			}
+			if start != tt.window {
+				t.Errorf("window start = %q, want %q", start, tt.window)
+			}
+		})
+	}
+}
+
+func TestDetectedHandlerUsesDetectionWindow(t *testing.T) {
+	fc := startFakeController(t)
+	s := newTestServer(t, fc)
+	// A window around now; one crossing midnight is handled as overnight.
+	now := s.now()
+	s.detectionWindows = []DetectionWindow{{
+		Start:        now.Add(-time.Minute).Format("15:04"),
+		End:          now.Add(2 * time.Minute).Format("15:04"),
+		LockDuration: "25m",
+		DetectMode:   "YELLOW",
+		lockDuration: 25 * time.Minute,
+	}}
+
+	before := time.Now()
+	rec := httptest.NewRecorder()
+	s.detectedHandler(rec, httptest.NewRequest(http.MethodPost, "/detected", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body)
+	}
+	var body struct {
+		Mode     string           `json:"mode"`
+		Duration string           `json:"duration"`
+		Window   *DetectionWindow `json:"window"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if body.Mode != "YELLOW" || body.Duration != "25m0s" || body.Window == nil || body.Window.LockDuration != "25m" {
+		t.Errorf("response = %s", rec.Body)
+	}
+	assertLockedFor(t, rec, before, 25*time.Minute)
+	if cmds := fc.commands(); len(cmds) != 1 || cmds[0] != "YELLOW" {
+		t.Errorf("controller commands = %v, want [YELLOW]", cmds)
+	}
+
+	// An explicit duration still overrides the window's.
+	rec = httptest.NewRecorder()
+	s.lastDetection = time.Time{}
+	s.detectedHandler(rec, httptest.NewRequest(http.MethodPost, "/detected?duration=15m", nil))
+	if !strings.Contains(rec.Body.String(), `"duration":"15m0s"`) {
+		t.Errorf("response = %s", rec.Body)
+	}
+}
+
+func TestDetectedHandlerDebounceInDetectionWindow(t *testing.T) {
+	s := newTestServer(t, startFakeController(t))
+	s.debounceWindow = time.Hour
+	defer s.unlock.stop()
+	now := s.now()
+	s.detectionWindows = []DetectionWindow{{
+		Start:      now.Add(-time.Minute).Format("15:04"),
+		End:        now.Add(2 * time.Minute).Format("15:04"),
+		DetectMode: "YELLOW",
+	}}
+
+	for _, want := range []bool{false, true} {
+		rec := httptest.NewRecorder()
+		s.detectedHandler(rec, httptest.NewRequest(http.MethodPost, "/detected", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, body = %s", rec.Code, rec.Body)
+		}
+		var body struct {
+			Debounced bool             `json:"debounced"`
+			Mode      string           `json:"mode"`
+			Window    *DetectionWindow `json:"window"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		if body.Debounced != want || body.Mode != "YELLOW" || body.Window == nil || body.Window.DetectMode != "YELLOW" {
+			t.Errorf("debounced %v: response = %s", want, rec.Body)
+		}
+	}
+}
+
+func TestConfigHandlerPutDetectionWindows(t *testing.T) {
+	s := newTestServer(t, startFakeController(t))
+
+	rec := httptest.NewRecorder()
+	body := `{"detection_windows":[{"start":"22:00","end":"06:00","lock_duration":"30m","weekdays":["Friday"]}]}`
+	s.configHandler(rec, httptest.NewRequest(http.MethodPut, "/config", strings.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body)
+	}
+	if len(s.detectionWindows) != 1 || s.detectionWindows[0].lockDuration != 30*time.Minute || s.detectionWindows[0].Weekdays[0] != "fri" {
+		t.Errorf("windows = %+v", s.detectionWindows)
+	}
+
+	// A restarted server picks the saved windows up again.
+	restarted := newTestServer(t, startFakeController(t))
+	restarted.config = s.config
+	if err := restarted.loadSettings(); err != nil {
+		t.Fatalf("loadSettings: %v", err)
+	}
+	if len(restarted.detectionWindows) != 1 || restarted.detectionWindows[0].lockDuration != 30*time.Minute {
+		t.Errorf("restored windows = %+v", restarted.detectionWindows)
+	}
+
+	// Other settings leave the windows alone; an empty list clears them.
+	rec = httptest.NewRecorder()
+	s.configHandler(rec, httptest.NewRequest(http.MethodPut, "/config", strings.NewReader(`{"detect_mode":"yellow"}`)))
+	if len(s.detectionWindows) != 1 {
+		t.Errorf("windows = %+v, want unchanged", s.detectionWindows)
+	}
+	rec = httptest.NewRecorder()
+	s.configHandler(rec, httptest.NewRequest(http.MethodPut, "/config", strings.NewReader(`{"detection_windows":[]}`)))
+	if rec.Code != http.StatusOK || len(s.detectionWindows) != 0 {
+		t.Errorf("status = %d, windows = %+v", rec.Code, s.detectionWindows)
+	}
+}
+
+func TestConfigHandlerPutInvalidDetectionWindows(t *testing.T) {
+	for _, body := range []string{
+		`{"detection_windows":[{"start":"22:00","end":"06:00"}]}`,
+		`{"detection_windows":[{"start":"25:00","end":"06:00","lock_duration":"30m"}]}`,
+		`{"detection_windows":[{"start":"22:00","end":"22:00","lock_duration":"30m"}]}`,
+		`{"detection_windows":[{"start":"22:00","end":"06:00","lock_duration":"2h"}]}`,
+		`{"detection_windows":[{"start":"22:00","end":"06:00","detect_mode":"green"}]}`,
+		`{"detection_windows":[{"start":"22:00","end":"06:00","weekdays":["someday"],"lock_duration":"30m"}]}`,
+		`{"max_lock_duration":"20m","detection_windows":[{"start":"22:00","end":"06:00","lock_duration":"30m"}]}`,
+	} {
+		t.Run(body, func(t *testing.T) {
+			s := newTestServer(t, startFakeController(t))
+
+			rec := httptest.NewRecorder()
+			s.configHandler(rec, httptest.NewRequest(http.MethodPut, "/config", strings.NewReader(body)))
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("status = %d, want 400", rec.Code)
+			}
+			if len(s.detectionWindows) != 0 || s.maxLock != time.Hour {
+				t.Errorf("settings changed: %+v, %s", s.detectionWindows, s.maxLock)
+			}
+		})
+	}
+}
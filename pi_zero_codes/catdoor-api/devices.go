@@ -0,0 +1,226 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// validDeviceName keeps device names safe to use in URLs and file names
+var validDeviceName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// deviceSpec is one catflap in the CATDOOR_DEVICES_FILE registry. Paths
+// left empty default to files named after the device next to the main
+// config file; the log paths default to the global ones.
+type deviceSpec struct {
+	Name            string `json:"name"`
+	ControllerAddr  string `json:"controller_addr"`
+	ConfigPath      string `json:"config_path,omitempty"`
+	HistoryPath     string `json:"history_path,omitempty"`
+	ModeHistoryPath string `json:"mode_history_path,omitempty"`
+	TelemetryPath   string `json:"telemetry_path,omitempty"`
+	ReedLog         string `json:"reed_log,omitempty"`
+	RadarLog        string `json:"radar_log,omitempty"`
+}
+
+// loadDeviceSpecs reads {"devices": [...]} from path, fills in the default
+// paths relative to configPath and validates the result.
+func loadDeviceSpecs(path, configPath string) ([]deviceSpec, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file struct {
+		Devices []deviceSpec `json:"devices"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("invalid devices file: %w", err)
+	}
+	if len(file.Devices) == 0 {
+		return nil, fmt.Errorf("devices file lists no devices")
+	}
+
+	names := make(map[string]bool)
+	configs := make(map[string]bool)
+	for i := range file.Devices {
+		d := &file.Devices[i]
+		d.Name = strings.ToLower(strings.TrimSpace(d.Name))
+		if !validDeviceName.MatchString(d.Name) {
+			return nil, fmt.Errorf("device %d: invalid name %q", i, d.Name)
+		}
+		if names[d.Name] {
+			return nil, fmt.Errorf("device %q is listed twice", d.Name)
+		}
+		names[d.Name] = true
+
+		if _, _, err := net.SplitHostPort(d.ControllerAddr); err != nil {
+			return nil, fmt.Errorf("device %q: invalid controller_addr %q: %w", d.Name, d.ControllerAddr, err)
+		}
+
+		dir := filepath.Dir(configPath)
+		for _, p := range []struct {
+			value *string
+			def   string
+		}{
+			{&d.ConfigPath, filepath.Join(dir, "catdoor-config-"+d.Name+".json")},
+			{&d.HistoryPath, filepath.Join(dir, "catdoor-detections-"+d.Name+".jsonl")},
+			{&d.ModeHistoryPath, filepath.Join(dir, "catdoor-modes-"+d.Name+".jsonl")},
+			{&d.TelemetryPath, filepath.Join(dir, "catdoor-telemetry-"+d.Name+".jsonl")},
+		} {
+			if *p.value == "" {
+				*p.value = p.def
+			}
+			if *p.value, err = expandHome(*p.value); err != nil {
+				return nil, fmt.Errorf("device %q: %w", d.Name, err)
+			}
+		}
+		if configs[d.ConfigPath] {
+			return nil, fmt.Errorf("device %q: config_path %s is shared with another device", d.Name, d.ConfigPath)
+		}
+		configs[d.ConfigPath] = true
+	}
+	return file.Devices, nil
+}
+
+// newDevice returns a server for one device, sharing s's settings and
+// process-wide pieces (webhook, rate limiter) but with its own controller,
+// state files and metrics.
+func (s *server) newDevice(spec deviceSpec, newController func(addr string, log *slog.Logger) ControllerClient) *server {
+	log := s.log.With("device", spec.Name)
+	config := newState(spec.ConfigPath)
+	m := newMetrics(config, s.clock.Now)
+
+	d := &server{
+		name:             spec.Name,
+		clock:            s.clock,
+		log:              log,
+		logFormat:        s.logFormat,
+		logFile:          s.logFile,
+		loc:              s.loc,
+		listenAddr:       s.listenAddr,
+		tlsConfig:        s.tlsConfig,
+		httpReadTimeout:  s.httpReadTimeout,
+		httpIdleTimeout:  s.httpIdleTimeout,
+		controller:       &instrumentedController{next: newController(spec.ControllerAddr, log), metrics: m},
+		controllerAddr:   spec.ControllerAddr,
+		dryRun:           s.dryRun,
+		config:           config,
+		history:          newHistoryStore(spec.HistoryPath),
+		modes:            newModeHistory(spec.ModeHistoryPath),
+		telemetry:        newTelemetryStore(spec.TelemetryPath, s.telemetry.interval),
+		webhook:          s.webhook,
+		metrics:          m,
+		detectMode:       s.detectMode,
+		debounceWindow:   s.debounceWindow,
+		cooldown:         s.cooldown,
+		detectionWindows: s.detectionWindows,
+		minConfidence:    s.minConfidence,
+		lockDuration:     s.lockDuration,
+		maxLock:          s.maxLock,
+		healthTimeout:    s.healthTimeout,
+		requestTimeout:   s.requestTimeout,
+		confirmTimeout:   s.confirmTimeout,
+		maxBodyBytes:     s.maxBodyBytes,
+		pingCommand:      s.pingCommand,
+		versionCommand:   s.versionCommand,
+		reedLog:          s.reedLog,
+		logSeverity:      s.logSeverity,
+		radarLog:         s.radarLog,
+		apiToken:         s.apiToken,
+		authReads:        s.authReads,
+		audit:            s.audit,
+		detectAllow:      s.detectAllow,
+		trustedProxies:   s.trustedProxies,
+		corsOrigins:      s.corsOrigins,
+		limiter:          s.limiter,
+		unlock:           unlockTimer{clock: s.clock},
+		watchdog:         watchdog{interval: s.watchdog.interval, correct: s.watchdog.correct},
+		queue:            s.queue,
+		threshold:        detectionThreshold{count: s.threshold.count, window: s.threshold.window},
+		unlockBackoff:    s.unlockBackoff,
+		unlockAttempts:   s.unlockAttempts,
+		maxUnlocks:       s.maxUnlocks,
+		unlockFallback:   s.unlockFallback,
+	}
+	m.trackUnlockTimers(&d.unlock)
+	m.trackDetectionsToday(&d.detectionsToday, d.now)
+	if spec.ReedLog != "" {
+		d.reedLog = spec.ReedLog
+	}
+	if spec.RadarLog != "" {
+		d.radarLog = spec.RadarLog
+	}
+	return d
+}
+
+// withDevices builds a server per spec and returns the first, which also
+// serves the unscoped routes and holds the registry.
+func (s *server) withDevices(specs []deviceSpec, newController func(addr string, log *slog.Logger) ControllerClient) *server {
+	devices := make(map[string]*server, len(specs))
+	names := make([]string, 0, len(specs))
+	for _, spec := range specs {
+		devices[spec.Name] = s.newDevice(spec, newController)
+		names = append(names, spec.Name)
+	}
+	root := devices[names[0]]
+	root.devices = devices
+	root.deviceNames = names
+	return root
+}
+
+// allDevices returns every device server, or just s without a registry
+func (s *server) allDevices() []*server {
+	if len(s.deviceNames) == 0 {
+		return []*server{s}
+	}
+	all := make([]*server, 0, len(s.deviceNames))
+	for _, name := range s.deviceNames {
+		all = append(all, s.devices[name])
+	}
+	return all
+}
+
+// registerDevices adds /devices and the /device/{name}/... routes to mux.
+// Each device gets its own copy of the unscoped routes.
+func (s *server) registerDevices(mux *http.ServeMux) {
+	if len(s.deviceNames) == 0 {
+		return
+	}
+	handlers := make(map[string]http.Handler, len(s.devices))
+	for name, d := range s.devices {
+		deviceMux := http.NewServeMux()
+		d.routes(deviceMux)
+		handlers[name] = http.StripPrefix("/device/"+name, deviceMux)
+	}
+
+	mux.HandleFunc("/device/", func(w http.ResponseWriter, r *http.Request) {
+		name, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/device/"), "/")
+		h, ok := handlers[name]
+		if !ok {
+			writeError(w, r, http.StatusNotFound, errCodeNotFound, fmt.Sprintf("unknown device %q (known: %s)", name, strings.Join(s.deviceNames, ", ")))
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+	mux.HandleFunc("/devices", allowMethods(s.readAuth(s.devicesHandler), http.MethodGet))
+}
+
+// devicesHandler handles GET /devices, listing the configured devices
+func (s *server) devicesHandler(w http.ResponseWriter, r *http.Request) {
+	devices := make([]map[string]interface{}, 0, len(s.deviceNames))
+	for i, d := range s.allDevices() {
+		devices = append(devices, map[string]interface{}{
+			"name":       d.name,
+			"controller": d.controllerAddr,
+			"default":    i == 0,
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"devices": devices})
+}
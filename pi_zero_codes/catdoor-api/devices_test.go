@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeDevicesFile(t *testing.T, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "devices.json")
+	if err := os.WriteFile(path, []byte(body), 0644); err != nil {
+		t.Fatalf("write devices file: %v", err)
+	}
+	return path
+}
+
+func TestLoadDeviceSpecs(t *testing.T) {
+	path := writeDevicesFile(t, `{"devices":[
+		{"name":"Front","controller_addr":"127.0.0.1:8765"},
+		{"name":"back","controller_addr":"127.0.0.1:8766","config_path":"/tmp/back.json"}
+	]}`)
+
+	specs, err := loadDeviceSpecs(path, "/data/catdoor-config.json")
+	if err != nil {
+		t.Fatalf("loadDeviceSpecs: %v", err)
+	}
+	if len(specs) != 2 || specs[0].Name != "front" {
+		t.Fatalf("specs = %+v", specs)
+	}
+	if specs[0].ConfigPath != "/data/catdoor-config-front.json" || specs[0].HistoryPath != "/data/catdoor-detections-front.jsonl" {
+		t.Errorf("default paths = %q, %q", specs[0].ConfigPath, specs[0].HistoryPath)
+	}
+	if specs[1].ConfigPath != "/tmp/back.json" {
+		t.Errorf("config_path = %q, want the explicit one", specs[1].ConfigPath)
+	}
+}
+
+func TestLoadDeviceSpecsInvalid(t *testing.T) {
+	tests := map[string]string{
+		"empty":         `{"devices":[]}`,
+		"bad json":      `{"devices":`,
+		"bad name":      `{"devices":[{"name":"front door","controller_addr":"127.0.0.1:1"}]}`,
+		"duplicate":     `{"devices":[{"name":"a","controller_addr":"127.0.0.1:1"},{"name":"a","controller_addr":"127.0.0.1:2"}]}`,
+		"bad addr":      `{"devices":[{"name":"a","controller_addr":"localhost"}]}`,
+		"shared config": `{"devices":[{"name":"a","controller_addr":"127.0.0.1:1","config_path":"/tmp/x.json"},{"name":"b","controller_addr":"127.0.0.1:2","config_path":"/tmp/x.json"}]}`,
+	}
+	for name, body := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := loadDeviceSpecs(writeDevicesFile(t, body), "/data/config.json"); err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
+
+func TestDeviceRoutes(t *testing.T) {
+	front, back := startFakeController(t), startFakeController(t)
+	dir := t.TempDir()
+	specs := []deviceSpec{
+		{Name: "front", ControllerAddr: front.addr, ConfigPath: filepath.Join(dir, "front.json"), HistoryPath: filepath.Join(dir, "front.jsonl")},
+		{Name: "back", ControllerAddr: back.addr, ConfigPath: filepath.Join(dir, "back.json"), HistoryPath: filepath.Join(dir, "back.jsonl")},
+	}
+	base := newTestServer(t, startFakeController(t))
+	s := base.withDevices(specs, func(addr string, log *slog.Logger) ControllerClient {
+		return newTCPController(addr, 0, log)
+	})
+
+	mux := s.newRouter()
+
+	post := func(target string) int {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, target, nil))
+		return rec.Code
+	}
+	if code := post("/device/back/mode/red"); code != http.StatusOK {
+		t.Fatalf("/device/back/mode/red: status = %d", code)
+	}
+	if code := post("/mode/yellow"); code != http.StatusOK {
+		t.Fatalf("/mode/yellow: status = %d", code)
+	}
+	if code := post("/device/side/mode/red"); code != http.StatusNotFound {
+		t.Errorf("unknown device: status = %d, want 404", code)
+	}
+
+	if cmds := back.commands(); strings.Join(cmds, ",") != "RED" {
+		t.Errorf("back commands = %v, want [RED]", cmds)
+	}
+	if cmds := front.commands(); strings.Join(cmds, ",") != "YELLOW" {
+		t.Errorf("front commands = %v, want the unscoped route on the first device", cmds)
+	}
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/devices", nil))
+	var body struct {
+		Devices []struct {
+			Name string `json:"name"`
+		} `json:"devices"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil || len(body.Devices) != 2 || body.Devices[1].Name != "back" {
+		t.Errorf("devices = %+v (%v)", body, err)
+	}
+}
+
+func TestNewServerFromEnvDevices(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("CATDOOR_CONFIG_PATH", filepath.Join(dir, "catdoor-config.json"))
+	t.Setenv("CATDOOR_DEVICES_FILE", writeDevicesFile(t, `{"devices":[
+		{"name":"front","controller_addr":"127.0.0.1:8765"},
+		{"name":"back","controller_addr":"127.0.0.1:8766"}
+	]}`))
+
+	s, err := newServerFromEnv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if s.name != "front" || len(s.allDevices()) != 2 {
+		t.Fatalf("default device = %q, devices = %v", s.name, s.deviceNames)
+	}
+	if back := s.devices["back"]; back.controllerAddr != "127.0.0.1:8766" || back.config.path != filepath.Join(dir, "catdoor-config-back.json") {
+		t.Errorf("back = %s, %s", back.controllerAddr, back.config.path)
+	}
+}
+
+func TestNewServerFromEnvDevicesHTTPTimeouts(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("CATDOOR_CONFIG_PATH", filepath.Join(dir, "catdoor-config.json"))
+	t.Setenv("CATDOOR_HTTP_READ_TIMEOUT", "7s")
+	t.Setenv("CATDOOR_HTTP_IDLE_TIMEOUT", "90s")
+	t.Setenv("CATDOOR_DEVICES_FILE", writeDevicesFile(t, `{"devices":[
+		{"name":"front","controller_addr":"127.0.0.1:8765"},
+		{"name":"back","controller_addr":"127.0.0.1:8766"}
+	]}`))
+
+	s, err := newServerFromEnv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// main runs the root device's server, so its timeouts guard the listener.
+	for _, d := range s.allDevices() {
+		if d.httpReadTimeout != 7*time.Second || d.httpIdleTimeout != 90*time.Second {
+			t.Errorf("%s: read timeout = %s, idle timeout = %s, want 7s and 1m30s", d.name, d.httpReadTimeout, d.httpIdleTimeout)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Error codes used in the JSON error envelope. Clients should branch on the
+// code; the message is for people.
+const (
+	errCodeInvalidRequest   = "invalid_request"
+	errCodeUnauthorized     = "unauthorized"
+	errCodeForbidden        = "forbidden"
+	errCodeNotFound         = "not_found"
+	errCodeMethodNotAllowed = "method_not_allowed"
+	errCodeRateLimited      = "rate_limited"
+	errCodeConflict         = "conflict"
+	errCodeUnavailable      = "unavailable"
+	errCodeTooLarge         = "payload_too_large"
+	errCodeTimeout          = "timeout"
+	errCodeControllerBusy   = "controller_busy"
+	errCodeControllerDown   = "controller_unavailable"
+	errCodeControllerError  = "controller_error"
+	errCodeInternal         = "internal_error"
+)
+
+// apiError is the "error" object of an error response
+type apiError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// errorBody is the JSON error envelope {"error":{"code":...,"message":...}}
+type errorBody struct {
+	Error apiError `json:"error"`
+}
+
+// writeError answers with status and the JSON error envelope. A request
+// with ?format=text gets just the message as plain text, as http.Error
+// would write it, for scripts and curl users.
+func writeError(w http.ResponseWriter, r *http.Request, status int, code, msg string) {
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Set("X-Content-Type-Options", "nosniff")
+	if r.URL.Query().Get("format") == "text" {
+		h.Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(status)
+		fmt.Fprintln(w, msg)
+		return
+	}
+
+	h.Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(errorBody{Error: apiError{Code: code, Message: msg}})
+}
+
+// writeBodyError reports a request body that couldn't be decoded: 413 when
+// it ran past the limit set by limitBody, else 400 with err as the message.
+func writeBodyError(w http.ResponseWriter, r *http.Request, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeError(w, r, http.StatusRequestEntityTooLarge, errCodeTooLarge,
+			fmt.Sprintf("request body is larger than %d bytes", tooLarge.Limit))
+		return
+	}
+	writeError(w, r, http.StatusBadRequest, errCodeInvalidRequest, err.Error())
+}
+
+// writeControllerError reports a failed controller command, with 503 and
+// controller_busy while the controller is busy or controller_unavailable
+// while its circuit breaker is open, else 502
+func writeControllerError(w http.ResponseWriter, r *http.Request, prefix string, err error) {
+	writeError(w, r, controllerErrorStatus(err), controllerErrorCode(err), prefix+err.Error())
+}
+
+// controllerErrorCode is the error code matching controllerErrorStatus
+func controllerErrorCode(err error) string {
+	if errors.Is(err, errCircuitOpen) {
+		return errCodeControllerDown
+	}
+	if controllerErrorStatus(err) == http.StatusServiceUnavailable {
+		return errCodeControllerBusy
+	}
+	return errCodeControllerError
+}
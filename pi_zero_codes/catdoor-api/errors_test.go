@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// decodeError decodes the JSON error envelope of rec
+func decodeError(t *testing.T, rec *httptest.ResponseRecorder) apiError {
+	t.Helper()
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", ct)
+	}
+	var body errorBody
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode %q: %v", rec.Body, err)
+	}
+	return body.Error
+}
+
+func TestErrorEnvelope(t *testing.T) {
+	fc := startFakeController(t)
+	s := newTestServer(t, fc)
+	s.apiToken = "s3cret"
+	mux := http.NewServeMux()
+	s.routes(mux)
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		auth   bool
+		status int
+		code   string
+	}{
+		{"bad mode", http.MethodPost, "/mode/purple", true, http.StatusBadRequest, errCodeInvalidRequest},
+		{"no token", http.MethodPost, "/mode/red", false, http.StatusUnauthorized, errCodeUnauthorized},
+		{"wrong method", http.MethodGet, "/detected", true, http.StatusMethodNotAllowed, errCodeMethodNotAllowed},
+		{"bad log type", http.MethodGet, "/logs?type=kitchen", false, http.StatusBadRequest, errCodeInvalidRequest},
+		{"bad duration", http.MethodPost, "/detected?duration=forever", true, http.StatusBadRequest, errCodeInvalidRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, nil)
+			if tt.auth {
+				req.Header.Set("Authorization", "Bearer s3cret")
+			}
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d (body %q)", rec.Code, tt.status, rec.Body)
+			}
+			if got := decodeError(t, rec); got.Code != tt.code || got.Message == "" {
+				t.Errorf("error = %+v, want code %s and a message", got, tt.code)
+			}
+		})
+	}
+}
+
+func TestErrorEnvelopeController(t *testing.T) {
+	fc := startFakeController(t)
+	fc.reply = "ERR JAMMED\n"
+	s := newTestServer(t, fc)
+
+	rec := httptest.NewRecorder()
+	s.statusHandler(rec, httptest.NewRequest(http.MethodGet, "/status", nil))
+	if rec.Code != http.StatusBadGateway {
+		t.Fatalf("status = %d, want 502", rec.Code)
+	}
+	if got := decodeError(t, rec); got.Code != errCodeControllerError || !strings.Contains(got.Message, "JAMMED") {
+		t.Errorf("error = %+v, want controller_error with the controller's reply", got)
+	}
+	if got := controllerErrorCode(errControllerBusy); got != errCodeControllerBusy {
+		t.Errorf("busy code = %q, want %q", got, errCodeControllerBusy)
+	}
+}
+
+func TestErrorFormatText(t *testing.T) {
+	s := newTestServer(t, startFakeController(t))
+
+	rec := httptest.NewRecorder()
+	s.logsHandler(rec, httptest.NewRequest(http.MethodGet, "/logs?type=kitchen&format=text", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want 400", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("Content-Type = %q, want text/plain", ct)
+	}
+	if body := rec.Body.String(); strings.HasPrefix(body, "{") || !strings.Contains(body, "invalid type") {
+		t.Errorf("body = %q, want the plain message", body)
+	}
+}
+
+func TestOversizedBody(t *testing.T) {
+	fc := startFakeController(t)
+	s := newTestServer(t, fc)
+	s.maxBodyBytes = 1 << 10
+	mux := http.NewServeMux()
+	s.routes(mux)
+
+	huge := `{"species":"` + strings.Repeat("m", 4<<10) + `"}`
+	tests := []struct{ method, target string }{
+		{http.MethodPost, "/detected"},
+		{http.MethodPost, "/commands"},
+		{http.MethodPost, "/schedule"},
+		{http.MethodPut, "/config"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.target, strings.NewReader(huge)))
+			if rec.Code != http.StatusRequestEntityTooLarge {
+				t.Fatalf("status = %d, want 413 (body %q)", rec.Code, rec.Body)
+			}
+			if got := decodeError(t, rec); got.Code != errCodeTooLarge {
+				t.Errorf("code = %q, want %q", got.Code, errCodeTooLarge)
+			}
+		})
+	}
+	if cmds := fc.commands(); len(cmds) != 0 {
+		t.Errorf("controller commands = %v, want none for rejected bodies", cmds)
+	}
+
+	// A body under the limit is still accepted.
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/detected", strings.NewReader(`{"species":"mouse"}`)))
+	if rec.Code != http.StatusOK {
+		t.Errorf("small body: status = %d, body = %s", rec.Code, rec.Body)
+	}
+}
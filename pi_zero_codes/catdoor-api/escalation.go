@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// parseFallbackCommands parses CATDOOR_UNLOCK_FALLBACK: the commands sent,
+// in order, when GREEN keeps failing, e.g. "FORCE_OPEN" or "YELLOW,GREEN".
+// Commands are single uppercase words; empty disables the escalation.
+func parseFallbackCommands(value string) ([]string, error) {
+	var cmds []string
+	for _, cmd := range strings.Split(value, ",") {
+		cmd = strings.ToUpper(strings.TrimSpace(cmd))
+		if cmd == "" {
+			continue
+		}
+		if strings.ContainsFunc(cmd, func(r rune) bool { return (r < 'A' || r > 'Z') && r != '_' }) {
+			return nil, fmt.Errorf("invalid command %q", cmd)
+		}
+		cmds = append(cmds, cmd)
+	}
+	return cmds, nil
+}
+
+// EscalationStatus describes the last fallback unlock, for /status
+type EscalationStatus struct {
+	At       string   `json:"at"`
+	Commands []string `json:"commands"`
+	OK       bool     `json:"ok"`
+	Error    string   `json:"error,omitempty"`
+}
+
+// unlockEscalation remembers the last fallback unlock
+type unlockEscalation struct {
+	mu   sync.Mutex
+	last *EscalationStatus
+}
+
+func (e *unlockEscalation) set(st EscalationStatus) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.last = &st
+}
+
+func (e *unlockEscalation) clear() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.last = nil
+}
+
+// get returns the last escalation, or nil if there hasn't been one
+func (e *unlockEscalation) get() *EscalationStatus {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.last == nil {
+		return nil
+	}
+	st := *e.last
+	return &st
+}
+
+// escalateUnlock sends the fallback commands after GREEN failed with
+// greenErr. Each one is recorded in the mode history with source
+// "escalation", and the outcome goes to the webhook as a high-priority
+// event either way.
+func (s *server) escalateUnlock(now time.Time, greenErr error) (string, error) {
+	s.log.Error("AUTO-UNLOCK FAILED: trying fallback unlock",
+		"fallback", strings.Join(s.unlockFallback, ","), "error", greenErr)
+
+	var resp string
+	var err error
+	for _, cmd := range s.unlockFallback {
+		if resp, err = s.setMode(cmd, "escalation"); err != nil {
+			err = fmt.Errorf("fallback %s failed: %w", cmd, err)
+			break
+		}
+	}
+
+	st := EscalationStatus{At: now.Format(time.RFC3339), Commands: s.unlockFallback, OK: err == nil}
+	payload := map[string]interface{}{
+		"event":        "auto_unlock_escalated",
+		"priority":     "high",
+		"escalated_at": st.At,
+		"fallback":     s.unlockFallback,
+		"green_error":  greenErr.Error(),
+		"ok":           st.OK,
+	}
+	if err != nil {
+		st.Error = err.Error()
+		payload["error"] = st.Error
+		s.log.Error("FALLBACK UNLOCK FAILED: catflap may still be locked", "error", err)
+	} else {
+		payload["controller"] = strings.TrimSpace(resp)
+		s.log.Warn("fallback unlock succeeded", "fallback", strings.Join(s.unlockFallback, ","))
+		// After a command that isn't a mode the controller's mode is
+		// unknown; keep the watchdog from putting the old lock back.
+		if !validMode(s.unlockFallback[len(s.unlockFallback)-1]) {
+			s.forgetIntendedMode()
+		}
+	}
+	s.escalation.set(st)
+	if s.webhook != nil {
+		s.webhook.notify(payload)
+	}
+	return resp, err
+}
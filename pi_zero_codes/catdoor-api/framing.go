@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// maxFrameSize bounds a length-prefixed reply so a corrupt prefix can't make
+// us allocate without limit. Replies are a few bytes.
+const maxFrameSize = 64 << 10
+
+// framing is how commands and replies are delimited on the controller
+// connection. Firmware builds differ: the Python controller uses lines, some
+// builds use length-prefixed or JSON messages. CATDOOR_CONTROLLER_FRAMING
+// selects one.
+type framing interface {
+	// encode returns cmd as it goes on the wire
+	encode(cmd string) []byte
+	// decode reads one reply. A reply cut short by the controller closing
+	// the connection comes back with io.EOF; callers decide whether to use it.
+	decode(rd *bufio.Reader) (string, error)
+}
+
+// framings are the framings CATDOOR_CONTROLLER_FRAMING can name
+var framings = map[string]framing{
+	"line":   lineFraming{},
+	"length": lengthFraming{},
+	"json":   jsonFraming{},
+}
+
+// parseFraming returns the framing called name
+func parseFraming(name string) (framing, error) {
+	f, ok := framings[strings.ToLower(strings.TrimSpace(name))]
+	if !ok {
+		return nil, fmt.Errorf("unknown framing %q (use line, length or json)", name)
+	}
+	return f, nil
+}
+
+// lineFraming terminates each command and reply with a newline. Replies keep
+// their newline.
+type lineFraming struct{}
+
+func (lineFraming) encode(cmd string) []byte {
+	return []byte(cmd + "\n")
+}
+
+func (lineFraming) decode(rd *bufio.Reader) (string, error) {
+	return rd.ReadString('\n')
+}
+
+// lengthFraming sends each command and reply as a 4-byte big-endian length
+// followed by that many bytes.
+type lengthFraming struct{}
+
+func (lengthFraming) encode(cmd string) []byte {
+	frame := binary.BigEndian.AppendUint32(nil, uint32(len(cmd)))
+	return append(frame, cmd...)
+}
+
+func (lengthFraming) decode(rd *bufio.Reader) (string, error) {
+	var prefix [4]byte
+	if _, err := io.ReadFull(rd, prefix[:]); err != nil {
+		return "", err
+	}
+	n := binary.BigEndian.Uint32(prefix[:])
+	if n > maxFrameSize {
+		return "", fmt.Errorf("reply frame of %d bytes exceeds %d", n, maxFrameSize)
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(rd, payload); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return "", err
+	}
+	return string(payload), nil
+}
+
+// jsonFraming sends {"cmd":"RED"} and reads {"reply":"OK RED"} or
+// {"error":"jammed"}, one object per line. An error comes back as the
+// "ERR <message>" reply the line protocol would give.
+type jsonFraming struct{}
+
+func (jsonFraming) encode(cmd string) []byte {
+	data, _ := json.Marshal(struct {
+		Cmd string `json:"cmd"`
+	}{cmd})
+	return append(data, '\n')
+}
+
+func (jsonFraming) decode(rd *bufio.Reader) (string, error) {
+	line, err := rd.ReadString('\n')
+	if err != nil && (!errors.Is(err, io.EOF) || strings.TrimSpace(line) == "") {
+		return "", err
+	}
+	var msg struct {
+		Reply string  `json:"reply"`
+		Error *string `json:"error"`
+	}
+	if err := json.Unmarshal([]byte(line), &msg); err != nil {
+		return "", fmt.Errorf("invalid JSON reply %q: %w", strings.TrimSpace(line), err)
+	}
+	if msg.Error != nil {
+		return "ERR " + *msg.Error, nil
+	}
+	return msg.Reply, nil
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestFramingRoundTrip(t *testing.T) {
+	tests := []struct {
+		name  string
+		f     framing
+		wire  string // encode("RED")
+		reply string // a reply on the wire
+		want  string // what decode makes of it
+	}{
+		{"line", lineFraming{}, "RED\n", "OK RED\n", "OK RED\n"},
+		{"length", lengthFraming{}, "\x00\x00\x00\x03RED", "\x00\x00\x00\x06OK RED", "OK RED"},
+		{"json", jsonFraming{}, `{"cmd":"RED"}` + "\n", `{"reply":"OK RED"}` + "\n", "OK RED"},
+		{"json error", jsonFraming{}, `{"cmd":"RED"}` + "\n", `{"error":"jammed"}` + "\n", "ERR jammed"},
+		{"json without newline", jsonFraming{}, `{"cmd":"RED"}` + "\n", `{"reply":"MODE RED"}`, "MODE RED"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(tt.f.encode("RED")); got != tt.wire {
+				t.Errorf("encode = %q, want %q", got, tt.wire)
+			}
+			got, err := tt.f.decode(bufio.NewReader(strings.NewReader(tt.reply)))
+			if err != nil || got != tt.want {
+				t.Errorf("decode = %q, %v; want %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestFramingDecodeErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		f     framing
+		reply string
+	}{
+		{"length truncated prefix", lengthFraming{}, "\x00\x00"},
+		{"length truncated payload", lengthFraming{}, "\x00\x00\x00\x06OK"},
+		{"length too large", lengthFraming{}, "\xff\xff\xff\xff"},
+		{"json invalid", jsonFraming{}, "OK RED\n"},
+		{"json empty", jsonFraming{}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, err := tt.f.decode(bufio.NewReader(strings.NewReader(tt.reply))); err == nil {
+				t.Errorf("decode = %q, want an error", got)
+			}
+		})
+	}
+
+	// A truncated payload is not mistaken for a clean close.
+	_, err := lengthFraming{}.decode(bufio.NewReader(strings.NewReader("\x00\x00\x00\x06OK")))
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("truncated payload: err = %v, want io.ErrUnexpectedEOF", err)
+	}
+}
+
+func TestParseFraming(t *testing.T) {
+	for name, want := range map[string]framing{"line": lineFraming{}, " LENGTH ": lengthFraming{}, "json": jsonFraming{}} {
+		if got, err := parseFraming(name); err != nil || got != want {
+			t.Errorf("parseFraming(%q) = %T, %v", name, got, err)
+		}
+	}
+	if _, err := parseFraming("xml"); err == nil {
+		t.Error("parseFraming(xml): expected an error")
+	}
+}
+
+// startFramedController serves a controller speaking f that answers every
+// command with "OK <cmd>", or an ERR for JAM
+func startFramedController(t *testing.T, f framing) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				rd := bufio.NewReader(conn)
+				for {
+					cmd, err := decodeCommand(f, rd)
+					if err != nil {
+						return
+					}
+					reply := map[bool]string{false: "OK " + cmd, true: "ERR jammed"}[cmd == "JAM"]
+					conn.Write(encodeReply(f, reply))
+				}
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// decodeCommand and encodeReply are the controller's side of f
+func decodeCommand(f framing, rd *bufio.Reader) (string, error) {
+	switch f.(type) {
+	case jsonFraming:
+		line, err := rd.ReadString('\n')
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(line), `{"cmd":"`), `"}`), nil
+	case lengthFraming:
+		return f.decode(rd)
+	}
+	line, err := rd.ReadString('\n')
+	return strings.TrimSpace(line), err
+}
+
+func encodeReply(f framing, reply string) []byte {
+	switch f.(type) {
+	case jsonFraming:
+		if msg, ok := strings.CutPrefix(reply, "ERR "); ok {
+			return []byte(fmt.Sprintf(`{"error":%q}`+"\n", msg))
+		}
+		return []byte(fmt.Sprintf(`{"reply":%q}`+"\n", reply))
+	}
+	return f.encode(reply)
+}
+
+func TestTCPControllerFramings(t *testing.T) {
+	for name, f := range framings {
+		for _, keepAlive := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%s/keepalive=%v", name, keepAlive), func(t *testing.T) {
+				c := newTCPController(startFramedController(t, f), 0, discardLogger())
+				c.framing, c.keepAlive = f, keepAlive
+				defer c.Close()
+
+				for _, cmd := range []string{"RED", "GREEN"} {
+					resp, err := c.Send(cmd)
+					if err != nil || strings.TrimSpace(resp) != "OK "+cmd {
+						t.Errorf("Send(%s) = %q, %v", cmd, resp, err)
+					}
+				}
+				var ce *controllerError
+				if _, err := c.Send("JAM"); !errors.As(err, &ce) || ce.msg != "jammed" {
+					t.Errorf("Send(JAM) error = %v, want the controller's ERR", err)
+				}
+			})
+		}
+	}
+}
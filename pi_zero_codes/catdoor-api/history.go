@@ -0,0 +1,336 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultHistoryMaxBytes is the size at which the history file is rotated.
+// One rotated file is kept, so history uses at most about twice this.
+const defaultHistoryMaxBytes = 1 << 20
+
+// defaultHistoryFile is created next to the config file unless
+// CATDOOR_HISTORY_PATH says otherwise.
+const defaultHistoryFile = "catdoor-detections.jsonl"
+
+const (
+	defaultDetectionsLimit = 50
+	maxDetectionsLimit     = 1000
+)
+
+// DetectionEvent is one /detected call as recorded in the history file
+type DetectionEvent struct {
+	ID              string             `json:"id,omitempty"` // absent on events recorded before IDs
+	Timestamp       time.Time          `json:"timestamp"`
+	Duration        string             `json:"duration"`
+	Source          string             `json:"source"`
+	Snoozed         bool               `json:"snoozed,omitempty"`          // recorded but didn't lock
+	BelowConfidence bool               `json:"below_confidence,omitempty"` // under CATDOOR_MIN_CONFIDENCE, didn't lock
+	Cooldown        bool               `json:"cooldown,omitempty"`         // in the cooldown after an auto-unlock, didn't lock
+	BelowThreshold  bool               `json:"below_threshold,omitempty"`  // short of CATDOOR_DETECT_THRESHOLD, didn't lock
+	Debounced       bool               `json:"debounced,omitempty"`        // within the debounce window of the last lock, didn't lock
+	Maintenance     bool               `json:"maintenance,omitempty"`      // in maintenance mode, didn't lock
+	Queued          bool               `json:"queued,omitempty"`           // the controller was unreachable; queued to lock later
+	CancelledAt     *time.Time         `json:"cancelled_at,omitempty"`     // its lock was ended early through /detections/{id}/cancel
+	Metadata        *DetectionMetadata `json:"metadata,omitempty"`
+}
+
+// newDetectionID returns a short random ID for a detection
+func newDetectionID() string {
+	b := make([]byte, 6)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// DetectionMetadata is the optional JSON body of POST /detected, describing
+// what the detector saw.
+type DetectionMetadata struct {
+	Confidence *float64 `json:"confidence,omitempty"` // 0 to 1
+	Species    string   `json:"species,omitempty"`
+	ImageURL   string   `json:"image_url,omitempty"`
+}
+
+// parseDetectionMetadata decodes the request body, if any. An empty body
+// has no metadata.
+func parseDetectionMetadata(r *http.Request) (*DetectionMetadata, error) {
+	var meta DetectionMetadata
+	if err := json.NewDecoder(r.Body).Decode(&meta); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("invalid JSON body: %w", err)
+	}
+	if c := meta.Confidence; c != nil && (*c < 0 || *c > 1) {
+		return nil, fmt.Errorf("confidence must be between 0 and 1, got %g", *c)
+	}
+	meta.Species = strings.TrimSpace(meta.Species)
+	meta.ImageURL = strings.TrimSpace(meta.ImageURL)
+	if meta == (DetectionMetadata{}) {
+		return nil, nil
+	}
+	return &meta, nil
+}
+
+// historyStore appends detection events to a JSONL file, rotating it to
+// path+".1" once it reaches maxBytes.
+type historyStore struct {
+	path     string
+	maxBytes int64
+	mu       sync.Mutex
+}
+
+func newHistoryStore(path string) *historyStore {
+	return &historyStore{path: path, maxBytes: defaultHistoryMaxBytes}
+}
+
+// append writes ev as one line, rotating the file first if it is full
+func (h *historyStore) append(ev DetectionEvent) error {
+	line, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return appendLine(h.path, h.maxBytes, line)
+}
+
+// find returns the event with id, looking in the current file first
+func (h *historyStore) find(id string) (DetectionEvent, bool, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var found DetectionEvent
+	var ok bool
+	for _, path := range []string{h.path, h.path + ".1"} {
+		err := scanHistoryFile(path, func(ev DetectionEvent) error {
+			if ev.ID == id {
+				found, ok = ev, true
+			}
+			return nil
+		})
+		if err != nil || ok {
+			return found, ok, err
+		}
+	}
+	return found, false, nil
+}
+
+// update applies fn to the event with id and rewrites the file holding it,
+// leaving every other line as it was. It reports whether the event was
+// found.
+func (h *historyStore) update(id string, fn func(*DetectionEvent)) (bool, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, path := range []string{h.path, h.path + ".1"} {
+		data, err := os.ReadFile(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+		lines := strings.SplitAfter(string(data), "\n")
+		for i, line := range lines {
+			var ev DetectionEvent
+			if json.Unmarshal([]byte(line), &ev) != nil || ev.ID != id {
+				continue
+			}
+			fn(&ev)
+			updated, err := json.Marshal(ev)
+			if err != nil {
+				return false, err
+			}
+			lines[i] = string(updated) + "\n"
+			return true, writeFileAtomic(path, []byte(strings.Join(lines, "")))
+		}
+	}
+	return false, nil
+}
+
+// recordDetection appends ev to the history and pushes it to /ws clients
+func (s *server) recordDetection(ev DetectionEvent) {
+	if err := s.history.append(ev); err != nil {
+		s.log.Warn("failed to record detection", "error", err)
+	}
+	s.countDetection(ev.Timestamp)
+	s.events.publish(wsMessage{Type: "detection", Data: ev})
+}
+
+// appendLine appends line and a newline to path, first rotating the file to
+// path+".1" if it has reached maxBytes. Callers serialize their own writes.
+func appendLine(path string, maxBytes int64, line []byte) error {
+	if info, err := os.Stat(path); err == nil && info.Size() >= maxBytes {
+		if err := os.Rename(path, path+".1"); err != nil {
+			return fmt.Errorf("rotate history: %w", err)
+		}
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// recent returns the last n events in chronological order, or all of them
+// when n <= 0. Missing files are treated as empty and malformed lines are
+// skipped.
+func (h *historyStore) recent(n int) ([]DetectionEvent, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var events []DetectionEvent
+	for _, path := range []string{h.path + ".1", h.path} {
+		more, err := readHistoryFile(path)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, more...)
+	}
+	if n > 0 && len(events) > n {
+		events = events[len(events)-n:]
+	}
+	return events, nil
+}
+
+// each calls fn for every event oldest first without loading the whole
+// history. It doesn't hold h.mu, so a slow reader never blocks detections;
+// a line being appended concurrently is skipped as malformed.
+func (h *historyStore) each(fn func(DetectionEvent) error) error {
+	for _, path := range []string{h.path + ".1", h.path} {
+		if err := scanHistoryFile(path, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readHistoryFile(path string) ([]DetectionEvent, error) {
+	var events []DetectionEvent
+	err := scanHistoryFile(path, func(ev DetectionEvent) error {
+		events = append(events, ev)
+		return nil
+	})
+	return events, err
+}
+
+// scanHistoryFile calls fn for each well-formed event in path. A missing
+// file has no events.
+func scanHistoryFile(path string, fn func(DetectionEvent) error) error {
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var ev DetectionEvent
+		if json.Unmarshal(scanner.Bytes(), &ev) != nil {
+			continue
+		}
+		if err := fn(ev); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// detectionsHandler handles GET /detections?limit=N, returning the most
+// recent detection events oldest first.
+func (s *server) detectionsHandler(w http.ResponseWriter, r *http.Request) {
+	limit, err := queryInt(r.URL.Query(), "limit", defaultDetectionsLimit, 1, maxDetectionsLimit)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, errCodeInvalidRequest, err.Error())
+		return
+	}
+
+	events, err := s.history.recent(limit)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, errCodeInternal, "failed to read detection history: "+err.Error())
+		return
+	}
+	if events == nil {
+		events = []DetectionEvent{}
+	}
+	for i := range events {
+		events[i].Timestamp = events[i].Timestamp.In(s.loc)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"count":      len(events),
+		"detections": events,
+	})
+}
+
+// lockedUntil is when ev's lock was due to end, or ended if it was
+// cancelled, or zero if it didn't lock
+func (ev DetectionEvent) lockedUntil() time.Time {
+	d, err := time.ParseDuration(ev.Duration)
+	if ev.Snoozed || ev.BelowConfidence || ev.Cooldown || ev.BelowThreshold || ev.Debounced || err != nil {
+		return time.Time{}
+	}
+	if ev.CancelledAt != nil {
+		return *ev.CancelledAt
+	}
+	return ev.Timestamp.Add(d)
+}
+
+// detectionsCSVHandler handles GET /detections.csv, streaming the whole
+// detection history as a CSV download.
+func (s *server) detectionsCSVHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="catdoor-detections.csv"`)
+
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"timestamp", "locked_until", "duration", "source", "confidence", "species", "image_url", "id", "cancelled_at"})
+	err := s.history.each(func(ev DetectionEvent) error {
+		until := ""
+		if t := ev.lockedUntil(); !t.IsZero() {
+			until = t.In(s.loc).Format(time.RFC3339)
+		}
+		var confidence, species, imageURL string
+		if meta := ev.Metadata; meta != nil {
+			if meta.Confidence != nil {
+				confidence = strconv.FormatFloat(*meta.Confidence, 'f', -1, 64)
+			}
+			species, imageURL = meta.Species, meta.ImageURL
+		}
+		var cancelledAt string
+		if ev.CancelledAt != nil {
+			cancelledAt = ev.CancelledAt.In(s.loc).Format(time.RFC3339)
+		}
+		return cw.Write([]string{ev.Timestamp.In(s.loc).Format(time.RFC3339), until, ev.Duration, ev.Source, confidence, species, imageURL, ev.ID, cancelledAt})
+	})
+	cw.Flush()
+	if err == nil {
+		err = cw.Error()
+	}
+	if err != nil {
+		// The header has usually gone out already, so all we can do is log.
+		s.log.Error("failed to export detection history", "path", s.history.path, "error", err)
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHistoryStoreRecent(t *testing.T) {
+	h := newHistoryStore(filepath.Join(t.TempDir(), "detections.jsonl"))
+
+	events, err := h.recent(10)
+	if err != nil || len(events) != 0 {
+		t.Fatalf("recent on missing file = %v, %v; want empty", events, err)
+	}
+
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		if err := h.append(DetectionEvent{Timestamp: base.Add(time.Duration(i) * time.Minute), Duration: "5m0s", Source: "test"}); err != nil {
+			t.Fatalf("append: %v", err)
+		}
+	}
+
+	events, err = h.recent(2)
+	if err != nil {
+		t.Fatalf("recent: %v", err)
+	}
+	if len(events) != 2 || !events[0].Timestamp.Equal(base.Add(3*time.Minute)) || !events[1].Timestamp.Equal(base.Add(4*time.Minute)) {
+		t.Errorf("recent(2) = %+v, want the last two events oldest first", events)
+	}
+}
+
+func TestHistoryStoreRotates(t *testing.T) {
+	h := newHistoryStore(filepath.Join(t.TempDir(), "detections.jsonl"))
+	h.maxBytes = 200
+
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 20; i++ {
+		if err := h.append(DetectionEvent{Timestamp: base.Add(time.Duration(i) * time.Minute), Duration: "5m0s", Source: "test"}); err != nil {
+			t.Fatalf("append: %v", err)
+		}
+	}
+
+	for _, path := range []string{h.path, h.path + ".1"} {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("stat %s: %v", path, err)
+		}
+		if info.Size() > h.maxBytes+100 {
+			t.Errorf("%s is %d bytes, want it rotated near %d", path, info.Size(), h.maxBytes)
+		}
+	}
+
+	events, err := h.recent(0)
+	if err != nil {
+		t.Fatalf("recent: %v", err)
+	}
+	if len(events) == 0 || len(events) >= 20 {
+		t.Fatalf("kept %d events, want older ones dropped", len(events))
+	}
+	if last := events[len(events)-1]; !last.Timestamp.Equal(base.Add(19 * time.Minute)) {
+		t.Errorf("last event = %+v, want the newest", last)
+	}
+}
+
+func TestDetectedHandlerRecordsHistory(t *testing.T) {
+	s := newTestServer(t, startFakeController(t))
+	s.controller = &fakeClient{}
+
+	rec := httptest.NewRecorder()
+	s.detectedHandler(rec, httptest.NewRequest(http.MethodPost, "/detected?duration=15m&source=garden", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body)
+	}
+	s.unlock.stop()
+
+	rec = httptest.NewRecorder()
+	s.detectionsHandler(rec, httptest.NewRequest(http.MethodGet, "/detections", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body)
+	}
+	var body struct {
+		Count      int              `json:"count"`
+		Detections []DetectionEvent `json:"detections"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if body.Count != 1 || body.Detections[0].Source != "garden" || body.Detections[0].Duration != "15m0s" {
+		t.Errorf("detections = %+v", body)
+	}
+}
+
+func TestDetectionsHandlerInvalidLimit(t *testing.T) {
+	s := newTestServer(t, startFakeController(t))
+
+	rec := httptest.NewRecorder()
+	s.detectionsHandler(rec, httptest.NewRequest(http.MethodGet, "/detections?limit=0", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400", rec.Code)
+	}
+}
+
+func TestDetectionsCSVHandler(t *testing.T) {
+	s := newTestServer(t, startFakeController(t))
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	for _, ev := range []DetectionEvent{
+		{Timestamp: base, Duration: "5m0s", Source: "radar", Metadata: &DetectionMetadata{Confidence: ptr(0.9), Species: "mouse"}},
+		{Timestamp: base.Add(time.Hour), Duration: "10m0s", Source: "cam, garden", Snoozed: true},
+	} {
+		if err := s.history.append(ev); err != nil {
+			t.Fatalf("append: %v", err)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	s.detectionsCSVHandler(rec, httptest.NewRequest(http.MethodGet, "/detections.csv", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body)
+	}
+	if got := rec.Header().Get("Content-Disposition"); !strings.HasPrefix(got, "attachment;") {
+		t.Errorf("Content-Disposition = %q, want an attachment", got)
+	}
+
+	rows, err := csv.NewReader(rec.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("parse csv: %v", err)
+	}
+	want := [][]string{
+		{"timestamp", "locked_until", "duration", "source", "confidence", "species", "image_url", "id", "cancelled_at"},
+		{"2024-01-01T12:00:00Z", "2024-01-01T12:05:00Z", "5m0s", "radar", "0.9", "mouse", "", "", ""},
+		{"2024-01-01T13:00:00Z", "", "10m0s", "cam, garden", "", "", "", "", ""},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("rows = %q, want %q", rows, want)
+	}
+}
+
+func ptr[T any](v T) *T { return &v }
+
+func TestDetectedHandlerMetadata(t *testing.T) {
+	s := newTestServer(t, startFakeController(t))
+	s.controller = &fakeClient{}
+
+	body := `{"confidence":0.92,"species":"mouse","image_url":"http://cam/1.jpg"}`
+	rec := httptest.NewRecorder()
+	s.detectedHandler(rec, httptest.NewRequest(http.MethodPost, "/detected", strings.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body)
+	}
+	s.unlock.stop()
+
+	var resp struct {
+		Metadata *DetectionMetadata `json:"metadata"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	want := DetectionMetadata{Confidence: ptr(0.92), Species: "mouse", ImageURL: "http://cam/1.jpg"}
+	if resp.Metadata == nil || !reflect.DeepEqual(*resp.Metadata, want) {
+		t.Errorf("echoed metadata = %+v, want %+v", resp.Metadata, want)
+	}
+
+	events, err := s.history.recent(0)
+	if err != nil || len(events) != 1 {
+		t.Fatalf("history = %v, %v", events, err)
+	}
+	if events[0].Metadata == nil || !reflect.DeepEqual(*events[0].Metadata, want) {
+		t.Errorf("recorded metadata = %+v, want %+v", events[0].Metadata, want)
+	}
+}
+
+func TestDetectedHandlerInvalidMetadata(t *testing.T) {
+	for _, body := range []string{`{"confidence":1.5}`, `{"confidence":-0.1}`, `not json`, `{"species":7}`} {
+		s := newTestServer(t, startFakeController(t))
+		client := &fakeClient{}
+		s.controller = client
+
+		rec := httptest.NewRecorder()
+		s.detectedHandler(rec, httptest.NewRequest(http.MethodPost, "/detected", strings.NewReader(body)))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("body %s: status = %d, want 400", body, rec.Code)
+		}
+		if cmds := client.commands(); len(cmds) != 0 {
+			t.Errorf("body %s: controller commands = %v, want none", body, cmds)
+		}
+	}
+}
+
+func TestDetectedHandlerMinConfidence(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+		body   string
+		acted  bool
+	}{
+		{"below threshold", "/detected", `{"confidence":0.4}`, false},
+		{"at threshold", "/detected", `{"confidence":0.6}`, true},
+		{"no confidence", "/detected", `{"species":"mouse"}`, true},
+		{"no body", "/detected", ``, true},
+		{"lowered per request", "/detected?min_confidence=0.3", `{"confidence":0.4}`, true},
+		{"raised per request", "/detected?min_confidence=0.95", `{"confidence":0.9}`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, startFakeController(t))
+			client := &fakeClient{}
+			s.controller = client
+			s.minConfidence = 0.6
+
+			rec := httptest.NewRecorder()
+			s.detectedHandler(rec, httptest.NewRequest(http.MethodPost, tt.target, strings.NewReader(tt.body)))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, body = %s", rec.Code, rec.Body)
+			}
+			s.unlock.stop()
+
+			var resp struct {
+				Acted bool `json:"acted"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			if resp.Acted != tt.acted {
+				t.Errorf("acted = %v, want %v", resp.Acted, tt.acted)
+			}
+			if sent := len(client.commands()) > 0; sent != tt.acted {
+				t.Errorf("controller commands = %v, want locking %v", client.commands(), tt.acted)
+			}
+
+			events, err := s.history.recent(0)
+			if err != nil || len(events) != 1 {
+				t.Fatalf("history = %v, %v; want the event recorded either way", events, err)
+			}
+			if events[0].BelowConfidence == tt.acted {
+				t.Errorf("below_confidence = %v, want %v", events[0].BelowConfidence, !tt.acted)
+			}
+		})
+	}
+}
+
+func TestDetectedHandlerInvalidMinConfidence(t *testing.T) {
+	s := newTestServer(t, startFakeController(t))
+	rec := httptest.NewRecorder()
+	s.detectedHandler(rec, httptest.NewRequest(http.MethodPost, "/detected?min_confidence=2", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400", rec.Code)
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// idempotencyWindow is how long a detection's response is replayed for a
+// repeated Idempotency-Key
+const idempotencyWindow = 10 * time.Minute
+
+// maxIdempotencyKeyLen bounds the keys we are willing to store
+const maxIdempotencyKeyLen = 255
+
+// storedResponse is a response kept for replay
+type storedResponse struct {
+	status  int
+	header  http.Header
+	body    []byte
+	expires time.Time
+}
+
+// idempotencyEntry is a keyed request, still running until done is closed
+// and then kept for replay if it succeeded
+type idempotencyEntry struct {
+	done chan struct{}
+	resp storedResponse // set before done is closed
+}
+
+// idempotencyCache remembers recent successful responses by method, path
+// and Idempotency-Key, so a key reused on another endpoint runs that
+// endpoint rather than replaying a different one. A retry that arrives
+// while the original still runs waits for it; requests with other keys
+// don't wait at all.
+type idempotencyCache struct {
+	mu      sync.Mutex // guards entries only, never held while a request runs
+	entries map[string]*idempotencyEntry
+}
+
+// bufferedResponse collects a handler's response so it can be stored before
+// being sent.
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponse) Header() http.Header { return b.header }
+
+func (b *bufferedResponse) WriteHeader(status int) {
+	if b.status == 0 {
+		b.status = status
+	}
+}
+
+func (b *bufferedResponse) Write(p []byte) (int, error) {
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+	return b.body.Write(p)
+}
+
+// idempotent replays the stored response when a request repeats the
+// Idempotency-Key of a successful one within idempotencyWindow. Failed
+// responses aren't stored, so a retry after an error runs again.
+func (s *server) idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" {
+			next(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLen {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidRequest, "Idempotency-Key is too long")
+			return
+		}
+
+		c := &s.idempotency
+		id := r.Method + " " + r.URL.Path + " " + key
+		entry, replay := c.claim(id)
+		for replay {
+			select {
+			case <-entry.done:
+			case <-r.Context().Done():
+				return
+			}
+			if entry.resp.status != 0 {
+				break
+			}
+			// A failed original left nothing to replay; this request runs
+			// instead, unless another retry got there first.
+			entry, replay = c.claim(id)
+		}
+		if replay {
+			s.log.Info("replaying response for repeated Idempotency-Key", "key", key, "path", r.URL.Path)
+			writeStored(w, entry.resp, true)
+			return
+		}
+
+		buf := &bufferedResponse{header: make(http.Header)}
+		next(buf, r)
+		resp := storedResponse{status: buf.status, header: buf.header, body: buf.body.Bytes(), expires: time.Now().Add(idempotencyWindow)}
+		if resp.status == 0 {
+			resp.status = http.StatusOK
+		}
+		c.finish(id, entry, resp)
+		writeStored(w, resp, false)
+	}
+}
+
+// claim returns the entry for id and true when there is one, finished or
+// still running. Otherwise it registers a new running entry for the caller
+// and returns it with false. Expired entries are dropped first.
+func (c *idempotencyCache) claim(id string) (*idempotencyEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	for k, e := range c.entries {
+		if e.resp.status != 0 && now.After(e.resp.expires) {
+			delete(c.entries, k)
+		}
+	}
+	if e, ok := c.entries[id]; ok {
+		return e, true
+	}
+	if c.entries == nil {
+		c.entries = make(map[string]*idempotencyEntry)
+	}
+	e := &idempotencyEntry{done: make(chan struct{})}
+	c.entries[id] = e
+	return e, false
+}
+
+// finish completes the running entry for id, keeping resp for replay when
+// it succeeded and forgetting the request otherwise
+func (c *idempotencyCache) finish(id string, e *idempotencyEntry, resp storedResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if resp.status >= 200 && resp.status < 300 {
+		e.resp = resp
+	} else {
+		delete(c.entries, id)
+	}
+	close(e.done)
+}
+
+func writeStored(w http.ResponseWriter, e storedResponse, replayed bool) {
+	for k, v := range e.header {
+		w.Header()[k] = v
+	}
+	if replayed {
+		w.Header().Set("Idempotent-Replayed", "true")
+	}
+	w.WriteHeader(e.status)
+	w.Write(e.body)
+}
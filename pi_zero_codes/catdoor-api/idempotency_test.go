@@ -0,0 +1,137 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestIdempotentDetectionReplays(t *testing.T) {
+	client := &fakeClient{}
+	s := newTestServer(t, startFakeController(t))
+	s.controller = client
+	handler := s.idempotent(s.detectedHandler)
+	defer s.unlock.stop()
+
+	send := func(key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/detected", nil)
+		req.Header.Set("Idempotency-Key", key)
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+
+	first := send("camera-42")
+	second := send("camera-42")
+	if first.Code != http.StatusOK || second.Code != http.StatusOK {
+		t.Fatalf("statuses = %d, %d", first.Code, second.Code)
+	}
+	if first.Body.String() != second.Body.String() {
+		t.Errorf("replayed body = %s, want the original %s", second.Body, first.Body)
+	}
+	if second.Header().Get("Idempotent-Replayed") != "true" || first.Header().Get("Idempotent-Replayed") != "" {
+		t.Error("Idempotent-Replayed header not set on the replay only")
+	}
+	if second.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("replayed Content-Type = %q", second.Header().Get("Content-Type"))
+	}
+	if cmds := client.commands(); len(cmds) != 1 {
+		t.Errorf("controller commands = %v, want a single lock", cmds)
+	}
+
+	send("camera-43")
+	if cmds := client.commands(); len(cmds) != 2 {
+		t.Errorf("controller commands = %v, want a new key to lock again", cmds)
+	}
+}
+
+func TestIdempotentDoesNotStoreFailures(t *testing.T) {
+	client := &fakeClient{err: errors.New("boom")}
+	s := newTestServer(t, startFakeController(t))
+	s.controller = client
+	handler := s.idempotent(s.detectedHandler)
+	defer s.unlock.stop()
+
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest(http.MethodPost, "/detected", nil)
+		req.Header.Set("Idempotency-Key", "retry-me")
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		if rec.Code != http.StatusBadGateway {
+			t.Fatalf("attempt %d: status = %d, want 502", i, rec.Code)
+		}
+	}
+	if cmds := client.commands(); len(cmds) != 2 {
+		t.Errorf("controller commands = %v, want the failed request retried", cmds)
+	}
+}
+
+func TestIdempotentKeyIsPerRoute(t *testing.T) {
+	client := &fakeClient{}
+	s := newTestServer(t, startFakeController(t))
+	s.controller = client
+	defer s.unlock.stop()
+
+	for _, route := range []struct {
+		path    string
+		handler http.HandlerFunc
+	}{
+		{"/detected", s.idempotent(s.detectedHandler)},
+		{"/lock?duration=30m", s.idempotent(s.lockHandler)},
+	} {
+		req := httptest.NewRequest(http.MethodPost, route.path, nil)
+		req.Header.Set("Idempotency-Key", "shared")
+		rec := httptest.NewRecorder()
+		route.handler(rec, req)
+		if rec.Code != http.StatusOK || rec.Header().Get("Idempotent-Replayed") != "" {
+			t.Errorf("%s: status = %d, replayed = %q; want it to run", route.path, rec.Code, rec.Header().Get("Idempotent-Replayed"))
+		}
+	}
+	if cmds := client.commands(); len(cmds) != 2 {
+		t.Errorf("controller commands = %v, want both routes to lock", cmds)
+	}
+}
+
+func TestIdempotentOnlyWaitsForTheSameKey(t *testing.T) {
+	s := &server{log: discardLogger()}
+	release := make(chan struct{})
+	started := make(chan struct{}, 1)
+	var runs atomic.Int32
+	handler := s.idempotent(func(w http.ResponseWriter, r *http.Request) {
+		runs.Add(1)
+		if r.Header.Get("Idempotency-Key") == "slow" {
+			started <- struct{}{}
+			<-release
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	send := func(key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/commands", nil)
+		req.Header.Set("Idempotency-Key", key)
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+
+	slow := make(chan *httptest.ResponseRecorder, 2)
+	go func() { slow <- send("slow") }()
+	<-started
+	go func() { slow <- send("slow") }() // waits for the original
+
+	if rec := send("fast"); rec.Code != http.StatusNoContent {
+		t.Errorf("other key: status = %d", rec.Code)
+	}
+	close(release)
+	first, second := <-slow, <-slow
+	if first.Code != http.StatusNoContent || second.Code != http.StatusNoContent {
+		t.Errorf("statuses = %d, %d", first.Code, second.Code)
+	}
+	if first.Header().Get("Idempotent-Replayed") == second.Header().Get("Idempotent-Replayed") {
+		t.Error("want exactly one of the slow requests replayed")
+	}
+	if got := runs.Load(); got != 2 {
+		t.Errorf("handler ran %d times, want 2 (slow once, fast once)", got)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// unixListenPrefix marks a CATDOOR_LISTEN_ADDR that is a unix socket path,
+// e.g. "unix:/run/catdoor/api.sock", for a dashboard on the same Pi.
+const unixListenPrefix = "unix:"
+
+// unixSocketMode lets the owner and its group (e.g. the dashboard's user)
+// connect, and nobody else.
+const unixSocketMode fs.FileMode = 0660
+
+// unixSocketPath returns the socket path of a unix: listen address
+func unixSocketPath(addr string) (string, bool) {
+	return strings.CutPrefix(addr, unixListenPrefix)
+}
+
+// listen binds addr: a unix socket for a unix: address, TCP otherwise. A
+// socket file left behind by a process that died is replaced, but one that
+// still accepts connections, or a file that isn't a socket, is an error. The
+// socket file is removed again when the listener is closed, which happens on
+// shutdown.
+func listen(addr string) (net.Listener, error) {
+	path, ok := unixSocketPath(addr)
+	if !ok {
+		return net.Listen("tcp", addr)
+	}
+	if err := removeStaleSocket(path); err != nil {
+		return nil, err
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	ln.(*net.UnixListener).SetUnlinkOnClose(true)
+	if err := os.Chmod(path, unixSocketMode); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+// removeStaleSocket removes the socket at path unless something is still
+// listening on it.
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode().Type() != fs.ModeSocket {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+	if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+		conn.Close()
+		return fmt.Errorf("%s is in use by another process", path)
+	}
+	return os.Remove(path)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// lockHandler handles POST /lock?duration=1h, locking the flap by hand
+// (e.g. while the window cleaner is here). It sends RED and arms the same
+// single auto-unlock timer as a detection, so /unlock and restart recovery
+// treat it the same way, but nothing is recorded in the detection history.
+// Unlike /detected, a duration above the maximum is rejected, not capped.
+func (s *server) lockHandler(w http.ResponseWriter, r *http.Request) {
+	s.detectMu.Lock()
+	defer s.detectMu.Unlock()
+
+	value := strings.TrimSpace(r.URL.Query().Get("duration"))
+	if value == "" {
+		writeError(w, r, http.StatusBadRequest, errCodeInvalidRequest, "duration is required (e.g. ?duration=1h)")
+		return
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, errCodeInvalidRequest, fmt.Sprintf("invalid duration %q", value))
+		return
+	}
+	if duration < minLockDuration || duration > s.maxLock {
+		writeError(w, r, http.StatusBadRequest, errCodeInvalidRequest,
+			fmt.Sprintf("duration must be between %s and %s", minLockDuration, s.maxLock))
+		return
+	}
+	if s.maxUnlocks > 0 && s.unlock.inFlight() >= s.maxUnlocks {
+		writeError(w, r, http.StatusServiceUnavailable, errCodeUnavailable, "too many pending unlocks, try again later")
+		return
+	}
+
+	s.log.Info("manual lock", "duration", duration)
+	var resp string
+	_, err = s.unlock.lockAndSchedule(duration, func() error {
+		var err error
+		resp, err = s.setMode("RED", "manual")
+		return err
+	}, func() {
+		s.log.Info("auto-unlocking manual lock", "after", duration)
+		s.autoUnlock()
+	})
+	if err != nil {
+		s.log.Error("failed to lock catflap", "error", err)
+		writeControllerError(w, r, "failed to lock catflap: ", err)
+		return
+	}
+
+	unlockTime := s.now().Add(duration)
+	_, err = s.config.updateState(func(config *Config) {
+		config.LockedUntil = unlockTime.Format(time.RFC3339)
+		config.LockedBy = ""
+	})
+	persisted := err == nil
+	if !persisted {
+		s.log.Error("failed to save lock; it will not survive a restart", "config", s.config.path, "error", err)
+	}
+	s.log.Info("catflap locked", "locked_until", unlockTime.Format(time.RFC3339))
+
+	if s.webhook != nil {
+		s.webhook.notify(map[string]interface{}{
+			"event":        "manual_lock",
+			"mode":         "RED",
+			"locked_until": unlockTime.Format(time.RFC3339),
+			"duration":     duration.String(),
+			"controller":   strings.TrimSpace(resp),
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":       "locked",
+		"mode":         "RED",
+		"locked_until": unlockTime.Format(time.RFC3339),
+		"duration":     duration.String(),
+		"persisted":    persisted,
+		"controller":   strings.TrimSpace(resp),
+	})
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLockHandler(t *testing.T) {
+	fc := startFakeController(t)
+	s := newTestServer(t, fc)
+	defer s.unlock.stop()
+
+	before := time.Now()
+	rec := httptest.NewRecorder()
+	s.lockHandler(rec, httptest.NewRequest(http.MethodPost, "/lock?duration=30m", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body)
+	}
+	assertLockedFor(t, rec, before, 30*time.Minute)
+
+	if cmds := fc.commands(); len(cmds) != 1 || cmds[0] != "RED" {
+		t.Errorf("controller commands = %v, want [RED]", cmds)
+	}
+	if !s.unlock.pending() {
+		t.Error("no unlock pending")
+	}
+	config, err := s.config.load()
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+	if config.LockedUntil == "" || config.LastDetected != "" {
+		t.Errorf("config = %+v, want locked_until set and no last_detected", config)
+	}
+	if events, err := s.history.recent(10); err != nil || len(events) != 0 {
+		t.Errorf("history = %v (%v), want no detection recorded", events, err)
+	}
+
+	// It is released like any other lock.
+	rec = httptest.NewRecorder()
+	s.unlockHandler(rec, httptest.NewRequest(http.MethodPost, "/unlock", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unlock status = %d, body = %s", rec.Code, rec.Body)
+	}
+	if s.unlock.pending() {
+		t.Error("unlock still pending after /unlock")
+	}
+}
+
+func TestLockHandlerRejectsDuration(t *testing.T) {
+	fc := startFakeController(t)
+	s := newTestServer(t, fc)
+
+	for _, query := range []string{"", "?duration=soon", "?duration=100ms", "?duration=2h"} {
+		rec := httptest.NewRecorder()
+		s.lockHandler(rec, httptest.NewRequest(http.MethodPost, "/lock"+query, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%q: status = %d, want 400", query, rec.Code)
+		}
+	}
+	if cmds := fc.commands(); len(cmds) != 0 {
+		t.Errorf("controller commands = %v, want none", cmds)
+	}
+}
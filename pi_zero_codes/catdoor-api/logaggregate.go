@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// logBucket is one bar of an aggregated /logs histogram
+type logBucket struct {
+	Bucket string `json:"bucket"` // start of the hour or day, RFC3339 in CATDOOR_TZ
+	Count  int    `json:"count"`
+}
+
+// parseLogAggregate reads the aggregate query parameter: "" for raw
+// entries, or hour or day
+func parseLogAggregate(query url.Values) (string, error) {
+	switch aggregate := strings.ToLower(query.Get("aggregate")); aggregate {
+	case "", "hour", "day":
+		return aggregate, nil
+	default:
+		return "", fmt.Errorf("invalid aggregate %q (use hour or day)", query.Get("aggregate"))
+	}
+}
+
+// bucketStart returns the start of the hour or local day holding t
+func bucketStart(t time.Time, aggregate string, loc *time.Location) time.Time {
+	t = t.In(loc)
+	if aggregate == "day" {
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, loc)
+}
+
+// aggregateLogEntries counts entries per hour or day, oldest bucket first.
+// Only buckets with entries are listed. Entries whose timestamp didn't
+// parse can't be placed and are returned as excluded instead.
+func aggregateLogEntries(entries []logEntry, aggregate string, loc *time.Location) (buckets []logBucket, excluded int) {
+	counts := map[time.Time]int{}
+	for _, e := range entries {
+		if e.ParseError {
+			excluded++
+			continue
+		}
+		counts[bucketStart(e.time, aggregate, loc)]++
+	}
+	starts := slices.SortedFunc(maps.Keys(counts), time.Time.Compare)
+	buckets = make([]logBucket, 0, len(starts))
+	for _, start := range starts {
+		buckets = append(buckets, logBucket{Bucket: start.Format(time.RFC3339), Count: counts[start]})
+	}
+	return buckets, excluded
+}
+
+// writeLogAggregate answers an aggregated /logs request
+func writeLogAggregate(w http.ResponseWriter, entries []logEntry, aggregate string, loc *time.Location) {
+	buckets, excluded := aggregateLogEntries(entries, aggregate, loc)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"aggregate": aggregate,
+		"buckets":   buckets,
+		"total":     len(entries) - excluded,
+		"excluded":  excluded,
+	})
+}
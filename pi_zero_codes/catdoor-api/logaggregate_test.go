@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestLogsHandlerAggregate(t *testing.T) {
+	s := newTestServer(t, startFakeController(t))
+	reed := "2025-01-01 10:00:05 Flap LOCKED\n" +
+		"2025-01-01 10:59:59 Flap open 2.00s\n" +
+		"yesterday at-noon Flap open 1.00s\n" +
+		"2025-01-01 23:30:00 Flap open 1.50s\n" +
+		"2025-01-02 00:10:00 Flap LOCKED\n"
+	if err := os.WriteFile(s.reedLog, []byte(reed), 0644); err != nil {
+		t.Fatalf("write log: %v", err)
+	}
+	radar := "[2025-01-01 10:30:00] motion\n"
+	if err := os.WriteFile(s.radarLog, []byte(radar), 0644); err != nil {
+		t.Fatalf("write log: %v", err)
+	}
+
+	type result struct {
+		Aggregate string      `json:"aggregate"`
+		Buckets   []logBucket `json:"buckets"`
+		Total     int         `json:"total"`
+		Excluded  int         `json:"excluded"`
+	}
+	tests := []struct {
+		query string
+		loc   *time.Location
+		want  result
+	}{
+		{"type=reed&aggregate=hour", time.UTC, result{"hour", []logBucket{
+			{"2025-01-01T10:00:00Z", 2}, {"2025-01-01T23:00:00Z", 1}, {"2025-01-02T00:00:00Z", 1},
+		}, 4, 1}},
+		{"type=all&aggregate=day", time.UTC, result{"day", []logBucket{
+			{"2025-01-01T00:00:00Z", 4}, {"2025-01-02T00:00:00Z", 1},
+		}, 5, 1}},
+		// Days are local: in UTC+1 the 23:30 entry falls on the 2nd.
+		{"type=reed&aggregate=day", time.FixedZone("CET", 3600), result{"day", []logBucket{
+			{"2025-01-01T00:00:00+01:00", 2}, {"2025-01-02T00:00:00+01:00", 2},
+		}, 4, 1}},
+		// A range drops the unparseable line before it is counted.
+		{"type=reed&aggregate=hour&from=2025-01-01T10:30:00Z&to=2025-01-01T23:59:59Z", time.UTC, result{"hour", []logBucket{
+			{"2025-01-01T10:00:00Z", 1}, {"2025-01-01T23:00:00Z", 1},
+		}, 2, 0}},
+		{"type=reed&aggregate=hour&q=nothing", time.UTC, result{"hour", []logBucket{}, 0, 0}},
+	}
+	for _, tt := range tests {
+		s.loc = tt.loc
+		rec := httptest.NewRecorder()
+		s.logsHandler(rec, httptest.NewRequest(http.MethodGet, "/logs?"+url.PathEscape(tt.query), nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d, body = %s", tt.query, rec.Code, rec.Body)
+		}
+		var got result
+		if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+			t.Fatalf("%s: decode: %v", tt.query, err)
+		}
+		if got.Aggregate != tt.want.Aggregate || !slices.Equal(got.Buckets, tt.want.Buckets) || got.Total != tt.want.Total || got.Excluded != tt.want.Excluded {
+			t.Errorf("%s: got %+v, want %+v", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestLogsHandlerAggregateInvalid(t *testing.T) {
+	s := newTestServer(t, startFakeController(t))
+	for _, query := range []string{"type=reed&aggregate=week", "type=reed&aggregate=hour&tail=5"} {
+		rec := httptest.NewRecorder()
+		s.logsHandler(rec, httptest.NewRequest(http.MethodGet, "/logs?"+query, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", query, rec.Code)
+		}
+	}
+}
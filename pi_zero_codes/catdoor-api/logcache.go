@@ -0,0 +1,63 @@
+package main
+
+import (
+	"os"
+	"sync"
+	"time"
+)
+
+// logCache keeps the parsed contents of each log file so a dashboard polling
+// /logs doesn't re-parse an unchanged file on every request. An entry is
+// reused only while the file's identity, size and modification time are what
+// they were when it was parsed, so appends, truncation and rotation all
+// invalidate it.
+type logCache struct {
+	mu    sync.Mutex
+	files map[string]*cachedLog
+}
+
+type cachedLog struct {
+	info     os.FileInfo
+	input    string // the format the entries were parsed as
+	entries  []logEntry
+	parsedAt time.Time
+}
+
+// entries returns every parsed entry of the log at path, read in input, and
+// how long ago it was parsed. The returned slice is shared and must not be
+// modified.
+func (c *logCache) entries(path, logType, input string, now time.Time) ([]logEntry, time.Duration, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		c.forget(path)
+		return nil, 0, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if cached, ok := c.files[path]; ok && cached.input == input && sameLogFile(cached.info, info) {
+		return cached.entries, now.Sub(cached.parsedAt), nil
+	}
+
+	entries, err := parseLogFile(path, logType, input)
+	if err != nil {
+		return nil, 0, err
+	}
+	if c.files == nil {
+		c.files = map[string]*cachedLog{}
+	}
+	c.files[path] = &cachedLog{info: info, input: input, entries: entries, parsedAt: now}
+	return entries, 0, nil
+}
+
+// forget drops the cached entries for path
+func (c *logCache) forget(path string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.files, path)
+}
+
+// sameLogFile reports whether b describes the same, unchanged file as a
+func sameLogFile(a, b os.FileInfo) bool {
+	return os.SameFile(a, b) && a.Size() == b.Size() && a.ModTime().Equal(b.ModTime())
+}
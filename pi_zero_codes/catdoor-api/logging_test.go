@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewLogger(t *testing.T) {
+	var out bytes.Buffer
+	log, err := newLogger(&out, "text", "warn", time.UTC)
+	if err != nil {
+		t.Fatalf("newLogger: %v", err)
+	}
+	log.Info("hidden")
+	log.Warn("failed to save config", "error", "disk full")
+
+	got := out.String()
+	if strings.Contains(got, "hidden") {
+		t.Errorf("info message logged at warn level: %q", got)
+	}
+	if !strings.Contains(got, `level=WARN msg="failed to save config" error="disk full"`) {
+		t.Errorf("warn output = %q", got)
+	}
+
+	for _, tt := range []struct{ format, level string }{{"xml", "info"}, {"text", "loud"}} {
+		if _, err := newLogger(&out, tt.format, tt.level, time.UTC); err == nil {
+			t.Errorf("newLogger(%q, %q) succeeded, want an error", tt.format, tt.level)
+		}
+	}
+}
+
+func TestNewLoggerTimezone(t *testing.T) {
+	loc, err := time.LoadLocation("Asia/Kolkata")
+	if err != nil {
+		t.Skipf("no tzdata: %v", err)
+	}
+	var out bytes.Buffer
+	log, err := newLogger(&out, "json", "info", loc)
+	if err != nil {
+		t.Fatalf("newLogger: %v", err)
+	}
+	log.Info("catflap locked")
+	if !strings.Contains(out.String(), `+05:30"`) {
+		t.Errorf("log time not in Asia/Kolkata: %q", out.String())
+	}
+}
@@ -0,0 +1,150 @@
+// Package logparse parses the lines written by the Pi Zero sensor scripts:
+// the reed switch log ("2006-01-02 15:04:05 message"), the radar sensor
+// log ("[timestamp] message") and structured NDJSON logs of either.
+package logparse
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Layouts are the timestamp formats ParseTimestamp accepts. The sensor
+// scripts write local time without a zone.
+var Layouts = []string{
+	time.RFC3339,
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05",
+}
+
+// LogEntry is one parsed log line. Timestamp is zero when RawTimestamp isn't
+// in one of Layouts.
+type LogEntry struct {
+	Timestamp    time.Time
+	RawTimestamp string
+	Message      string
+	Fields       map[string]any // the other keys of an NDJSON line; nil otherwise
+}
+
+// NDJSON keys holding the timestamp and message, the first present winning
+var (
+	TimestampKeys = []string{"timestamp", "time", "ts"}
+	MessageKeys   = []string{"message", "msg"}
+)
+
+// ParseTimestamp parses value in any of Layouts, reading zone-less values as
+// local time. Fractional seconds are accepted after the seconds field.
+func ParseTimestamp(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	for _, layout := range Layouts {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognized timestamp %q", value)
+}
+
+// ParseReedLine parses a reed log line: a date and a time-of-day field
+// followed by the message. It reports false for blank lines and lines
+// without a message.
+func ParseReedLine(line string) (LogEntry, bool) {
+	date, rest := cutField(line)
+	clock, rest := cutField(rest)
+	message := strings.TrimSpace(rest)
+	if date == "" || clock == "" || message == "" {
+		return LogEntry{}, false
+	}
+	return newEntry(date+" "+clock, message), true
+}
+
+// ParseRadarLine parses a radar log line: a bracketed timestamp followed by
+// the message. It reports false for blank lines, unbalanced or empty
+// brackets, and lines without a message.
+func ParseRadarLine(line string) (LogEntry, bool) {
+	line = strings.TrimSpace(line)
+	if !strings.HasPrefix(line, "[") {
+		return LogEntry{}, false
+	}
+	end := strings.Index(line, "]")
+	if end < 0 {
+		return LogEntry{}, false
+	}
+	timestamp := strings.TrimSpace(line[1:end])
+	message := strings.TrimSpace(line[end+1:])
+	if timestamp == "" || message == "" {
+		return LogEntry{}, false
+	}
+	return newEntry(timestamp, message), true
+}
+
+// ParseNDJSONLine parses a line of a structured log: a JSON object with the
+// time under one of TimestampKeys and the text under one of MessageKeys. A
+// string timestamp is parsed as by ParseTimestamp and a number as Unix
+// seconds. Every other key is passed through in Fields, numbers kept as
+// written. It reports false for blank lines and lines that aren't an object.
+func ParseNDJSONLine(line string) (LogEntry, bool) {
+	dec := json.NewDecoder(strings.NewReader(line))
+	dec.UseNumber()
+	var fields map[string]any
+	if err := dec.Decode(&fields); err != nil || fields == nil || dec.More() {
+		return LogEntry{}, false
+	}
+
+	var entry LogEntry
+	for _, key := range TimestampKeys {
+		value, ok := fields[key]
+		if !ok {
+			continue
+		}
+		delete(fields, key)
+		switch v := value.(type) {
+		case string:
+			entry = newEntry(v, "")
+		case json.Number:
+			entry.RawTimestamp = v.String()
+			if secs, err := strconv.ParseFloat(v.String(), 64); err == nil {
+				entry.Timestamp = time.UnixMicro(int64(secs * 1e6))
+			}
+		default:
+			raw, _ := json.Marshal(v)
+			entry.RawTimestamp = string(bytes.TrimSpace(raw))
+		}
+		break
+	}
+	for _, key := range MessageKeys {
+		if value, ok := fields[key]; ok {
+			delete(fields, key)
+			if s, ok := value.(string); ok {
+				entry.Message = strings.TrimSpace(s)
+			} else {
+				raw, _ := json.Marshal(value)
+				entry.Message = string(raw)
+			}
+			break
+		}
+	}
+	if len(fields) > 0 {
+		entry.Fields = fields
+	}
+	return entry, true
+}
+
+func newEntry(raw, message string) LogEntry {
+	entry := LogEntry{RawTimestamp: raw, Message: message}
+	if t, err := ParseTimestamp(raw); err == nil {
+		entry.Timestamp = t
+	}
+	return entry
+}
+
+// cutField splits off the first whitespace-separated field of s
+func cutField(s string) (field, rest string) {
+	s = strings.TrimLeft(s, " \t")
+	if i := strings.IndexAny(s, " \t"); i >= 0 {
+		return s[:i], s[i+1:]
+	}
+	return strings.TrimSpace(s), ""
+}
@@ -0,0 +1,134 @@
+package logparse
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+type lineTest struct {
+	line    string
+	ok      bool
+	raw     string
+	message string
+	parsed  bool
+}
+
+func runLineTests(t *testing.T, parse func(string) (LogEntry, bool), tests []lineTest) {
+	t.Helper()
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			entry, ok := parse(tt.line)
+			if ok != tt.ok {
+				t.Fatalf("ok = %v, want %v (entry %+v)", ok, tt.ok, entry)
+			}
+			if !ok {
+				return
+			}
+			if entry.RawTimestamp != tt.raw {
+				t.Errorf("raw timestamp = %q, want %q", entry.RawTimestamp, tt.raw)
+			}
+			if entry.Message != tt.message {
+				t.Errorf("message = %q, want %q", entry.Message, tt.message)
+			}
+			if parsed := !entry.Timestamp.IsZero(); parsed != tt.parsed {
+				t.Errorf("timestamp parsed = %v, want %v", parsed, tt.parsed)
+			}
+		})
+	}
+}
+
+func TestParseReedLine(t *testing.T) {
+	runLineTests(t, ParseReedLine, []lineTest{
+		{"2025-06-01 21:14:03 Flap open 1.52s", true, "2025-06-01 21:14:03", "Flap open 1.52s", true},
+		{"2025-06-01 21:14:03 Flap open 12.00s\n", true, "2025-06-01 21:14:03", "Flap open 12.00s", true},
+		{"  2025-06-01 21:14:03 Flap open 1.00s  ", true, "2025-06-01 21:14:03", "Flap open 1.00s", true},
+		{"2025-06-01  21:14:03   Flap open 3.10s", true, "2025-06-01 21:14:03", "Flap open 3.10s", true},
+		{"2025-06-01\t21:14:03\tFlap open 1.10s", true, "2025-06-01 21:14:03", "Flap open 1.10s", true},
+		{"2025-06-01 21:14:03 Flap  open  2s", true, "2025-06-01 21:14:03", "Flap  open  2s", true},
+		{"2025-06-01 21:14:03.250 Flap open 1.00s", true, "2025-06-01 21:14:03.250", "Flap open 1.00s", true},
+		{"06/01/2025 21:14 Flap open 1.00s", true, "06/01/2025 21:14", "Flap open 1.00s", false},
+		{"2025-13-01 21:14:03 Flap open 1.00s", true, "2025-13-01 21:14:03", "Flap open 1.00s", false},
+		{"2025-06-01 21:14:03", false, "", "", false},
+		{"2025-06-01 21:14:03   ", false, "", "", false},
+		{"2025-06-01", false, "", "", false},
+		{"", false, "", "", false},
+		{"   ", false, "", "", false},
+	})
+}
+
+func TestParseRadarLine(t *testing.T) {
+	runLineTests(t, ParseRadarLine, []lineTest{
+		{"[2025-06-01 21:14:03] presence detected", true, "2025-06-01 21:14:03", "presence detected", true},
+		{"[2025-06-01 21:14:03.123456] moving target 1.2m", true, "2025-06-01 21:14:03.123456", "moving target 1.2m", true},
+		{"[2025-06-01T21:14:03Z] still target", true, "2025-06-01T21:14:03Z", "still target", true},
+		{"[2025-06-01T21:14:03+02:00] still target", true, "2025-06-01T21:14:03+02:00", "still target", true},
+		{"[ 2025-06-01 21:14:03 ]   no target  ", true, "2025-06-01 21:14:03", "no target", true},
+		{"  [2025-06-01 21:14:03] presence\n", true, "2025-06-01 21:14:03", "presence", true},
+		{"[2025-06-01 21:14:03] [zone 2] presence", true, "2025-06-01 21:14:03", "[zone 2] presence", true},
+		{"[boot] sensor online", true, "boot", "sensor online", false},
+		{"[2025-06-01 21:14:03]presence", true, "2025-06-01 21:14:03", "presence", true},
+		{"[2025-06-01 21:14:03 presence", false, "", "", false},
+		{"2025-06-01 21:14:03] presence", false, "", "", false},
+		{"[] presence", false, "", "", false},
+		{"[2025-06-01 21:14:03]", false, "", "", false},
+		{"[2025-06-01 21:14:03]    ", false, "", "", false},
+		{"", false, "", "", false},
+	})
+}
+
+func TestParseNDJSONLine(t *testing.T) {
+	runLineTests(t, ParseNDJSONLine, []lineTest{
+		{`{"timestamp":"2025-06-01 21:14:03","message":"presence detected"}`, true, "2025-06-01 21:14:03", "presence detected", true},
+		{`{"time":"2025-06-01T21:14:03Z","msg":" Flap open 1.52s "}`, true, "2025-06-01T21:14:03Z", "Flap open 1.52s", true},
+		{`{"ts":1748812443.5,"message":"still target"}`, true, "1748812443.5", "still target", true},
+		{`{"timestamp":"boot","message":"sensor online"}` + "\r", true, "boot", "sensor online", false},
+		{`{"message":"no time"}`, true, "", "no time", false},
+		{`{"timestamp":"2025-06-01 21:14:03","message":{"zone":2}}`, true, "2025-06-01 21:14:03", `{"zone":2}`, true},
+		{`[2025-06-01 21:14:03] presence`, false, "", "", false},
+		{`["timestamp","message"]`, false, "", "", false},
+		{`{"message":"a"} {"message":"b"}`, false, "", "", false},
+		{`{"message":`, false, "", "", false},
+		{`null`, false, "", "", false},
+		{"", false, "", "", false},
+	})
+
+	entry, _ := ParseNDJSONLine(`{"timestamp":"2025-06-01 21:14:03","time":"ignored","message":"hi","distance":1.20,"zone":"hall"}`)
+	if len(entry.Fields) != 3 || entry.Fields["time"] != "ignored" || entry.Fields["zone"] != "hall" {
+		t.Errorf("fields = %v, want time, distance and zone passed through", entry.Fields)
+	}
+	if d, ok := entry.Fields["distance"].(json.Number); !ok || d.String() != "1.20" {
+		t.Errorf("distance = %#v, want the number as written", entry.Fields["distance"])
+	}
+	if entry, _ := ParseNDJSONLine(`{"ts":1748812443}`); !entry.Timestamp.Equal(time.Unix(1748812443, 0)) {
+		t.Errorf("unix timestamp = %s", entry.Timestamp)
+	}
+}
+
+func TestParseTimestamp(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Time
+	}{
+		{"2025-06-01 21:14:03", time.Date(2025, 6, 1, 21, 14, 3, 0, time.Local)},
+		{"2025-06-01T21:14:03", time.Date(2025, 6, 1, 21, 14, 3, 0, time.Local)},
+		{"2025-06-01T21:14:03Z", time.Date(2025, 6, 1, 21, 14, 3, 0, time.UTC)},
+		{" 2025-06-01 21:14:03.5 ", time.Date(2025, 6, 1, 21, 14, 3, 5e8, time.Local)},
+	}
+	for _, tt := range tests {
+		got, err := ParseTimestamp(tt.value)
+		if err != nil {
+			t.Errorf("ParseTimestamp(%q): %v", tt.value, err)
+			continue
+		}
+		if !got.Equal(tt.want) {
+			t.Errorf("ParseTimestamp(%q) = %s, want %s", tt.value, got, tt.want)
+		}
+	}
+
+	for _, value := range []string{"", "yesterday", "2025-06-01", "21:14:03"} {
+		if _, err := ParseTimestamp(value); err == nil {
+			t.Errorf("ParseTimestamp(%q) succeeded, want an error", value)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// Defaults for the service log file set by CATDOOR_LOG_FILE. With the
+// defaults the logs use at most about 60 MiB of the SD card.
+const defaultLogMaxBytes = 10 << 20
+const defaultLogMaxFiles = 5
+
+// rotatingFile is the service log when it is written to a file. Once a write
+// would take the file past maxBytes it is renamed to path+".1", older files
+// move up one (path+".1" to path+".2" and so on) and the one past maxFiles
+// is removed. It is safe for concurrent use.
+type rotatingFile struct {
+	path     string
+	maxBytes int64
+	maxFiles int // rotated files kept besides path
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+// openRotatingFile opens path for appending, creating it if needed
+func openRotatingFile(path string, maxBytes int64, maxFiles int) (*rotatingFile, error) {
+	rf := &rotatingFile{path: path, maxBytes: maxBytes, maxFiles: maxFiles}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+func (rf *rotatingFile) open() error {
+	f, err := os.OpenFile(rf.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	rf.f, rf.size = f, info.Size()
+	return nil
+}
+
+// Write appends p, rotating first if p would not fit. A single record larger
+// than maxBytes still goes into a file of its own rather than being split.
+func (rf *rotatingFile) Write(p []byte) (int, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if rf.f == nil {
+		return 0, os.ErrClosed
+	}
+	if rf.size > 0 && rf.size+int64(len(p)) > rf.maxBytes {
+		if err := rf.rotate(); err != nil {
+			// Keep logging to path rather than losing records; the next
+			// write tries the rotation again.
+			fmt.Fprintf(os.Stderr, "catdoor-api: %v\n", err)
+			if rf.f == nil {
+				if err := rf.open(); err != nil {
+					return 0, err
+				}
+			}
+		}
+	}
+	n, err := rf.f.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+// rotate shifts the old files up and starts an empty path. Callers hold mu.
+func (rf *rotatingFile) rotate() error {
+	if err := rf.f.Close(); err != nil {
+		return fmt.Errorf("rotate log: %w", err)
+	}
+	rf.f = nil
+
+	if rf.maxFiles == 0 {
+		if err := os.Remove(rf.path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("rotate log: %w", err)
+		}
+	} else {
+		for i := rf.maxFiles - 1; i >= 1; i-- {
+			err := os.Rename(rf.rotated(i), rf.rotated(i+1))
+			if err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("rotate log: %w", err)
+			}
+		}
+		if err := os.Rename(rf.path, rf.rotated(1)); err != nil {
+			return fmt.Errorf("rotate log: %w", err)
+		}
+	}
+	return rf.open()
+}
+
+// rotated returns the name of the i-th rotated file, 1 being the newest
+func (rf *rotatingFile) rotated(i int) string {
+	return fmt.Sprintf("%s.%d", rf.path, i)
+}
+
+// Close closes the current file. Later writes fail with os.ErrClosed.
+func (rf *rotatingFile) Close() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if rf.f == nil {
+		return nil
+	}
+	err := rf.f.Close()
+	rf.f = nil
+	return err
+}
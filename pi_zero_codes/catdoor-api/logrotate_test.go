@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "catdoor.log")
+	rf, err := openRotatingFile(path, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"one\n", "two\n", "three\n", "four\n", "five\n", "six\n"} {
+		if _, err := rf.Write([]byte(line)); err != nil {
+			t.Fatalf("write %q: %v", line, err)
+		}
+	}
+	if err := rf.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Each rotation moves the older files up one; the first file ("one",
+	// "two") fell off the end when "six" rotated a third time.
+	want := map[string]string{
+		path:        "six\n",
+		path + ".1": "four\nfive\n",
+		path + ".2": "three\n",
+	}
+	for name, content := range want {
+		b, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != content {
+			t.Errorf("%s = %q, want %q", filepath.Base(name), b, content)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("%s.3 exists (err = %v), want only 2 rotated files", filepath.Base(path), err)
+	}
+
+	if _, err := rf.Write([]byte("late\n")); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("write after close: err = %v, want os.ErrClosed", err)
+	}
+}
+
+func TestRotatingFileAppendsToExisting(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "catdoor.log")
+	if err := os.WriteFile(path, []byte("earlier\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	rf, err := openRotatingFile(path, 12, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rf.Close()
+
+	// The existing size counts toward the limit, so the first write
+	// rotates; with no rotated files kept the old content is dropped.
+	rf.Write([]byte("next\n"))
+	rf.Write([]byte("after\n"))
+	b, _ := os.ReadFile(path)
+	if string(b) != "next\nafter\n" {
+		t.Errorf("log = %q, want only the records after the rotation", b)
+	}
+	if _, err := os.Stat(path + ".1"); !os.IsNotExist(err) {
+		t.Errorf("rotated file kept with CATDOOR_LOG_MAX_FILES=0 (err = %v)", err)
+	}
+}
+
+func TestLogFileFromEnv(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "catdoor.log")
+	t.Setenv("CATDOOR_CONFIG_PATH", filepath.Join(t.TempDir(), "config.json"))
+	t.Setenv("CATDOOR_LOG_FILE", path)
+	s, err := newServerFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.logFile.Close()
+
+	s.log.Info("catflap locked")
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), `msg="catflap locked"`) {
+		t.Errorf("log file = %q, want the record", b)
+	}
+}
@@ -0,0 +1,643 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"catdoor-api/logparse"
+)
+
+const defaultReedLogPath = "/home/rami/logs/reed_logs.txt"
+const defaultRadarLogPath = "/home/rami/logs/sensor_logs.txt"
+
+// defaultLogLimit caps a /logs response when no limit is given
+const defaultLogLimit = 500
+
+// maxLogLimit is the largest page a client may ask for
+const maxLogLimit = 5000
+
+// tailChunkSize is how much of the file tailLogEntries reads per step
+const tailChunkSize = 4096
+
+// errCorruptLog marks a compressed log that couldn't be decompressed
+var errCorruptLog = errors.New("corrupt gzip data")
+
+// logEntry is one parsed log line as returned by /logs. Timestamp is the raw
+// timestamp normalized to RFC3339, or null with ParseError set when the raw
+// value isn't in a known format.
+type logEntry struct {
+	Timestamp    *string `json:"timestamp"`
+	RawTimestamp string  `json:"raw_timestamp"`
+	Message      string  `json:"message"`
+	Source       string  `json:"source"`
+	Severity     string  `json:"severity"` // inferred from the message by CATDOOR_LOG_SEVERITY_RULES
+	ParseError   bool    `json:"parse_error,omitempty"`
+
+	// Fields are the other keys of an NDJSON log line, passed through
+	Fields map[string]any `json:"fields,omitempty"`
+
+	time time.Time // zero when ParseError is set
+	raw  string    // the line as read, for text/plain responses
+}
+
+// logPage selects which parsed entries a /logs request returns. When tail is
+// set it wins over limit and offset.
+type logPage struct {
+	limit  int
+	offset int
+	tail   int
+}
+
+// parseLogPage reads the limit, offset and tail query parameters
+func parseLogPage(query url.Values) (logPage, error) {
+	var page logPage
+	var err error
+	if page.limit, err = queryInt(query, "limit", defaultLogLimit, 1, maxLogLimit); err != nil {
+		return logPage{}, err
+	}
+	if page.offset, err = queryInt(query, "offset", 0, 0, math.MaxInt); err != nil {
+		return logPage{}, err
+	}
+	if page.tail, err = queryInt(query, "tail", 0, 1, maxLogLimit); err != nil {
+		return logPage{}, err
+	}
+	return page, nil
+}
+
+// queryInt parses an integer query parameter within [lo, hi], returning def
+// when it is absent.
+func queryInt(query url.Values, name string, def, lo, hi int) (int, error) {
+	value := query.Get(name)
+	if value == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < lo || n > hi {
+		return 0, fmt.Errorf("invalid %s %q", name, value)
+	}
+	return n, nil
+}
+
+// logFilter restricts entries to a time range and, with q set, to messages
+// containing q, and with severities set to messages rules classify as one
+// of them. A zero bound is open.
+type logFilter struct {
+	from time.Time
+	to   time.Time
+	q    string // lower-cased
+
+	severities []string
+	rules      []severityRule
+}
+
+// parseLogFilter reads the from, to, q and severity query parameters. The
+// caller sets the severity rules.
+func parseLogFilter(query url.Values) (logFilter, error) {
+	filter := logFilter{q: strings.ToLower(strings.TrimSpace(query.Get("q")))}
+	severities, err := parseSeverities(query.Get("severity"))
+	if err != nil {
+		return logFilter{}, err
+	}
+	filter.severities = severities
+	for _, p := range []struct {
+		name string
+		dst  *time.Time
+	}{
+		{"from", &filter.from},
+		{"to", &filter.to},
+	} {
+		value := query.Get(p.name)
+		if value == "" {
+			continue
+		}
+		t, err := logparse.ParseTimestamp(value)
+		if err != nil {
+			return logFilter{}, fmt.Errorf("invalid %s: %w", p.name, err)
+		}
+		*p.dst = t
+	}
+	if !filter.from.IsZero() && !filter.to.IsZero() && filter.to.Before(filter.from) {
+		return logFilter{}, fmt.Errorf("to is before from")
+	}
+	return filter, nil
+}
+
+// match reports whether entry falls within the range, both ends inclusive,
+// and its message contains q, ignoring case. When a range is set, entries
+// whose timestamp doesn't parse are dropped.
+func (f logFilter) match(entry logEntry) bool {
+	if f.q != "" && !strings.Contains(strings.ToLower(entry.Message), f.q) {
+		return false
+	}
+	if len(f.severities) > 0 && !slices.Contains(f.severities, severityOf(f.rules, entry.Message)) {
+		return false
+	}
+	if f.from.IsZero() && f.to.IsZero() {
+		return true
+	}
+	if entry.ParseError {
+		return false
+	}
+	if !f.from.IsZero() && entry.time.Before(f.from) {
+		return false
+	}
+	if !f.to.IsZero() && entry.time.After(f.to) {
+		return false
+	}
+	return true
+}
+
+// paginate returns the page of entries starting at offset
+func paginate(entries []logEntry, offset, limit int) []logEntry {
+	if offset >= len(entries) {
+		return []logEntry{}
+	}
+	entries = entries[offset:]
+	if len(entries) > limit {
+		entries = entries[:limit]
+	}
+	return entries
+}
+
+// Log file formats /logs can read. The reed and radar logs are text by
+// default; a log whose name ends in .ndjson or .jsonl (before any rotation
+// or .gz suffix) is read as NDJSON, as is any log with ?input=ndjson.
+const (
+	logInputText   = "text"
+	logInputNDJSON = "ndjson"
+)
+
+// parseLogInput reads the input query parameter; "" means detect from the
+// file name.
+func parseLogInput(query url.Values) (string, error) {
+	switch input := strings.ToLower(query.Get("input")); input {
+	case "", logInputText, logInputNDJSON:
+		return input, nil
+	default:
+		return "", fmt.Errorf("invalid input %q (use text or ndjson)", query.Get("input"))
+	}
+}
+
+// logInputFor returns the format of the log at path: input when set,
+// otherwise the one its name implies
+func logInputFor(path, input string) string {
+	if input != "" {
+		return input
+	}
+	name := strings.TrimSuffix(path, ".gz")
+	if ext := filepath.Ext(name); len(ext) > 1 && strings.Trim(ext[1:], "0123456789") == "" {
+		name = strings.TrimSuffix(name, ext)
+	}
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".ndjson", ".jsonl":
+		return logInputNDJSON
+	}
+	return logInputText
+}
+
+// parseLogLine parses one line of a reed or radar log in the given input
+// format. Blank and malformed lines are reported as not ok; a line whose
+// timestamp can't be parsed is still returned, flagged with ParseError.
+func parseLogLine(logType, input, line string) (logEntry, bool) {
+	var parsed logparse.LogEntry
+	var ok bool
+	switch {
+	case input == logInputNDJSON:
+		parsed, ok = logparse.ParseNDJSONLine(line)
+	case logType == "reed":
+		parsed, ok = logparse.ParseReedLine(line)
+	case logType == "radar":
+		parsed, ok = logparse.ParseRadarLine(line)
+	}
+	if !ok {
+		return logEntry{}, false
+	}
+
+	entry := logEntry{
+		RawTimestamp: parsed.RawTimestamp,
+		Message:      parsed.Message,
+		Source:       logType,
+		Fields:       parsed.Fields,
+		time:         parsed.Timestamp,
+		raw:          strings.TrimSuffix(line, "\r"),
+	}
+	if parsed.Timestamp.IsZero() {
+		entry.ParseError = true
+	} else {
+		normalized := parsed.Timestamp.Format(time.RFC3339)
+		entry.Timestamp = &normalized
+	}
+	return entry, true
+}
+
+// inZone returns entry with its normalized timestamp expressed in loc
+func (e logEntry) inZone(loc *time.Location) logEntry {
+	if e.Timestamp != nil {
+		normalized := e.time.In(loc).Format(time.RFC3339)
+		e.Timestamp = &normalized
+	}
+	return e
+}
+
+// readLogEntries parses every line of a log file that matches filter,
+// dropping the others as it goes
+func readLogEntries(path, logType, input string, filter logFilter) ([]logEntry, error) {
+	content, err := readLogFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	logs := []logEntry{}
+	for _, line := range strings.Split(string(content), "\n") {
+		if entry, ok := parseLogLine(logType, input, line); ok && filter.match(entry) {
+			logs = append(logs, entry)
+		}
+	}
+	return logs, nil
+}
+
+// isGzipLog reports whether the log at path is gzip-compressed, as logrotate
+// leaves old logs with compress enabled
+func isGzipLog(path string) bool {
+	return strings.HasSuffix(path, ".gz")
+}
+
+// readLogFile returns the contents of a log file, decompressing a .gz log
+func readLogFile(path string) ([]byte, error) {
+	if !isGzipLog(path) {
+		return os.ReadFile(path)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w: %v", path, errCorruptLog, err)
+	}
+	content, err := io.ReadAll(zr)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w: %v", path, errCorruptLog, err)
+	}
+	return content, nil
+}
+
+// rotatedLogPath returns the most recent rotated copy of the log at path,
+// path.1 or path.1.gz, or "" when there is none
+func rotatedLogPath(path string) string {
+	for _, candidate := range []string{path + ".1", path + ".1.gz"} {
+		if _, err := os.Stat(candidate); err == nil {
+			return candidate
+		}
+	}
+	return ""
+}
+
+// parseLogFile parses every line of a log file
+func parseLogFile(path, logType, input string) ([]logEntry, error) {
+	return readLogEntries(path, logType, input, logFilter{})
+}
+
+// filterLogEntries returns the entries that match filter in a new slice
+func filterLogEntries(entries []logEntry, filter logFilter) []logEntry {
+	logs := make([]logEntry, 0, len(entries))
+	for _, entry := range entries {
+		if filter.match(entry) {
+			logs = append(logs, entry)
+		}
+	}
+	return logs
+}
+
+// tailLogEntries returns the last n parsed entries of a log file that match
+// filter. It reads the file backwards in chunks so large logs aren't parsed
+// in full. A compressed log can't be read backwards and is parsed in full.
+func tailLogEntries(path, logType, input string, n int, filter logFilter) ([]logEntry, error) {
+	if isGzipLog(path) {
+		entries, err := readLogEntries(path, logType, input, filter)
+		if err != nil {
+			return nil, err
+		}
+		return entries[max(0, len(entries)-n):], nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	logs := []logEntry{}
+	var partial []byte // start of a line that continues into the chunk read earlier
+	for pos := info.Size(); pos > 0 && len(logs) < n; {
+		size := min(tailChunkSize, pos)
+		pos -= size
+
+		buf := make([]byte, size, size+int64(len(partial)))
+		if _, err := f.ReadAt(buf, pos); err != nil {
+			return nil, err
+		}
+		lines := bytes.Split(append(buf, partial...), []byte("\n"))
+
+		// Unless this chunk starts the file, its first line may be cut off.
+		first := 0
+		partial = nil
+		if pos > 0 {
+			partial = lines[0]
+			first = 1
+		}
+		for i := len(lines) - 1; i >= first && len(logs) < n; i-- {
+			if entry, ok := parseLogLine(logType, input, string(lines[i])); ok && filter.match(entry) {
+				logs = append(logs, entry)
+			}
+		}
+	}
+
+	slices.Reverse(logs)
+	return logs, nil
+}
+
+// Response formats /logs can produce
+const (
+	logFormatJSON   = "json"
+	logFormatNDJSON = "ndjson"
+	logFormatText   = "text"
+)
+
+// logFormatTypes maps each response format to its media type
+var logFormatTypes = map[string]string{
+	logFormatJSON:   "application/json",
+	logFormatNDJSON: "application/x-ndjson",
+	logFormatText:   "text/plain",
+}
+
+// negotiateLogFormat picks the /logs response format. An explicit format
+// parameter wins; otherwise the Accept type with the highest q that /logs
+// can produce is used, earlier entries breaking ties. Anything else,
+// including no Accept header, gets JSON as before.
+func negotiateLogFormat(r *http.Request) (string, error) {
+	if value := r.URL.Query().Get("format"); value != "" {
+		format := strings.ToLower(value)
+		if _, ok := logFormatTypes[format]; !ok {
+			return "", fmt.Errorf("invalid format %q (use json, ndjson or text)", value)
+		}
+		return format, nil
+	}
+
+	best, bestQ := logFormatJSON, 0.0
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if strings.EqualFold(name, "q") {
+				if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+		for format, formatType := range logFormatTypes {
+			if mediaType == formatType && q > bestQ {
+				best, bestQ = format, q
+			}
+		}
+	}
+	return best, nil
+}
+
+// writeLogEntries encodes entries in format: a JSON array, one JSON object
+// per line, or the raw log lines
+func writeLogEntries(w http.ResponseWriter, format string, entries []logEntry) {
+	switch format {
+	case logFormatNDJSON:
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		enc := json.NewEncoder(w)
+		for _, entry := range entries {
+			enc.Encode(entry)
+		}
+	case logFormatText:
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		for _, entry := range entries {
+			io.WriteString(w, entry.raw+"\n")
+		}
+	default:
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(entries)
+	}
+}
+
+// logSources returns the log types a type parameter selects; "all" selects
+// every log.
+func logSources(logType string) ([]string, bool) {
+	switch logType {
+	case "reed", "radar":
+		return []string{logType}, true
+	case "all":
+		return []string{"reed", "radar"}, true
+	}
+	return nil, false
+}
+
+// logPath returns the file a log type is read from
+func (s *server) logPath(logType string) string {
+	if logType == "reed" {
+		return s.reedLog
+	}
+	return s.radarLog
+}
+
+// readLog returns the entries of one log selected by page and filter, and the
+// age of the cached parse for full reads. With rotated set the most recent
+// rotated file is read too and its entries come first. Each file is read in
+// input, or the format its name implies when input is "".
+func (s *server) readLog(source string, page logPage, filter logFilter, rotated bool, input string) ([]logEntry, time.Duration, error) {
+	current := s.logPath(source)
+	paths := []string{current}
+	if rotated {
+		if old := rotatedLogPath(current); old != "" {
+			paths = []string{old, current}
+		}
+	}
+
+	var logs []logEntry
+	var cacheAge time.Duration
+	for _, path := range paths {
+		var entries []logEntry
+		var err error
+		pathInput := logInputFor(path, input)
+		if page.tail > 0 {
+			entries, err = tailLogEntries(path, source, pathInput, page.tail, filter)
+		} else {
+			var age time.Duration
+			entries, age, err = s.logCache.entries(path, source, pathInput, time.Now())
+			entries = filterLogEntries(entries, filter)
+			cacheAge = max(cacheAge, age)
+		}
+		// Just after a rotation only the rotated copy exists yet; the
+		// current log then reads as empty.
+		if len(paths) > 1 && path == current && errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		logs = append(logs, entries...)
+	}
+	return logs, cacheAge, nil
+}
+
+// sortLogEntries orders entries chronologically. Entries without a parsed
+// timestamp sort first, each source's lines keeping their file order.
+func sortLogEntries(entries []logEntry) {
+	slices.SortStableFunc(entries, func(a, b logEntry) int {
+		return a.time.Compare(b.time)
+	})
+}
+
+// logsHandler parses and returns the logs. type=all merges every log
+// into one chronological timeline. Logs that don't exist yet (e.g. on a fresh
+// install) read as empty and are named in X-Log-Missing.
+// Entries can be filtered to a from/to range, then paged with limit/offset
+// (total in X-Total-Count) or limited to the last N via tail. Full reads
+// come from s.logCache; X-Log-Cache-Age gives the age of the parse in seconds.
+// A log configured with a .gz path is decompressed on read, and rotated=true
+// also reads the previous rotation (<log>.1 or <log>.1.gz) so a query can
+// reach back past the last rotation. q keeps only entries whose message
+// contains it, ignoring case; the number of matches is sent in X-Match-Count.
+// The Accept header or format=json|ndjson|text selects a JSON array (the
+// default), one JSON object per line, or the raw matching lines.
+// input=text|ndjson says how the log files are written, overriding the
+// format their names imply; the keys of an NDJSON line other than its
+// timestamp and message are returned as fields.
+// Each entry gets a severity inferred from its message, and
+// severity=error,warning keeps only entries of those severities.
+// aggregate=hour|day returns counts per bucket of the filtered entries
+// instead, as JSON whatever the format; paging doesn't apply, and entries
+// whose timestamp doesn't parse are only counted as excluded.
+func (s *server) logsHandler(w http.ResponseWriter, r *http.Request) {
+	logType := strings.ToLower(r.URL.Query().Get("type"))
+	sources, ok := logSources(logType)
+	if !ok {
+		writeError(w, r, http.StatusBadRequest, errCodeInvalidRequest, "invalid type parameter (use type=reed, type=radar or type=all)")
+		return
+	}
+
+	page, err := parseLogPage(r.URL.Query())
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, errCodeInvalidRequest, err.Error())
+		return
+	}
+
+	filter, err := parseLogFilter(r.URL.Query())
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, errCodeInvalidRequest, err.Error())
+		return
+	}
+	filter.rules = s.logSeverity
+
+	format, err := negotiateLogFormat(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, errCodeInvalidRequest, err.Error())
+		return
+	}
+
+	input, err := parseLogInput(r.URL.Query())
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, errCodeInvalidRequest, err.Error())
+		return
+	}
+
+	rotated := false
+	if value := r.URL.Query().Get("rotated"); value != "" {
+		if rotated, err = strconv.ParseBool(value); err != nil {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidRequest, fmt.Sprintf("invalid rotated %q", value))
+			return
+		}
+	}
+
+	aggregate, err := parseLogAggregate(r.URL.Query())
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, errCodeInvalidRequest, err.Error())
+		return
+	}
+	if aggregate != "" && page.tail > 0 {
+		writeError(w, r, http.StatusBadRequest, errCodeInvalidRequest, "tail can't be combined with aggregate")
+		return
+	}
+
+	logs := []logEntry{}
+	var missing []string
+	var cacheAge time.Duration
+	for _, source := range sources {
+		entries, age, err := s.readLog(source, page, filter, rotated, input)
+		cacheAge = max(cacheAge, age)
+		if errors.Is(err, fs.ErrNotExist) {
+			missing = append(missing, source)
+			continue
+		}
+		if errors.Is(err, errCorruptLog) {
+			s.log.Error("failed to decompress log file", "type", source, "error", err)
+			writeError(w, r, http.StatusInternalServerError, errCodeInternal, "failed to read "+source+" log: "+errCorruptLog.Error())
+			return
+		}
+		if err != nil {
+			// The error names the file; keep the path in our log, not the response.
+			s.log.Error("failed to read log file", "type", source, "error", err)
+			writeError(w, r, http.StatusInternalServerError, errCodeInternal, "failed to read "+source+" log")
+			return
+		}
+		logs = append(logs, entries...)
+	}
+	if len(missing) > 0 {
+		w.Header().Set("X-Log-Missing", strings.Join(missing, ","))
+	}
+	if page.tail == 0 {
+		w.Header().Set("X-Log-Cache-Age", strconv.Itoa(int(cacheAge.Seconds())))
+	}
+	if filter.q != "" {
+		w.Header().Set("X-Match-Count", strconv.Itoa(len(logs)))
+	}
+
+	if aggregate != "" {
+		writeLogAggregate(w, logs, aggregate, s.loc)
+		return
+	}
+
+	if len(sources) > 1 {
+		sortLogEntries(logs)
+	}
+	if page.tail > 0 {
+		logs = logs[max(0, len(logs)-page.tail):]
+	} else {
+		w.Header().Set("X-Total-Count", strconv.Itoa(len(logs)))
+		logs = paginate(logs, page.offset, page.limit)
+	}
+	for i := range logs {
+		logs[i] = s.withSeverity(logs[i].inZone(s.loc))
+	}
+
+	w.Header().Add("Vary", "Accept")
+	writeLogEntries(w, format, logs)
+}
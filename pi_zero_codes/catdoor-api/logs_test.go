@@ -0,0 +1,717 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeRadarLog writes a radar log with n numbered entries and returns its path
+func writeRadarLog(t *testing.T, n int) string {
+	t.Helper()
+	var b strings.Builder
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&b, "[2025-01-01 10:00:%02d] motion %d\n", i%60, i)
+		if i%7 == 0 {
+			b.WriteString("garbage line\n\n")
+		}
+	}
+	path := filepath.Join(t.TempDir(), "sensor_logs.txt")
+	if err := os.WriteFile(path, []byte(b.String()), 0644); err != nil {
+		t.Fatalf("write log: %v", err)
+	}
+	return path
+}
+
+func TestParseLogPage(t *testing.T) {
+	tests := []struct {
+		query   string
+		want    logPage
+		wantErr bool
+	}{
+		{"", logPage{limit: defaultLogLimit}, false},
+		{"limit=10&offset=20", logPage{limit: 10, offset: 20}, false},
+		{"tail=5", logPage{limit: defaultLogLimit, tail: 5}, false},
+		{"limit=0", logPage{}, true},
+		{"limit=abc", logPage{}, true},
+		{"offset=-1", logPage{}, true},
+		{"tail=999999", logPage{}, true},
+	}
+	for _, tt := range tests {
+		query, _ := url.ParseQuery(tt.query)
+		got, err := parseLogPage(query)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseLogPage(%q) error = %v, wantErr %v", tt.query, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseLogPage(%q) = %+v, want %+v", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestPaginate(t *testing.T) {
+	entries, err := readLogEntries(writeRadarLog(t, 10), "radar", logInputText, logFilter{})
+	if err != nil {
+		t.Fatalf("readLogEntries: %v", err)
+	}
+	if len(entries) != 10 {
+		t.Fatalf("read %d entries, want 10", len(entries))
+	}
+
+	page := paginate(entries, 8, 5)
+	if len(page) != 2 || page[0].Message != "motion 8" {
+		t.Errorf("paginate(8, 5) = %v", page)
+	}
+	if page := paginate(entries, 20, 5); len(page) != 0 {
+		t.Errorf("paginate past the end = %v, want empty", page)
+	}
+}
+
+func TestTailLogEntries(t *testing.T) {
+	// Enough entries to span several read chunks.
+	path := writeRadarLog(t, 1000)
+
+	for _, n := range []int{1, 3, 200, 1000, 2000} {
+		got, err := tailLogEntries(path, "radar", logInputText, n, logFilter{})
+		if err != nil {
+			t.Fatalf("tailLogEntries(%d): %v", n, err)
+		}
+		want := min(n, 1000)
+		if len(got) != want {
+			t.Errorf("tailLogEntries(%d) returned %d entries, want %d", n, len(got), want)
+			continue
+		}
+		for i, entry := range got {
+			if msg := fmt.Sprintf("motion %d", 1000-want+i); entry.Message != msg {
+				t.Errorf("tailLogEntries(%d)[%d] = %q, want %q", n, i, entry.Message, msg)
+				break
+			}
+		}
+	}
+}
+
+func TestParseLogFilter(t *testing.T) {
+	tests := []struct {
+		query   string
+		wantErr bool
+	}{
+		{"", false},
+		{"from=2025-01-01 10:00:00", false},
+		{"from=2025-01-01T10:00:00Z&to=2025-01-02T10:00:00%2B01:00", false},
+		{"to=2025-01-01T10:00:00", false},
+		{"from=yesterday", true},
+		{"from=2025-01-02 00:00:00&to=2025-01-01 00:00:00", true},
+	}
+	for _, tt := range tests {
+		query, _ := url.ParseQuery(tt.query)
+		if _, err := parseLogFilter(query); (err != nil) != tt.wantErr {
+			t.Errorf("parseLogFilter(%q) error = %v, wantErr %v", tt.query, err, tt.wantErr)
+		}
+	}
+}
+
+func TestReadLogEntriesFiltered(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "reed_logs.txt")
+	content := strings.Join([]string{
+		"2025-01-01 22:59:59 Flap open 1.50s",
+		"2025-01-01 23:00:00 Flap open 2.00s",
+		"2025-01-02 01:30:00 Flap open 3.25s",
+		"2025-01-02 02:00:00 Flap open 1.10s",
+		"2025-01-02 02:00:01 Flap open 4.00s",
+		"not-a-date 02:00:00 Flap open 9.99s",
+	}, "\n")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("write log: %v", err)
+	}
+
+	query, _ := url.ParseQuery("from=2025-01-01 23:00:00&to=2025-01-02 02:00:00")
+	filter, err := parseLogFilter(query)
+	if err != nil {
+		t.Fatalf("parseLogFilter: %v", err)
+	}
+
+	for name, read := range map[string]func() ([]logEntry, error){
+		"read": func() ([]logEntry, error) { return readLogEntries(path, "reed", logInputText, filter) },
+		"tail": func() ([]logEntry, error) { return tailLogEntries(path, "reed", logInputText, 10, filter) },
+	} {
+		entries, err := read()
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		var got []string
+		for _, entry := range entries {
+			got = append(got, entry.Message)
+		}
+		want := []string{"Flap open 2.00s", "Flap open 3.25s", "Flap open 1.10s"}
+		if strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Errorf("%s: got %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestParseLogLineTimestamps(t *testing.T) {
+	tests := []struct {
+		logType, line string
+		wantRaw       string
+		wantParsed    bool
+	}{
+		{"reed", "2025-03-04 05:06:07 Flap open 1.20s", "2025-03-04 05:06:07", true},
+		{"radar", "[2025-03-04 05:06:07.123456] presence", "2025-03-04 05:06:07.123456", true},
+		{"radar", "[2025-03-04T05:06:07Z] presence", "2025-03-04T05:06:07Z", true},
+		{"radar", "[boot] sensor online", "boot", false},
+		{"reed", "yesterday at-noon Flap open", "yesterday at-noon", false},
+	}
+	for _, tt := range tests {
+		entry, ok := parseLogLine(tt.logType, logInputText, tt.line)
+		if !ok {
+			t.Errorf("parseLogLine(%q) not ok", tt.line)
+			continue
+		}
+		if entry.RawTimestamp != tt.wantRaw {
+			t.Errorf("parseLogLine(%q) raw = %q, want %q", tt.line, entry.RawTimestamp, tt.wantRaw)
+		}
+		if got := entry.Timestamp != nil; got != tt.wantParsed || entry.ParseError == tt.wantParsed {
+			t.Errorf("parseLogLine(%q) timestamp = %v, parse_error = %v", tt.line, entry.Timestamp, entry.ParseError)
+			continue
+		}
+		if tt.wantParsed {
+			if _, err := time.Parse(time.RFC3339, *entry.Timestamp); err != nil {
+				t.Errorf("parseLogLine(%q) timestamp %q is not RFC3339", tt.line, *entry.Timestamp)
+			}
+		}
+	}
+}
+
+func TestSortLogEntriesMergesSources(t *testing.T) {
+	var entries []logEntry
+	for _, line := range []struct{ logType, line string }{
+		{"reed", "2025-01-01 10:00:05 Flap open 1.00s"},
+		{"reed", "2025-01-01 10:00:20 Flap open 2.00s"},
+		{"radar", "[2025-01-01 10:00:00] approach"},
+		{"radar", "[2025-01-01 10:00:10] presence"},
+		{"radar", "[boot] sensor online"},
+	} {
+		entry, ok := parseLogLine(line.logType, logInputText, line.line)
+		if !ok {
+			t.Fatalf("parseLogLine(%q) not ok", line.line)
+		}
+		entries = append(entries, entry)
+	}
+
+	sortLogEntries(entries)
+
+	var got []string
+	for _, entry := range entries {
+		got = append(got, entry.Source+":"+entry.Message)
+	}
+	want := "radar:sensor online,radar:approach,reed:Flap open 1.00s,radar:presence,reed:Flap open 2.00s"
+	if strings.Join(got, ",") != want {
+		t.Errorf("sorted = %v, want %s", got, want)
+	}
+}
+
+func TestLogsHandlerMissingFile(t *testing.T) {
+	s := newTestServer(t, startFakeController(t))
+	s.reedLog = filepath.Join(t.TempDir(), "no-such-dir", "reed_logs.txt")
+
+	rec := httptest.NewRecorder()
+	s.logsHandler(rec, httptest.NewRequest(http.MethodGet, "/logs?type=reed", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body)
+	}
+	if got := rec.Header().Get("X-Log-Missing"); got != "reed" {
+		t.Errorf("X-Log-Missing = %q, want reed", got)
+	}
+	var entries []logEntry
+	if err := json.NewDecoder(rec.Body).Decode(&entries); err != nil || entries == nil || len(entries) != 0 {
+		t.Errorf("entries = %v (%v), want an empty list", entries, err)
+	}
+}
+
+func TestLogsHandlerAllWithOneMissing(t *testing.T) {
+	s := newTestServer(t, startFakeController(t))
+	s.radarLog = writeRadarLog(t, 3)
+
+	rec := httptest.NewRecorder()
+	s.logsHandler(rec, httptest.NewRequest(http.MethodGet, "/logs?type=all", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body)
+	}
+	if got := rec.Header().Get("X-Log-Missing"); got != "reed" {
+		t.Errorf("X-Log-Missing = %q, want reed", got)
+	}
+}
+
+func TestLogsHandlerReadErrorHidesPath(t *testing.T) {
+	s := newTestServer(t, startFakeController(t))
+	// A directory exists but can't be read as a log.
+	s.reedLog = t.TempDir()
+
+	rec := httptest.NewRecorder()
+	s.logsHandler(rec, httptest.NewRequest(http.MethodGet, "/logs?type=reed", nil))
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("status = %d, want 500", rec.Code)
+	}
+	if strings.Contains(rec.Body.String(), s.reedLog) {
+		t.Errorf("body %q leaks the log path", rec.Body)
+	}
+}
+
+func TestLogCache(t *testing.T) {
+	path := writeRadarLog(t, 10)
+	var cache logCache
+	start := time.Now()
+
+	first, age, err := cache.entries(path, "radar", logInputText, start)
+	if err != nil || age != 0 || len(first) != 10 {
+		t.Fatalf("first read = %d entries, age %s, %v", len(first), age, err)
+	}
+	again, age, err := cache.entries(path, "radar", logInputText, start.Add(5*time.Second))
+	if err != nil || age != 5*time.Second || &again[0] != &first[0] {
+		t.Fatalf("second read age = %s, %v; want the cached slice", age, err)
+	}
+
+	// Appending invalidates the cached parse.
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	fmt.Fprintln(f, "[2025-01-01 10:01:00] motion 10")
+	f.Close()
+	entries, age, err := cache.entries(path, "radar", logInputText, start.Add(6*time.Second))
+	if err != nil || age != 0 || len(entries) != 11 {
+		t.Fatalf("after append = %d entries, age %s, %v", len(entries), age, err)
+	}
+
+	// So does replacing the file, as log rotation does.
+	rotated := writeRadarLog(t, 3)
+	if err := os.Rename(rotated, path); err != nil {
+		t.Fatalf("rename: %v", err)
+	}
+	entries, _, err = cache.entries(path, "radar", logInputText, start.Add(7*time.Second))
+	if err != nil || len(entries) != 3 {
+		t.Fatalf("after rotation = %d entries, %v", len(entries), err)
+	}
+
+	os.Remove(path)
+	if _, _, err := cache.entries(path, "radar", logInputText, start); !os.IsNotExist(err) {
+		t.Errorf("missing file error = %v", err)
+	}
+}
+
+func TestLogsHandlerCacheAgeHeader(t *testing.T) {
+	s := newTestServer(t, startFakeController(t))
+	s.radarLog = writeRadarLog(t, 5)
+
+	for i := 0; i < 2; i++ {
+		rec := httptest.NewRecorder()
+		s.logsHandler(rec, httptest.NewRequest(http.MethodGet, "/logs?type=radar", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d", rec.Code)
+		}
+		if rec.Header().Get("X-Log-Cache-Age") == "" {
+			t.Errorf("request %d: no X-Log-Cache-Age header", i)
+		}
+	}
+}
+
+// BenchmarkLogsRepeated compares re-parsing a large log on every request with
+// serving it from the cache while it is unchanged.
+func BenchmarkLogsRepeated(b *testing.B) {
+	var sb strings.Builder
+	for i := 0; i < 20000; i++ {
+		fmt.Fprintf(&sb, "[2025-01-01 10:%02d:%02d] motion %d\n", i/60%60, i%60, i)
+	}
+	path := filepath.Join(b.TempDir(), "sensor_logs.txt")
+	if err := os.WriteFile(path, []byte(sb.String()), 0644); err != nil {
+		b.Fatalf("write log: %v", err)
+	}
+
+	b.Run("uncached", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := readLogEntries(path, "radar", logInputText, logFilter{}); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("cached", func(b *testing.B) {
+		var cache logCache
+		for i := 0; i < b.N; i++ {
+			entries, _, err := cache.entries(path, "radar", logInputText, time.Now())
+			if err != nil {
+				b.Fatal(err)
+			}
+			filterLogEntries(entries, logFilter{})
+		}
+	})
+}
+
+// gzipFile writes a gzip-compressed copy of content to path
+func gzipFile(t *testing.T, path, content string) {
+	t.Helper()
+	var b bytes.Buffer
+	zw := gzip.NewWriter(&b)
+	zw.Write([]byte(content))
+	if err := zw.Close(); err != nil {
+		t.Fatalf("gzip: %v", err)
+	}
+	if err := os.WriteFile(path, b.Bytes(), 0644); err != nil {
+		t.Fatalf("write log: %v", err)
+	}
+}
+
+func TestLogsHandlerGzip(t *testing.T) {
+	s := newTestServer(t, startFakeController(t))
+	s.radarLog = filepath.Join(t.TempDir(), "sensor_logs.txt.1.gz")
+	gzipFile(t, s.radarLog, "[2025-01-01 10:00:00] motion 0\n[2025-01-01 10:00:01] motion 1\n")
+
+	for _, query := range []string{"type=radar", "type=radar&tail=1"} {
+		rec := httptest.NewRecorder()
+		s.logsHandler(rec, httptest.NewRequest(http.MethodGet, "/logs?"+query, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d, body = %s", query, rec.Code, rec.Body)
+		}
+		var entries []logEntry
+		if err := json.NewDecoder(rec.Body).Decode(&entries); err != nil {
+			t.Fatalf("%s: decode: %v", query, err)
+		}
+		if len(entries) == 0 || entries[len(entries)-1].Message != "motion 1" {
+			t.Errorf("%s: entries = %+v, want to end with motion 1", query, entries)
+		}
+	}
+}
+
+func TestLogsHandlerRotated(t *testing.T) {
+	s := newTestServer(t, startFakeController(t))
+	s.radarLog = filepath.Join(t.TempDir(), "sensor_logs.txt")
+	gzipFile(t, s.radarLog+".1.gz", "[2024-12-31 23:59:00] motion yesterday\n")
+	if err := os.WriteFile(s.radarLog, []byte("[2025-01-01 10:00:00] motion today\n"), 0644); err != nil {
+		t.Fatalf("write log: %v", err)
+	}
+
+	for _, tc := range []struct {
+		query string
+		want  []string
+	}{
+		{"type=radar", []string{"motion today"}},
+		{"type=radar&rotated=true", []string{"motion yesterday", "motion today"}},
+		{"type=radar&rotated=true&tail=1", []string{"motion today"}},
+		{"type=radar&rotated=true&to=2025-01-01T00:00:00Z", []string{"motion yesterday"}},
+	} {
+		rec := httptest.NewRecorder()
+		s.logsHandler(rec, httptest.NewRequest(http.MethodGet, "/logs?"+tc.query, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d, body = %s", tc.query, rec.Code, rec.Body)
+		}
+		var entries []logEntry
+		if err := json.NewDecoder(rec.Body).Decode(&entries); err != nil {
+			t.Fatalf("%s: decode: %v", tc.query, err)
+		}
+		var got []string
+		for _, e := range entries {
+			got = append(got, e.Message)
+		}
+		if !slices.Equal(got, tc.want) {
+			t.Errorf("%s: messages = %q, want %q", tc.query, got, tc.want)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	s.logsHandler(rec, httptest.NewRequest(http.MethodGet, "/logs?type=radar&rotated=maybe", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("rotated=maybe: status = %d, want 400", rec.Code)
+	}
+}
+
+func TestLogsHandlerRotatedWithoutCurrent(t *testing.T) {
+	s := newTestServer(t, startFakeController(t))
+	s.radarLog = filepath.Join(t.TempDir(), "sensor_logs.txt")
+	gzipFile(t, s.radarLog+".1.gz", "[2024-12-31 23:58:00] motion late\n[2024-12-31 23:59:00] motion yesterday\n")
+
+	for _, tc := range []struct {
+		query   string
+		want    []string
+		missing string
+	}{
+		{"type=radar", nil, "radar"},
+		{"type=radar&rotated=true", []string{"motion late", "motion yesterday"}, ""},
+		{"type=radar&rotated=true&tail=1", []string{"motion yesterday"}, ""},
+	} {
+		rec := httptest.NewRecorder()
+		s.logsHandler(rec, httptest.NewRequest(http.MethodGet, "/logs?"+tc.query, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d, body = %s", tc.query, rec.Code, rec.Body)
+		}
+		if got := rec.Header().Get("X-Log-Missing"); got != tc.missing {
+			t.Errorf("%s: X-Log-Missing = %q, want %q", tc.query, got, tc.missing)
+		}
+		var entries []logEntry
+		if err := json.NewDecoder(rec.Body).Decode(&entries); err != nil {
+			t.Fatalf("%s: decode: %v", tc.query, err)
+		}
+		var got []string
+		for _, e := range entries {
+			got = append(got, e.Message)
+		}
+		if !slices.Equal(got, tc.want) {
+			t.Errorf("%s: messages = %q, want %q", tc.query, got, tc.want)
+		}
+	}
+}
+
+func TestLogsHandlerCorruptGzip(t *testing.T) {
+	s := newTestServer(t, startFakeController(t))
+	s.radarLog = filepath.Join(t.TempDir(), "sensor_logs.txt.gz")
+	gzipFile(t, s.radarLog, "[2025-01-01 10:00:00] motion 0\n")
+	content, _ := os.ReadFile(s.radarLog)
+	// Keep the header but cut the stream short.
+	if err := os.WriteFile(s.radarLog, content[:len(content)-6], 0644); err != nil {
+		t.Fatalf("write log: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	s.logsHandler(rec, httptest.NewRequest(http.MethodGet, "/logs?type=radar", nil))
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("status = %d, want 500", rec.Code)
+	}
+	if body := rec.Body.String(); !strings.Contains(body, "corrupt gzip") || strings.Contains(body, s.radarLog) {
+		t.Errorf("body = %q, want a corrupt gzip error without the path", body)
+	}
+}
+
+func TestLogsHandlerQuery(t *testing.T) {
+	s := newTestServer(t, startFakeController(t))
+	s.radarLog = writeRadarLog(t, 20)
+	reed := strings.Join([]string{
+		"2025-01-01 10:00:05 Flap LOCKED",
+		"2025-01-01 10:00:06 Flap open 2.00s",
+		"2025-01-01 10:00:30 flap locked again",
+	}, "\n")
+	if err := os.WriteFile(s.reedLog, []byte(reed), 0644); err != nil {
+		t.Fatalf("write log: %v", err)
+	}
+
+	for _, tc := range []struct {
+		query string
+		want  []string
+	}{
+		{"type=reed&q=locked", []string{"Flap LOCKED", "flap locked again"}},
+		{"type=reed&q=LOCKED&to=2025-01-01T10:00:10Z", []string{"Flap LOCKED"}},
+		{"type=all&q=motion 1", []string{"motion 1", "motion 10", "motion 11", "motion 12", "motion 13", "motion 14", "motion 15", "motion 16", "motion 17", "motion 18", "motion 19"}},
+		{"type=all&q=motion 1&tail=2", []string{"motion 18", "motion 19"}},
+		{"type=all&q=nothing", nil},
+	} {
+		rec := httptest.NewRecorder()
+		s.logsHandler(rec, httptest.NewRequest(http.MethodGet, "/logs?"+url.PathEscape(tc.query), nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d, body = %s", tc.query, rec.Code, rec.Body)
+		}
+		var entries []logEntry
+		if err := json.NewDecoder(rec.Body).Decode(&entries); err != nil {
+			t.Fatalf("%s: decode: %v", tc.query, err)
+		}
+		var got []string
+		for _, e := range entries {
+			got = append(got, e.Message)
+		}
+		if !slices.Equal(got, tc.want) {
+			t.Errorf("%s: messages = %q, want %q", tc.query, got, tc.want)
+		}
+		if rec.Header().Get("X-Match-Count") != strconv.Itoa(len(tc.want)) {
+			t.Errorf("%s: X-Match-Count = %q, want %d", tc.query, rec.Header().Get("X-Match-Count"), len(tc.want))
+		}
+	}
+}
+
+func TestNegotiateLogFormat(t *testing.T) {
+	for _, tc := range []struct {
+		query, accept string
+		want          string
+	}{
+		{"", "", logFormatJSON},
+		{"", "*/*", logFormatJSON},
+		{"", "text/html,application/xhtml+xml,*/*;q=0.8", logFormatJSON},
+		{"", "application/xml", logFormatJSON},
+		{"", "application/x-ndjson", logFormatNDJSON},
+		{"", "text/plain", logFormatText},
+		{"", "Text/Plain; charset=utf-8", logFormatText},
+		{"", "text/plain;q=0.5, application/x-ndjson;q=0.9", logFormatNDJSON},
+		{"", "application/json, text/plain", logFormatJSON},
+		{"", "text/plain;q=0", logFormatJSON},
+		{"format=text", "application/json", logFormatText},
+		{"format=NDJSON", "", logFormatNDJSON},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/logs?"+tc.query, nil)
+		if tc.accept != "" {
+			r.Header.Set("Accept", tc.accept)
+		}
+		got, err := negotiateLogFormat(r)
+		if err != nil || got != tc.want {
+			t.Errorf("format %q, Accept %q: got %q, %v; want %q", tc.query, tc.accept, got, err, tc.want)
+		}
+	}
+
+	if _, err := negotiateLogFormat(httptest.NewRequest(http.MethodGet, "/logs?format=xml", nil)); err == nil {
+		t.Error("format=xml: expected an error")
+	}
+}
+
+func TestLogsHandlerFormats(t *testing.T) {
+	s := newTestServer(t, startFakeController(t))
+	reed := "2025-01-01 10:00:05 Flap LOCKED\r\n\n2025-01-01 10:00:06 Flap open 2.00s\n"
+	if err := os.WriteFile(s.reedLog, []byte(reed), 0644); err != nil {
+		t.Fatalf("write log: %v", err)
+	}
+
+	get := func(query, accept string) *httptest.ResponseRecorder {
+		t.Helper()
+		r := httptest.NewRequest(http.MethodGet, "/logs?"+query, nil)
+		if accept != "" {
+			r.Header.Set("Accept", accept)
+		}
+		rec := httptest.NewRecorder()
+		s.logsHandler(rec, r)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d, body = %s", query, rec.Code, rec.Body)
+		}
+		if vary := rec.Header().Get("Vary"); vary != "Accept" {
+			t.Errorf("%s: Vary = %q", query, vary)
+		}
+		return rec
+	}
+
+	rec := get("type=reed", "text/plain")
+	if ct := rec.Header().Get("Content-Type"); ct != "text/plain; charset=utf-8" {
+		t.Errorf("text Content-Type = %q", ct)
+	}
+	if want := "2025-01-01 10:00:05 Flap LOCKED\n2025-01-01 10:00:06 Flap open 2.00s\n"; rec.Body.String() != want {
+		t.Errorf("text body = %q, want %q", rec.Body, want)
+	}
+
+	rec = get("type=reed&q=open&format=ndjson", "")
+	if ct := rec.Header().Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Errorf("ndjson Content-Type = %q", ct)
+	}
+	lines := strings.Split(strings.TrimSuffix(rec.Body.String(), "\n"), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("ndjson lines = %q, want 1", lines)
+	}
+	var entry logEntry
+	if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil || entry.Message != "Flap open 2.00s" {
+		t.Errorf("ndjson line = %s (%v)", lines[0], err)
+	}
+
+	rec = get("type=reed", "")
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json; charset=utf-8" {
+		t.Errorf("default Content-Type = %q", ct)
+	}
+	var entries []logEntry
+	if err := json.NewDecoder(rec.Body).Decode(&entries); err != nil || len(entries) != 2 {
+		t.Errorf("default body decoded to %d entries (%v)", len(entries), err)
+	}
+
+	rec = httptest.NewRecorder()
+	s.logsHandler(rec, httptest.NewRequest(http.MethodGet, "/logs?type=reed&format=xml", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("format=xml: status = %d, want 400", rec.Code)
+	}
+}
+
+func TestLogInputFor(t *testing.T) {
+	tests := []struct{ path, input, want string }{
+		{"/logs/sensor_logs.txt", "", logInputText},
+		{"/logs/sensor_logs.ndjson", "", logInputNDJSON},
+		{"/logs/sensor_logs.JSONL", "", logInputNDJSON},
+		{"/logs/sensor_logs.ndjson.1", "", logInputNDJSON},
+		{"/logs/sensor_logs.ndjson.2.gz", "", logInputNDJSON},
+		{"/logs/sensor_logs.txt.1.gz", "", logInputText},
+		{"/logs/sensor_logs.txt", logInputNDJSON, logInputNDJSON},
+		{"/logs/sensor_logs.ndjson", logInputText, logInputText},
+	}
+	for _, tt := range tests {
+		if got := logInputFor(tt.path, tt.input); got != tt.want {
+			t.Errorf("logInputFor(%q, %q) = %q, want %q", tt.path, tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestLogsHandlerNDJSON(t *testing.T) {
+	fixture, err := os.ReadFile(filepath.Join("testdata", "sensor_logs.ndjson"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newTestServer(t, startFakeController(t))
+	s.radarLog = filepath.Join(t.TempDir(), "sensor_logs.ndjson")
+	if err := os.WriteFile(s.radarLog, fixture, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(query string) []logEntry {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		s.logsHandler(rec, httptest.NewRequest(http.MethodGet, "/logs?"+query, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d, body = %s", query, rec.Code, rec.Body)
+		}
+		var entries []logEntry
+		if err := json.NewDecoder(rec.Body).Decode(&entries); err != nil {
+			t.Fatal(err)
+		}
+		return entries
+	}
+
+	// Detected from the extension; the non-JSON and blank lines are skipped.
+	entries := get("type=radar")
+	var messages []string
+	for _, e := range entries {
+		messages = append(messages, e.Message)
+	}
+	if want := "presence detected,moving target,still target,sensor online"; strings.Join(messages, ",") != want {
+		t.Fatalf("messages = %q, want %q", messages, want)
+	}
+	if f := entries[0].Fields; f["zone"] != "hall" || f["distance_m"] != 1.2 || f["timestamp"] != nil {
+		t.Errorf("fields = %v, want the extra keys passed through", f)
+	}
+	if entries[2].Timestamp == nil || *entries[2].Timestamp != "2025-01-01T10:00:03Z" {
+		t.Errorf("unix timestamp = %v, want 2025-01-01T10:00:03Z", entries[2].Timestamp)
+	}
+	if !entries[3].ParseError || entries[3].RawTimestamp != "at boot" {
+		t.Errorf("unparseable timestamp entry = %+v, want parse_error", entries[3])
+	}
+
+	// Filters, tail and the cache work on NDJSON entries as on text ones.
+	if got := get("type=radar&q=target&tail=1"); len(got) != 1 || got[0].Message != "still target" {
+		t.Errorf("q=target&tail=1 = %+v", got)
+	}
+
+	// input=text reads the same file with the legacy parser, which skips
+	// every line here; input=ndjson reads a .txt log as NDJSON.
+	if got := get("type=radar&input=text"); len(got) != 0 {
+		t.Errorf("input=text returned %d entries, want 0", len(got))
+	}
+	s.reedLog = filepath.Join(t.TempDir(), "reed_logs.txt")
+	if err := os.WriteFile(s.reedLog, fixture, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got := get("type=reed&input=ndjson"); len(got) != 4 || got[0].Source != "reed" {
+		t.Errorf("input=ndjson on a .txt log = %+v, want the 4 entries", got)
+	}
+
+	rec := httptest.NewRecorder()
+	s.logsHandler(rec, httptest.NewRequest(http.MethodGet, "/logs?type=radar&input=csv", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("input=csv: status = %d, want 400", rec.Code)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Severities /logs gives entries. Lines carry no level of their own, so it
+// is inferred from the message.
+const (
+	severityError   = "error"
+	severityWarning = "warning"
+	severityInfo    = "info"
+)
+
+// severityRule gives entries whose message matches re the severity level
+type severityRule struct {
+	level string
+	re    *regexp.Regexp
+}
+
+// defaultSeverityRules treat messages mentioning ERROR or WARN, in any case,
+// as errors and warnings
+var defaultSeverityRules = []severityRule{
+	{severityError, regexp.MustCompile(`(?i)error`)},
+	{severityWarning, regexp.MustCompile(`(?i)warn`)},
+}
+
+// parseSeverityRules parses CATDOOR_LOG_SEVERITY_RULES: level=regexp pairs
+// separated by semicolons, tried in order, e.g.
+// "error=(?i)jam|fail;warning=(?i)retry|slow". A message no rule matches is
+// info. Empty keeps the defaults.
+func parseSeverityRules(value string) ([]severityRule, error) {
+	if strings.TrimSpace(value) == "" {
+		return defaultSeverityRules, nil
+	}
+	var rules []severityRule
+	for _, entry := range strings.Split(value, ";") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		level, expr, ok := strings.Cut(entry, "=")
+		level = strings.ToLower(strings.TrimSpace(level))
+		if !ok || !validSeverity(level) {
+			return nil, fmt.Errorf("invalid rule %q (use error=, warning= or info= followed by a regexp)", strings.TrimSpace(entry))
+		}
+		re, err := regexp.Compile(strings.TrimSpace(expr))
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s rule: %w", level, err)
+		}
+		rules = append(rules, severityRule{level, re})
+	}
+	return rules, nil
+}
+
+func validSeverity(level string) bool {
+	return level == severityError || level == severityWarning || level == severityInfo
+}
+
+// severityOf returns the level of the first rule matching message
+func severityOf(rules []severityRule, message string) string {
+	for _, rule := range rules {
+		if rule.re.MatchString(message) {
+			return rule.level
+		}
+	}
+	return severityInfo
+}
+
+// withSeverity fills in e's severity
+func (s *server) withSeverity(e logEntry) logEntry {
+	e.Severity = severityOf(s.logSeverity, e.Message)
+	return e
+}
+
+// parseSeverities reads the severity query parameter, a comma-separated
+// list of levels
+func parseSeverities(value string) ([]string, error) {
+	var levels []string
+	for _, level := range strings.Split(value, ",") {
+		level = strings.ToLower(strings.TrimSpace(level))
+		if level == "" {
+			continue
+		}
+		if !validSeverity(level) {
+			return nil, fmt.Errorf("invalid severity %q (use error, warning or info)", level)
+		}
+		levels = append(levels, level)
+	}
+	return levels, nil
+}
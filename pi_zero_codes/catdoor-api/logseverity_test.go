@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"testing"
+)
+
+func TestParseSeverityRules(t *testing.T) {
+	rules, err := parseSeverityRules("error=(?i)jam|stuck; Warning = retry ;")
+	if err != nil {
+		t.Fatalf("parseSeverityRules: %v", err)
+	}
+	for message, want := range map[string]string{
+		"motor JAMMED":      severityError,
+		"retry 2 of 3":      severityWarning,
+		"ERROR reading pin": severityInfo, // the defaults no longer apply
+		"Flap open 1.20s":   severityInfo,
+		"stuck, will retry": severityError, // first matching rule wins
+	} {
+		if got := severityOf(rules, message); got != want {
+			t.Errorf("severityOf(%q) = %q, want %q", message, got, want)
+		}
+	}
+
+	rules, err = parseSeverityRules("")
+	if err != nil || severityOf(rules, "sensor Error 5") != severityError || severityOf(rules, "WARNING: low voltage") != severityWarning {
+		t.Errorf("empty rules = %v (%v), want the defaults", rules, err)
+	}
+
+	for _, value := range []string{"fatal=panic", "error", "warning=(oops"} {
+		if _, err := parseSeverityRules(value); err == nil {
+			t.Errorf("parseSeverityRules(%q): expected an error", value)
+		}
+	}
+}
+
+func TestLogsHandlerSeverity(t *testing.T) {
+	s := newTestServer(t, startFakeController(t))
+	reed := "2025-01-01 10:00:01 Flap open 1.20s\n" +
+		"2025-01-01 10:00:02 ERROR reed switch stuck\n" +
+		"2025-01-01 10:00:03 warn: bounce detected\n" +
+		"2025-01-01 10:00:04 Flap LOCKED\n" +
+		"2025-01-01 10:00:05 error: reed switch stuck again\n"
+	if err := os.WriteFile(s.reedLog, []byte(reed), 0644); err != nil {
+		t.Fatalf("write log: %v", err)
+	}
+
+	get := func(query string) []logEntry {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		s.logsHandler(rec, httptest.NewRequest(http.MethodGet, "/logs?"+query, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d, body = %s", query, rec.Code, rec.Body)
+		}
+		var entries []logEntry
+		if err := json.NewDecoder(rec.Body).Decode(&entries); err != nil {
+			t.Fatalf("%s: decode: %v", query, err)
+		}
+		return entries
+	}
+	severities := func(entries []logEntry) []string {
+		var got []string
+		for _, e := range entries {
+			got = append(got, e.Severity)
+		}
+		return got
+	}
+
+	if got := severities(get("type=reed")); !slices.Equal(got, []string{"info", "error", "warning", "info", "error"}) {
+		t.Errorf("severities = %q", got)
+	}
+	if got := get("type=reed&severity=error,warning"); len(got) != 3 || got[0].Message != "ERROR reed switch stuck" {
+		t.Errorf("error,warning = %+v", got)
+	}
+	if got := get("type=reed&severity=error&tail=1"); len(got) != 1 || got[0].Message != "error: reed switch stuck again" {
+		t.Errorf("error tail = %+v", got)
+	}
+
+	// Other log styles bring their own rules.
+	s.logSeverity, _ = parseSeverityRules("error=LOCKED")
+	if got := severities(get("type=reed&severity=error")); !slices.Equal(got, []string{"error"}) {
+		t.Errorf("custom rules = %q", got)
+	}
+
+	rec := httptest.NewRecorder()
+	s.logsHandler(rec, httptest.NewRequest(http.MethodGet, "/logs?type=reed&severity=fatal", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("severity=fatal: status = %d, want 400", rec.Code)
+	}
+}
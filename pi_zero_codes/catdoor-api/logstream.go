@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// logStreamPollInterval is how often /logs/stream checks for new lines
+const logStreamPollInterval = 500 * time.Millisecond
+
+// logFollower reads the lines appended to a log file, like tail -F. It
+// starts again from the top when the file is truncated or replaced by
+// rotation.
+type logFollower struct {
+	path    string
+	file    *os.File
+	info    os.FileInfo
+	offset  int64
+	partial []byte // trailing bytes not yet terminated by a newline
+}
+
+// start opens the file positioned at its end, so only lines written from now
+// on are returned. A missing file is not an error; it is picked up once it
+// appears.
+func (f *logFollower) start() error {
+	if err := f.open(); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	f.offset = f.info.Size()
+	return nil
+}
+
+// open (re)opens the file and reads it from the beginning
+func (f *logFollower) open() error {
+	f.close()
+	file, err := os.Open(f.path)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file, f.info, f.offset, f.partial = file, info, 0, nil
+	return nil
+}
+
+func (f *logFollower) close() {
+	if f.file != nil {
+		f.file.Close()
+		f.file = nil
+	}
+}
+
+// poll returns the complete lines appended since the last call
+func (f *logFollower) poll() ([]string, error) {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	switch {
+	case f.file == nil || !os.SameFile(info, f.info):
+		// First appearance or rotation: read the new file from the top.
+		if err := f.open(); err != nil {
+			return nil, err
+		}
+	case info.Size() < f.offset:
+		// Truncated in place.
+		f.offset, f.partial = 0, nil
+	}
+
+	if info.Size() == f.offset {
+		return nil, nil
+	}
+	data, err := io.ReadAll(io.NewSectionReader(f.file, f.offset, info.Size()-f.offset))
+	if err != nil {
+		return nil, err
+	}
+	f.offset += int64(len(data))
+
+	data = append(f.partial, data...)
+	end := bytes.LastIndexByte(data, '\n')
+	if end < 0 {
+		f.partial = data
+		return nil, nil
+	}
+	f.partial = append([]byte(nil), data[end+1:]...)
+	return strings.Split(string(data[:end]), "\n"), nil
+}
+
+// logsStreamHandler handles /logs/stream?type=reed|radar, pushing each newly
+// appended entry as a Server-Sent Event until the client disconnects.
+// severity=error,warning only pushes entries of those severities.
+func (s *server) logsStreamHandler(w http.ResponseWriter, r *http.Request) {
+	logType := strings.ToLower(r.URL.Query().Get("type"))
+	if logType != "reed" && logType != "radar" {
+		writeError(w, r, http.StatusBadRequest, errCodeInvalidRequest, "invalid type parameter (use type=reed or type=radar)")
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, r, http.StatusInternalServerError, errCodeInternal, "streaming not supported")
+		return
+	}
+
+	input, err := parseLogInput(r.URL.Query())
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, errCodeInvalidRequest, err.Error())
+		return
+	}
+	input = logInputFor(s.logPath(logType), input)
+	severities, err := parseSeverities(r.URL.Query().Get("severity"))
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, errCodeInvalidRequest, err.Error())
+		return
+	}
+
+	follower := &logFollower{path: s.logPath(logType)}
+	if err := follower.start(); err != nil {
+		writeError(w, r, http.StatusInternalServerError, errCodeInternal, "failed to open log file: "+err.Error())
+		return
+	}
+	defer follower.close()
+
+	// The stream outlives the server's read timeout, which would otherwise
+	// cancel it.
+	_ = http.NewResponseController(w).SetReadDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ticker := time.NewTicker(logStreamPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+		}
+
+		lines, err := follower.poll()
+		if err != nil {
+			fmt.Fprintf(w, "event: error\ndata: %s\n\n", strings.ReplaceAll(err.Error(), "\n", " "))
+			flusher.Flush()
+			return
+		}
+		for _, line := range lines {
+			entry, ok := parseLogLine(logType, input, line)
+			if !ok {
+				continue
+			}
+			entry = s.withSeverity(entry)
+			if len(severities) > 0 && !slices.Contains(severities, entry.Severity) {
+				continue
+			}
+			data, err := json.Marshal(entry.inZone(s.loc))
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "data: %s\n\n", data)
+		}
+		if len(lines) > 0 {
+			flusher.Flush()
+		}
+	}
+}
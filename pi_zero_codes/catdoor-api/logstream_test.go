@@ -0,0 +1,89 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func appendFile(t *testing.T, path, content string) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(content); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+}
+
+func pollLines(t *testing.T, f *logFollower) string {
+	t.Helper()
+	lines, err := f.poll()
+	if err != nil {
+		t.Fatalf("poll: %v", err)
+	}
+	return strings.Join(lines, "|")
+}
+
+func TestLogFollower(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sensor_logs.txt")
+	appendFile(t, path, "[old] before start\n")
+
+	f := &logFollower{path: path}
+	if err := f.start(); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	defer f.close()
+
+	if got := pollLines(t, f); got != "" {
+		t.Errorf("lines before any append = %q, want none", got)
+	}
+
+	appendFile(t, path, "[1] one\n[2] tw")
+	if got := pollLines(t, f); got != "[1] one" {
+		t.Errorf("after append = %q", got)
+	}
+	appendFile(t, path, "o\n")
+	if got := pollLines(t, f); got != "[2] two" {
+		t.Errorf("after completing a partial line = %q", got)
+	}
+
+	// Truncation restarts from the top.
+	if err := os.Truncate(path, 0); err != nil {
+		t.Fatalf("truncate: %v", err)
+	}
+	appendFile(t, path, "[3] three\n")
+	if got := pollLines(t, f); got != "[3] three" {
+		t.Errorf("after truncation = %q", got)
+	}
+
+	// Rotation replaces the file; the new one is read from the top.
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatalf("rename: %v", err)
+	}
+	appendFile(t, path, "[4] four\n[5] five\n")
+	if got := pollLines(t, f); got != "[4] four|[5] five" {
+		t.Errorf("after rotation = %q", got)
+	}
+}
+
+func TestLogFollowerMissingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "reed_logs.txt")
+
+	f := &logFollower{path: path}
+	if err := f.start(); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	defer f.close()
+
+	if got := pollLines(t, f); got != "" {
+		t.Errorf("lines from missing file = %q", got)
+	}
+	appendFile(t, path, "2025-01-01 10:00:00 Flap open 1.00s\n")
+	if got := pollLines(t, f); got != "2025-01-01 10:00:00 Flap open 1.00s" {
+		t.Errorf("lines once the file appears = %q", got)
+	}
+}
@@ -1,238 +1,1709 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io"
+	"log/slog"
 	"net"
 	"net/http"
+	"net/netip"
+	"net/url"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 )
 
-const controllerAddr = "127.0.0.1:8765"
-const configPath = "/home/rami/catdoor-config.json"
+const defaultControllerAddr = "127.0.0.1:8765"
+const defaultListenAddr = ":8080"
+const defaultConfigPath = "/home/rami/catdoor-config.json"
+const defaultLockDuration = 5 * time.Minute
+const defaultMaxLockDuration = time.Hour
+const minLockDuration = time.Second
+const defaultDebounceWindow = 10 * time.Second
+const shutdownTimeout = 10 * time.Second
+const defaultHealthTimeout = 500 * time.Millisecond
 
-// Config represents the catdoor configuration
-type Config struct {
-	LastDetected string `json:"last_detected"`
-	LockedUntil  string `json:"locked_until,omitempty"`
+// HTTP timeouts. A request/response endpoint that runs past the request
+// timeout answers 503; the header and idle timeouts stop slow or idle
+// clients from holding connections. There is no write timeout, which would
+// cut off /logs/stream and /ws.
+const defaultRequestTimeout = 5 * time.Second
+const defaultHTTPReadTimeout = 10 * time.Second
+const defaultHTTPIdleTimeout = 60 * time.Second
+
+// defaultMaxBodyBytes caps request bodies (CATDOOR_MAX_BODY_BYTES) so a
+// runaway client can't exhaust the Pi Zero's memory. Every body the API
+// takes is a small JSON document.
+const defaultMaxBodyBytes = 64 << 10
+const defaultTimezone = "UTC"
+const defaultPingCommand = "STATUS"
+
+// defaultMaxUnlockTimers caps the auto-unlock timers in flight at once
+const defaultMaxUnlockTimers = 16
+
+// defaultUnlockAttempts is how many times GREEN is sent before an
+// auto-unlock counts as failed and escalates (CATDOOR_UNLOCK_ATTEMPTS)
+const defaultUnlockAttempts = 4
+
+// defaultAutoUnlockBackoff is the delay before the first auto-unlock retry;
+// it doubles after each further attempt.
+const defaultAutoUnlockBackoff = time.Second
+
+// server holds the runtime settings shared by the HTTP handlers
+type server struct {
+	name      string // device name; empty without a devices file
+	log       *slog.Logger
+	logFormat string         // "text" or "json"
+	logFile   *rotatingFile  // CATDOOR_LOG_FILE; nil logs to stdout
+	loc       *time.Location // CATDOOR_TZ; every emitted timestamp uses it
+
+	listenAddr string      // host:port, or unix:/path for a local socket
+	tlsConfig  *tls.Config // nil serves plain HTTP
+
+	clock          Clock // every timing-sensitive path reads the time from it
+	controller     ControllerClient
+	controllerAddr string
+	dryRun         bool
+	config         *State
+	history        *historyStore
+	modes          *modeHistory
+	telemetry      *telemetryStore
+	webhook        *webhookNotifier // nil unless CATDOOR_WEBHOOK_URL is set
+	metrics        *metrics
+	detectMode     string        // sent on detection: RED, or YELLOW to keep prey out but let the cat in
+	debounceWindow time.Duration // repeat detections within this are ignored
+	cooldown       time.Duration // after an auto-unlock, detections don't lock again for this long
+	// detectionWindows override lockDuration and detectMode by time of day
+	detectionWindows []DetectionWindow
+	minConfidence    float64 // detections reporting less are recorded but don't lock
+	lockDuration     time.Duration
+	maxLock          time.Duration
+	healthTimeout    time.Duration
+	requestTimeout   time.Duration // CATDOOR_REQUEST_TIMEOUT; 0 disables
+	confirmTimeout   time.Duration // CATDOOR_CONFIRM_TIMEOUT, for /detected?confirm=true
+	httpReadTimeout  time.Duration // for a request's headers and body
+	httpIdleTimeout  time.Duration // between keep-alive requests
+	maxBodyBytes     int64
+	pingCommand      string        // sent by /ping
+	versionCommand   string        // sent at startup for the firmware version
+	unlockBackoff    time.Duration // before the first auto-unlock retry
+	unlockAttempts   int           // GREEN attempts before the auto-unlock escalates
+	unlockFallback   []string      // sent when every GREEN attempt failed; nil disables
+	maxUnlocks       int           // detections are refused while this many unlock timers are active; 0 is no limit
+	reedLog          string
+	radarLog         string
+	logSeverity      []severityRule // CATDOOR_LOG_SEVERITY_RULES, for /logs
+	apiToken         string
+	authReads        bool
+	audit            *auditLog      // CATDOOR_AUDIT_LOG; nil keeps no audit trail
+	detectAllow      []netip.Prefix // CATDOOR_DETECT_ALLOW_CIDRS; empty accepts detections from anywhere
+	trustedProxies   []netip.Prefix // CATDOOR_TRUSTED_PROXIES, whose X-Forwarded-For is believed
+	corsOrigins      []string
+	limiter          *tokenBucket // shared by the mutating endpoints; nil disables
+
+	// detectMu serializes detections with each other and with PUT /config,
+	// which may change detectMode, debounceWindow, lockDuration, maxLock and
+	// detectionWindows.
+	detectMu sync.Mutex
+	// cmdMu is held for every mode change, and by POST /commands for a
+	// whole sequence. It is taken after unlock.mu, never before.
+	cmdMu         sync.Mutex
+	lastDetection time.Time
+
+	unlock          unlockTimer
+	unlockFailure   unlockFailure
+	escalation      unlockEscalation
+	firmware        firmwareVersion
+	schedule        scheduler
+	watchdog        watchdog
+	queue           detectionQueue
+	threshold       detectionThreshold // CATDOOR_DETECT_THRESHOLD
+	statsCache      statsCache
+	detectionsToday dailyCounter
+	logCache        logCache
+	idempotency     idempotencyCache
+	events          eventHub // mode changes and detections for /ws
+
+	// Set on the default device when CATDOOR_DEVICES_FILE lists several
+	devices     map[string]*server
+	deviceNames []string
+}
+
+// unlockFailure remembers an auto-unlock that gave up, so /status can report
+// that the flap may still be locked. Any later successful unlock clears it.
+type unlockFailure struct {
+	mu  sync.Mutex
+	err string
+	at  time.Time
+}
+
+func (f *unlockFailure) set(err error, at time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.err, f.at = err.Error(), at
+}
+
+func (f *unlockFailure) clear() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.err, f.at = "", time.Time{}
+}
+
+// get returns the last failure, or "" if the last unlock succeeded
+func (f *unlockFailure) get() (string, time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.err, f.at
+}
+
+// unlockTimer holds the single pending auto-unlock. Scheduling a new one
+// cancels the previous, so a later detection can't be cut short by the
+// unlock of an earlier one.
+type unlockTimer struct {
+	clock Clock // nil uses the real clock
+
+	mu       sync.Mutex // also held while the unlock runs
+	timer    Timer
+	cancel   chan struct{} // closed when timer is stopped before it fires
+	deadline time.Time     // when timer fires; zero without one
+	gen      uint64        // bumped on every change so a superseded callback does nothing
+
+	// active counts armed timers plus callbacks that are running or waiting
+	// for mu. It is atomic so /status and /metrics don't wait out an unlock.
+	active atomic.Int64
+}
+
+// schedule replaces any pending unlock with f after d
+func (u *unlockTimer) schedule(d time.Duration, f func()) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.scheduleLocked(d, f)
+}
+
+// lockAndSchedule runs lock and, if it succeeds, replaces any pending unlock
+// with f after d, returning when the replaced unlock was due (zero if there
+// was none). An unlock that is already firing finishes before lock runs, so
+// its GREEN can't land after the new lock.
+func (u *unlockTimer) lockAndSchedule(d time.Duration, lock func() error, f func()) (time.Time, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if err := lock(); err != nil {
+		return time.Time{}, err
+	}
+	previous := u.deadline
+	u.scheduleLocked(d, f)
+	return previous, nil
+}
+
+func (u *unlockTimer) scheduleLocked(d time.Duration, f func()) {
+	u.stopLocked()
+	gen := u.gen
+	clock := clockOrSystem(u.clock)
+	u.active.Add(1)
+	u.deadline = clock.Now().Add(d)
+	timer, cancel := clock.NewTimer(d), make(chan struct{})
+	u.timer, u.cancel = timer, cancel
+	go func() {
+		select {
+		case <-timer.C():
+		case <-cancel:
+			return
+		}
+		defer u.active.Add(-1)
+		u.mu.Lock()
+		defer u.mu.Unlock()
+		if u.gen != gen {
+			return
+		}
+		u.timer = nil
+		u.deadline = time.Time{}
+		f()
+	}()
 }
 
-// sendToController connects to the Python TCP controller and sends a command.
-func sendToController(cmd string) (string, error) {
-	conn, err := net.DialTimeout("tcp", controllerAddr, 2*time.Second)
+// pending reports whether an unlock is scheduled and hasn't fired yet
+func (u *unlockTimer) pending() bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.timer != nil
+}
+
+// unlessPending runs f if no unlock is pending, reporting whether it ran.
+// f runs under mu, so no detection can lock between the check and f.
+func (u *unlockTimer) unlessPending(f func()) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.timer != nil {
+		return false
+	}
+	f()
+	return true
+}
+
+// stopWith runs f and, if it succeeds, cancels the pending unlock, returning
+// how many timers were cancelled. As with lockAndSchedule, an unlock already
+// firing finishes first.
+func (u *unlockTimer) stopWith(f func() error) (int, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if err := f(); err != nil {
+		return 0, err
+	}
+	if u.stopLocked() {
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// stop cancels the pending unlock and reports whether there was one
+func (u *unlockTimer) stop() bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.stopLocked()
+}
+
+func (u *unlockTimer) stopLocked() bool {
+	u.gen++
+	if u.timer == nil {
+		return false
+	}
+	// A timer that already fired has its callback on the way, which sees
+	// the new gen and does nothing; any other is never going to fire.
+	if u.timer.Stop() {
+		u.active.Add(-1)
+		close(u.cancel)
+	}
+	u.timer, u.cancel = nil, nil
+	u.deadline = time.Time{}
+	return true
+}
+
+// inFlight returns how many unlock timers are armed or running
+func (u *unlockTimer) inFlight() int {
+	return int(u.active.Load())
+}
+
+// now returns the current time in the configured zone
+func (s *server) now() time.Time {
+	return s.clock.Now().In(s.loc)
+}
+
+// inZone reformats an RFC3339 timestamp from the config file in the
+// configured zone, leaving anything unparseable as it is.
+func (s *server) inZone(value string) string {
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return value
+	}
+	return t.In(s.loc).Format(time.RFC3339)
+}
+
+// newServerFromEnv builds a server from CATDOOR_* environment variables,
+// falling back to the defaults when a variable is unset.
+func newServerFromEnv() (*server, error) {
+	addr, err := envOrDefault("CATDOOR_CONTROLLER_ADDR", defaultControllerAddr)
+	if err != nil {
+		return nil, err
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return nil, fmt.Errorf("invalid CATDOOR_CONTROLLER_ADDR %q: %w", addr, err)
+	}
+
+	listenAddr, err := envOrDefault("CATDOOR_LISTEN_ADDR", defaultListenAddr)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateListenAddr(listenAddr); err != nil {
+		return nil, fmt.Errorf("invalid CATDOOR_LISTEN_ADDR %q: %w", listenAddr, err)
+	}
+
+	tlsConfig, err := tlsConfigFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	retries, err := envInt("CATDOOR_CONTROLLER_RETRIES", defaultControllerRetries)
+	if err != nil {
+		return nil, err
+	}
+	if retries < 0 {
+		return nil, fmt.Errorf("CATDOOR_CONTROLLER_RETRIES must not be negative, got %d", retries)
+	}
+
+	keepAlive, err := envBool("CATDOOR_CONTROLLER_KEEPALIVE", false)
+	if err != nil {
+		return nil, err
+	}
+	framingName, err := envOrDefault("CATDOOR_CONTROLLER_FRAMING", "line")
+	if err != nil {
+		return nil, err
+	}
+	controllerFraming, err := parseFraming(framingName)
+	if err != nil {
+		return nil, fmt.Errorf("CATDOOR_CONTROLLER_FRAMING: %w", err)
+	}
+	dialTimeout, err := envDuration("CATDOOR_DIAL_TIMEOUT", defaultDialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	if dialTimeout <= 0 {
+		return nil, fmt.Errorf("CATDOOR_DIAL_TIMEOUT must be positive, got %s", dialTimeout)
+	}
+	readTimeout, err := envDuration("CATDOOR_READ_TIMEOUT", defaultReadTimeout)
+	if err != nil {
+		return nil, err
+	}
+	if readTimeout <= 0 {
+		return nil, fmt.Errorf("CATDOOR_READ_TIMEOUT must be positive, got %s", readTimeout)
+	}
+	ackValue, err := envOrDefault("CATDOOR_CONTROLLER_ACK", "")
+	if err != nil {
+		return nil, err
+	}
+	acks, err := parseAcks(ackValue)
+	if err != nil {
+		return nil, fmt.Errorf("CATDOOR_CONTROLLER_ACK: %w", err)
+	}
+	ackTimeout, err := envDuration("CATDOOR_ACK_TIMEOUT", defaultAckTimeout)
+	if err != nil {
+		return nil, err
+	}
+	if ackTimeout <= 0 {
+		return nil, fmt.Errorf("CATDOOR_ACK_TIMEOUT must be positive, got %s", ackTimeout)
+	}
+	// A threshold of 0 turns the circuit breaker off.
+	breakerThreshold, err := envInt("CATDOOR_BREAKER_THRESHOLD", defaultBreakerThreshold)
+	if err != nil {
+		return nil, err
+	}
+	if breakerThreshold < 0 {
+		return nil, fmt.Errorf("CATDOOR_BREAKER_THRESHOLD must not be negative, got %d", breakerThreshold)
+	}
+	breakerCooldown, err := envDuration("CATDOOR_BREAKER_COOLDOWN", defaultBreakerCooldown)
+	if err != nil {
+		return nil, err
+	}
+	if breakerCooldown <= 0 {
+		return nil, fmt.Errorf("CATDOOR_BREAKER_COOLDOWN must be positive, got %s", breakerCooldown)
+	}
+
+	path, err := envOrDefault("CATDOOR_CONFIG_PATH", defaultConfigPath)
+	if err != nil {
+		return nil, err
+	}
+	path, err = expandHome(path)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CATDOOR_CONFIG_PATH: %w", err)
+	}
+
+	historyPath, err := envOrDefault("CATDOOR_HISTORY_PATH", filepath.Join(filepath.Dir(path), defaultHistoryFile))
+	if err != nil {
+		return nil, err
+	}
+	historyPath, err = expandHome(historyPath)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CATDOOR_HISTORY_PATH: %w", err)
+	}
+	modeHistoryPath, err := envOrDefault("CATDOOR_MODE_HISTORY_PATH", filepath.Join(filepath.Dir(path), defaultModeHistoryFile))
+	if err != nil {
+		return nil, err
+	}
+	modeHistoryPath, err = expandHome(modeHistoryPath)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CATDOOR_MODE_HISTORY_PATH: %w", err)
+	}
+	telemetryPath, err := envOrDefault("CATDOOR_TELEMETRY_PATH", filepath.Join(filepath.Dir(path), defaultTelemetryFile))
+	if err != nil {
+		return nil, err
+	}
+	telemetryPath, err = expandHome(telemetryPath)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CATDOOR_TELEMETRY_PATH: %w", err)
+	}
+
+	detectMode, err := envOrDefault("CATDOOR_DETECT_MODE", "red")
+	if err != nil {
+		return nil, err
+	}
+	detectMode = strings.ToUpper(detectMode)
+	if detectMode != "RED" && detectMode != "YELLOW" {
+		return nil, fmt.Errorf("invalid CATDOOR_DETECT_MODE %q (use red|yellow)", detectMode)
+	}
+
+	lockDuration, err := envDuration("CATDOOR_LOCK_DURATION", defaultLockDuration)
+	if err != nil {
+		return nil, err
+	}
+	if lockDuration < minLockDuration {
+		return nil, fmt.Errorf("CATDOOR_LOCK_DURATION must be at least %s, got %s", minLockDuration, lockDuration)
+	}
+
+	maxLock, err := envDuration("CATDOOR_MAX_LOCK_DURATION", defaultMaxLockDuration)
+	if err != nil {
+		return nil, err
+	}
+	if maxLock < lockDuration {
+		return nil, fmt.Errorf("CATDOOR_MAX_LOCK_DURATION (%s) is shorter than the lock duration (%s)", maxLock, lockDuration)
+	}
+
+	debounceWindow, err := envDuration("CATDOOR_DEBOUNCE_WINDOW", defaultDebounceWindow)
+	if err != nil {
+		return nil, err
+	}
+	if debounceWindow < 0 {
+		return nil, fmt.Errorf("CATDOOR_DEBOUNCE_WINDOW must not be negative, got %s", debounceWindow)
+	}
+
+	thresholdCount, err := envInt("CATDOOR_DETECT_THRESHOLD", 1)
+	if err != nil {
+		return nil, err
+	}
+	if thresholdCount < 1 {
+		return nil, fmt.Errorf("CATDOOR_DETECT_THRESHOLD must be positive, got %d", thresholdCount)
+	}
+	thresholdWindow, err := envDuration("CATDOOR_DETECT_THRESHOLD_WINDOW", defaultThresholdWindow)
+	if err != nil {
+		return nil, err
+	}
+	if thresholdWindow <= 0 {
+		return nil, fmt.Errorf("CATDOOR_DETECT_THRESHOLD_WINDOW must be positive, got %s", thresholdWindow)
+	}
+
+	cooldown, err := envDuration("CATDOOR_COOLDOWN", 0)
+	if err != nil {
+		return nil, err
+	}
+	if cooldown < 0 {
+		return nil, fmt.Errorf("CATDOOR_COOLDOWN must not be negative, got %s", cooldown)
+	}
+
+	minConfidence, err := envFloat("CATDOOR_MIN_CONFIDENCE", 0)
+	if err != nil {
+		return nil, err
+	}
+	if minConfidence < 0 || minConfidence > 1 {
+		return nil, fmt.Errorf("CATDOOR_MIN_CONFIDENCE must be between 0 and 1, got %g", minConfidence)
+	}
+
+	healthTimeout, err := envDuration("CATDOOR_HEALTH_TIMEOUT", defaultHealthTimeout)
+	if err != nil {
+		return nil, err
+	}
+	if healthTimeout <= 0 {
+		return nil, fmt.Errorf("CATDOOR_HEALTH_TIMEOUT must be positive, got %s", healthTimeout)
+	}
+
+	requestTimeout, err := envDuration("CATDOOR_REQUEST_TIMEOUT", defaultRequestTimeout)
+	if err != nil {
+		return nil, err
+	}
+	if requestTimeout < 0 {
+		return nil, fmt.Errorf("CATDOOR_REQUEST_TIMEOUT must not be negative, got %s", requestTimeout)
+	}
+	confirmTimeout, err := envDuration("CATDOOR_CONFIRM_TIMEOUT", defaultConfirmTimeout)
+	if err != nil {
+		return nil, err
+	}
+	if confirmTimeout <= 0 {
+		return nil, fmt.Errorf("CATDOOR_CONFIRM_TIMEOUT must be positive, got %s", confirmTimeout)
+	}
+	httpReadTimeout, err := envDuration("CATDOOR_HTTP_READ_TIMEOUT", defaultHTTPReadTimeout)
+	if err != nil {
+		return nil, err
+	}
+	httpIdleTimeout, err := envDuration("CATDOOR_HTTP_IDLE_TIMEOUT", defaultHTTPIdleTimeout)
+	if err != nil {
+		return nil, err
+	}
+	if httpReadTimeout <= 0 || httpIdleTimeout <= 0 {
+		return nil, fmt.Errorf("CATDOOR_HTTP_READ_TIMEOUT and CATDOOR_HTTP_IDLE_TIMEOUT must be positive, got %s and %s", httpReadTimeout, httpIdleTimeout)
+	}
+	maxBodyBytes, err := envInt("CATDOOR_MAX_BODY_BYTES", defaultMaxBodyBytes)
+	if err != nil {
+		return nil, err
+	}
+	if maxBodyBytes <= 0 {
+		return nil, fmt.Errorf("CATDOOR_MAX_BODY_BYTES must be positive, got %d", maxBodyBytes)
+	}
+
+	watchdogInterval, err := envDuration("CATDOOR_WATCHDOG_INTERVAL", defaultWatchdogInterval)
+	if err != nil {
+		return nil, err
+	}
+	if watchdogInterval < 0 {
+		return nil, fmt.Errorf("CATDOOR_WATCHDOG_INTERVAL must not be negative, got %s", watchdogInterval)
+	}
+	watchdogCorrect, err := envBool("CATDOOR_WATCHDOG_CORRECT", false)
+	if err != nil {
+		return nil, err
+	}
+	telemetryInterval, err := envDuration("CATDOOR_TELEMETRY_INTERVAL", defaultTelemetryInterval)
+	if err != nil {
+		return nil, err
+	}
+	if telemetryInterval < 0 {
+		return nil, fmt.Errorf("CATDOOR_TELEMETRY_INTERVAL must not be negative, got %s", telemetryInterval)
+	}
+
+	queueMaxAge, err := envDuration("CATDOOR_DETECTION_QUEUE_MAX_AGE", 0)
+	if err != nil {
+		return nil, err
+	}
+	if queueMaxAge < 0 {
+		return nil, fmt.Errorf("CATDOOR_DETECTION_QUEUE_MAX_AGE must not be negative, got %s", queueMaxAge)
+	}
+	queueRetry, err := envDuration("CATDOOR_DETECTION_QUEUE_RETRY", defaultQueueRetryInterval)
+	if err != nil {
+		return nil, err
+	}
+	if queueRetry <= 0 {
+		return nil, fmt.Errorf("CATDOOR_DETECTION_QUEUE_RETRY must be positive, got %s", queueRetry)
+	}
+
+	pingCommand, err := envOrDefault("CATDOOR_PING_COMMAND", defaultPingCommand)
+	if err != nil {
+		return nil, err
+	}
+	pingCommand = strings.ToUpper(pingCommand)
+	if strings.ContainsFunc(pingCommand, func(r rune) bool { return r < 'A' || r > 'Z' }) {
+		return nil, fmt.Errorf("CATDOOR_PING_COMMAND must be a single word, got %q", pingCommand)
+	}
+	if validMode(pingCommand) {
+		return nil, fmt.Errorf("CATDOOR_PING_COMMAND must not change the mode, got %q", pingCommand)
+	}
+	versionCommand, err := envOrDefault("CATDOOR_VERSION_COMMAND", defaultVersionCommand)
+	if err != nil {
+		return nil, err
+	}
+	versionCommand = strings.ToUpper(versionCommand)
+	if strings.ContainsFunc(versionCommand, func(r rune) bool { return r < 'A' || r > 'Z' }) {
+		return nil, fmt.Errorf("CATDOOR_VERSION_COMMAND must be a single word, got %q", versionCommand)
+	}
+	if validMode(versionCommand) {
+		return nil, fmt.Errorf("CATDOOR_VERSION_COMMAND must not change the mode, got %q", versionCommand)
+	}
+
+	unlockAttempts, err := envInt("CATDOOR_UNLOCK_ATTEMPTS", defaultUnlockAttempts)
+	if err != nil {
+		return nil, err
+	}
+	if unlockAttempts < 1 {
+		return nil, fmt.Errorf("CATDOOR_UNLOCK_ATTEMPTS must be at least 1, got %d", unlockAttempts)
+	}
+
+	fallback, err := envOrDefault("CATDOOR_UNLOCK_FALLBACK", "")
+	if err != nil {
+		return nil, err
+	}
+	unlockFallback, err := parseFallbackCommands(fallback)
+	if err != nil {
+		return nil, fmt.Errorf("CATDOOR_UNLOCK_FALLBACK: %w", err)
+	}
+
+	apiToken, err := envToken()
+	if err != nil {
+		return nil, err
+	}
+	authReads, err := envBool("CATDOOR_AUTH_READS", false)
+	if err != nil {
+		return nil, err
+	}
+	if authReads && apiToken == "" {
+		return nil, fmt.Errorf("CATDOOR_AUTH_READS requires CATDOOR_API_TOKEN or CATDOOR_API_TOKEN_FILE")
+	}
+
+	auditPath, err := envOrDefault("CATDOOR_AUDIT_LOG", "")
+	if err != nil {
+		return nil, err
+	}
+	var audit *auditLog
+	if auditPath != "" {
+		if auditPath, err = expandHome(auditPath); err != nil {
+			return nil, fmt.Errorf("invalid CATDOOR_AUDIT_LOG: %w", err)
+		}
+		if audit, err = openAuditLog(auditPath); err != nil {
+			return nil, fmt.Errorf("CATDOOR_AUDIT_LOG: %w", err)
+		}
+	}
+
+	maxUnlocks, err := envInt("CATDOOR_MAX_UNLOCK_TIMERS", defaultMaxUnlockTimers)
+	if err != nil {
+		return nil, err
+	}
+	if maxUnlocks < 0 {
+		return nil, fmt.Errorf("CATDOOR_MAX_UNLOCK_TIMERS must not be negative, got %d", maxUnlocks)
+	}
+
+	rateLimit, err := envInt("CATDOOR_RATE_LIMIT", defaultRateLimit)
+	if err != nil {
+		return nil, err
+	}
+	if rateLimit < 0 {
+		return nil, fmt.Errorf("CATDOOR_RATE_LIMIT must not be negative, got %d", rateLimit)
+	}
+	var limiter *tokenBucket
+	if rateLimit > 0 {
+		limiter = newTokenBucket(rateLimit)
+	}
+
+	detectAllow, err := parsePrefixes(os.Getenv("CATDOOR_DETECT_ALLOW_CIDRS"))
+	if err != nil {
+		return nil, fmt.Errorf("CATDOOR_DETECT_ALLOW_CIDRS: %w", err)
+	}
+	trustedProxies, err := parsePrefixes(os.Getenv("CATDOOR_TRUSTED_PROXIES"))
+	if err != nil {
+		return nil, fmt.Errorf("CATDOOR_TRUSTED_PROXIES: %w", err)
+	}
+
+	// An empty CATDOOR_CORS_ORIGINS just means CORS is off.
+	var corsOrigins []string
+	for _, origin := range strings.Split(os.Getenv("CATDOOR_CORS_ORIGINS"), ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			corsOrigins = append(corsOrigins, origin)
+		}
+	}
+
+	// CATDOOR_REQUEST_LOG_FORMAT predates CATDOOR_LOG_FORMAT and is still
+	// honoured as its default.
+	logFormat, err := envOrDefault("CATDOOR_REQUEST_LOG_FORMAT", "text")
+	if err != nil {
+		return nil, err
+	}
+	logFormat, err = envOrDefault("CATDOOR_LOG_FORMAT", logFormat)
+	if err != nil {
+		return nil, err
+	}
+	logLevel, err := envOrDefault("CATDOOR_LOG_LEVEL", "info")
+	if err != nil {
+		return nil, err
+	}
+	tz, err := envOrDefault("CATDOOR_TZ", defaultTimezone)
+	if err != nil {
+		return nil, err
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CATDOOR_TZ %q: %w", tz, err)
+	}
+	// The service's own logs go to stdout unless CATDOOR_LOG_FILE names a
+	// file, which is then rotated by size.
+	var logOut io.Writer = os.Stdout
+	var logFile *rotatingFile
+	if logPath := strings.TrimSpace(os.Getenv("CATDOOR_LOG_FILE")); logPath != "" {
+		maxBytes, err := envInt("CATDOOR_LOG_MAX_BYTES", defaultLogMaxBytes)
+		if err != nil {
+			return nil, err
+		}
+		if maxBytes <= 0 {
+			return nil, fmt.Errorf("CATDOOR_LOG_MAX_BYTES must be positive, got %d", maxBytes)
+		}
+		maxFiles, err := envInt("CATDOOR_LOG_MAX_FILES", defaultLogMaxFiles)
+		if err != nil {
+			return nil, err
+		}
+		if maxFiles < 0 {
+			return nil, fmt.Errorf("CATDOOR_LOG_MAX_FILES must not be negative, got %d", maxFiles)
+		}
+		logFile, err = openRotatingFile(logPath, int64(maxBytes), maxFiles)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CATDOOR_LOG_FILE: %w", err)
+		}
+		logOut = logFile
+	}
+	logger, err := newLogger(logOut, logFormat, logLevel, loc)
+	if err != nil {
+		return nil, err
+	}
+	if len(acks) > 0 && requestTimeout > 0 && requestTimeout < readTimeout+ackTimeout {
+		// The command still completes; only its response is lost.
+		logger.Warn("CATDOOR_REQUEST_TIMEOUT is shorter than a command waiting for its completion line",
+			"request_timeout", requestTimeout, "ack_timeout", ackTimeout)
+	}
+
+	var webhook *webhookNotifier
+	if webhookURL := strings.TrimSpace(os.Getenv("CATDOOR_WEBHOOK_URL")); webhookURL != "" {
+		u, err := url.Parse(webhookURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid CATDOOR_WEBHOOK_URL %q: want an http(s) URL", webhookURL)
+		}
+		webhookTimeout, err := envDuration("CATDOOR_WEBHOOK_TIMEOUT", defaultWebhookTimeout)
+		if err != nil {
+			return nil, err
+		}
+		if webhookTimeout <= 0 {
+			return nil, fmt.Errorf("CATDOOR_WEBHOOK_TIMEOUT must be positive, got %s", webhookTimeout)
+		}
+		webhookAttempts, err := envInt("CATDOOR_WEBHOOK_MAX_ATTEMPTS", defaultWebhookAttempts)
+		if err != nil {
+			return nil, err
+		}
+		if webhookAttempts < 1 {
+			return nil, fmt.Errorf("CATDOOR_WEBHOOK_MAX_ATTEMPTS must be at least 1, got %d", webhookAttempts)
+		}
+		webhook = newWebhookNotifier(webhookURL, webhookTimeout, logger)
+		webhook.attempts = webhookAttempts
+	}
+
+	reedLog, err := envLogPath("CATDOOR_REED_LOG", defaultReedLogPath, logger)
+	if err != nil {
+		return nil, err
+	}
+	radarLog, err := envLogPath("CATDOOR_RADAR_LOG", defaultRadarLogPath, logger)
+	if err != nil {
+		return nil, err
+	}
+	severityValue, err := envOrDefault("CATDOOR_LOG_SEVERITY_RULES", "")
+	if err != nil {
+		return nil, err
+	}
+	logSeverity, err := parseSeverityRules(severityValue)
+	if err != nil {
+		return nil, fmt.Errorf("CATDOOR_LOG_SEVERITY_RULES: %w", err)
+	}
+
+	dryRun, err := envBool("CATDOOR_DRY_RUN", false)
 	if err != nil {
-		return "", fmt.Errorf("cannot connect to controller: %w", err)
+		return nil, err
+	}
+	newController := func(addr string, log *slog.Logger) ControllerClient {
+		if dryRun {
+			return newDryRunController(log)
+		}
+		c := newTCPController(addr, retries, log)
+		c.keepAlive, c.framing = keepAlive, controllerFraming
+		c.dialTimeout, c.readTimeout = dialTimeout, readTimeout
+		c.acks, c.ackTimeout = acks, ackTimeout
+		if breakerThreshold > 0 {
+			c.breaker = newCircuitBreaker(breakerThreshold, breakerCooldown)
+		}
+		return c
+	}
+	config := newState(path)
+	clock := systemClock{}
+	m := newMetrics(config, clock.Now)
+
+	s := &server{
+		clock:           clock,
+		log:             logger,
+		logFormat:       logFormat,
+		logFile:         logFile,
+		loc:             loc,
+		listenAddr:      listenAddr,
+		tlsConfig:       tlsConfig,
+		controller:      &instrumentedController{next: newController(addr, logger), metrics: m},
+		controllerAddr:  addr,
+		dryRun:          dryRun,
+		config:          config,
+		history:         newHistoryStore(historyPath),
+		modes:           newModeHistory(modeHistoryPath),
+		telemetry:       newTelemetryStore(telemetryPath, telemetryInterval),
+		webhook:         webhook,
+		metrics:         m,
+		detectMode:      detectMode,
+		debounceWindow:  debounceWindow,
+		cooldown:        cooldown,
+		minConfidence:   minConfidence,
+		lockDuration:    lockDuration,
+		maxLock:         maxLock,
+		healthTimeout:   healthTimeout,
+		requestTimeout:  requestTimeout,
+		confirmTimeout:  confirmTimeout,
+		httpReadTimeout: httpReadTimeout,
+		httpIdleTimeout: httpIdleTimeout,
+		maxBodyBytes:    int64(maxBodyBytes),
+		pingCommand:     pingCommand,
+		versionCommand:  versionCommand,
+		reedLog:         reedLog,
+		logSeverity:     logSeverity,
+		radarLog:        radarLog,
+		apiToken:        apiToken,
+		audit:           audit,
+		detectAllow:     detectAllow,
+		trustedProxies:  trustedProxies,
+		authReads:       authReads,
+		corsOrigins:     corsOrigins,
+		limiter:         limiter,
+		unlock:          unlockTimer{clock: clock},
+		watchdog:        watchdog{interval: watchdogInterval, correct: watchdogCorrect},
+		queue:           detectionQueue{maxAge: queueMaxAge, retryInterval: queueRetry},
+		threshold:       detectionThreshold{count: thresholdCount, window: thresholdWindow},
+		unlockBackoff:   defaultAutoUnlockBackoff,
+		unlockAttempts:  unlockAttempts,
+		maxUnlocks:      maxUnlocks,
+		unlockFallback:  unlockFallback,
 	}
-	defer conn.Close()
+	m.trackUnlockTimers(&s.unlock)
+	m.trackDetectionsToday(&s.detectionsToday, s.now)
 
-	_, err = io.WriteString(conn, cmd+"\n")
+	devicesFile, err := envOrDefault("CATDOOR_DEVICES_FILE", "")
+	if err != nil {
+		return nil, err
+	}
+	if devicesFile == "" {
+		return s, nil
+	}
+	if devicesFile, err = expandHome(devicesFile); err != nil {
+		return nil, fmt.Errorf("invalid CATDOOR_DEVICES_FILE: %w", err)
+	}
+	specs, err := loadDeviceSpecs(devicesFile, path)
 	if err != nil {
-		return "", fmt.Errorf("failed to send command: %w", err)
+		return nil, fmt.Errorf("CATDOOR_DEVICES_FILE: %w", err)
 	}
+	return s.withDevices(specs, newController), nil
+}
 
-	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
-	resp, err := io.ReadAll(conn)
+// validateListenAddr checks that addr is host:port with a numeric port; the
+// host may be empty to listen on every interface. A unix: address needs a
+// socket path in an existing directory.
+func validateListenAddr(addr string) error {
+	if path, ok := unixSocketPath(addr); ok {
+		if path == "" {
+			return fmt.Errorf("missing socket path")
+		}
+		if info, err := os.Stat(filepath.Dir(path)); err != nil || !info.IsDir() {
+			return fmt.Errorf("directory %s does not exist", filepath.Dir(path))
+		}
+		return nil
+	}
+	_, port, err := net.SplitHostPort(addr)
 	if err != nil {
-		return "", fmt.Errorf("failed to read response: %w", err)
+		return err
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		return fmt.Errorf("invalid port %q", port)
 	}
+	return nil
+}
 
-	return string(resp), nil
+// envOrDefault returns the trimmed value of an environment variable, or def
+// when it is unset. A variable that is set but blank is an error.
+func envOrDefault(key, def string) (string, error) {
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		return def, nil
+	}
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return "", fmt.Errorf("%s is set but empty", key)
+	}
+	return value, nil
 }
 
-// loadConfig reads the config file
-func loadConfig() (*Config, error) {
-	data, err := os.ReadFile(configPath)
+// envLogPath reads a log file path. A path that was set explicitly must be in
+// an existing directory; for the default a missing directory only warns, as
+// the logs may simply not have been written yet.
+func envLogPath(key, def string, log *slog.Logger) (string, error) {
+	path, err := envOrDefault(key, def)
+	if err != nil {
+		return "", err
+	}
+	path, err = expandHome(path)
 	if err != nil {
-		if os.IsNotExist(err) {
-			return &Config{}, nil
+		return "", fmt.Errorf("invalid %s: %w", key, err)
+	}
+
+	dir := filepath.Dir(path)
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		if _, set := os.LookupEnv(key); set {
+			return "", fmt.Errorf("invalid %s: directory %s does not exist", key, dir)
 		}
-		return nil, err
+		log.Warn("log directory does not exist", "env", key, "dir", dir)
 	}
+	return path, nil
+}
 
-	var config Config
-	if err := json.Unmarshal(data, &config); err != nil {
-		return nil, err
+// envDuration parses an environment variable with time.ParseDuration, or
+// returns def when it is unset.
+func envDuration(key string, def time.Duration) (time.Duration, error) {
+	value, err := envOrDefault(key, def.String())
+	if err != nil {
+		return 0, err
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", key, err)
 	}
-	return &config, nil
+	return d, nil
 }
 
-// saveConfig writes the config file
-func saveConfig(config *Config) error {
-	data, err := json.MarshalIndent(config, "", "  ")
+// envFloat parses an environment variable as a number, or returns def when
+// it is unset.
+func envFloat(key string, def float64) (float64, error) {
+	value, err := envOrDefault(key, strconv.FormatFloat(def, 'f', -1, 64))
 	if err != nil {
-		return err
+		return 0, err
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", key, err)
+	}
+	return f, nil
+}
+
+// envInt parses an environment variable as an integer, or returns def when
+// it is unset.
+func envInt(key string, def int) (int, error) {
+	value, err := envOrDefault(key, strconv.Itoa(def))
+	if err != nil {
+		return 0, err
 	}
-	return os.WriteFile(configPath, data, 0644)
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", key, err)
+	}
+	return n, nil
 }
 
-// detectedHandler handles prey detection events
-func detectedHandler(w http.ResponseWriter, r *http.Request) {
-	fmt.Println("🚨 Prey detected! Locking catflap...")
+// envBool parses an environment variable with strconv.ParseBool, or returns
+// def when it is unset.
+func envBool(key string, def bool) (bool, error) {
+	value, err := envOrDefault(key, strconv.FormatBool(def))
+	if err != nil {
+		return false, err
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s: %w", key, err)
+	}
+	return b, nil
+}
 
-	// Set mode to RED immediately
-	resp, err := sendToController("RED")
+// expandHome replaces a leading ~ with the current user's home directory
+func expandHome(path string) (string, error) {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path, nil
+	}
+	home, err := os.UserHomeDir()
 	if err != nil {
-		http.Error(w, "failed to lock catflap: "+err.Error(), http.StatusBadGateway)
-		return
+		return "", err
 	}
+	return filepath.Join(home, path[1:]), nil
+}
 
-	// Update config with detection timestamp
-	now := time.Now()
-	unlockTime := now.Add(5 * time.Minute)
+// lockDurationFor returns the lock duration requested via the duration query
+// parameter, capped at the configured maximum. Absent means def.
+func (s *server) lockDurationFor(r *http.Request, def time.Duration) (time.Duration, error) {
+	value := strings.TrimSpace(r.URL.Query().Get("duration"))
+	if value == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q", value)
+	}
+	if d < minLockDuration {
+		return 0, fmt.Errorf("duration must be at least %s", minLockDuration)
+	}
+	if d > s.maxLock {
+		d = s.maxLock
+	}
+	return d, nil
+}
+
+// detectedHandler handles prey detection events. With ?confirm=true it
+// only answers success once STATUS reports the lock mode, and 502 if that
+// doesn't happen within CATDOOR_CONFIRM_TIMEOUT.
+func (s *server) detectedHandler(w http.ResponseWriter, r *http.Request) {
+	// Detections are handled one at a time so the debounce check and the
+	// timer replacement below see a consistent lastDetection.
+	s.detectMu.Lock()
+	defer s.detectMu.Unlock()
 
-	config := &Config{
-		LastDetected: now.Format(time.RFC3339),
-		LockedUntil:  unlockTime.Format(time.RFC3339),
+	// The time-of-day windows are resolved at the moment of detection; an
+	// explicit ?duration still wins over theirs.
+	now := s.now()
+	profile := s.resolveDetection(now)
+	detectMode := profile.detectMode
+	lockDuration, err := s.lockDurationFor(r, profile.lockDuration)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, errCodeInvalidRequest, err.Error())
+		return
+	}
+	meta, err := parseDetectionMetadata(r)
+	if err != nil {
+		writeBodyError(w, r, err)
+		return
+	}
+	minConfidence, err := s.minConfidenceFor(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, errCodeInvalidRequest, err.Error())
+		return
+	}
+	confirm, err := confirmRequested(r.URL.Query().Get("confirm"))
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, errCodeInvalidRequest, err.Error())
+		return
 	}
 
-	if err := saveConfig(config); err != nil {
-		fmt.Printf("Warning: failed to save config: %v\n", err)
+	source := detectionSource(r)
+	id := newDetectionID()
+	if m := s.maintenance(); m != nil {
+		s.log.Info("prey detected in maintenance mode, not locking", "since", m.Since)
+		event := DetectionEvent{ID: id, Timestamp: now.Truncate(time.Second), Duration: "0s", Source: source, Maintenance: true, Metadata: meta}
+		s.recordDetection(event)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":      "maintenance",
+			"id":          id,
+			"acted":       false,
+			"maintenance": true,
+			"metadata":    meta,
+		})
+		return
+	}
+	if meta != nil && meta.Confidence != nil && *meta.Confidence < minConfidence {
+		s.log.Info("prey detected below minimum confidence, not locking",
+			"confidence", *meta.Confidence, "min_confidence", minConfidence)
+		event := DetectionEvent{ID: id, Timestamp: now.Truncate(time.Second), Duration: "0s", Source: source, BelowConfidence: true, Metadata: meta}
+		s.recordDetection(event)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":         "ignored",
+			"id":             id,
+			"acted":          false,
+			"confidence":     *meta.Confidence,
+			"min_confidence": minConfidence,
+			"metadata":       meta,
+		})
+		return
+	}
+	if until, ok := s.snoozedUntil(now); ok {
+		s.log.Info("prey detected while snoozed, not locking", "snoozed_until", until.Format(time.RFC3339))
+		event := DetectionEvent{ID: id, Timestamp: now.Truncate(time.Second), Duration: "0s", Source: source, Snoozed: true, Metadata: meta}
+		s.recordDetection(event)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":        "snoozed",
+			"id":            id,
+			"acted":         false,
+			"snoozed":       true,
+			"snoozed_until": until.Format(time.RFC3339),
+			"metadata":      meta,
+		})
+		return
 	}
 
-	fmt.Printf("✅ Catflap locked until %s\n", unlockTime.Format("15:04:05"))
+	if until, ok := s.cooldownUntil(now); ok {
+		s.log.Info("prey detected during the post-unlock cooldown, not locking", "cooldown_until", until.Format(time.RFC3339))
+		event := DetectionEvent{ID: id, Timestamp: now.Truncate(time.Second), Duration: "0s", Source: source, Cooldown: true, Metadata: meta}
+		s.recordDetection(event)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":         "cooldown",
+			"id":             id,
+			"acted":          false,
+			"cooldown":       true,
+			"cooldown_until": until.Format(time.RFC3339),
+			"metadata":       meta,
+		})
+		return
+	}
 
-	// Start goroutine to auto-unlock after 5 minutes
-	go func() {
-		time.Sleep(5 * time.Minute)
-		fmt.Println("⏰ Auto-unlocking catflap after 5 minutes...")
+	if s.debounceWindow > 0 && !s.lastDetection.IsZero() && now.Sub(s.lastDetection) < s.debounceWindow {
+		s.log.Info("prey detected again within debounce window, ignoring",
+			"since_last", now.Sub(s.lastDetection), "window", s.debounceWindow)
+		event := DetectionEvent{ID: id, Timestamp: now.Truncate(time.Second), Duration: "0s", Source: source, Debounced: true, Metadata: meta}
+		s.recordDetection(event)
+		s.writeDebounced(w, id, profile, meta)
+		return
+	}
 
-		unlockResp, err := sendToController("GREEN")
-		if err != nil {
-			fmt.Printf("❌ Failed to auto-unlock: %v\n", err)
+	var threshold *ThresholdStatus
+	if s.threshold.enabled() {
+		var met bool
+		threshold, met = s.threshold.add(now)
+		if !met {
+			s.log.Info("prey detected, waiting for more detections before locking",
+				"count", threshold.Count, "required", threshold.Required, "window", threshold.Window)
+			event := DetectionEvent{ID: id, Timestamp: now.Truncate(time.Second), Duration: "0s", Source: source, BelowThreshold: true, Metadata: meta}
+			s.recordDetection(event)
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"status":    "pending",
+				"id":        id,
+				"acted":     false,
+				"threshold": threshold,
+				"metadata":  meta,
+			})
 			return
 		}
+	}
+
+	if s.maxUnlocks > 0 && s.unlock.inFlight() >= s.maxUnlocks {
+		s.log.Error("too many unlock timers in flight, refusing detection",
+			"in_flight", s.unlock.inFlight(), "max", s.maxUnlocks)
+		writeError(w, r, http.StatusServiceUnavailable, errCodeUnavailable, "too many pending unlocks, try again later")
+		return
+	}
+
+	s.log.Info("prey detected, locking catflap", "mode", detectMode, "duration", lockDuration)
 
-		fmt.Printf("✅ Auto-unlock complete: %s\n", unlockResp)
+	// Lock immediately and schedule the auto-unlock, replacing the one of
+	// any earlier detection.
+	var resp string
+	previous, err := s.unlock.lockAndSchedule(lockDuration, func() error {
+		var err error
+		resp, err = s.setMode(detectMode, "detection")
+		return err
+	}, func() {
+		s.log.Info("auto-unlocking catflap", "after", lockDuration)
+		s.autoUnlock()
+	})
+	if err != nil {
+		s.log.Error("failed to lock catflap", "error", err)
+		if s.queueable(err) {
+			s.queueDetection(w, r, PendingDetection{
+				ID:         id,
+				DetectedAt: now.Format(time.RFC3339),
+				Mode:       detectMode,
+				Duration:   lockDuration.String(),
+				Source:     source,
+			}, meta, err)
+			return
+		}
+		writeControllerError(w, r, "failed to lock catflap: ", err)
+		return
+	}
+	s.lastDetection = now
 
-		// Clear locked_until in config
-		config, err := loadConfig()
-		if err == nil {
-			config.LockedUntil = ""
-			saveConfig(config)
+	// The lock stands either way; a failed confirmation only changes the
+	// answer, after the bookkeeping below.
+	var confirmErr error
+	if confirm {
+		confirmErr = s.confirmMode(detectMode)
+		if confirmErr != nil {
+			s.log.Error("lock not confirmed", "mode", detectMode, "error", confirmErr)
 		}
-	}()
+	}
+
+	// Update config with detection timestamp
+	unlockTime := now.Add(lockDuration)
+
+	_, err = s.config.updateState(func(config *Config) {
+		config.LastDetected = now.Format(time.RFC3339)
+		config.LockedUntil = unlockTime.Format(time.RFC3339)
+		config.LockedBy = id
+		// This lock supersedes any detection still queued.
+		config.PendingDetections = nil
+	})
+	persisted := err == nil
+	if !persisted {
+		s.log.Error("failed to save lock; it will not survive a restart", "config", s.config.path, "error", err)
+	}
+
+	s.log.Info("catflap locked", "locked_until", unlockTime.Format(time.RFC3339))
+	s.metrics.detections.Inc()
+
+	event := DetectionEvent{ID: id, Timestamp: now.Truncate(time.Second), Duration: lockDuration.String(), Source: source, Metadata: meta}
+	s.recordDetection(event)
+
+	// A detection during an active lock replaces its unlock timer rather
+	// than starting a second one; tell the caller which lock it replaced.
+	extended := !previous.IsZero()
+	var previousLockedUntil interface{}
+	if extended {
+		previousLockedUntil = previous.In(s.loc).Format(time.RFC3339)
+	}
+
+	if s.webhook != nil {
+		s.webhook.notify(map[string]interface{}{
+			"event":        "prey_detected",
+			"mode":         detectMode,
+			"detected_at":  now.Format(time.RFC3339),
+			"locked_until": unlockTime.Format(time.RFC3339),
+			"duration":     lockDuration.String(),
+			"source":       source,
+			"metadata":     meta,
+			"controller":   strings.TrimSpace(resp),
+		})
+	}
+
+	if confirmErr != nil {
+		writeControllerError(w, r, "failed to confirm the lock: ", confirmErr)
+		return
+	}
 
 	// Return success response
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"status":       "locked",
-		"locked_until": unlockTime.Format(time.RFC3339),
-		"controller":   strings.TrimSpace(resp),
+		"status":                "locked",
+		"id":                    id,
+		"confirmed":             confirm,
+		"acted":                 true,
+		"debounced":             false,
+		"snoozed":               false,
+		"mode":                  detectMode,
+		"locked_until":          unlockTime.Format(time.RFC3339),
+		"duration":              lockDuration.String(),
+		"window":                profile.window,
+		"threshold":             threshold,
+		"extended":              extended,
+		"metadata":              meta,
+		"persisted":             persisted,
+		"controller":            strings.TrimSpace(resp),
+		"previous_locked_until": previousLockedUntil,
 	})
 }
 
-// modeHandler handles requests like /mode/green, /mode/yellow, /mode/red
-func modeHandler(w http.ResponseWriter, r *http.Request) {
-	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
-	if len(parts) != 2 || parts[0] != "mode" {
-		http.NotFound(w, r)
+// minConfidenceFor returns the ?min_confidence override, or the configured
+// threshold without one
+func (s *server) minConfidenceFor(r *http.Request) (float64, error) {
+	value := strings.TrimSpace(r.URL.Query().Get("min_confidence"))
+	if value == "" {
+		return s.minConfidence, nil
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil || f < 0 || f > 1 {
+		return 0, fmt.Errorf("invalid min_confidence %q (must be between 0 and 1)", value)
+	}
+	return f, nil
+}
+
+// detectionSource names who reported a detection, from the source query
+// parameter.
+func detectionSource(r *http.Request) string {
+	if source := strings.TrimSpace(r.URL.Query().Get("source")); source != "" {
+		return source
+	}
+	return "detector"
+}
+
+// writeDebounced answers a detection ignored by the debounce window with
+// the lock that is already in place and the profile resolved for it.
+func (s *server) writeDebounced(w http.ResponseWriter, id string, profile detectionProfile, meta *DetectionMetadata) {
+	response := map[string]interface{}{
+		"status":    "locked",
+		"id":        id,
+		"acted":     false,
+		"debounced": true,
+		"mode":      profile.detectMode,
+		"window":    profile.window,
+		"metadata":  meta,
+	}
+	if config, err := s.config.load(); err == nil && config.LockedUntil != "" {
+		response["locked_until"] = config.LockedUntil
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// unlockHandler handles POST /unlock, releasing an active lock early. The
+// pending auto-unlock is cancelled so it can't fire later.
+func (s *server) unlockHandler(w http.ResponseWriter, r *http.Request) {
+
+	// GREEN and cancelling the timer happen together under unlock.mu, so
+	// no auto-unlock or detection can land in between.
+	var resp string
+	cancelled, err := s.unlock.stopWith(func() error {
+		var err error
+		resp, err = s.setMode("GREEN", "manual")
+		return err
+	})
+	if err != nil {
+		writeControllerError(w, r, "failed to unlock catflap: ", err)
 		return
 	}
+	s.unlockFailure.clear()
 
-	name := strings.ToUpper(parts[1])
-	switch name {
-	case "GREEN", "YELLOW", "RED":
-		resp, err := sendToController(name)
-		if err != nil {
-			http.Error(w, "controller error: "+err.Error(), http.StatusBadGateway)
-			return
-		}
-		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-		fmt.Fprint(w, resp)
-	default:
-		http.Error(w, "unknown mode (use green|yellow|red)", http.StatusBadRequest)
+	var previous Config
+	current, err := s.config.updateState(func(config *Config) {
+		previous = *config
+		config.LockedUntil = ""
+		config.LockedBy = ""
+	})
+	if err != nil {
+		s.log.Warn("failed to save config", "error", err)
+	}
+	if current == nil {
+		current = &Config{}
 	}
+
+	s.log.Info("catflap unlocked manually", "cancelled_timers", cancelled, "previous_locked_until", previous.LockedUntil)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":           "unlocked",
+		"previous":         previous,
+		"current":          current,
+		"cancelled_timers": cancelled,
+		"controller":       strings.TrimSpace(resp),
+	})
 }
 
-// statusHandler handles /status
-func statusHandler(w http.ResponseWriter, r *http.Request) {
-	resp, err := sendToController("STATUS")
+// autoUnlock sends GREEN to the controller, up to unlockAttempts times, and
+// clears locked_until. If GREEN never goes through, the fallback commands
+// are tried. Success and failure are both sent to the webhook: a silent
+// failure would leave the cat locked out.
+func (s *server) autoUnlock() {
+	var unlockResp string
+	var err error
+	backoff := s.unlockBackoff
+	for attempt := 0; ; attempt++ {
+		unlockResp, err = s.setMode("GREEN", "auto-unlock")
+		if err == nil || attempt+1 >= s.unlockAttempts {
+			break
+		}
+		s.log.Warn("auto-unlock failed, retrying",
+			"attempt", attempt+1, "attempts", s.unlockAttempts, "backoff", backoff, "error", err)
+		<-s.clock.After(backoff)
+		backoff *= 2
+	}
+	now := s.now()
+	mode := "GREEN"
+	if err != nil && len(s.unlockFallback) > 0 {
+		unlockResp, err = s.escalateUnlock(now, err)
+		mode = s.unlockFallback[len(s.unlockFallback)-1]
+	}
 	if err != nil {
-		http.Error(w, "controller error: "+err.Error(), http.StatusBadGateway)
+		s.log.Error("AUTO-UNLOCK FAILED: catflap may still be locked",
+			"attempts", s.unlockAttempts, "error", err)
+		s.unlockFailure.set(err, now)
+		if s.webhook != nil {
+			s.webhook.notify(map[string]interface{}{
+				"event":     "auto_unlock_failed",
+				"priority":  "high",
+				"failed_at": now.Format(time.RFC3339),
+				"attempts":  s.unlockAttempts,
+				"escalated": len(s.unlockFallback) > 0,
+				"error":     err.Error(),
+			})
+		}
 		return
 	}
-	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-	fmt.Fprint(w, resp)
+
+	s.log.Info("auto-unlock complete", "controller", strings.TrimSpace(unlockResp))
+	s.unlockFailure.clear()
+	if s.webhook != nil {
+		s.webhook.notify(map[string]interface{}{
+			"event":       "auto_unlocked",
+			"mode":        mode,
+			"unlocked_at": now.Format(time.RFC3339),
+			"controller":  strings.TrimSpace(unlockResp),
+		})
+	}
+
+	// Clear locked_until in config and start the cooldown
+	_, err = s.config.updateState(func(config *Config) {
+		config.LockedUntil = ""
+		config.LockedBy = ""
+		if s.cooldown > 0 {
+			config.CooldownUntil = now.Add(s.cooldown).Format(time.RFC3339)
+		}
+	})
+	if err != nil {
+		s.log.Warn("failed to save config", "error", err)
+	}
+}
+
+// recoverLock re-arms the auto-unlock for a lock that was still active when
+// the process last stopped. A lock that expired while the process was down is
+// released immediately.
+func (s *server) recoverLock(now time.Time) error {
+	config, err := s.config.load()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	if config.LockedUntil == "" {
+		return nil
+	}
+
+	lockedUntil, err := time.Parse(time.RFC3339, config.LockedUntil)
+	if err != nil {
+		return fmt.Errorf("invalid locked_until %q: %w", config.LockedUntil, err)
+	}
+
+	remaining := lockedUntil.Sub(now)
+	if remaining <= 0 {
+		s.log.Info("lock expired while stopped, unlocking now", "locked_until", config.LockedUntil)
+		s.autoUnlock()
+		return nil
+	}
+
+	s.log.Info("restoring lock", "locked_until", config.LockedUntil)
+	s.unlock.schedule(remaining, func() {
+		s.log.Info("auto-unlocking restored lock")
+		s.autoUnlock()
+	})
+	return nil
 }
 
-// logsHandler parses and returns the logs as JSON
-func logsHandler(w http.ResponseWriter, r *http.Request) {
-	logType := r.URL.Query().Get("type")
+// modeNames are the modes accepted by /mode/{name}, in display order
+var modeNames = []string{"green", "yellow", "red"}
+
+// parseModePath extracts the mode from a /mode/{name} request. The single
+// segment after /mode/ is unescaped, trimmed and compared case-insensitively;
+// extra segments, an empty name and query strings are rejected.
+func parseModePath(r *http.Request) (string, error) {
+	rest, ok := strings.CutPrefix(r.URL.EscapedPath(), "/mode/")
+	if !ok {
+		return "", fmt.Errorf("path must be /mode/{%s}", strings.Join(modeNames, "|"))
+	}
+	if strings.Contains(rest, "/") {
+		return "", fmt.Errorf("unexpected path segments after the mode")
+	}
+	if r.URL.RawQuery != "" {
+		return "", fmt.Errorf("/mode takes no query parameters")
+	}
+	name, err := url.PathUnescape(rest)
+	if err != nil {
+		return "", fmt.Errorf("invalid mode encoding: %v", err)
+	}
+	name = strings.TrimSpace(name)
+	for _, mode := range modeNames {
+		if strings.EqualFold(name, mode) {
+			return strings.ToUpper(mode), nil
+		}
+	}
+	return "", fmt.Errorf("unknown mode %q", name)
+}
 
-	var filePath string
-	switch strings.ToLower(logType) {
-	case "reed":
-		filePath = "/home/rami/logs/reed_logs.txt"
-	case "radar":
-		filePath = "/home/rami/logs/sensor_logs.txt"
-	default:
-		http.Error(w, "invalid type parameter (use type=reed or type=radar)", http.StatusBadRequest)
+// modeHandler handles requests like /mode/green, /mode/yellow, /mode/red
+func (s *server) modeHandler(w http.ResponseWriter, r *http.Request) {
+	name, err := parseModePath(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, errCodeInvalidRequest, err.Error()+" (valid modes: "+strings.Join(modeNames, ", ")+")")
 		return
 	}
 
-	content, err := os.ReadFile(filePath)
+	resp, err := s.manualMode(name)
 	if err != nil {
-		http.Error(w, "failed to read log file: "+err.Error(), http.StatusInternalServerError)
+		writeControllerError(w, r, "controller error: ", err)
 		return
 	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprint(w, resp)
+}
 
-	lines := strings.Split(string(content), "\n")
-	var logs []map[string]string
+// manualMode sets a mode asked for by a user, via /mode/* or /ws. A manual
+// GREEN also clears a reported auto-unlock failure.
+func (s *server) manualMode(name string) (string, error) {
+	resp, err := s.setMode(name, "manual")
+	if err != nil {
+		return resp, err
+	}
+	if name == "GREEN" {
+		s.unlockFailure.clear()
+	}
+	return resp, nil
+}
 
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if line == "" {
-			continue
-		}
+// routes registers the device-scoped endpoints on mux
+func (s *server) routes(mux *http.ServeMux) {
+	get, post := http.MethodGet, http.MethodPost
+	// Every request/response endpoint gets the request budget; a batch may
+	// also spend up to maxBatchWait in its WAIT steps, which covers the
+	// self-test's pauses too, and a detection may wait to confirm its lock.
+	// The streams are left out, as http.TimeoutHandler would buffer them
+	// whole.
+	t, batchTimeout, detectTimeout := s.requestTimeout, s.requestTimeout, s.requestTimeout
+	if t > 0 {
+		batchTimeout += maxBatchWait
+		detectTimeout += s.confirmTimeout
+	}
+	mux.HandleFunc("/mode/", timed(allowMethods(s.requireAuth(s.rateLimit(s.modeHandler)), post), t))
+	mux.HandleFunc("/mode/history", timed(allowMethods(s.readAuth(s.modeHistoryHandler), get), t))
+	mux.HandleFunc("/modes", timed(allowMethods(s.readAuth(s.modesHandler), get), t))
+	mux.HandleFunc("/status", timed(allowMethods(s.readAuth(s.statusHandler), get), t))
+	mux.HandleFunc("/logs", timed(allowMethods(s.readAuth(s.logsHandler), get), t))
+	mux.HandleFunc("/logs/stream", allowMethods(s.readAuth(s.logsStreamHandler), get))
+	mux.HandleFunc("/ws", allowMethods(s.requireAuth(s.wsHandler), get))
+	mux.HandleFunc("/detected", timed(allowMethods(s.limitBody(s.allowDetectors(s.requireAuth(s.idempotent(s.rateLimit(s.detectedHandler))))), post), detectTimeout)) // NEW ENDPOINT
+	mux.HandleFunc("/unlock", timed(allowMethods(s.requireAuth(s.rateLimit(s.unlockHandler)), post), t))
+	mux.HandleFunc("/commands", timed(allowMethods(s.limitBody(s.requireAuth(s.idempotent(s.rateLimit(s.commandsHandler)))), post), batchTimeout))
+	mux.HandleFunc("/selftest", timed(allowMethods(s.requireAuth(s.rateLimit(s.selftestHandler)), post), batchTimeout))
+	mux.HandleFunc("/reset", timed(allowMethods(s.requireAuth(s.rateLimit(s.resetHandler)), post), t))
+	mux.HandleFunc("/lock", timed(allowMethods(s.requireAuth(s.idempotent(s.rateLimit(s.lockHandler))), post), t))
+	mux.HandleFunc("/healthz", timed(allowMethods(s.healthzHandler, get), t))
+	mux.HandleFunc("/ping", timed(allowMethods(s.readAuth(s.pingHandler), get), t))
+	mux.HandleFunc("/version", timed(allowMethods(s.readAuth(s.versionHandler), get), t))
+	mux.HandleFunc("/openapi.json", timed(allowMethods(s.openAPIHandler, get), t))
+	mux.HandleFunc("/docs", timed(allowMethods(s.docsHandler, get), t))
+	mux.HandleFunc("/schedule", timed(s.limitBody(s.methodAuth(s.scheduleHandler)), t))
+	mux.HandleFunc("/detections", timed(allowMethods(s.readAuth(s.detectionsHandler), get), t))
+	mux.HandleFunc("/detections/", timed(allowMethods(s.requireAuth(s.rateLimit(s.detectionCancelHandler)), post), t))
+	mux.HandleFunc("/detections.csv", allowMethods(s.readAuth(s.detectionsCSVHandler), get))
+	mux.HandleFunc("/telemetry", timed(allowMethods(s.readAuth(s.telemetryHandler), get), t))
+	mux.HandleFunc("/stats", timed(allowMethods(s.readAuth(s.statsHandler), get), t))
+	mux.HandleFunc("/metrics", timed(allowMethods(s.readAuth(s.metrics.handler().ServeHTTP), get), t))
+	mux.HandleFunc("/maintenance", timed(allowMethods(s.methodAuth(s.maintenanceHandler), get, post), t))
+	mux.HandleFunc("/snooze", timed(allowMethods(s.methodAuth(s.snoozeHandler), get, post, http.MethodDelete), t))
+	mux.HandleFunc("/config", timed(allowMethods(s.limitBody(s.methodAuth(s.configHandler)), get, http.MethodPut), t))
+}
 
-		var timestamp, message string
+// newRouter returns the service's HTTP handler: every route, including the
+// per-device ones, on a mux of its own behind the request logger, CORS and
+// the audit log.
+// main serves it, and tests can serve it with httptest.NewServer.
+func (s *server) newRouter() http.Handler {
+	mux := http.NewServeMux()
+	s.routes(mux)
+	s.registerDevices(mux)
+	return s.logRequests(s.cors(s.audited(mux)))
+}
+
+// run serves handler on ln until ctx is cancelled, then stops accepting
+// connections, drains in-flight requests and stops the pending unlock. Its
+// locked_until stays in the config file, which gets a final flush.
+func (s *server) run(ctx context.Context, ln net.Listener, handler http.Handler) error {
+	httpServer := &http.Server{
+		Handler:           handler,
+		TLSConfig:         s.tlsConfig,
+		ReadHeaderTimeout: s.httpReadTimeout,
+		ReadTimeout:       s.httpReadTimeout,
+		IdleTimeout:       s.httpIdleTimeout,
+	}
 
-		if logType == "reed" {
-			parts := strings.SplitN(line, " ", 3)
-			if len(parts) >= 3 {
-				timestamp = parts[0] + " " + parts[1]
-				message = parts[2]
-			}
-		} else if logType == "radar" {
-			if strings.HasPrefix(line, "[") {
-				endBracket := strings.Index(line, "]")
-				if endBracket > 0 {
-					timestamp = line[1:endBracket]
-					message = strings.TrimSpace(line[endBracket+1:])
-				}
-			}
+	errCh := make(chan error, 1)
+	go func() {
+		if s.tlsConfig != nil {
+			errCh <- httpServer.ServeTLS(ln, "", "")
+		} else {
+			errCh <- httpServer.Serve(ln)
 		}
+	}()
 
-		if timestamp != "" && message != "" {
-			logs = append(logs, map[string]string{
-				"timestamp": timestamp,
-				"message":   message,
-			})
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+
+	s.log.Info("shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	err := httpServer.Shutdown(shutdownCtx)
+
+	for _, d := range s.allDevices() {
+		if d.unlock.stop() {
+			d.log.Warn("auto-unlock was outstanding; locked_until remains in config", "config", d.config.path)
+		} else {
+			d.log.Info("no auto-unlock outstanding")
 		}
+		if err := d.config.close(); err != nil {
+			d.log.Error("failed to save state on shutdown", "config", d.config.path, "error", err)
+		}
+		closeController(d.controller)
+	}
+	if s.logFile != nil {
+		s.logFile.Close()
 	}
+	return err
+}
 
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	json.NewEncoder(w).Encode(logs)
+// printEndpoints lists the routes for someone running the API interactively
+func printEndpoints(devices bool) {
+	fmt.Println("📡 Endpoints:")
+	fmt.Println("  - POST /detected[?duration=15m&source=name] (prey detection, honours Idempotency-Key)")
+	fmt.Println("  - POST /unlock (cancel an active lock)")
+	fmt.Println("  - POST /lock?duration=1h (manual lock)")
+	fmt.Println("  - POST /reset (GREEN, no timers, lock and snooze cleared)")
+	fmt.Println("  - POST /selftest[?force=true] (cycle every mode and report each step)")
+	fmt.Println("  - POST /mode/{green|yellow|red}")
+	fmt.Println("  - POST /commands [\"RED\", \"WAIT 2s\", \"YELLOW\"] (run a sequence atomically)")
+	fmt.Println("  - GET /mode/history?limit=N (mode changes)")
+	fmt.Println("  - GET /modes (supported modes and the active one)")
+	fmt.Println("  - GET /status[?format=text]")
+	fmt.Println("  - GET /healthz")
+	fmt.Println("  - GET /ping (controller round trip)")
+	fmt.Println("  - GET /version")
+	fmt.Println("  - GET /openapi.json (OpenAPI 3 spec), GET /docs (Swagger UI)")
+	fmt.Println("  - GET/POST /schedule (recurring mode windows)")
+	fmt.Println("  - GET /detections?limit=N (detection history)")
+	fmt.Println("  - GET /detections.csv (detection history as CSV)")
+	fmt.Println("  - POST /detections/{id}/cancel (end that detection's lock early)")
+	fmt.Println("  - GET /telemetry?from=&to= (battery and reed samples)")
+	fmt.Println("  - GET /stats (detection counts)")
+	fmt.Println("  - GET /metrics (Prometheus)")
+	fmt.Println("  - GET/PUT /config (runtime settings)")
+	fmt.Println("  - POST /snooze?duration=30m, DELETE /snooze (ignore detections)")
+	fmt.Println("  - GET/POST /maintenance[?mode=red|?enabled=false] (disarm detection until turned off)")
+	fmt.Println("  - GET /logs?type={reed|radar|all}[&from=&to=][&q=text][&rotated=true][&limit=N&offset=N|&tail=N][&format=json|ndjson|text][&input=text|ndjson][&severity=error,warning][&aggregate=hour|day]")
+	fmt.Println("  - GET /logs/stream?type={reed|radar}[&severity=error,warning] (Server-Sent Events)")
+	fmt.Println("  - GET /ws (websocket: status, modes and detections; accepts {\"cmd\":\"green\"})")
+	if devices {
+		fmt.Println("  - GET /devices; every route above also as /device/{name}/... (unscoped = first device)")
+	}
 }
 
 func main() {
-	http.HandleFunc("/mode/", modeHandler)
-	http.HandleFunc("/status", statusHandler)
-	http.HandleFunc("/logs", logsHandler)
-	http.HandleFunc("/detected", detectedHandler) // NEW ENDPOINT
+	s, err := newServerFromEnv()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Invalid configuration: %v\n", err)
+		os.Exit(1)
+	}
 
-	addr := ":8080"
-	fmt.Println("🚀 REST API listening on", addr)
-	fmt.Println("📡 Endpoints:")
-	fmt.Println("  - POST/GET /detected (prey detection)")
-	fmt.Println("  - GET /mode/{green|yellow|red}")
-	fmt.Println("  - GET /status")
-	fmt.Println("  - GET /logs?type={reed|radar}")
+	for _, d := range s.allDevices() {
+		if err := d.config.checkWritable(); err != nil {
+			d.log.Error("CONFIG FILE IS NOT WRITABLE: locks will only be kept in memory and won't survive a restart",
+				"config", d.config.path, "error", err)
+		}
+		if err := d.loadSettings(); err != nil {
+			d.log.Warn("failed to load saved settings", "error", err)
+		}
+		if err := d.recoverLock(d.now()); err != nil {
+			d.log.Warn("failed to recover lock state", "error", err)
+		}
+		if err := d.restoreDetectionsToday(d.now()); err != nil {
+			d.log.Warn("failed to restore the daily detection count", "error", err)
+		}
+	}
+
+	ln, err := listen(s.listenAddr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Cannot listen on %s: %v\n", s.listenAddr, err)
+		os.Exit(1)
+	}
+
+	auth := "disabled"
+	switch {
+	case s.authReads:
+		auth = "all endpoints except /healthz"
+	case s.apiToken != "":
+		auth = "mutating endpoints"
+	}
+	s.log.Info("REST API listening",
+		"addr", ln.Addr().String(),
+		"version", version,
+		"commit", commit,
+		"tls", s.tlsConfig != nil,
+		"tz", s.loc.String(),
+		"watchdog", s.watchdog.interval,
+		"controller", s.controllerAddr,
+		"dry_run", s.dryRun,
+		"config", s.config.path,
+		"reed_log", s.reedLog,
+		"radar_log", s.radarLog,
+		"lock_duration", s.lockDuration,
+		"max_lock_duration", s.maxLock,
+		"auth", auth,
+		"cors_origins", strings.Join(s.corsOrigins, ","),
+		"devices", strings.Join(s.deviceNames, ","))
+	if s.logFormat == "text" {
+		printEndpoints(len(s.deviceNames) > 0)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	for _, d := range s.allDevices() {
+		go d.runSchedule(ctx)
+		go d.runWatchdog(ctx)
+		go d.runDailyReset(ctx)
+		go d.runDetectionQueue(ctx)
+		go d.runTelemetry(ctx)
+		go d.readFirmwareVersion()
+	}
 
-	if err := http.ListenAndServe(addr, nil); err != nil {
+	if err := s.run(ctx, ln, s.newRouter()); err != nil {
 		panic(err)
 	}
 }
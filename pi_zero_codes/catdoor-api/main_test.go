@@ -0,0 +1,1052 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestNewServerFromEnvDefaults(t *testing.T) {
+	unsetEnv(t, "CATDOOR_CONTROLLER_ADDR", "CATDOOR_CONFIG_PATH", "CATDOOR_LISTEN_ADDR", "CATDOOR_REED_LOG", "CATDOOR_RADAR_LOG")
+
+	s, err := newServerFromEnv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if s.controllerAddr != defaultControllerAddr {
+		t.Errorf("controllerAddr = %q, want %q", s.controllerAddr, defaultControllerAddr)
+	}
+	if s.config.path != defaultConfigPath {
+		t.Errorf("configPath = %q, want %q", s.config.path, defaultConfigPath)
+	}
+	if s.listenAddr != defaultListenAddr {
+		t.Errorf("listenAddr = %q, want %q", s.listenAddr, defaultListenAddr)
+	}
+	if s.reedLog != defaultReedLogPath || s.radarLog != defaultRadarLogPath {
+		t.Errorf("log paths = %q, %q, want the defaults", s.reedLog, s.radarLog)
+	}
+}
+
+func TestNewServerFromEnvOverrides(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("CATDOOR_CONTROLLER_ADDR", " 10.0.0.5:9000 \n")
+	t.Setenv("CATDOOR_CONFIG_PATH", "~/catdoor.json ")
+
+	s, err := newServerFromEnv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if s.controllerAddr != "10.0.0.5:9000" {
+		t.Errorf("controllerAddr = %q", s.controllerAddr)
+	}
+	if want := filepath.Join(home, "catdoor.json"); s.config.path != want {
+		t.Errorf("configPath = %q, want %q", s.config.path, want)
+	}
+}
+
+func TestNewServerFromEnvLogPaths(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("CATDOOR_REED_LOG", filepath.Join(dir, "reed.txt"))
+	t.Setenv("CATDOOR_RADAR_LOG", filepath.Join(dir, "radar.txt"))
+
+	s, err := newServerFromEnv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if s.reedLog != filepath.Join(dir, "reed.txt") || s.radarLog != filepath.Join(dir, "radar.txt") {
+		t.Errorf("log paths = %q, %q", s.reedLog, s.radarLog)
+	}
+}
+
+func TestNewServerFromEnvListenAddr(t *testing.T) {
+	t.Setenv("CATDOOR_LISTEN_ADDR", "127.0.0.1:0")
+
+	s, err := newServerFromEnv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ln, err := net.Listen("tcp", s.listenAddr)
+	if err != nil {
+		t.Fatalf("listen on %q: %v", s.listenAddr, err)
+	}
+	defer ln.Close()
+
+	host, port, _ := net.SplitHostPort(ln.Addr().String())
+	if host != "127.0.0.1" || port == "0" {
+		t.Errorf("bound %s, want 127.0.0.1 on an assigned port", ln.Addr())
+	}
+}
+
+func TestNewServerFromEnvLockDuration(t *testing.T) {
+	t.Setenv("CATDOOR_LOCK_DURATION", "10m")
+
+	s, err := newServerFromEnv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if s.lockDuration != 10*time.Minute {
+		t.Errorf("lockDuration = %s, want 10m", s.lockDuration)
+	}
+}
+
+func TestNewServerFromEnvInvalid(t *testing.T) {
+	tests := map[string]map[string]string{
+		"empty addr":      {"CATDOOR_CONTROLLER_ADDR": "  "},
+		"missing port":    {"CATDOOR_CONTROLLER_ADDR": "localhost"},
+		"bad allow cidr":  {"CATDOOR_DETECT_ALLOW_CIDRS": "192.168.1.0/33"},
+		"bad proxy cidr":  {"CATDOOR_TRUSTED_PROXIES": "proxy"},
+		"neg breaker":     {"CATDOOR_BREAKER_THRESHOLD": "-1"},
+		"zero breaker":    {"CATDOOR_BREAKER_COOLDOWN": "0s"},
+		"zero confirm":    {"CATDOOR_CONFIRM_TIMEOUT": "0s"},
+		"neg telemetry":   {"CATDOOR_TELEMETRY_INTERVAL": "-1m"},
+		"empty path":      {"CATDOOR_CONFIG_PATH": "\t"},
+		"bad duration":    {"CATDOOR_LOCK_DURATION": "five minutes"},
+		"too short":       {"CATDOOR_LOCK_DURATION": "500ms"},
+		"bad retries":     {"CATDOOR_CONTROLLER_RETRIES": "lots"},
+		"neg retries":     {"CATDOOR_CONTROLLER_RETRIES": "-1"},
+		"neg max unlocks": {"CATDOOR_MAX_UNLOCK_TIMERS": "-1"},
+		"bad keepalive":   {"CATDOOR_CONTROLLER_KEEPALIVE": "sometimes"},
+		"bad framing":     {"CATDOOR_CONTROLLER_FRAMING": "xml"},
+		"zero body":       {"CATDOOR_MAX_BODY_BYTES": "0"},
+		"bad dial":        {"CATDOOR_DIAL_TIMEOUT": "soon"},
+		"zero read":       {"CATDOOR_READ_TIMEOUT": "0s"},
+		"bad ack":         {"CATDOOR_CONTROLLER_ACK": "RED"},
+		"zero ack":        {"CATDOOR_ACK_TIMEOUT": "0s"},
+		"neg request":     {"CATDOOR_REQUEST_TIMEOUT": "-1s"},
+		"zero idle":       {"CATDOOR_HTTP_IDLE_TIMEOUT": "0s"},
+		"zero log size":   {"CATDOOR_LOG_FILE": "catdoor.log", "CATDOOR_LOG_MAX_BYTES": "0"},
+		"neg log files":   {"CATDOOR_LOG_FILE": "catdoor.log", "CATDOOR_LOG_MAX_FILES": "-1"},
+		"bad timezone":    {"CATDOOR_TZ": "Mars/Olympus_Mons"},
+		"ping two words":  {"CATDOOR_PING_COMMAND": "PING ME"},
+		"ping mode":       {"CATDOOR_PING_COMMAND": "red"},
+		"version mode":    {"CATDOOR_VERSION_COMMAND": "green"},
+		"bad fallback":    {"CATDOOR_UNLOCK_FALLBACK": "YELLOW,FORCE OPEN"},
+		"bad unlock try":  {"CATDOOR_UNLOCK_ATTEMPTS": "many"},
+		"no unlock tries": {"CATDOOR_UNLOCK_ATTEMPTS": "0"},
+		"bad confidence":  {"CATDOOR_MIN_CONFIDENCE": "high"},
+		"neg watchdog":    {"CATDOOR_WATCHDOG_INTERVAL": "-1m"},
+		"neg queue age":   {"CATDOOR_DETECTION_QUEUE_MAX_AGE": "-1m"},
+		"zero queue wait": {"CATDOOR_DETECTION_QUEUE_RETRY": "0s"},
+		"confidence > 1":  {"CATDOOR_MIN_CONFIDENCE": "1.5"},
+		"max too low":     {"CATDOOR_LOCK_DURATION": "2h", "CATDOOR_MAX_LOCK_DURATION": "1h"},
+		"bad webhook":     {"CATDOOR_WEBHOOK_URL": "ftp://example.com/hook"},
+		"detect green":    {"CATDOOR_DETECT_MODE": "green"},
+		"neg debounce":    {"CATDOOR_DEBOUNCE_WINDOW": "-1s"},
+		"neg cooldown":    {"CATDOOR_COOLDOWN": "-1s"},
+		"listen no port":  {"CATDOOR_LISTEN_ADDR": "127.0.0.1"},
+		"listen bad port": {"CATDOOR_LISTEN_ADDR": ":http-alt"},
+		"listen no path":  {"CATDOOR_LISTEN_ADDR": "unix:"},
+		"listen sock dir": {"CATDOOR_LISTEN_ADDR": "unix:/nonexistent/dir/api.sock"},
+		"reed log dir":    {"CATDOOR_REED_LOG": "/nonexistent/dir/reed_logs.txt"},
+		"radar blank":     {"CATDOOR_RADAR_LOG": " "},
+		"bad severity":    {"CATDOOR_LOG_SEVERITY_RULES": "fatal=panic"},
+		"bad severity re": {"CATDOOR_LOG_SEVERITY_RULES": "error=(unclosed"},
+		"bad timeout":     {"CATDOOR_WEBHOOK_URL": "https://example.com/hook", "CATDOOR_WEBHOOK_TIMEOUT": "0s"},
+		"zero attempts":   {"CATDOOR_WEBHOOK_URL": "https://example.com/hook", "CATDOOR_WEBHOOK_MAX_ATTEMPTS": "0"},
+		"token file gone": {"CATDOOR_API_TOKEN_FILE": "/nonexistent/token"},
+		"blank tok file":  {"CATDOOR_API_TOKEN_FILE": " "},
+		"zero threshold":  {"CATDOOR_DETECT_THRESHOLD": "0"},
+		"threshold win":   {"CATDOOR_DETECT_THRESHOLD": "3", "CATDOOR_DETECT_THRESHOLD_WINDOW": "0s"},
+	}
+	for name, env := range tests {
+		t.Run(name, func(t *testing.T) {
+			for k, v := range env {
+				t.Setenv(k, v)
+			}
+			if _, err := newServerFromEnv(); err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
+
+func TestDetectedHandlerUsesLockDuration(t *testing.T) {
+	fc := startFakeController(t)
+	s := newTestServer(t, fc)
+
+	before := time.Now()
+	rec := httptest.NewRecorder()
+	s.detectedHandler(rec, httptest.NewRequest(http.MethodPost, "/detected", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body)
+	}
+	assertLockedFor(t, rec, before, 10*time.Minute)
+	if cmds := fc.commands(); len(cmds) != 1 || cmds[0] != "RED" {
+		t.Errorf("controller commands = %v, want [RED]", cmds)
+	}
+}
+
+func TestDetectedHandlerLocksThenUnlocks(t *testing.T) {
+	client := &fakeClient{}
+	s := newTestServer(t, startFakeController(t))
+	s.controller = client
+	s.lockDuration = 20 * time.Millisecond
+
+	rec := httptest.NewRecorder()
+	s.detectedHandler(rec, httptest.NewRequest(http.MethodPost, "/detected", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body)
+	}
+
+	// The unlock is done once it has cleared locked_until.
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if config, err := s.config.load(); err == nil && config.LockedUntil == "" {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if cmds := client.commands(); strings.Join(cmds, ",") != "RED,GREEN" {
+		t.Errorf("controller commands = %v, want [RED GREEN]", cmds)
+	}
+}
+
+func TestDetectedHandlerReportsExtendedLock(t *testing.T) {
+	s := newTestServer(t, startFakeController(t))
+	s.controller = &fakeClient{}
+
+	detect := func(query string) map[string]interface{} {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		s.detectedHandler(rec, httptest.NewRequest(http.MethodPost, "/detected?"+query, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, body = %s", rec.Code, rec.Body)
+		}
+		var body map[string]interface{}
+		if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		return body
+	}
+
+	first := detect("duration=10m")
+	if first["extended"] != false || first["previous_locked_until"] != nil {
+		t.Errorf("first detection: extended = %v, previous_locked_until = %v, want false and null",
+			first["extended"], first["previous_locked_until"])
+	}
+
+	second := detect("duration=20m")
+	if second["extended"] != true {
+		t.Errorf("second detection: extended = %v, want true", second["extended"])
+	}
+	if second["previous_locked_until"] != first["locked_until"] {
+		t.Errorf("previous_locked_until = %v, want the first lock's %v",
+			second["previous_locked_until"], first["locked_until"])
+	}
+	if second["locked_until"] == first["locked_until"] {
+		t.Errorf("locked_until = %v, want it moved past the first lock", second["locked_until"])
+	}
+	if n := s.unlock.inFlight(); n != 1 {
+		t.Errorf("unlock timers = %d, want the one rescheduled timer", n)
+	}
+
+	// Once the lock is released, the next detection starts afresh.
+	s.unlock.stop()
+	if third := detect(""); third["extended"] != false {
+		t.Errorf("after unlock: extended = %v, want false", third["extended"])
+	}
+}
+
+func TestDetectedHandlerControllerError(t *testing.T) {
+	s := newTestServer(t, startFakeController(t))
+	s.controller = &fakeClient{err: errors.New("boom")}
+
+	rec := httptest.NewRecorder()
+	s.detectedHandler(rec, httptest.NewRequest(http.MethodPost, "/detected", nil))
+	if rec.Code != http.StatusBadGateway {
+		t.Errorf("status = %d, want 502", rec.Code)
+	}
+	if s.unlock.pending() {
+		t.Error("unlock pending after a failed lock")
+	}
+}
+
+func TestDetectedHandlerDurationOverride(t *testing.T) {
+	tests := []struct {
+		query string
+		want  time.Duration
+	}{
+		{"duration=15m", 15 * time.Minute},
+		{"duration=48h", time.Hour},
+		{"duration=", 10 * time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			s := newTestServer(t, startFakeController(t))
+
+			before := time.Now()
+			rec := httptest.NewRecorder()
+			s.detectedHandler(rec, httptest.NewRequest(http.MethodPost, "/detected?"+tt.query, nil))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, body = %s", rec.Code, rec.Body)
+			}
+			assertLockedFor(t, rec, before, tt.want)
+		})
+	}
+}
+
+func TestDetectedHandlerRejectsBadDuration(t *testing.T) {
+	for _, query := range []string{"duration=soon", "duration=100ms", "duration=-5m"} {
+		t.Run(query, func(t *testing.T) {
+			fc := startFakeController(t)
+			s := newTestServer(t, fc)
+
+			rec := httptest.NewRecorder()
+			s.detectedHandler(rec, httptest.NewRequest(http.MethodPost, "/detected?"+query, nil))
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("status = %d, want 400", rec.Code)
+			}
+			if cmds := fc.commands(); len(cmds) != 0 {
+				t.Errorf("controller commands = %v, want none", cmds)
+			}
+		})
+	}
+}
+
+func TestNewRouter(t *testing.T) {
+	fc := startFakeController(t)
+	s := newTestServer(t, fc)
+	s.corsOrigins = []string{"https://dash.example"}
+	ts := httptest.NewServer(s.newRouter())
+	defer ts.Close()
+
+	req, _ := http.NewRequest(http.MethodPost, ts.URL+"/mode/red", nil)
+	req.Header.Set("Origin", "https://dash.example")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("POST /mode/red: status = %d", resp.StatusCode)
+	}
+	if got := resp.Header.Get("Access-Control-Allow-Origin"); got != "https://dash.example" {
+		t.Errorf("Access-Control-Allow-Origin = %q, want the CORS middleware applied", got)
+	}
+	if cmds := fc.commands(); len(cmds) != 1 || cmds[0] != "RED" {
+		t.Errorf("controller commands = %v, want [RED]", cmds)
+	}
+
+	resp, err = http.Get(ts.URL + "/healthz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("GET /healthz: status = %d", resp.StatusCode)
+	}
+
+	// Each call builds its own mux, so two routers can coexist.
+	httptest.NewServer(s.newRouter()).Close()
+}
+
+func TestRunShutsDownOnSignal(t *testing.T) {
+	s := newTestServer(t, startFakeController(t))
+	s.unlock.schedule(time.Hour, func() { t.Error("unlock timer fired after shutdown") })
+	s.config.flushDelay = time.Hour
+	s.config.updateLater(func(c *Config) { c.LockedUntil = "2024-01-01T12:00:00Z" })
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", s.statusHandler)
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM)
+	defer stop()
+
+	done := make(chan error, 1)
+	go func() { done <- s.run(ctx, ln, mux) }()
+
+	resp, err := http.Get("http://" + ln.Addr().String() + "/status")
+	if err != nil {
+		t.Fatalf("request before shutdown: %v", err)
+	}
+	resp.Body.Close()
+
+	if err := syscall.Kill(os.Getpid(), syscall.SIGTERM); err != nil {
+		t.Fatalf("send SIGTERM: %v", err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("run returned %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("run did not return after SIGTERM")
+	}
+	if s.unlock.pending() {
+		t.Error("unlock still pending")
+	}
+	if config, err := loadConfig(s.config.path); err != nil || config.LockedUntil != "2024-01-01T12:00:00Z" {
+		t.Errorf("config file after shutdown = %+v, %v; want the pending lock flushed", config, err)
+	}
+}
+
+func TestRecoverLockExpired(t *testing.T) {
+	fc := startFakeController(t)
+	s := newTestServer(t, fc)
+	now := time.Now()
+	writeConfig(t, s, &Config{LockedUntil: now.Add(-time.Minute).Format(time.RFC3339)})
+
+	if err := s.recoverLock(now); err != nil {
+		t.Fatalf("recoverLock: %v", err)
+	}
+	if cmds := fc.commands(); len(cmds) != 1 || cmds[0] != "GREEN" {
+		t.Errorf("controller commands = %v, want [GREEN]", cmds)
+	}
+	config, err := s.config.load()
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+	if config.LockedUntil != "" {
+		t.Errorf("locked_until = %q, want it cleared", config.LockedUntil)
+	}
+}
+
+func TestRecoverLockPending(t *testing.T) {
+	fc := startFakeController(t)
+	s := newTestServer(t, fc)
+	now := time.Now()
+	writeConfig(t, s, &Config{LockedUntil: now.Add(time.Minute).Format(time.RFC3339)})
+
+	if err := s.recoverLock(now); err != nil {
+		t.Fatalf("recoverLock: %v", err)
+	}
+	defer s.unlock.stop()
+
+	if !s.unlock.pending() {
+		t.Error("no unlock pending")
+	}
+	if cmds := fc.commands(); len(cmds) != 0 {
+		t.Errorf("controller commands = %v, want none before expiry", cmds)
+	}
+}
+
+func TestUnlockHandlerCancelsPendingUnlock(t *testing.T) {
+	fc := startFakeController(t)
+	s := newTestServer(t, fc)
+
+	rec := httptest.NewRecorder()
+	s.detectedHandler(rec, httptest.NewRequest(http.MethodPost, "/detected", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("detect status = %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	s.unlockHandler(rec, httptest.NewRequest(http.MethodPost, "/unlock", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unlock status = %d, body = %s", rec.Code, rec.Body)
+	}
+
+	var body struct {
+		Previous        Config `json:"previous"`
+		Current         Config `json:"current"`
+		CancelledTimers int    `json:"cancelled_timers"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if body.Previous.LockedUntil == "" || body.Current.LockedUntil != "" {
+		t.Errorf("previous/current locked_until = %q/%q", body.Previous.LockedUntil, body.Current.LockedUntil)
+	}
+	if body.CancelledTimers != 1 {
+		t.Errorf("cancelled_timers = %d, want 1", body.CancelledTimers)
+	}
+	if s.unlock.pending() {
+		t.Error("unlock still pending")
+	}
+	if cmds := fc.commands(); len(cmds) != 2 || cmds[1] != "GREEN" {
+		t.Errorf("controller commands = %v, want [RED GREEN]", cmds)
+	}
+}
+
+func TestDetectedHandlerConcurrent(t *testing.T) {
+	s := newTestServer(t, startFakeController(t))
+	defer s.unlock.stop()
+
+	var wg sync.WaitGroup
+	for i := 0; i < maxQueuedCommands; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rec := httptest.NewRecorder()
+			s.detectedHandler(rec, httptest.NewRequest(http.MethodPost, "/detected", nil))
+			if rec.Code != http.StatusOK {
+				t.Errorf("status = %d", rec.Code)
+			}
+		}()
+	}
+	wg.Wait()
+
+	config, err := s.config.load()
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+	if config.LockedUntil == "" || config.LastDetected == "" {
+		t.Errorf("config after concurrent detections = %+v", config)
+	}
+}
+
+func TestUnlockHandlerRequiresPost(t *testing.T) {
+	s := newTestServer(t, startFakeController(t))
+	mux := http.NewServeMux()
+	s.routes(mux)
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/unlock", nil))
+	if rec.Code != http.StatusMethodNotAllowed || rec.Header().Get("Allow") != http.MethodPost {
+		t.Errorf("status = %d, Allow = %q, want 405 and POST", rec.Code, rec.Header().Get("Allow"))
+	}
+}
+
+func writeConfig(t *testing.T, s *server, config *Config) {
+	t.Helper()
+	if err := s.config.save(config); err != nil {
+		t.Fatalf("save config: %v", err)
+	}
+}
+
+// newTestServer returns a server wired to fc with a temp config file, a 10m
+// default lock and a 1h cap. With a nil fc the caller sets s.controller.
+func newTestServer(t *testing.T, fc *fakeController) *server {
+	t.Helper()
+	var controller ControllerClient
+	var controllerAddr string
+	if fc != nil {
+		controller, controllerAddr = newTCPController(fc.addr, 0, discardLogger()), fc.addr
+	}
+	dir := t.TempDir()
+	config := newState(filepath.Join(dir, "config.json"))
+	// Flush before the temp dir goes, so no background write races its removal.
+	t.Cleanup(func() { config.close() })
+	s := &server{
+		clock:          systemClock{},
+		log:            discardLogger(),
+		loc:            time.UTC,
+		controller:     controller,
+		controllerAddr: controllerAddr,
+		config:         config,
+		history:        newHistoryStore(filepath.Join(dir, "detections.jsonl")),
+		modes:          newModeHistory(filepath.Join(dir, "modes.jsonl")),
+		telemetry:      newTelemetryStore(filepath.Join(dir, "telemetry.jsonl"), 0),
+		detectMode:     "RED",
+		lockDuration:   10 * time.Minute,
+		maxLock:        time.Hour,
+		healthTimeout:  time.Second,
+		maxBodyBytes:   defaultMaxBodyBytes,
+		pingCommand:    "STATUS",
+		unlockAttempts: defaultUnlockAttempts,
+		reedLog:        filepath.Join(dir, "reed_logs.txt"),
+		logSeverity:    defaultSeverityRules,
+		radarLog:       filepath.Join(dir, "sensor_logs.txt"),
+	}
+	s.metrics = newMetrics(config, s.now)
+	s.metrics.trackUnlockTimers(&s.unlock)
+	s.metrics.trackDetectionsToday(&s.detectionsToday, s.now)
+	return s
+}
+
+// assertLockedFor checks that the response's locked_until is roughly want
+// after before.
+func assertLockedFor(t *testing.T, rec *httptest.ResponseRecorder, before time.Time, want time.Duration) {
+	t.Helper()
+	var body struct {
+		LockedUntil string `json:"locked_until"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	lockedUntil, err := time.Parse(time.RFC3339, body.LockedUntil)
+	if err != nil {
+		t.Fatalf("parse locked_until: %v", err)
+	}
+	if got := lockedUntil.Sub(before); got < want-time.Second || got > want+time.Second {
+		t.Errorf("locked_until is %s after the request, want ~%s", got, want)
+	}
+}
+
+// fakeClient is an in-memory ControllerClient that records commands
+type fakeClient struct {
+	mu    sync.Mutex
+	cmds  []string
+	err   error // returned from every Send when set
+	fails int   // the next fails Sends return errFakeFailure
+}
+
+var errFakeFailure = errors.New("controller replied ERR jammed")
+
+func (c *fakeClient) Send(cmd string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cmds = append(c.cmds, cmd)
+	if c.err != nil {
+		return "", c.err
+	}
+	if c.fails > 0 {
+		c.fails--
+		return "", errFakeFailure
+	}
+	if cmd == "STATUS" {
+		return "MODE GREEN\n", nil
+	}
+	return "OK " + cmd + "\n", nil
+}
+
+func (c *fakeClient) commands() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.cmds...)
+}
+
+// fakeController is a TCP server that records commands and answers them the
+// way the Python controller does: one reply line per command line, until the
+// client closes the connection.
+type fakeController struct {
+	addr  string
+	delay time.Duration // how long to take before replying
+	reply string        // sent instead of the usual reply when set
+
+	mu        sync.Mutex
+	cmds      []string
+	active    int
+	maxActive int
+	accepted  int
+	open      map[net.Conn]bool
+}
+
+func startFakeController(t testing.TB) *fakeController {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	fc := &fakeController{addr: ln.Addr().String(), open: map[net.Conn]bool{}}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			fc.mu.Lock()
+			fc.accepted++
+			fc.open[conn] = true
+			fc.mu.Unlock()
+			go fc.handle(conn)
+		}
+	}()
+	return fc
+}
+
+func (fc *fakeController) handle(conn net.Conn) {
+	defer func() {
+		fc.mu.Lock()
+		delete(fc.open, conn)
+		fc.mu.Unlock()
+		conn.Close()
+	}()
+	rd := bufio.NewReader(conn)
+	for {
+		line, err := rd.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fc.answer(conn, strings.TrimSpace(line))
+	}
+}
+
+func (fc *fakeController) answer(conn net.Conn, cmd string) {
+	fc.mu.Lock()
+	fc.cmds = append(fc.cmds, cmd)
+	fc.active++
+	fc.maxActive = max(fc.maxActive, fc.active)
+	delay, reply := fc.delay, fc.reply
+	fc.mu.Unlock()
+
+	defer func() {
+		fc.mu.Lock()
+		fc.active--
+		fc.mu.Unlock()
+	}()
+	time.Sleep(delay)
+
+	if reply != "" {
+		conn.Write([]byte(reply))
+		return
+	}
+	if cmd == "STATUS" {
+		conn.Write([]byte("MODE GREEN\n"))
+		return
+	}
+	conn.Write([]byte("OK " + cmd + "\n"))
+}
+
+// dropConns closes every open connection, as a restarted controller would
+func (fc *fakeController) dropConns() {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	for conn := range fc.open {
+		conn.Close()
+	}
+}
+
+// connections returns how many connections the controller has accepted
+func (fc *fakeController) connections() int {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	return fc.accepted
+}
+
+func (fc *fakeController) commands() []string {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	return append([]string(nil), fc.cmds...)
+}
+
+// discardLogger returns a logger that drops everything
+func discardLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+// unsetEnv clears the given variables for the duration of the test
+func unsetEnv(t *testing.T, keys ...string) {
+	t.Helper()
+	for _, key := range keys {
+		t.Setenv(key, "")
+		os.Unsetenv(key)
+	}
+}
+
+func TestModeHandlerPathValidation(t *testing.T) {
+	tests := []struct {
+		target string
+		want   int
+		cmd    string
+	}{
+		{"/mode/green", http.StatusOK, "GREEN"},
+		{"/mode/YELLOW", http.StatusOK, "YELLOW"},
+		{"/mode/Red", http.StatusOK, "RED"},
+		{"/mode/red%20", http.StatusOK, "RED"},
+		{"/mode/%72ed", http.StatusOK, "RED"},
+		{"/mode/blue", http.StatusBadRequest, ""},
+		{"/mode/", http.StatusBadRequest, ""},
+		{"/mode/green/extra", http.StatusBadRequest, ""},
+		{"/mode/green/", http.StatusBadRequest, ""},
+		{"/mode/green%2Fextra", http.StatusBadRequest, ""},
+		{"/mode/gr%00een", http.StatusBadRequest, ""},
+		{"/mode/green?force=1", http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			client := &fakeClient{}
+			s := newTestServer(t, startFakeController(t))
+			s.controller = client
+
+			rec := httptest.NewRecorder()
+			s.modeHandler(rec, httptest.NewRequest(http.MethodPost, tt.target, nil))
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d (body %q)", rec.Code, tt.want, rec.Body)
+			}
+			if tt.want == http.StatusBadRequest && !strings.Contains(rec.Body.String(), "green, yellow, red") {
+				t.Errorf("body = %q, want the list of valid modes", rec.Body)
+			}
+			if got := strings.Join(client.commands(), ","); got != tt.cmd {
+				t.Errorf("controller commands = %q, want %q", got, tt.cmd)
+			}
+		})
+	}
+}
+
+func TestModeHandlerControllerErr(t *testing.T) {
+	fc := startFakeController(t)
+	fc.reply = "ERR: hardware fault\n"
+	s := newTestServer(t, fc)
+
+	rec := httptest.NewRecorder()
+	s.modeHandler(rec, httptest.NewRequest(http.MethodPost, "/mode/red", nil))
+	if rec.Code != http.StatusBadGateway {
+		t.Fatalf("status = %d, want 502", rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), "hardware fault") {
+		t.Errorf("body = %q, want the controller's message", rec.Body)
+	}
+}
+
+func TestTimestampsUseConfiguredZone(t *testing.T) {
+	loc, err := time.LoadLocation("Asia/Kolkata")
+	if err != nil {
+		t.Skipf("no tzdata: %v", err)
+	}
+	s := newTestServer(t, startFakeController(t))
+	s.controller = &fakeClient{}
+	s.loc = loc
+
+	rec := httptest.NewRecorder()
+	s.detectedHandler(rec, httptest.NewRequest(http.MethodPost, "/detected", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body)
+	}
+	s.unlock.stop()
+
+	config, err := s.config.load()
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+	for name, value := range map[string]string{
+		"LastDetected": config.LastDetected,
+		"LockedUntil":  config.LockedUntil,
+	} {
+		if !strings.HasSuffix(value, "+05:30") {
+			t.Errorf("%s = %q, want it in Asia/Kolkata", name, value)
+		}
+	}
+
+	// A value saved under another zone is reported in the configured one.
+	if got := s.inZone("2024-06-01T12:00:00Z"); got != "2024-06-01T17:30:00+05:30" {
+		t.Errorf("inZone = %q", got)
+	}
+}
+
+func TestDetectedHandlerDetectMode(t *testing.T) {
+	t.Setenv("CATDOOR_DETECT_MODE", "Yellow")
+	s, err := newServerFromEnv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if s.detectMode != "YELLOW" {
+		t.Fatalf("detectMode = %q, want YELLOW", s.detectMode)
+	}
+
+	client := &fakeClient{}
+	ts := newTestServer(t, startFakeController(t))
+	ts.controller = client
+	ts.detectMode = s.detectMode
+	ts.lockDuration = 20 * time.Millisecond
+
+	rec := httptest.NewRecorder()
+	ts.detectedHandler(rec, httptest.NewRequest(http.MethodPost, "/detected", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body)
+	}
+	var body struct {
+		Mode string `json:"mode"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil || body.Mode != "YELLOW" {
+		t.Errorf("response mode = %q (%v), want YELLOW", body.Mode, err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if config, err := ts.config.load(); err == nil && config.LockedUntil == "" {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if cmds := client.commands(); strings.Join(cmds, ",") != "YELLOW,GREEN" {
+		t.Errorf("controller commands = %v, want [YELLOW GREEN]", cmds)
+	}
+}
+
+func TestDetectedHandlerDebounce(t *testing.T) {
+	client := &fakeClient{}
+	s := newTestServer(t, startFakeController(t))
+	s.controller = client
+	s.debounceWindow = time.Hour
+	defer s.unlock.stop()
+
+	detect := func() bool {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		s.detectedHandler(rec, httptest.NewRequest(http.MethodPost, "/detected", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, body = %s", rec.Code, rec.Body)
+		}
+		var body struct {
+			Debounced   bool   `json:"debounced"`
+			LockedUntil string `json:"locked_until"`
+		}
+		if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		if body.LockedUntil == "" {
+			t.Error("response has no locked_until")
+		}
+		return body.Debounced
+	}
+
+	if detect() {
+		t.Error("first detection was debounced")
+	}
+	if !detect() || !detect() {
+		t.Error("repeat detections were not debounced")
+	}
+	if cmds := client.commands(); len(cmds) != 1 {
+		t.Errorf("controller commands = %v, want a single RED", cmds)
+	}
+	if !s.unlock.pending() {
+		t.Error("no unlock pending")
+	}
+
+	s.lastDetection = time.Now().Add(-2 * time.Hour)
+	if detect() {
+		t.Error("detection after the window was debounced")
+	}
+	if !s.unlock.pending() {
+		t.Error("no unlock pending after a new lock")
+	}
+}
+
+func TestDetectedHandlerDebounceRecordsID(t *testing.T) {
+	s := newTestServer(t, startFakeController(t))
+	s.debounceWindow = time.Hour
+	defer s.unlock.stop()
+
+	first := detectID(t, s)
+	second := detectID(t, s)
+	if second == first {
+		t.Fatalf("debounced detection reused id %q", first)
+	}
+	events, err := s.history.recent(2)
+	if err != nil {
+		t.Fatalf("history: %v", err)
+	}
+	if len(events) != 2 || events[1].ID != second || !events[1].Debounced || events[1].Duration != "0s" {
+		t.Errorf("history = %+v, want the debounced detection last", events)
+	}
+	if !events[1].lockedUntil().IsZero() {
+		t.Errorf("debounced detection locked until %s", events[1].lockedUntil())
+	}
+}
+
+func TestStaggeredDetectionsUnlockOnce(t *testing.T) {
+	client := &fakeClient{}
+	s := newTestServer(t, startFakeController(t))
+	s.controller = client
+	defer s.unlock.stop()
+
+	detect := func(d string) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		s.detectedHandler(rec, httptest.NewRequest(http.MethodPost, "/detected?duration="+d, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, body = %s", rec.Code, rec.Body)
+		}
+	}
+
+	// The first lock would end at ~1s, the second at ~1.5s.
+	start := time.Now()
+	detect("1s")
+	time.Sleep(500 * time.Millisecond)
+	detect("1s")
+
+	time.Sleep(800 * time.Millisecond)
+	if cmds := client.commands(); strings.Join(cmds, ",") != "RED,RED" {
+		t.Fatalf("controller commands = %v at %s, want the first unlock cancelled", cmds, time.Since(start))
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for s.unlock.pending() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	elapsed := time.Since(start)
+	// Let a stray second unlock show up if there were one.
+	time.Sleep(200 * time.Millisecond)
+	if cmds := client.commands(); strings.Join(cmds, ",") != "RED,RED,GREEN" {
+		t.Errorf("controller commands = %v, want a single GREEN", cmds)
+	}
+	if elapsed < 1500*time.Millisecond {
+		t.Errorf("unlocked after %s, want the later lock's 1.5s", elapsed)
+	}
+}
+
+func TestUnlockTimerInFlight(t *testing.T) {
+	var u unlockTimer
+	u.schedule(time.Hour, func() {})
+	u.schedule(time.Hour, func() {})
+	if got := u.inFlight(); got != 1 {
+		t.Errorf("after replacing: in flight = %d, want 1", got)
+	}
+	u.stop()
+	if got := u.inFlight(); got != 0 {
+		t.Errorf("after stop: in flight = %d, want 0", got)
+	}
+
+	fired := make(chan struct{})
+	u.schedule(time.Millisecond, func() { close(fired) })
+	<-fired
+	waitFor(t, func() bool { return u.inFlight() == 0 })
+}
+
+func TestDetectedHandlerUnlockTimerCap(t *testing.T) {
+	client := &fakeClient{}
+	s := newTestServer(t, startFakeController(t))
+	s.controller = client
+	s.maxUnlocks = 1
+	defer s.unlock.stop()
+
+	// An unlock that is still running counts against the cap.
+	release := make(chan struct{})
+	started := make(chan struct{})
+	s.unlock.schedule(0, func() { close(started); <-release })
+	<-started
+
+	rec := httptest.NewRecorder()
+	s.detectedHandler(rec, httptest.NewRequest(http.MethodPost, "/detected", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, want 503", rec.Code)
+	}
+	if cmds := client.commands(); len(cmds) != 0 {
+		t.Errorf("controller commands = %v, want none", cmds)
+	}
+
+	close(release)
+	waitFor(t, func() bool { return s.unlock.inFlight() == 0 })
+	rec = httptest.NewRecorder()
+	s.detectedHandler(rec, httptest.NewRequest(http.MethodPost, "/detected", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("after release: status = %d, body = %s", rec.Code, rec.Body)
+	}
+}
+
+// waitFor polls cond until it holds, failing the test after 2s
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met within 2s")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestUnlockTimerUnlessPending(t *testing.T) {
+	var u unlockTimer
+	u.schedule(time.Hour, func() {})
+	if u.unlessPending(func() { t.Error("ran with an unlock pending") }) {
+		t.Error("unlessPending = true with an unlock pending")
+	}
+	u.stop()
+	ran := false
+	if !u.unlessPending(func() { ran = true }) || !ran {
+		t.Error("didn't run without an unlock pending")
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Maintenance is saved in the config file while maintenance mode is on.
+// Detections are then only logged, and the schedule stays out of the way.
+// With Mode set the flap is held in it; there is no expiry.
+type Maintenance struct {
+	Since string `json:"since"`
+	Mode  string `json:"mode,omitempty"` // held while in maintenance; empty leaves the flap and any pending unlock alone
+}
+
+// maintenance returns the saved maintenance state, or nil when it is off
+func (s *server) maintenance() *Maintenance {
+	config, err := s.config.load()
+	if err != nil || config.Maintenance == nil {
+		return nil
+	}
+	m := *config.Maintenance
+	m.Since = s.inZone(m.Since)
+	return &m
+}
+
+// maintenanceHandler handles /maintenance. POST /maintenance[?mode=red]
+// turns maintenance mode on, sending mode first and cancelling any pending
+// auto-unlock so nothing moves the flap off it; POST /maintenance?enabled=false
+// turns it off again. GET reports it. It survives a restart until turned off.
+func (s *server) maintenanceHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		s.detectMu.Lock()
+		defer s.detectMu.Unlock()
+
+		enabled := true
+		if value := r.URL.Query().Get("enabled"); value != "" {
+			var err error
+			if enabled, err = strconv.ParseBool(value); err != nil {
+				writeError(w, r, http.StatusBadRequest, errCodeInvalidRequest, fmt.Sprintf("invalid enabled %q", value))
+				return
+			}
+		}
+		mode := strings.ToUpper(strings.TrimSpace(r.URL.Query().Get("mode")))
+		if mode != "" && !validMode(mode) {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidRequest, fmt.Sprintf("invalid mode %q (use green|yellow|red)", mode))
+			return
+		}
+		if !enabled && mode != "" {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidRequest, "mode only applies when enabling maintenance")
+			return
+		}
+
+		if enabled {
+			if !s.enableMaintenance(w, r, mode) {
+				return
+			}
+		} else {
+			if _, err := s.config.update(func(config *Config) { config.Maintenance = nil }); err != nil {
+				writeError(w, r, http.StatusInternalServerError, errCodeInternal, "failed to save maintenance: "+err.Error())
+				return
+			}
+			// Let the schedule put the flap where it should be now.
+			s.schedule.reset()
+			s.log.Info("maintenance mode off")
+		}
+	}
+
+	m := s.maintenance()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"maintenance": m != nil,
+		"state":       m,
+	})
+}
+
+// enableMaintenance saves maintenance mode with mode to hold, answering the
+// request itself and returning false on failure. Callers hold detectMu.
+func (s *server) enableMaintenance(w http.ResponseWriter, r *http.Request, mode string) bool {
+	if mode != "" {
+		cancelled, err := s.unlock.stopWith(func() error {
+			_, err := s.setMode(mode, "maintenance")
+			return err
+		})
+		if err != nil {
+			writeControllerError(w, r, "failed to set maintenance mode: ", err)
+			return false
+		}
+		if cancelled > 0 {
+			s.log.Info("maintenance: cancelled the pending auto-unlock")
+		}
+	}
+
+	m := &Maintenance{Since: s.now().Format(time.RFC3339), Mode: mode}
+	_, err := s.config.update(func(config *Config) {
+		config.Maintenance = m
+		if mode != "" {
+			config.LockedUntil = ""
+			config.LockedBy = ""
+		}
+	})
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, errCodeInternal, "failed to save maintenance: "+err.Error())
+		return false
+	}
+	s.log.Warn("maintenance mode on: detections will not lock the flap", "mode", mode)
+	return true
+}
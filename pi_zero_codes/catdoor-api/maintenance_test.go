@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMaintenanceIgnoresDetections(t *testing.T) {
+	client := &fakeClient{}
+	s := newTestServer(t, startFakeController(t))
+	s.controller = client
+
+	post := func(target string) *httptest.ResponseRecorder {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		s.maintenanceHandler(rec, httptest.NewRequest(http.MethodPost, target, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("POST %s: status = %d, body = %s", target, rec.Code, rec.Body)
+		}
+		return rec
+	}
+	post("/maintenance")
+
+	for i := 0; i < 2; i++ {
+		rec := httptest.NewRecorder()
+		s.detectedHandler(rec, httptest.NewRequest(http.MethodPost, "/detected", nil))
+		var body struct {
+			Status      string `json:"status"`
+			Acted       bool   `json:"acted"`
+			Maintenance bool   `json:"maintenance"`
+		}
+		if err := json.NewDecoder(rec.Body).Decode(&body); err != nil || body.Status != "maintenance" || body.Acted || !body.Maintenance {
+			t.Errorf("detection %d: body = %+v (%v), want an ignored maintenance detection", i, body, err)
+		}
+	}
+	if cmds := client.commands(); len(cmds) != 0 {
+		t.Errorf("controller commands = %v, want none in maintenance", cmds)
+	}
+	if s.unlock.pending() {
+		t.Error("unlock scheduled in maintenance")
+	}
+	events, err := s.history.recent(0)
+	if err != nil || len(events) != 2 || !events[0].Maintenance {
+		t.Errorf("history = %+v (%v), want the detections recorded as maintenance", events, err)
+	}
+
+	// It survives a restart: a fresh State reads it back from the file.
+	if err := s.config.close(); err != nil {
+		t.Fatal(err)
+	}
+	s.config = newState(s.config.path)
+	if s.maintenance() == nil {
+		t.Fatal("maintenance lost on reload")
+	}
+
+	post("/maintenance?enabled=false")
+	rec := httptest.NewRecorder()
+	s.detectedHandler(rec, httptest.NewRequest(http.MethodPost, "/detected", nil))
+	defer s.unlock.stop()
+	if cmds := client.commands(); strings.Join(cmds, ",") != "RED" {
+		t.Errorf("controller commands after maintenance = %v, want [RED]", cmds)
+	}
+}
+
+func TestMaintenanceHoldsMode(t *testing.T) {
+	client := &fakeClient{}
+	s := newTestServer(t, startFakeController(t))
+	s.controller = client
+
+	// An active lock's auto-unlock must not move the flap off the held mode.
+	s.unlock.schedule(time.Hour, func() { t.Error("auto-unlock fired in maintenance") })
+	s.config.updateState(func(c *Config) { c.LockedUntil = time.Now().Add(time.Hour).Format(time.RFC3339) })
+
+	rec := httptest.NewRecorder()
+	s.maintenanceHandler(rec, httptest.NewRequest(http.MethodPost, "/maintenance?mode=yellow", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body)
+	}
+	if cmds := client.commands(); strings.Join(cmds, ",") != "YELLOW" {
+		t.Errorf("controller commands = %v, want [YELLOW]", cmds)
+	}
+	if s.unlock.pending() {
+		t.Error("auto-unlock still pending")
+	}
+	if m := s.maintenance(); m == nil || m.Mode != "YELLOW" {
+		t.Errorf("maintenance = %+v, want YELLOW held", m)
+	}
+	if last := s.modes.recent(1); len(last) != 1 || last[0].Source != "maintenance" {
+		t.Errorf("mode history = %+v, want the change tagged maintenance", last)
+	}
+
+	// The schedule stands aside while maintenance is on.
+	if _, err := s.config.update(func(c *Config) {
+		c.Schedule = []ScheduleWindow{{Start: "00:00", End: "23:59", Mode: "RED"}}
+	}); err != nil {
+		t.Fatal(err)
+	}
+	s.applySchedule(s.now())
+	if cmds := client.commands(); len(cmds) != 1 {
+		t.Errorf("controller commands = %v, want the schedule held off", cmds)
+	}
+
+	rec = httptest.NewRecorder()
+	s.statusHandler(rec, httptest.NewRequest(http.MethodGet, "/status", nil))
+	var status Status
+	if err := json.NewDecoder(rec.Body).Decode(&status); err != nil || status.Maintenance == nil || status.Maintenance.Mode != "YELLOW" {
+		t.Errorf("status maintenance = %+v (%v)", status.Maintenance, err)
+	}
+}
+
+func TestMaintenanceInvalid(t *testing.T) {
+	s := newTestServer(t, startFakeController(t))
+	for _, query := range []string{"mode=purple", "enabled=maybe", "enabled=false&mode=red"} {
+		rec := httptest.NewRecorder()
+		s.maintenanceHandler(rec, httptest.NewRequest(http.MethodPost, "/maintenance?"+query, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", query, rec.Code)
+		}
+	}
+	rec := httptest.NewRecorder()
+	s.maintenanceHandler(rec, httptest.NewRequest(http.MethodGet, "/maintenance", nil))
+	if !strings.Contains(rec.Body.String(), `"maintenance":false`) {
+		t.Errorf("GET body = %s, want maintenance off", rec.Body)
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metrics holds the Prometheus collectors exposed on /metrics. Each server
+// has its own registry so tests don't share counters.
+type metrics struct {
+	registry         *prometheus.Registry
+	detections       prometheus.Counter
+	modeChanges      *prometheus.CounterVec
+	controllerErrors prometheus.Counter
+}
+
+// newMetrics returns the metrics of the device whose lock state is config.
+// now is the server's clock, so the lock gauges agree with /status.
+func newMetrics(config *State, now func() time.Time) *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		detections: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "catdoor_detections_total",
+			Help: "Prey detections that locked the catflap.",
+		}),
+		modeChanges: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "catdoor_mode_changes_total",
+			Help: "Mode commands accepted by the controller.",
+		}, []string{"mode"}),
+		controllerErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "catdoor_controller_errors_total",
+			Help: "Controller commands that failed.",
+		}),
+	}
+
+	locked := prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "catdoor_locked",
+		Help: "1 while a detection lock is active, else 0.",
+	}, func() float64 {
+		if lockRemaining(config, now()) > 0 {
+			return 1
+		}
+		return 0
+	})
+	remaining := prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "catdoor_unlock_seconds_remaining",
+		Help: "Seconds until the active lock is released, 0 when unlocked.",
+	}, func() float64 {
+		return lockRemaining(config, now()).Seconds()
+	})
+
+	m.registry.MustRegister(
+		m.detections, m.modeChanges, m.controllerErrors, locked, remaining,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+// trackUnlockTimers exports how many of u's unlock timers are in flight
+func (m *metrics) trackUnlockTimers(u *unlockTimer) {
+	m.registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "catdoor_unlock_timers_in_flight",
+		Help: "Auto-unlock timers armed or running.",
+	}, func() float64 {
+		return float64(u.inFlight())
+	}))
+}
+
+// trackDetectionsToday exports the detections-today count of c
+func (m *metrics) trackDetectionsToday(c *dailyCounter, now func() time.Time) {
+	m.registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "catdoor_detections_today",
+		Help: "Detections recorded since local midnight.",
+	}, func() float64 {
+		return float64(c.value(now()))
+	}))
+}
+
+// lockRemaining returns how long the saved lock has left, or 0 when there
+// is none or it can't be read.
+func lockRemaining(config *State, now time.Time) time.Duration {
+	c, err := config.load()
+	if err != nil {
+		return 0
+	}
+	return remainingUntil(c.LockedUntil, now)
+}
+
+// remainingUntil returns the time from now to an RFC 3339 locked_until,
+// clamped at 0 when it is empty, invalid or in the past.
+func remainingUntil(lockedUntil string, now time.Time) time.Duration {
+	if lockedUntil == "" {
+		return 0
+	}
+	until, err := time.Parse(time.RFC3339, lockedUntil)
+	if err != nil || !until.After(now) {
+		return 0
+	}
+	return until.Sub(now)
+}
+
+// handler serves the registry in the Prometheus exposition format
+func (m *metrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// instrumentedController counts mode changes and errors for every command
+// sent through it, whichever handler or timer sent it. It also remembers the
+// last mode set, which the watchdog treats as the intended one.
+type instrumentedController struct {
+	next    ControllerClient
+	metrics *metrics
+
+	mu   sync.Mutex
+	mode string // last mode the controller accepted; empty until one is set
+}
+
+func (c *instrumentedController) Send(cmd string) (string, error) {
+	resp, err := c.next.Send(cmd)
+	if err != nil {
+		c.metrics.controllerErrors.Inc()
+		return resp, err
+	}
+	if validMode(cmd) {
+		c.metrics.modeChanges.WithLabelValues(cmd).Inc()
+		c.mu.Lock()
+		c.mode = cmd
+		c.mu.Unlock()
+	}
+	return resp, nil
+}
+
+// lastMode returns the last mode the controller accepted
+func (c *instrumentedController) lastMode() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.mode
+}
+
+// forgetMode clears the last mode, as if none had been set
+func (c *instrumentedController) forgetMode() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.mode = ""
+}
+
+// Close closes the wrapped client
+func (c *instrumentedController) Close() error {
+	closeController(c.next)
+	return nil
+}
+
+// breakerStatus reports the wrapped client's circuit breaker
+func (c *instrumentedController) breakerStatus() *BreakerStatus {
+	return controllerBreaker(c.next)
+}
+
+// Probe keeps health checks on the wrapped client's fast path
+func (c *instrumentedController) Probe(timeout time.Duration) (string, error) {
+	return probeController(c.next, timeout)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func scrapeMetrics(t *testing.T, s *server) string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	s.metrics.handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body)
+	}
+	return rec.Body.String()
+}
+
+func TestMetricsReflectHandlerActivity(t *testing.T) {
+	s := newTestServer(t, startFakeController(t))
+	client := &fakeClient{}
+	s.controller = &instrumentedController{next: client, metrics: s.metrics}
+
+	rec := httptest.NewRecorder()
+	s.detectedHandler(rec, httptest.NewRequest(http.MethodPost, "/detected", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("detected status = %d", rec.Code)
+	}
+	defer s.unlock.stop()
+	rec = httptest.NewRecorder()
+	s.modeHandler(rec, httptest.NewRequest(http.MethodPost, "/mode/yellow", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("mode status = %d", rec.Code)
+	}
+
+	client.err = errors.New("boom")
+	s.modeHandler(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/mode/green", nil))
+
+	body := scrapeMetrics(t, s)
+	for _, want := range []string{
+		"catdoor_detections_total 1\n",
+		`catdoor_mode_changes_total{mode="RED"} 1` + "\n",
+		`catdoor_mode_changes_total{mode="YELLOW"} 1` + "\n",
+		"catdoor_controller_errors_total 1\n",
+		"catdoor_locked 1\n",
+		"catdoor_unlock_timers_in_flight 1\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics missing %q", want)
+		}
+	}
+	if strings.Contains(body, "catdoor_unlock_seconds_remaining 0\n") {
+		t.Error("unlock_seconds_remaining is 0 while locked")
+	}
+}
+
+func TestMetricsUnlocked(t *testing.T) {
+	s := newTestServer(t, startFakeController(t))
+
+	body := scrapeMetrics(t, s)
+	for _, want := range []string{"catdoor_locked 0\n", "catdoor_unlock_seconds_remaining 0\n", "catdoor_detections_total 0\n"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics missing %q", want)
+		}
+	}
+}
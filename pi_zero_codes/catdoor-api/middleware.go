@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+const corsAllowMethods = "GET, POST, PUT, DELETE, OPTIONS"
+const corsAllowHeaders = "Authorization, Content-Type, Idempotency-Key"
+
+// cors adds CORS headers for the origins in CATDOOR_CORS_ORIGINS ("*" allows
+// any) and answers preflight requests with 204. With no origins configured
+// next is returned unchanged.
+func (s *server) cors(next http.Handler) http.Handler {
+	if len(s.corsOrigins) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		w.Header().Add("Vary", "Origin")
+		if origin == "" || !s.corsAllowed(origin) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Set("Access-Control-Allow-Methods", corsAllowMethods)
+			w.Header().Set("Access-Control-Allow-Headers", corsAllowHeaders)
+			w.Header().Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// corsAllowed reports whether origin may call the API
+func (s *server) corsAllowed(origin string) bool {
+	return slices.Contains(s.corsOrigins, "*") || slices.Contains(s.corsOrigins, origin)
+}
+
+// statusRecorder captures the status code written by a handler. It keeps
+// http.Flusher and http.Hijacker working so streaming endpoints and /ws
+// still work through it.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	if r.status == 0 {
+		r.status = http.StatusSwitchingProtocols
+	}
+	return h.Hijack()
+}
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// logRequests logs the method, path, status and latency of every request
+func (s *server) logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		status := rec.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		s.log.Info("request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", status,
+			"duration", time.Since(start),
+			"remote", r.RemoteAddr)
+	})
+}
+
+// timed fails a request with 503 once it has taken longer than d, so a hung
+// controller can't tie up connections. The handler still runs to the end in
+// the background, so e.g. a timed-out detection still locks and arms its
+// unlock; only its response is discarded. d <= 0 disables the budget.
+// Streaming endpoints must not be wrapped: http.TimeoutHandler supports
+// neither flushing nor hijacking.
+func timed(next http.HandlerFunc, d time.Duration) http.HandlerFunc {
+	if d <= 0 {
+		return next
+	}
+	body, _ := json.Marshal(errorBody{Error: apiError{Code: errCodeTimeout, Message: fmt.Sprintf("request took longer than %s", d)}})
+	h := http.TimeoutHandler(next, d, string(body))
+	return func(w http.ResponseWriter, r *http.Request) {
+		h.ServeHTTP(timeoutReplyWriter{w}, r)
+	}
+}
+
+// timeoutReplyWriter labels http.TimeoutHandler's 503 body as JSON. Handler
+// responses, which bring their own Content-Type, pass through unchanged.
+type timeoutReplyWriter struct {
+	http.ResponseWriter
+}
+
+func (w timeoutReplyWriter) WriteHeader(status int) {
+	if status == http.StatusServiceUnavailable && w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// limitBody caps the request body at maxBodyBytes. A handler reading past
+// the cap gets an *http.MaxBytesError, which writeBodyError turns into 413.
+func (s *server) limitBody(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.maxBodyBytes > 0 {
+			r.Body = http.MaxBytesReader(w, r.Body, s.maxBodyBytes)
+		}
+		next(w, r)
+	}
+}
+
+// allowMethods answers 405 with an Allow header unless the request uses one
+// of methods. Allowing GET also allows HEAD.
+func allowMethods(next http.HandlerFunc, methods ...string) http.HandlerFunc {
+	if slices.Contains(methods, http.MethodGet) && !slices.Contains(methods, http.MethodHead) {
+		methods = append(methods, http.MethodHead)
+	}
+	allow := strings.Join(methods, ", ")
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !slices.Contains(methods, r.Method) {
+			w.Header().Set("Allow", allow)
+			writeError(w, r, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "method not allowed")
+			return
+		}
+		next(w, r)
+	}
+}
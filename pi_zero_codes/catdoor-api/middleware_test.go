@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCORS(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	tests := []struct {
+		name       string
+		origins    []string
+		method     string
+		origin     string
+		wantStatus int
+		wantAllow  string
+	}{
+		{"disabled", nil, http.MethodGet, "http://dash.local", http.StatusOK, ""},
+		{"allowed", []string{"http://dash.local"}, http.MethodGet, "http://dash.local", http.StatusOK, "http://dash.local"},
+		{"other origin", []string{"http://dash.local"}, http.MethodGet, "http://evil.example", http.StatusOK, ""},
+		{"wildcard", []string{"*"}, http.MethodGet, "http://any.example", http.StatusOK, "http://any.example"},
+		{"preflight", []string{"http://dash.local"}, http.MethodOptions, "http://dash.local", http.StatusNoContent, "http://dash.local"},
+		{"preflight other origin", []string{"http://dash.local"}, http.MethodOptions, "http://evil.example", http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &server{corsOrigins: tt.origins}
+			req := httptest.NewRequest(tt.method, "/status", nil)
+			req.Header.Set("Origin", tt.origin)
+			if tt.method == http.MethodOptions {
+				req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+			}
+			rec := httptest.NewRecorder()
+			s.cors(next).ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if got := rec.Header().Get("Access-Control-Allow-Origin"); got != tt.wantAllow {
+				t.Errorf("Access-Control-Allow-Origin = %q, want %q", got, tt.wantAllow)
+			}
+			if tt.origins == nil && len(rec.Header()) != 0 {
+				t.Errorf("headers set with CORS disabled: %v", rec.Header())
+			}
+		})
+	}
+}
+
+func TestLogRequests(t *testing.T) {
+	var out bytes.Buffer
+	log, err := newLogger(&out, "json", "info", time.UTC)
+	if err != nil {
+		t.Fatalf("newLogger: %v", err)
+	}
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "nope", http.StatusTeapot)
+	})
+
+	s := &server{log: log}
+	s.logRequests(next).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/mode/red", nil))
+
+	var entry struct {
+		Msg      string `json:"msg"`
+		Method   string `json:"method"`
+		Path     string `json:"path"`
+		Status   int    `json:"status"`
+		Duration int64  `json:"duration"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &entry); err != nil {
+		t.Fatalf("log line %q: %v", out.String(), err)
+	}
+	if entry.Msg != "request" || entry.Method != "POST" || entry.Path != "/mode/red" || entry.Status != http.StatusTeapot {
+		t.Errorf("log entry = %+v", entry)
+	}
+}
+
+func TestStatusRecorderDefaultsTo200(t *testing.T) {
+	rec := &statusRecorder{ResponseWriter: httptest.NewRecorder()}
+	rec.Write([]byte("ok"))
+	rec.WriteHeader(http.StatusInternalServerError)
+	if rec.status != http.StatusOK {
+		t.Errorf("status = %d, want 200 from the implicit header", rec.status)
+	}
+}
+
+func TestAllowMethods(t *testing.T) {
+	ok := func(w http.ResponseWriter, r *http.Request) {}
+	tests := []struct {
+		name      string
+		methods   []string
+		method    string
+		want      int
+		wantAllow string
+	}{
+		{"post allowed", []string{http.MethodPost}, http.MethodPost, http.StatusOK, ""},
+		{"get on post route", []string{http.MethodPost}, http.MethodGet, http.StatusMethodNotAllowed, "POST"},
+		{"get allowed", []string{http.MethodGet}, http.MethodGet, http.StatusOK, ""},
+		{"head with get", []string{http.MethodGet}, http.MethodHead, http.StatusOK, ""},
+		{"post on get route", []string{http.MethodGet}, http.MethodPost, http.StatusMethodNotAllowed, "GET, HEAD"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			allowMethods(ok, tt.methods...)(rec, httptest.NewRequest(tt.method, "/", nil))
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+			if got := rec.Header().Get("Allow"); got != tt.wantAllow {
+				t.Errorf("Allow = %q, want %q", got, tt.wantAllow)
+			}
+		})
+	}
+}
+
+func TestTimed(t *testing.T) {
+	release := make(chan struct{})
+	finished := make(chan struct{})
+	slow := func(w http.ResponseWriter, r *http.Request) {
+		defer close(finished)
+		<-release
+		w.Write([]byte("late"))
+	}
+	rec := httptest.NewRecorder()
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		close(release)
+	}()
+	timed(slow, 20*time.Millisecond)(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, want 503", rec.Code)
+	}
+	if got := decodeError(t, rec); got.Code != errCodeTimeout {
+		t.Errorf("code = %q, want %q", got.Code, errCodeTimeout)
+	}
+	select {
+	case <-finished:
+	case <-time.After(time.Second):
+		t.Fatal("handler did not run to completion after the timeout")
+	}
+}
+
+func TestTimedPassesThrough(t *testing.T) {
+	fast := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("busy"))
+	}
+	for _, d := range []time.Duration{0, time.Second} {
+		rec := httptest.NewRecorder()
+		timed(fast, d)(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		if rec.Code != http.StatusServiceUnavailable || rec.Body.String() != "busy" {
+			t.Errorf("timeout %s: got %d %q, want the handler's response", d, rec.Code, rec.Body.String())
+		}
+		if ct := rec.Header().Get("Content-Type"); ct != "text/plain" {
+			t.Errorf("timeout %s: Content-Type = %q, want text/plain", d, ct)
+		}
+	}
+}
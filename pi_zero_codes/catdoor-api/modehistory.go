@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// defaultModeHistoryFile is created next to the config file unless
+// CATDOOR_MODE_HISTORY_PATH says otherwise.
+const defaultModeHistoryFile = "catdoor-modes.jsonl"
+
+// modeHistoryMemory is how many transitions are kept in memory for
+// /mode/history; the file keeps more until it rotates.
+const modeHistoryMemory = 1000
+
+const defaultModeHistoryLimit = 50
+
+// ModeTransition is one mode the API set on the controller
+type ModeTransition struct {
+	Timestamp time.Time `json:"timestamp"`
+	Previous  string    `json:"previous,omitempty"` // empty if nothing was recorded before
+	Mode      string    `json:"mode"`
+	Source    string    `json:"source"` // manual, detection, schedule, auto-unlock, escalation, watchdog, batch, reset, maintenance, queue, selftest, cancel
+}
+
+// modeHistory keeps the recent mode transitions in memory and appends each
+// one to a JSONL file, which rotates like the detection history. The file is
+// read back the first time the history is used, so previous modes carry over
+// a restart.
+type modeHistory struct {
+	path     string
+	maxBytes int64
+
+	mu      sync.Mutex
+	loaded  bool
+	entries []ModeTransition
+}
+
+func newModeHistory(path string) *modeHistory {
+	return &modeHistory{path: path, maxBytes: defaultHistoryMaxBytes}
+}
+
+// record notes that mode was set by source, returning the transition
+func (h *modeHistory) record(now time.Time, mode, source string) (ModeTransition, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.load()
+
+	t := ModeTransition{Timestamp: now.Truncate(time.Second), Mode: mode, Source: source}
+	if n := len(h.entries); n > 0 {
+		t.Previous = h.entries[n-1].Mode
+	}
+	h.entries = append(h.entries, t)
+	if len(h.entries) > modeHistoryMemory {
+		h.entries = h.entries[len(h.entries)-modeHistoryMemory:]
+	}
+
+	line, err := json.Marshal(t)
+	if err != nil {
+		return t, err
+	}
+	return t, appendLine(h.path, h.maxBytes, line)
+}
+
+// recent returns the last n transitions oldest first
+func (h *modeHistory) recent(n int) []ModeTransition {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.load()
+
+	entries := h.entries[max(0, len(h.entries)-n):]
+	return append([]ModeTransition{}, entries...)
+}
+
+// load reads the saved transitions once. Missing files and malformed lines
+// are skipped; the history just starts empty. h.mu must be held.
+func (h *modeHistory) load() {
+	if h.loaded {
+		return
+	}
+	h.loaded = true
+	for _, path := range []string{h.path + ".1", h.path} {
+		f, err := os.Open(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return
+		}
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			var t ModeTransition
+			if json.Unmarshal(scanner.Bytes(), &t) == nil {
+				h.entries = append(h.entries, t)
+			}
+		}
+		f.Close()
+	}
+	if len(h.entries) > modeHistoryMemory {
+		h.entries = h.entries[len(h.entries)-modeHistoryMemory:]
+	}
+}
+
+// setMode sends mode to the controller and records the change, tagged with
+// what asked for it
+func (s *server) setMode(mode, source string) (string, error) {
+	s.cmdMu.Lock()
+	defer s.cmdMu.Unlock()
+	return s.setModeLocked(mode, source)
+}
+
+// setModeLocked is setMode for callers already holding cmdMu
+func (s *server) setModeLocked(mode, source string) (string, error) {
+	resp, err := s.controller.Send(mode)
+	if err != nil {
+		return resp, err
+	}
+	t, err := s.modes.record(s.now(), mode, source)
+	if err != nil {
+		s.log.Warn("failed to record mode change", "mode", mode, "source", source, "error", err)
+	}
+	s.events.publish(wsMessage{Type: "mode", Data: t})
+	return resp, nil
+}
+
+// modeHistoryHandler handles GET /mode/history?limit=N
+func (s *server) modeHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	limit, err := queryInt(r.URL.Query(), "limit", defaultModeHistoryLimit, 1, modeHistoryMemory)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, errCodeInvalidRequest, err.Error())
+		return
+	}
+
+	transitions := s.modes.recent(limit)
+	for i := range transitions {
+		transitions[i].Timestamp = transitions[i].Timestamp.In(s.loc)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"count":       len(transitions),
+		"transitions": transitions,
+	})
+}
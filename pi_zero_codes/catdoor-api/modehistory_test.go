@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestModeHistoryPersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "modes.jsonl")
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	h := newModeHistory(path)
+	if _, err := h.record(now, "RED", "detection"); err != nil {
+		t.Fatalf("record: %v", err)
+	}
+	if _, err := h.record(now.Add(time.Minute), "GREEN", "auto-unlock"); err != nil {
+		t.Fatalf("record: %v", err)
+	}
+
+	// A restarted server picks up where the file left off.
+	h = newModeHistory(path)
+	got, err := h.record(now.Add(2*time.Minute), "YELLOW", "manual")
+	if err != nil {
+		t.Fatalf("record: %v", err)
+	}
+	if got.Previous != "GREEN" {
+		t.Errorf("previous = %q, want GREEN", got.Previous)
+	}
+	recent := h.recent(2)
+	if len(recent) != 2 || recent[0].Mode != "GREEN" || recent[1].Mode != "YELLOW" {
+		t.Errorf("recent(2) = %+v", recent)
+	}
+}
+
+func TestModeHistoryHandlerTagsSources(t *testing.T) {
+	s := newTestServer(t, startFakeController(t))
+	s.controller = &fakeClient{}
+	mux := http.NewServeMux()
+	s.routes(mux)
+
+	for _, target := range []string{"/mode/yellow", "/detected", "/unlock"} {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, target, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("POST %s: status = %d, body = %s", target, rec.Code, rec.Body)
+		}
+	}
+	s.unlock.stop()
+	s.autoUnlock()
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/mode/history?limit=10", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body)
+	}
+	var body struct {
+		Count       int              `json:"count"`
+		Transitions []ModeTransition `json:"transitions"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	want := []struct{ previous, mode, source string }{
+		{"", "YELLOW", "manual"},
+		{"YELLOW", "RED", "detection"},
+		{"RED", "GREEN", "manual"},
+		{"GREEN", "GREEN", "auto-unlock"},
+	}
+	if body.Count != len(want) {
+		t.Fatalf("transitions = %+v, want %d", body.Transitions, len(want))
+	}
+	for i, w := range want {
+		got := body.Transitions[i]
+		if got.Previous != w.previous || got.Mode != w.mode || got.Source != w.source {
+			t.Errorf("transition %d = %+v, want %+v", i, got, w)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// modeDescriptions says what each of modeNames does to the flap
+var modeDescriptions = map[string]string{
+	"green":  "open: cats can come in and go out",
+	"yellow": "one-way: cats can go out but not come back in",
+	"red":    "locked: the flap stays shut both ways",
+}
+
+// modeInfo is one entry of GET /modes
+type modeInfo struct {
+	Name        string `json:"name"`
+	Command     string `json:"command"`
+	Description string `json:"description"`
+	Active      bool   `json:"active"`
+}
+
+// modesHandler handles GET /modes, listing the modes /mode/{name} accepts
+// and which one is active. The active mode is the last one the API set, from
+// the mode history, so the controller isn't asked; it is null until a mode
+// has been set.
+func (s *server) modesHandler(w http.ResponseWriter, r *http.Request) {
+	var active *ModeTransition
+	if last := s.modes.recent(1); len(last) == 1 {
+		active = &last[0]
+		active.Timestamp = active.Timestamp.In(s.loc)
+	}
+
+	modes := make([]modeInfo, 0, len(modeNames))
+	for _, name := range modeNames {
+		command := strings.ToUpper(name)
+		modes = append(modes, modeInfo{
+			Name:        name,
+			Command:     command,
+			Description: modeDescriptions[name],
+			Active:      active != nil && active.Mode == command,
+		})
+	}
+	response := map[string]interface{}{
+		"modes":  modes,
+		"active": nil,
+	}
+	if active != nil {
+		response["active"] = active.Mode
+		response["since"] = active.Timestamp
+		response["source"] = active.Source
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestModesHandler(t *testing.T) {
+	s := newTestServer(t, startFakeController(t))
+	s.controller = &fakeClient{}
+
+	get := func() (body struct {
+		Modes  []modeInfo `json:"modes"`
+		Active *string    `json:"active"`
+		Source string     `json:"source"`
+	}) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		s.modesHandler(rec, httptest.NewRequest(http.MethodGet, "/modes", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, body = %s", rec.Code, rec.Body)
+		}
+		if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		return body
+	}
+
+	body := get()
+	if len(body.Modes) != len(modeNames) {
+		t.Fatalf("modes = %+v, want one per mode name", body.Modes)
+	}
+	for i, m := range body.Modes {
+		if m.Name != modeNames[i] || m.Description == "" || m.Active {
+			t.Errorf("mode %d = %+v, want %s with a description, inactive", i, m, modeNames[i])
+		}
+	}
+	if body.Active != nil {
+		t.Errorf("active = %q before any mode was set, want null", *body.Active)
+	}
+
+	if _, err := s.setMode("YELLOW", "manual"); err != nil {
+		t.Fatal(err)
+	}
+	body = get()
+	if body.Active == nil || *body.Active != "YELLOW" || body.Source != "manual" {
+		t.Fatalf("active = %v (source %q), want YELLOW from manual", body.Active, body.Source)
+	}
+	for _, m := range body.Modes {
+		if m.Active != (m.Command == "YELLOW") {
+			t.Errorf("%s active = %v", m.Name, m.Active)
+		}
+	}
+}
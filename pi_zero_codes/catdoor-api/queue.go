@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// defaultQueueRetryInterval is how often queued detections are retried
+const defaultQueueRetryInterval = 15 * time.Second
+
+// detectionQueue holds detections whose lock failed because the controller
+// couldn't be reached (CATDOOR_DETECTION_QUEUE_MAX_AGE). They are saved in
+// the config file and retried in the background, so a controller outage or
+// a restart delays the lock instead of losing it.
+type detectionQueue struct {
+	maxAge        time.Duration // 0 disables the queue
+	retryInterval time.Duration
+}
+
+// PendingDetection is a queued detection as saved in the config file
+type PendingDetection struct {
+	ID         string `json:"id"`
+	DetectedAt string `json:"detected_at"`
+	Mode       string `json:"mode"`
+	Duration   string `json:"duration"`
+	Source     string `json:"source"`
+}
+
+// queueable reports whether a detection whose lock failed with err should
+// be queued. An ERR reply is not: the controller answered and refused.
+func (s *server) queueable(err error) bool {
+	var cerr *controllerError
+	return s.queue.maxAge > 0 && !errors.As(err, &cerr)
+}
+
+// queueDetection saves p for a later retry and answers 202. If it can't be
+// saved the detection fails with lockErr as before. Callers hold detectMu.
+func (s *server) queueDetection(w http.ResponseWriter, r *http.Request, p PendingDetection, meta *DetectionMetadata, lockErr error) {
+	config, err := s.config.update(func(config *Config) {
+		config.PendingDetections = append(config.PendingDetections, p)
+	})
+	if err != nil {
+		s.log.Error("failed to queue detection", "error", err)
+		writeControllerError(w, r, "failed to lock catflap: ", lockErr)
+		return
+	}
+	detectedAt, _ := time.Parse(time.RFC3339, p.DetectedAt)
+	expiresAt := detectedAt.Add(s.queue.maxAge)
+	s.log.Warn("controller unavailable, detection queued for retry",
+		"queued", len(config.PendingDetections), "expires_at", expiresAt.Format(time.RFC3339), "error", lockErr)
+
+	event := DetectionEvent{ID: p.ID, Timestamp: detectedAt, Duration: p.Duration, Source: p.Source, Queued: true, Metadata: meta}
+	s.recordDetection(event)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":      "queued",
+		"id":          p.ID,
+		"acted":       false,
+		"queued":      true,
+		"mode":        p.Mode,
+		"duration":    p.Duration,
+		"queue_depth": len(config.PendingDetections),
+		"expires_at":  expiresAt.In(s.loc).Format(time.RFC3339),
+		"metadata":    meta,
+		"error":       lockErr.Error(),
+	})
+}
+
+// runDetectionQueue retries queued detections straight away, picking up
+// any left from before a restart, and then every retry interval until ctx
+// is done.
+func (s *server) runDetectionQueue(ctx context.Context) {
+	if s.queue.maxAge <= 0 {
+		return
+	}
+	ticker := time.NewTicker(s.queue.retryInterval)
+	defer ticker.Stop()
+	for {
+		s.retryQueuedDetections(s.now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// retryQueuedDetections drops queued detections older than the max age and
+// locks for the newest of the rest. Its full duration runs from now, as the
+// cat may still be about; a success settles the whole queue, the same way a
+// new detection replaces an active lock.
+func (s *server) retryQueuedDetections(now time.Time) {
+	s.detectMu.Lock()
+	defer s.detectMu.Unlock()
+
+	config, err := s.config.load()
+	if err != nil || len(config.PendingDetections) == 0 {
+		return
+	}
+	var pending []PendingDetection
+	for _, p := range config.PendingDetections {
+		detectedAt, err := time.Parse(time.RFC3339, p.DetectedAt)
+		if err == nil && now.Sub(detectedAt) < s.queue.maxAge {
+			pending = append(pending, p)
+		}
+	}
+	if expired := len(config.PendingDetections) - len(pending); expired > 0 {
+		s.log.Error("queued detections expired before the controller came back; they never locked",
+			"expired", expired, "max_age", s.queue.maxAge)
+	}
+	if m := s.maintenance(); m != nil && len(pending) > 0 {
+		s.log.Info("dropping queued detections in maintenance mode", "dropped", len(pending))
+		pending = nil
+	}
+	if len(pending) == 0 {
+		s.saveQueue(nil)
+		return
+	}
+
+	p := pending[len(pending)-1]
+	duration, err := time.ParseDuration(p.Duration)
+	if err != nil {
+		duration = s.lockDuration
+	}
+	_, err = s.unlock.lockAndSchedule(duration, func() error {
+		_, err := s.setMode(p.Mode, "queue")
+		return err
+	}, func() {
+		s.log.Info("auto-unlocking catflap", "after", duration)
+		s.autoUnlock()
+	})
+	if err != nil {
+		s.log.Warn("queued detection still can't lock, will retry",
+			"queued", len(pending), "retry_in", s.queue.retryInterval, "error", err)
+		if len(pending) != len(config.PendingDetections) {
+			s.saveQueue(pending)
+		}
+		return
+	}
+	s.lastDetection = now
+
+	unlockTime := now.Add(duration)
+	_, err = s.config.update(func(config *Config) {
+		config.PendingDetections = nil
+		config.LastDetected = p.DetectedAt
+		config.LockedUntil = unlockTime.Format(time.RFC3339)
+		config.LockedBy = p.ID
+	})
+	if err != nil {
+		s.log.Error("failed to save lock; it will not survive a restart", "config", s.config.path, "error", err)
+	}
+	s.metrics.detections.Inc()
+	s.log.Info("queued detection locked catflap",
+		"detected_at", p.DetectedAt, "locked_until", unlockTime.Format(time.RFC3339), "queued", len(pending))
+}
+
+// saveQueue replaces the saved queue with pending
+func (s *server) saveQueue(pending []PendingDetection) {
+	if _, err := s.config.update(func(config *Config) { config.PendingDetections = pending }); err != nil {
+		s.log.Warn("failed to save the detection queue", "error", err)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDetectionQueuedWhileControllerDown(t *testing.T) {
+	client := &fakeClient{err: errors.New("cannot connect to controller: connection refused")}
+	s := newTestServer(t, startFakeController(t))
+	s.controller = client
+	s.queue = detectionQueue{maxAge: 10 * time.Minute, retryInterval: time.Minute}
+	defer s.unlock.stop()
+
+	rec := httptest.NewRecorder()
+	s.detectedHandler(rec, httptest.NewRequest(http.MethodPost, "/detected?duration=20m", nil))
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body)
+	}
+	var body struct {
+		Status     string `json:"status"`
+		Acted      bool   `json:"acted"`
+		QueueDepth int    `json:"queue_depth"`
+		Duration   string `json:"duration"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil || body.Status != "queued" || body.Acted || body.QueueDepth != 1 || body.Duration != "20m0s" {
+		t.Fatalf("body = %+v (%v)", body, err)
+	}
+	if got := s.statusFrom("MODE GREEN\n").DetectionQueue; got != 1 {
+		t.Errorf("status detection_queue = %d, want 1", got)
+	}
+
+	// The queue survives a restart: a fresh State reads it back.
+	if err := s.config.close(); err != nil {
+		t.Fatal(err)
+	}
+	s.config = newState(s.config.path)
+
+	// Still down: the detection stays queued.
+	s.retryQueuedDetections(s.now())
+	if got := s.statusFrom("MODE GREEN\n").DetectionQueue; got != 1 {
+		t.Fatalf("detection_queue after a failed retry = %d, want 1", got)
+	}
+
+	client.mu.Lock()
+	client.err = nil
+	client.mu.Unlock()
+	before := time.Now()
+	s.retryQueuedDetections(s.now())
+	if !s.unlock.pending() {
+		t.Error("no auto-unlock scheduled after the queued lock")
+	}
+	config, err := s.config.load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(config.PendingDetections) != 0 {
+		t.Errorf("pending = %+v, want the queue settled", config.PendingDetections)
+	}
+	lockedUntil, err := time.Parse(time.RFC3339, config.LockedUntil)
+	if err != nil || lockedUntil.Before(before.Add(20*time.Minute-time.Second)) {
+		t.Errorf("locked_until = %q, want 20m from the retry", config.LockedUntil)
+	}
+	if transitions := s.modes.recent(1); len(transitions) != 1 || transitions[0].Mode != "RED" || transitions[0].Source != "queue" {
+		t.Errorf("mode history = %+v, want RED from the queue", transitions)
+	}
+	events, err := s.history.recent(0)
+	if err != nil || len(events) != 1 || !events[0].Queued {
+		t.Errorf("history = %+v (%v), want the detection recorded as queued", events, err)
+	}
+}
+
+func TestQueuedDetectionExpires(t *testing.T) {
+	client := &fakeClient{}
+	s := newTestServer(t, startFakeController(t))
+	s.controller = client
+	s.queue = detectionQueue{maxAge: 10 * time.Minute, retryInterval: time.Minute}
+
+	old := PendingDetection{DetectedAt: s.now().Add(-time.Hour).Format(time.RFC3339), Mode: "RED", Duration: "5m0s", Source: "detector"}
+	if _, err := s.config.update(func(config *Config) { config.PendingDetections = []PendingDetection{old} }); err != nil {
+		t.Fatal(err)
+	}
+	s.retryQueuedDetections(s.now())
+	if cmds := client.commands(); len(cmds) != 0 {
+		t.Errorf("controller commands = %v, want an expired detection dropped", cmds)
+	}
+	if got := s.statusFrom("MODE GREEN\n").DetectionQueue; got != 0 {
+		t.Errorf("detection_queue = %d, want 0", got)
+	}
+}
+
+func TestDetectionNotQueued(t *testing.T) {
+	tests := map[string]struct {
+		err    error
+		maxAge time.Duration
+	}{
+		"queue off": {err: errors.New("cannot connect to controller")},
+		"err reply": {err: &controllerError{msg: "JAMMED"}, maxAge: 10 * time.Minute},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			s := newTestServer(t, startFakeController(t))
+			s.controller = &fakeClient{err: tt.err}
+			s.queue.maxAge = tt.maxAge
+
+			rec := httptest.NewRecorder()
+			s.detectedHandler(rec, httptest.NewRequest(http.MethodPost, "/detected", nil))
+			if rec.Code != http.StatusBadGateway {
+				t.Errorf("status = %d, want 502", rec.Code)
+			}
+			if got := s.statusFrom("MODE GREEN\n").DetectionQueue; got != 0 {
+				t.Errorf("detection_queue = %d, want 0", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// defaultRateLimit is how many mutating requests are allowed per minute
+const defaultRateLimit = 30
+
+// tokenBucket is a process-wide token bucket: it holds up to burst tokens
+// and refills at rate tokens per second.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// newTokenBucket allows perMinute requests a minute, all of which may arrive
+// in a burst.
+func newTokenBucket(perMinute int) *tokenBucket {
+	return &tokenBucket{
+		rate:   float64(perMinute) / 60,
+		burst:  float64(perMinute),
+		tokens: float64(perMinute),
+	}
+}
+
+// take spends a token if one is available. Otherwise it returns how long
+// until the next one is.
+func (b *tokenBucket) take(now time.Time) (bool, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.last.IsZero() {
+		b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	}
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+// rateLimit rejects requests with 429 once the shared bucket is empty. With
+// no limiter configured next is returned unchanged.
+func (s *server) rateLimit(next http.HandlerFunc) http.HandlerFunc {
+	if s.limiter == nil {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		ok, wait := s.limiter.take(time.Now())
+		if !ok {
+			retryAfter := int(math.Ceil(wait.Seconds()))
+			s.log.Warn("rate limit exceeded", "method", r.Method, "path", r.URL.Path, "retry_after", retryAfter)
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+			writeError(w, r, http.StatusTooManyRequests, errCodeRateLimited, "too many requests")
+			return
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	b := newTokenBucket(60) // one per second, burst of 60
+	now := time.Now()
+	for i := 0; i < 60; i++ {
+		if ok, _ := b.take(now); !ok {
+			t.Fatalf("request %d rejected within the burst", i)
+		}
+	}
+	ok, wait := b.take(now)
+	if ok || wait <= 0 || wait > time.Second {
+		t.Fatalf("take past the burst = %v, %s; want a rejection within a second", ok, wait)
+	}
+	if ok, _ := b.take(now.Add(time.Second)); !ok {
+		t.Error("no token after refilling for a second")
+	}
+}
+
+func TestRateLimitReturns429(t *testing.T) {
+	s := newTestServer(t, startFakeController(t))
+	s.controller = &fakeClient{}
+	s.limiter = newTokenBucket(3)
+	handler := s.rateLimit(s.detectedHandler)
+	defer s.unlock.stop()
+
+	for i := 0; i < 3; i++ {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(http.MethodPost, "/detected", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("request %d: status = %d", i, rec.Code)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodPost, "/detected", nil))
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("status = %d, want 429", rec.Code)
+	}
+	if n, err := strconv.Atoi(rec.Header().Get("Retry-After")); err != nil || n < 1 {
+		t.Errorf("Retry-After = %q, want a positive number of seconds", rec.Header().Get("Retry-After"))
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// resetHandler handles POST /reset, putting the flap back to a known
+// baseline: GREEN, no pending unlock, no lock, snooze or queued detection in
+// the config, and no remembered unlock failure, debounce or counted detections.
+// Schedule, settings and maintenance mode are kept.
+// Resetting twice gives the same result. If GREEN fails nothing is changed.
+func (s *server) resetHandler(w http.ResponseWriter, r *http.Request) {
+	s.detectMu.Lock()
+	defer s.detectMu.Unlock()
+
+	var resp string
+	cancelled, err := s.unlock.stopWith(func() error {
+		var err error
+		resp, err = s.setMode("GREEN", "reset")
+		return err
+	})
+	if err != nil {
+		writeControllerError(w, r, "failed to reset catflap: ", err)
+		return
+	}
+	s.lastDetection = time.Time{}
+	s.threshold.reset()
+	s.unlockFailure.clear()
+	s.escalation.clear()
+	s.schedule.reset()
+
+	current, err := s.config.updateState(func(config *Config) {
+		config.LockedUntil = ""
+		config.LockedBy = ""
+		config.SnoozedUntil = ""
+		config.CooldownUntil = ""
+		config.PendingDetections = nil
+	})
+	persisted := err == nil
+	if !persisted {
+		s.log.Warn("failed to save config", "error", err)
+	}
+	if current == nil {
+		current = &Config{}
+	}
+	s.log.Info("state reset", "cancelled_timers", cancelled)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":           "reset",
+		"mode":             "GREEN",
+		"cancelled_timers": cancelled,
+		"config":           current,
+		"persisted":        persisted,
+		"controller":       strings.TrimSpace(resp),
+	})
+}
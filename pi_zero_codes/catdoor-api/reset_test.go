@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestResetHandler(t *testing.T) {
+	client := &fakeClient{}
+	s := newTestServer(t, startFakeController(t))
+	s.controller = client
+	s.debounceWindow = time.Hour
+	defer s.unlock.stop()
+
+	rec := httptest.NewRecorder()
+	s.detectedHandler(rec, httptest.NewRequest(http.MethodPost, "/detected", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("detect status = %d", rec.Code)
+	}
+	if _, err := s.config.update(func(c *Config) { c.SnoozedUntil = time.Now().Add(time.Hour).Format(time.RFC3339) }); err != nil {
+		t.Fatalf("snooze: %v", err)
+	}
+	s.unlockFailure.set(errFakeFailure, time.Now())
+
+	for i, wantCancelled := range []int{1, 0} {
+		rec := httptest.NewRecorder()
+		s.resetHandler(rec, httptest.NewRequest(http.MethodPost, "/reset", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("reset %d: status = %d, body = %s", i, rec.Code, rec.Body)
+		}
+		var body struct {
+			Mode            string `json:"mode"`
+			CancelledTimers int    `json:"cancelled_timers"`
+			Config          Config `json:"config"`
+		}
+		if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		if body.Mode != "GREEN" || body.CancelledTimers != wantCancelled || body.Config.LockedUntil != "" || body.Config.SnoozedUntil != "" {
+			t.Errorf("reset %d: body = %+v, want GREEN with %d cancelled and no lock or snooze", i, body, wantCancelled)
+		}
+	}
+	if s.unlock.pending() {
+		t.Error("unlock still pending")
+	}
+	if msg, _ := s.unlockFailure.get(); msg != "" {
+		t.Errorf("unlock failure = %q, want it cleared", msg)
+	}
+
+	// The debounce window no longer swallows the next detection.
+	rec = httptest.NewRecorder()
+	s.detectedHandler(rec, httptest.NewRequest(http.MethodPost, "/detected", nil))
+	var detect struct {
+		Debounced bool `json:"debounced"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&detect); err != nil || detect.Debounced {
+		t.Errorf("detection after reset debounced = %v (%v), want a fresh lock", detect.Debounced, err)
+	}
+}
+
+func TestResetHandlerControllerError(t *testing.T) {
+	client := &fakeClient{}
+	s := newTestServer(t, startFakeController(t))
+	s.controller = client
+	defer s.unlock.stop()
+
+	rec := httptest.NewRecorder()
+	s.detectedHandler(rec, httptest.NewRequest(http.MethodPost, "/detected", nil))
+	client.err = errFakeFailure
+
+	rec = httptest.NewRecorder()
+	s.resetHandler(rec, httptest.NewRequest(http.MethodPost, "/reset", nil))
+	if rec.Code != http.StatusBadGateway {
+		t.Fatalf("status = %d, want 502", rec.Code)
+	}
+	if !s.unlock.pending() {
+		t.Error("the pending unlock was cancelled although GREEN failed")
+	}
+	if config, _ := s.config.load(); config.LockedUntil == "" {
+		t.Error("locked_until was cleared although GREEN failed")
+	}
+}
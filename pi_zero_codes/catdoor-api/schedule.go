@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// scheduleTickInterval is how often the scheduler checks for window boundaries
+const scheduleTickInterval = 30 * time.Second
+
+// maxScheduleWindows bounds the size of a schedule
+const maxScheduleWindows = 32
+
+// scheduleDefaultMode is the mode outside every window
+const scheduleDefaultMode = "GREEN"
+
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// ScheduleWindow is a recurring daily window during which the flap is held
+// in Mode. Start and End are local "HH:MM" times; a window whose end is
+// before its start runs overnight. Weekdays ("mon".."sun") name the days the
+// window starts on; empty means every day.
+type ScheduleWindow struct {
+	Start    string   `json:"start"`
+	End      string   `json:"end"`
+	Mode     string   `json:"mode"`
+	Weekdays []string `json:"weekdays,omitempty"`
+}
+
+// normalize validates w and returns it with canonical casing
+func (w ScheduleWindow) normalize() (ScheduleWindow, error) {
+	if _, err := time.Parse("15:04", w.Start); err != nil {
+		return w, fmt.Errorf("invalid start %q (use HH:MM)", w.Start)
+	}
+	if _, err := time.Parse("15:04", w.End); err != nil {
+		return w, fmt.Errorf("invalid end %q (use HH:MM)", w.End)
+	}
+	if w.Start == w.End {
+		return w, fmt.Errorf("window %s-%s is empty", w.Start, w.End)
+	}
+	w.Mode = strings.ToUpper(strings.TrimSpace(w.Mode))
+	if !validMode(w.Mode) {
+		return w, fmt.Errorf("invalid mode %q (use green|yellow|red)", w.Mode)
+	}
+	days := make([]string, 0, len(w.Weekdays))
+	for _, day := range w.Weekdays {
+		day = strings.ToLower(strings.TrimSpace(day))
+		if len(day) > 3 {
+			day = day[:3]
+		}
+		if _, ok := weekdayNames[day]; !ok {
+			return w, fmt.Errorf("invalid weekday %q", day)
+		}
+		days = append(days, day)
+	}
+	w.Weekdays = days
+	return w, nil
+}
+
+// startsOn reports whether the window runs on days starting on weekday d
+func (w ScheduleWindow) startsOn(d time.Weekday) bool {
+	if len(w.Weekdays) == 0 {
+		return true
+	}
+	for _, day := range w.Weekdays {
+		if weekdayNames[day] == d {
+			return true
+		}
+	}
+	return false
+}
+
+// contains reports whether t falls inside the window
+func (w ScheduleWindow) contains(t time.Time) bool {
+	start, end := clockMinutes(w.Start), clockMinutes(w.End)
+	now := t.Hour()*60 + t.Minute()
+	if start < end {
+		return now >= start && now < end && w.startsOn(t.Weekday())
+	}
+	// Overnight: the evening part belongs to today's window, the early
+	// morning part to yesterday's.
+	if now >= start {
+		return w.startsOn(t.Weekday())
+	}
+	return now < end && w.startsOn(t.AddDate(0, 0, -1).Weekday())
+}
+
+// clockMinutes converts a validated "HH:MM" to minutes after midnight
+func clockMinutes(hhmm string) int {
+	t, _ := time.Parse("15:04", hhmm)
+	return t.Hour()*60 + t.Minute()
+}
+
+func validMode(mode string) bool {
+	return mode == "GREEN" || mode == "YELLOW" || mode == "RED"
+}
+
+// scheduledMode returns the mode the schedule wants at t. The first matching
+// window wins; outside every window it is scheduleDefaultMode.
+func scheduledMode(windows []ScheduleWindow, t time.Time) string {
+	for _, w := range windows {
+		if w.contains(t) {
+			return w.Mode
+		}
+	}
+	return scheduleDefaultMode
+}
+
+// scheduler remembers the mode it last applied. It only sends a command when
+// the scheduled mode changes, i.e. at a window boundary, so a manual
+// /mode/* change holds until the next boundary.
+type scheduler struct {
+	mu      sync.Mutex
+	applied string // "" makes the next tick apply the scheduled mode
+}
+
+// reset makes the next tick apply the scheduled mode even without a boundary
+func (sc *scheduler) reset() {
+	sc.mu.Lock()
+	sc.applied = ""
+	sc.mu.Unlock()
+}
+
+// runSchedule applies the schedule every scheduleTickInterval until ctx is
+// cancelled.
+func (s *server) runSchedule(ctx context.Context) {
+	ticker := time.NewTicker(scheduleTickInterval)
+	defer ticker.Stop()
+	for {
+		s.applySchedule(s.now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// applySchedule sends the scheduled mode if it changed since the last tick.
+// It stays out of the way of an active detection lock and re-applies once
+// the lock has been released. The check and the send both happen under
+// unlock.mu, so a detection can't lock in between and be undone.
+func (s *server) applySchedule(now time.Time) {
+	config, err := s.config.load()
+	if err != nil {
+		s.log.Warn("schedule: failed to load config", "error", err)
+		return
+	}
+	if len(config.Schedule) == 0 || config.Maintenance != nil {
+		return
+	}
+
+	s.schedule.mu.Lock()
+	defer s.schedule.mu.Unlock()
+
+	mode := scheduledMode(config.Schedule, now)
+	var sent bool
+	if !s.unlock.unlessPending(func() {
+		if mode != s.schedule.applied {
+			sent = true
+			_, err = s.setMode(mode, "schedule")
+		}
+	}) {
+		s.schedule.applied = ""
+		return
+	}
+	if !sent {
+		return
+	}
+	if err != nil {
+		s.log.Error("schedule: failed to apply mode", "mode", mode, "error", err)
+		return
+	}
+	s.log.Info("schedule: applied mode", "mode", mode)
+	s.schedule.applied = mode
+}
+
+// scheduleHandler handles GET /schedule, returning the windows and the mode
+// they currently call for, and POST /schedule, replacing the windows with
+// the JSON body {"windows": [...]}.
+func (s *server) scheduleHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var body struct {
+			Windows []ScheduleWindow `json:"windows"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeBodyError(w, r, fmt.Errorf("invalid JSON body: %w", err))
+			return
+		}
+		if len(body.Windows) > maxScheduleWindows {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidRequest, fmt.Sprintf("too many windows (max %d)", maxScheduleWindows))
+			return
+		}
+		for i, window := range body.Windows {
+			normalized, err := window.normalize()
+			if err != nil {
+				writeError(w, r, http.StatusBadRequest, errCodeInvalidRequest, fmt.Sprintf("window %d: %v", i, err))
+				return
+			}
+			body.Windows[i] = normalized
+		}
+
+		_, err := s.config.update(func(config *Config) {
+			config.Schedule = body.Windows
+		})
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, errCodeInternal, "failed to save schedule: "+err.Error())
+			return
+		}
+		s.log.Info("schedule updated", "windows", len(body.Windows))
+		s.schedule.reset()
+		s.applySchedule(s.now())
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		writeError(w, r, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	config, err := s.config.load()
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, errCodeInternal, "failed to load config: "+err.Error())
+		return
+	}
+	response := map[string]interface{}{
+		"windows":        config.Schedule,
+		"scheduled_mode": nil,
+	}
+	if len(config.Schedule) > 0 {
+		response["scheduled_mode"] = scheduledMode(config.Schedule, s.now())
+	}
+	if response["windows"] == nil {
+		response["windows"] = []ScheduleWindow{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
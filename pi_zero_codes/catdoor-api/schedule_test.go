@@ -0,0 +1,153 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestScheduleWindowContains(t *testing.T) {
+	// 2024-01-01 was a Monday.
+	at := func(day int, hhmm string) time.Time {
+		clock, _ := time.Parse("15:04", hhmm)
+		return time.Date(2024, 1, day, clock.Hour(), clock.Minute(), 0, 0, time.Local)
+	}
+	tests := []struct {
+		name   string
+		window ScheduleWindow
+		t      time.Time
+		want   bool
+	}{
+		{"inside daytime", ScheduleWindow{Start: "09:00", End: "17:00"}, at(1, "12:00"), true},
+		{"start is inclusive", ScheduleWindow{Start: "09:00", End: "17:00"}, at(1, "09:00"), true},
+		{"end is exclusive", ScheduleWindow{Start: "09:00", End: "17:00"}, at(1, "17:00"), false},
+		{"overnight evening", ScheduleWindow{Start: "22:00", End: "06:00"}, at(1, "23:30"), true},
+		{"overnight morning", ScheduleWindow{Start: "22:00", End: "06:00"}, at(2, "05:59"), true},
+		{"overnight outside", ScheduleWindow{Start: "22:00", End: "06:00"}, at(2, "12:00"), false},
+		{"weekday match", ScheduleWindow{Start: "09:00", End: "17:00", Weekdays: []string{"mon"}}, at(1, "12:00"), true},
+		{"weekday mismatch", ScheduleWindow{Start: "09:00", End: "17:00", Weekdays: []string{"tue"}}, at(1, "12:00"), false},
+		{"overnight from previous day", ScheduleWindow{Start: "22:00", End: "06:00", Weekdays: []string{"mon"}}, at(2, "03:00"), true},
+		{"overnight not from previous day", ScheduleWindow{Start: "22:00", End: "06:00", Weekdays: []string{"tue"}}, at(2, "03:00"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.window.contains(tt.t); got != tt.want {
+				t.Errorf("contains = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScheduleWindowNormalize(t *testing.T) {
+	w, err := ScheduleWindow{Start: "22:00", End: "06:00", Mode: "yellow", Weekdays: []string{"Monday", " FRI"}}.normalize()
+	if err != nil {
+		t.Fatalf("normalize: %v", err)
+	}
+	if w.Mode != "YELLOW" || strings.Join(w.Weekdays, ",") != "mon,fri" {
+		t.Errorf("normalized = %+v", w)
+	}
+
+	for _, bad := range []ScheduleWindow{
+		{Start: "25:00", End: "06:00", Mode: "RED"},
+		{Start: "22:00", End: "6pm", Mode: "RED"},
+		{Start: "22:00", End: "22:00", Mode: "RED"},
+		{Start: "22:00", End: "06:00", Mode: "BLUE"},
+		{Start: "22:00", End: "06:00", Mode: "RED", Weekdays: []string{"someday"}},
+	} {
+		if _, err := bad.normalize(); err == nil {
+			t.Errorf("normalize(%+v) succeeded, want error", bad)
+		}
+	}
+}
+
+func TestApplyScheduleOnlyAtBoundaries(t *testing.T) {
+	client := &fakeClient{}
+	s := newTestServer(t, startFakeController(t))
+	s.controller = client
+	writeConfig(t, s, &Config{Schedule: []ScheduleWindow{{Start: "22:00", End: "06:00", Mode: "YELLOW"}}})
+
+	day := time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local)
+	s.applySchedule(day.Add(21 * time.Hour)) // outside: GREEN
+	s.applySchedule(day.Add(21*time.Hour + 30*time.Minute))
+	s.applySchedule(day.Add(22 * time.Hour)) // boundary: YELLOW
+	s.applySchedule(day.Add(23 * time.Hour))
+	s.applySchedule(day.Add(30 * time.Hour)) // boundary: GREEN
+
+	if cmds := client.commands(); strings.Join(cmds, ",") != "GREEN,YELLOW,GREEN" {
+		t.Errorf("controller commands = %v, want [GREEN YELLOW GREEN]", cmds)
+	}
+}
+
+func TestApplyScheduleDefersToDetectionLock(t *testing.T) {
+	client := &fakeClient{}
+	s := newTestServer(t, startFakeController(t))
+	s.controller = client
+	writeConfig(t, s, &Config{Schedule: []ScheduleWindow{{Start: "22:00", End: "06:00", Mode: "YELLOW"}}})
+
+	now := time.Date(2024, 1, 1, 23, 0, 0, 0, time.Local)
+	s.applySchedule(now)
+	s.unlock.schedule(time.Hour, func() {})
+	s.applySchedule(now.Add(time.Minute))
+	if cmds := client.commands(); len(cmds) != 1 {
+		t.Fatalf("controller commands = %v, want only the first YELLOW while locked", cmds)
+	}
+
+	s.unlock.stop()
+	s.applySchedule(now.Add(2 * time.Minute))
+	if cmds := client.commands(); strings.Join(cmds, ",") != "YELLOW,YELLOW" {
+		t.Errorf("controller commands = %v, want the schedule re-applied after the lock", cmds)
+	}
+}
+
+func TestScheduleHandler(t *testing.T) {
+	client := &fakeClient{}
+	s := newTestServer(t, startFakeController(t))
+	s.controller = client
+
+	body := `{"windows":[{"start":"22:00","end":"06:00","mode":"red","weekdays":["sat","sun"]}]}`
+	rec := httptest.NewRecorder()
+	s.scheduleHandler(rec, httptest.NewRequest(http.MethodPost, "/schedule", strings.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("POST status = %d, body = %s", rec.Code, rec.Body)
+	}
+
+	config, err := s.config.load()
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if len(config.Schedule) != 1 || config.Schedule[0].Mode != "RED" {
+		t.Errorf("saved schedule = %+v", config.Schedule)
+	}
+	if len(client.commands()) != 1 {
+		t.Errorf("controller commands = %v, want the schedule applied once", client.commands())
+	}
+
+	rec = httptest.NewRecorder()
+	s.scheduleHandler(rec, httptest.NewRequest(http.MethodGet, "/schedule", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"start":"22:00"`) {
+		t.Errorf("GET status = %d, body = %s", rec.Code, rec.Body)
+	}
+}
+
+func TestScheduleHandlerRejectsInvalid(t *testing.T) {
+	s := newTestServer(t, startFakeController(t))
+
+	for _, body := range []string{
+		`not json`,
+		`{"windows":[{"start":"22:00","end":"06:00","mode":"purple"}]}`,
+	} {
+		rec := httptest.NewRecorder()
+		s.scheduleHandler(rec, httptest.NewRequest(http.MethodPost, "/schedule", strings.NewReader(body)))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("body %q: status = %d, want 400", body, rec.Code)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	s.scheduleHandler(rec, httptest.NewRequest(http.MethodDelete, "/schedule", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("DELETE status = %d, want 405", rec.Code)
+	}
+}
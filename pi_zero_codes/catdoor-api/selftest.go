@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// selftestModes is the cycle POST /selftest puts the flap through
+var selftestModes = []string{"GREEN", "YELLOW", "RED", "GREEN"}
+
+// selftestPause is the time given to each mode before the next, so the
+// motor can finish moving. Tests shorten it.
+var selftestPause = 500 * time.Millisecond
+
+// selftestStep reports one command of a self-test
+type selftestStep struct {
+	Command   string `json:"command"`
+	Status    string `json:"status"` // pass, fail or skipped
+	Reply     string `json:"reply,omitempty"`
+	LatencyMs int64  `json:"latency_ms"`
+	Error     string `json:"error,omitempty"`
+}
+
+// selftestHandler handles POST /selftest[?force=true]. It reads the mode
+// with STATUS, cycles GREEN, YELLOW, RED and back to GREEN with a pause
+// after each, then puts back the mode it started in. Steps after a failure
+// are skipped, but the original mode is still restored. The run holds the
+// detection and command locks, so nothing else moves the flap meanwhile.
+// While a detection lock is active it refuses with 409 unless forced, as
+// the cycle briefly opens the flap. A failed step makes the response a 502
+// (503 if the controller is busy), still with the full report.
+func (s *server) selftestHandler(w http.ResponseWriter, r *http.Request) {
+	force := false
+	if value := r.URL.Query().Get("force"); value != "" {
+		var err error
+		if force, err = strconv.ParseBool(value); err != nil {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidRequest, fmt.Sprintf("invalid force %q", value))
+			return
+		}
+	}
+
+	s.detectMu.Lock()
+	defer s.detectMu.Unlock()
+
+	var warning string
+	if s.locked() {
+		if !force {
+			writeError(w, r, http.StatusConflict, errCodeConflict, "a detection lock is active; the self-test would open the flap (use force=true to run it anyway)")
+			return
+		}
+		warning = "ran during an active detection lock; the flap was open for part of it"
+		s.log.Warn("self-test forced during an active lock")
+	}
+
+	s.cmdMu.Lock()
+	defer s.cmdMu.Unlock()
+
+	status, failed := s.selftestSend("STATUS", "")
+	steps := []selftestStep{status}
+	original := parseModeReply(status.Reply)
+	for _, mode := range selftestModes {
+		if failed != nil {
+			steps = append(steps, selftestStep{Command: mode, Status: "skipped"})
+			continue
+		}
+		var step selftestStep
+		step, failed = s.selftestSend(mode, "selftest")
+		steps = append(steps, step)
+		if failed == nil {
+			time.Sleep(selftestPause)
+		}
+	}
+
+	// Put back what the test found, unless that is where the cycle ended.
+	restored := "GREEN"
+	if failed != nil {
+		restored = ""
+	}
+	if validMode(original) && (failed != nil || original != "GREEN") {
+		step, err := s.selftestSend(original, "selftest")
+		steps = append(steps, step)
+		if err == nil {
+			restored = original
+		} else {
+			s.log.Error("self-test could not restore the original mode", "mode", original, "error", err)
+			if failed == nil {
+				failed, restored = err, ""
+			}
+		}
+	}
+
+	response := map[string]interface{}{
+		"ok":       failed == nil,
+		"original": original,
+		"restored": restored,
+		"steps":    steps,
+	}
+	if warning != "" {
+		response["warning"] = warning
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if failed != nil {
+		s.log.Error("self-test failed", "error", failed)
+		response["error"] = apiError{Code: controllerErrorCode(failed), Message: failed.Error()}
+		w.WriteHeader(controllerErrorStatus(failed))
+	} else {
+		s.log.Info("self-test passed")
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// selftestSend sends one self-test command and times it. Modes are recorded
+// in the mode history under source; STATUS goes straight to the controller.
+// s.cmdMu must be held.
+func (s *server) selftestSend(cmd, source string) (selftestStep, error) {
+	start := time.Now()
+	var resp string
+	var err error
+	if validMode(cmd) {
+		resp, err = s.setModeLocked(cmd, source)
+	} else {
+		resp, err = s.controller.Send(cmd)
+	}
+	step := selftestStep{
+		Command:   cmd,
+		Status:    "pass",
+		Reply:     strings.TrimSpace(resp),
+		LatencyMs: time.Since(start).Milliseconds(),
+	}
+	if err != nil {
+		step.Status, step.Error = "fail", err.Error()
+	}
+	return step, err
+}
+
+// locked reports whether a detection lock is active
+func (s *server) locked() bool {
+	if s.unlock.pending() {
+		return true
+	}
+	config, err := s.config.load()
+	return err == nil && remainingUntil(config.LockedUntil, s.now()) > 0
+}
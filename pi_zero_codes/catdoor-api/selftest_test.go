@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+type selftestReport struct {
+	OK       bool           `json:"ok"`
+	Original string         `json:"original"`
+	Restored string         `json:"restored"`
+	Steps    []selftestStep `json:"steps"`
+	Warning  string         `json:"warning"`
+	Error    *apiError      `json:"error"`
+}
+
+func runSelftest(t *testing.T, s *server, target string) (*httptest.ResponseRecorder, selftestReport) {
+	t.Helper()
+	pause := selftestPause
+	selftestPause = 0
+	defer func() { selftestPause = pause }()
+
+	rec := httptest.NewRecorder()
+	s.selftestHandler(rec, httptest.NewRequest(http.MethodPost, target, nil))
+	var report selftestReport
+	if rec.Code != http.StatusConflict {
+		if err := json.NewDecoder(rec.Body).Decode(&report); err != nil {
+			t.Fatalf("decode: %v (status %d)", err, rec.Code)
+		}
+	}
+	return rec, report
+}
+
+func TestSelftestPasses(t *testing.T) {
+	client := &fakeClient{}
+	s := newTestServer(t, startFakeController(t))
+	s.controller = client
+
+	rec, report := runSelftest(t, s, "/selftest")
+	if rec.Code != http.StatusOK || !report.OK {
+		t.Fatalf("status = %d, report = %+v", rec.Code, report)
+	}
+	if want := []string{"STATUS", "GREEN", "YELLOW", "RED", "GREEN"}; !slices.Equal(client.cmds, want) {
+		t.Errorf("commands = %v, want %v", client.cmds, want)
+	}
+	if report.Original != "GREEN" || report.Restored != "GREEN" || len(report.Steps) != 5 {
+		t.Errorf("report = %+v", report)
+	}
+	for _, step := range report.Steps {
+		if step.Status != "pass" || step.Reply == "" {
+			t.Errorf("step = %+v", step)
+		}
+	}
+	history := s.modes.recent(10)
+	if len(history) != 4 || history[0].Source != "selftest" {
+		t.Errorf("mode history = %+v", history)
+	}
+}
+
+func TestSelftestRefusedWhileLocked(t *testing.T) {
+	client := &fakeClient{}
+	s := newTestServer(t, startFakeController(t))
+	s.controller = client
+	writeConfig(t, s, &Config{LockedUntil: time.Now().Add(time.Hour).Format(time.RFC3339)})
+
+	rec, _ := runSelftest(t, s, "/selftest")
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body)
+	}
+	if got := decodeError(t, rec); got.Code != errCodeConflict {
+		t.Errorf("error = %+v", got)
+	}
+	if len(client.cmds) != 0 {
+		t.Errorf("commands sent while refused: %v", client.cmds)
+	}
+
+	rec, report := runSelftest(t, s, "/selftest?force=true")
+	if rec.Code != http.StatusOK || !report.OK || report.Warning == "" {
+		t.Errorf("forced: status = %d, report = %+v", rec.Code, report)
+	}
+}
+
+func TestSelftestSkipsAfterFailure(t *testing.T) {
+	client := &fakeClient{fails: 1}
+	s := newTestServer(t, startFakeController(t))
+	s.controller = client
+
+	rec, report := runSelftest(t, s, "/selftest")
+	if rec.Code != http.StatusBadGateway || report.OK || report.Error == nil {
+		t.Fatalf("status = %d, report = %+v", rec.Code, report)
+	}
+	if report.Steps[0].Status != "fail" || !strings.Contains(report.Steps[0].Error, "jammed") {
+		t.Errorf("STATUS step = %+v", report.Steps[0])
+	}
+	for _, step := range report.Steps[1:] {
+		if step.Status != "skipped" {
+			t.Errorf("step after the failure = %+v", step)
+		}
+	}
+	if report.Original != "UNKNOWN" || report.Restored != "" {
+		t.Errorf("report = %+v", report)
+	}
+	if len(client.cmds) != 1 {
+		t.Errorf("commands = %v, want only STATUS", client.cmds)
+	}
+}
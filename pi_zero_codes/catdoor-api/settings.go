@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Settings are the options that can be changed at runtime with PUT /config.
+// Saved settings override the environment on the next start. Durations use
+// time.ParseDuration syntax; an empty field leaves the setting unchanged.
+// DetectionWindows replaces the windows when present; [] removes them.
+type Settings struct {
+	LockDuration     string            `json:"lock_duration,omitempty"`
+	MaxLockDuration  string            `json:"max_lock_duration,omitempty"`
+	DetectMode       string            `json:"detect_mode,omitempty"`
+	DebounceWindow   string            `json:"debounce_window,omitempty"`
+	DetectionWindows []DetectionWindow `json:"detection_windows,omitempty"`
+}
+
+// runtimeSettings is the parsed form of Settings
+type runtimeSettings struct {
+	lockDuration     time.Duration
+	maxLock          time.Duration
+	detectMode       string
+	debounceWindow   time.Duration
+	detectionWindows []DetectionWindow
+}
+
+// apply parses the fields set in in over cur and validates the result
+func (in Settings) apply(cur runtimeSettings) (runtimeSettings, error) {
+	next := cur
+	for _, f := range []struct {
+		name  string
+		value string
+		dst   *time.Duration
+	}{
+		{"lock_duration", in.LockDuration, &next.lockDuration},
+		{"max_lock_duration", in.MaxLockDuration, &next.maxLock},
+		{"debounce_window", in.DebounceWindow, &next.debounceWindow},
+	} {
+		if f.value == "" {
+			continue
+		}
+		d, err := time.ParseDuration(strings.TrimSpace(f.value))
+		if err != nil {
+			return cur, fmt.Errorf("invalid %s %q", f.name, f.value)
+		}
+		*f.dst = d
+	}
+	if in.DetectMode != "" {
+		next.detectMode = strings.ToUpper(strings.TrimSpace(in.DetectMode))
+	}
+
+	switch {
+	case next.lockDuration < minLockDuration:
+		return cur, fmt.Errorf("lock_duration must be at least %s", minLockDuration)
+	case next.maxLock < next.lockDuration:
+		return cur, fmt.Errorf("max_lock_duration (%s) is shorter than lock_duration (%s)", next.maxLock, next.lockDuration)
+	case next.detectMode != "RED" && next.detectMode != "YELLOW":
+		return cur, fmt.Errorf("invalid detect_mode %q (use red|yellow)", in.DetectMode)
+	case next.debounceWindow < 0:
+		return cur, fmt.Errorf("debounce_window must not be negative")
+	}
+	if in.DetectionWindows != nil {
+		next.detectionWindows = in.DetectionWindows
+	}
+	// Re-checked even when unchanged, as max_lock_duration may have shrunk.
+	windows, err := normalizeDetectionWindows(next.detectionWindows, next.maxLock)
+	if err != nil {
+		return cur, err
+	}
+	next.detectionWindows = windows
+	return next, nil
+}
+
+// settings returns the current runtime settings. Callers hold detectMu.
+func (s *server) settings() runtimeSettings {
+	return runtimeSettings{
+		lockDuration:     s.lockDuration,
+		maxLock:          s.maxLock,
+		detectMode:       s.detectMode,
+		debounceWindow:   s.debounceWindow,
+		detectionWindows: s.detectionWindows,
+	}
+}
+
+// setSettings replaces the runtime settings. Callers hold detectMu.
+func (s *server) setSettings(rs runtimeSettings) {
+	s.lockDuration = rs.lockDuration
+	s.maxLock = rs.maxLock
+	s.detectMode = rs.detectMode
+	s.debounceWindow = rs.debounceWindow
+	s.detectionWindows = rs.detectionWindows
+}
+
+// loadSettings applies settings saved by an earlier PUT /config
+func (s *server) loadSettings() error {
+	config, err := s.config.load()
+	if err != nil {
+		return err
+	}
+	if config.Settings == nil {
+		return nil
+	}
+
+	s.detectMu.Lock()
+	defer s.detectMu.Unlock()
+	rs, err := config.Settings.apply(s.settings())
+	if err != nil {
+		return fmt.Errorf("saved settings: %w", err)
+	}
+	s.setSettings(rs)
+	s.log.Info("applied saved settings", "lock_duration", rs.lockDuration,
+		"max_lock_duration", rs.maxLock, "detect_mode", rs.detectMode, "debounce_window", rs.debounceWindow)
+	return nil
+}
+
+// configHandler handles GET /config, returning the effective configuration
+// without secrets, and PUT /config, updating the runtime settings from a
+// JSON Settings body.
+func (s *server) configHandler(w http.ResponseWriter, r *http.Request) {
+	s.detectMu.Lock()
+	defer s.detectMu.Unlock()
+
+	if r.Method == http.MethodPut {
+		var in Settings
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+			writeBodyError(w, r, fmt.Errorf("invalid JSON body: %w", err))
+			return
+		}
+		rs, err := in.apply(s.settings())
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidRequest, err.Error())
+			return
+		}
+
+		saved := &Settings{
+			LockDuration:     rs.lockDuration.String(),
+			MaxLockDuration:  rs.maxLock.String(),
+			DetectMode:       rs.detectMode,
+			DebounceWindow:   rs.debounceWindow.String(),
+			DetectionWindows: rs.detectionWindows,
+		}
+		if _, err := s.config.update(func(config *Config) { config.Settings = saved }); err != nil {
+			writeError(w, r, http.StatusInternalServerError, errCodeInternal, "failed to save settings: "+err.Error())
+			return
+		}
+		s.setSettings(rs)
+		s.log.Info("settings updated", "lock_duration", rs.lockDuration,
+			"max_lock_duration", rs.maxLock, "detect_mode", rs.detectMode, "debounce_window", rs.debounceWindow)
+	}
+
+	rs := s.settings()
+	logFile := ""
+	if s.logFile != nil {
+		logFile = s.logFile.path
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"lock_duration":     rs.lockDuration.String(),
+		"max_lock_duration": rs.maxLock.String(),
+		"detect_mode":       rs.detectMode,
+		"debounce_window":   rs.debounceWindow.String(),
+		"cooldown":          s.cooldown.String(),
+		"detect_threshold":  map[string]interface{}{"count": s.threshold.count, "window": s.threshold.window.String()},
+		"detection_windows": detectionWindowsOrEmpty(rs.detectionWindows),
+		"controller_addr":   s.controllerAddr,
+		"dry_run":           s.dryRun,
+		"listen_addr":       s.listenAddr,
+		"tls":               s.tlsConfig != nil,
+		"config_path":       s.config.path,
+		"history_path":      s.history.path,
+		"reed_log":          s.reedLog,
+		"radar_log":         s.radarLog,
+		"log_file":          logFile,
+		"auth_enabled":      s.apiToken != "",
+		"auth_reads":        s.authReads,
+		"audit_log":         s.auditPath(),
+		"detect_allow":      prefixStrings(s.detectAllow),
+		"cors_origins":      s.corsOrigins,
+		"webhook_enabled":   s.webhook != nil,
+		"unlock_attempts":   s.unlockAttempts,
+		"unlock_fallback":   s.unlockFallback,
+	})
+}
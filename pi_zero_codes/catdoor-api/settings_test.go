@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConfigHandlerGetOmitsToken(t *testing.T) {
+	s := newTestServer(t, startFakeController(t))
+	s.apiToken = "s3cret"
+
+	rec := httptest.NewRecorder()
+	s.configHandler(rec, httptest.NewRequest(http.MethodGet, "/config", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body)
+	}
+	if strings.Contains(rec.Body.String(), "s3cret") {
+		t.Errorf("body leaks the API token: %s", rec.Body)
+	}
+	var body map[string]interface{}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if body["lock_duration"] != "10m0s" || body["detect_mode"] != "RED" || body["auth_enabled"] != true {
+		t.Errorf("config = %v", body)
+	}
+}
+
+func TestConfigHandlerPut(t *testing.T) {
+	s := newTestServer(t, startFakeController(t))
+
+	rec := httptest.NewRecorder()
+	body := `{"lock_duration":"20m","detect_mode":"yellow"}`
+	s.configHandler(rec, httptest.NewRequest(http.MethodPut, "/config", strings.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body)
+	}
+	if s.lockDuration != 20*time.Minute || s.detectMode != "YELLOW" || s.maxLock != time.Hour {
+		t.Errorf("settings = %s, %s, %s", s.lockDuration, s.detectMode, s.maxLock)
+	}
+
+	// A restarted server picks the saved settings up again.
+	restarted := newTestServer(t, startFakeController(t))
+	restarted.config = s.config
+	if err := restarted.loadSettings(); err != nil {
+		t.Fatalf("loadSettings: %v", err)
+	}
+	if restarted.lockDuration != 20*time.Minute || restarted.detectMode != "YELLOW" {
+		t.Errorf("restored settings = %s, %s", restarted.lockDuration, restarted.detectMode)
+	}
+}
+
+func TestConfigHandlerPutInvalid(t *testing.T) {
+	for _, body := range []string{
+		`{"lock_duration":"100ms"}`,
+		`{"lock_duration":"2h"}`,
+		`{"lock_duration":"soon"}`,
+		`{"detect_mode":"green"}`,
+		`{"debounce_window":"-1s"}`,
+		`not json`,
+	} {
+		t.Run(body, func(t *testing.T) {
+			s := newTestServer(t, startFakeController(t))
+
+			rec := httptest.NewRecorder()
+			s.configHandler(rec, httptest.NewRequest(http.MethodPut, "/config", strings.NewReader(body)))
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("status = %d, want 400", rec.Code)
+			}
+			if s.lockDuration != 10*time.Minute || s.detectMode != "RED" {
+				t.Errorf("settings changed to %s, %s", s.lockDuration, s.detectMode)
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const defaultSnoozeDuration = 30 * time.Minute
+const maxSnoozeDuration = 24 * time.Hour
+
+// snoozedUntil returns the saved snooze end, in the configured zone, if it
+// is still in the future
+func (s *server) snoozedUntil(now time.Time) (time.Time, bool) {
+	config, err := s.config.load()
+	if err != nil || config.SnoozedUntil == "" {
+		return time.Time{}, false
+	}
+	until, err := time.Parse(time.RFC3339, config.SnoozedUntil)
+	if err != nil || !until.After(now) {
+		return time.Time{}, false
+	}
+	return until.In(s.loc), true
+}
+
+// snoozeHandler handles /snooze. POST /snooze?duration=30m ignores
+// detections until then, DELETE /snooze ends the snooze early and GET
+// /snooze reports it. The snooze is kept in the config file so it survives a
+// restart.
+func (s *server) snoozeHandler(w http.ResponseWriter, r *http.Request) {
+	now := s.now()
+	switch r.Method {
+	case http.MethodPost:
+		d := defaultSnoozeDuration
+		if value := strings.TrimSpace(r.URL.Query().Get("duration")); value != "" {
+			var err error
+			if d, err = time.ParseDuration(value); err != nil || d <= 0 {
+				writeError(w, r, http.StatusBadRequest, errCodeInvalidRequest, fmt.Sprintf("invalid duration %q", value))
+				return
+			}
+		}
+		if d > maxSnoozeDuration {
+			d = maxSnoozeDuration
+		}
+		until := now.Add(d).Format(time.RFC3339)
+		if _, err := s.config.update(func(config *Config) { config.SnoozedUntil = until }); err != nil {
+			writeError(w, r, http.StatusInternalServerError, errCodeInternal, "failed to save snooze: "+err.Error())
+			return
+		}
+		s.log.Info("detections snoozed", "until", until)
+	case http.MethodDelete:
+		if _, err := s.config.update(func(config *Config) { config.SnoozedUntil = "" }); err != nil {
+			writeError(w, r, http.StatusInternalServerError, errCodeInternal, "failed to clear snooze: "+err.Error())
+			return
+		}
+		s.log.Info("snooze cleared")
+	}
+
+	response := map[string]interface{}{"snoozed": false}
+	if until, ok := s.snoozedUntil(now); ok {
+		response["snoozed"] = true
+		response["snoozed_until"] = until.Format(time.RFC3339)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
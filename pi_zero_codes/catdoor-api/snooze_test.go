@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSnoozeSkipsLocking(t *testing.T) {
+	client := &fakeClient{}
+	s := newTestServer(t, startFakeController(t))
+	s.controller = client
+
+	rec := httptest.NewRecorder()
+	s.snoozeHandler(rec, httptest.NewRequest(http.MethodPost, "/snooze?duration=30m", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("snooze status = %d, body = %s", rec.Code, rec.Body)
+	}
+
+	rec = httptest.NewRecorder()
+	s.detectedHandler(rec, httptest.NewRequest(http.MethodPost, "/detected", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("detected status = %d, body = %s", rec.Code, rec.Body)
+	}
+	var body struct {
+		Snoozed bool `json:"snoozed"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil || !body.Snoozed {
+		t.Errorf("snoozed = %v (%v), want true", body.Snoozed, err)
+	}
+	if cmds := client.commands(); len(cmds) != 0 {
+		t.Errorf("controller commands = %v, want none while snoozed", cmds)
+	}
+	if s.unlock.pending() {
+		t.Error("unlock scheduled while snoozed")
+	}
+	events, err := s.history.recent(0)
+	if err != nil || len(events) != 1 || !events[0].Snoozed {
+		t.Errorf("history = %+v (%v), want one snoozed detection", events, err)
+	}
+
+	rec = httptest.NewRecorder()
+	s.snoozeHandler(rec, httptest.NewRequest(http.MethodDelete, "/snooze", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("delete status = %d", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	s.detectedHandler(rec, httptest.NewRequest(http.MethodPost, "/detected", nil))
+	defer s.unlock.stop()
+	if cmds := client.commands(); len(cmds) != 1 || cmds[0] != "RED" {
+		t.Errorf("controller commands = %v, want RED after the snooze ends", cmds)
+	}
+}
+
+func TestSnoozeExpires(t *testing.T) {
+	s := newTestServer(t, startFakeController(t))
+	writeConfig(t, s, &Config{SnoozedUntil: time.Now().Add(-time.Minute).Format(time.RFC3339)})
+
+	if _, ok := s.snoozedUntil(time.Now()); ok {
+		t.Error("expired snooze still active")
+	}
+}
+
+func TestSnoozeInvalidDuration(t *testing.T) {
+	s := newTestServer(t, startFakeController(t))
+	for _, d := range []string{"soon", "-5m", "0s"} {
+		rec := httptest.NewRecorder()
+		s.snoozeHandler(rec, httptest.NewRequest(http.MethodPost, "/snooze?duration="+d, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("duration=%s: status = %d, want 400", d, rec.Code)
+		}
+	}
+}
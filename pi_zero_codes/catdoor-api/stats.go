@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// statsCacheTTL is how long a computed /stats response is reused
+const statsCacheTTL = 30 * time.Second
+
+// detectionStats summarizes the detection history. Counts only cover what
+// the (rotated) history file still holds.
+type detectionStats struct {
+	Today       int     `json:"today"`
+	ThisWeek    int     `json:"this_week"`
+	ThisMonth   int     `json:"this_month"`
+	Total       int     `json:"total"`
+	ByHour      [24]int `json:"by_hour"`
+	GeneratedAt string  `json:"generated_at"`
+}
+
+// computeStats counts events relative to now in now's location. Weeks start
+// on Monday.
+func computeStats(events []DetectionEvent, now time.Time) detectionStats {
+	loc := now.Location()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	week := today.AddDate(0, 0, -((int(today.Weekday()) + 6) % 7))
+	month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, loc)
+
+	stats := detectionStats{Total: len(events), GeneratedAt: now.Format(time.RFC3339)}
+	for _, ev := range events {
+		t := ev.Timestamp.In(loc)
+		stats.ByHour[t.Hour()]++
+		if !t.Before(today) {
+			stats.Today++
+		}
+		if !t.Before(week) {
+			stats.ThisWeek++
+		}
+		if !t.Before(month) {
+			stats.ThisMonth++
+		}
+	}
+	return stats
+}
+
+// statsCache holds the last computed stats for statsCacheTTL
+type statsCache struct {
+	mu      sync.Mutex
+	expires time.Time
+	stats   detectionStats
+}
+
+// statsHandler handles GET /stats
+func (s *server) statsHandler(w http.ResponseWriter, r *http.Request) {
+	s.statsCache.mu.Lock()
+	defer s.statsCache.mu.Unlock()
+
+	now := s.now()
+	if now.After(s.statsCache.expires) {
+		events, err := s.history.recent(0)
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, errCodeInternal, "failed to read detection history: "+err.Error())
+			return
+		}
+		s.statsCache.stats = computeStats(events, now)
+		s.statsCache.expires = now.Add(statsCacheTTL)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.statsCache.stats)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestComputeStats(t *testing.T) {
+	// Wednesday 2024-05-15 18:00 UTC.
+	now := time.Date(2024, 5, 15, 18, 0, 0, 0, time.UTC)
+	at := func(month time.Month, day, hour int) DetectionEvent {
+		return DetectionEvent{Timestamp: time.Date(2024, month, day, hour, 30, 0, 0, time.UTC)}
+	}
+	events := []DetectionEvent{
+		at(4, 30, 3),  // last month
+		at(5, 2, 3),   // this month
+		at(5, 13, 22), // Monday: this week
+		at(5, 15, 3),  // today
+		at(5, 15, 17), // today
+	}
+
+	stats := computeStats(events, now)
+	if stats.Today != 2 || stats.ThisWeek != 3 || stats.ThisMonth != 4 || stats.Total != 5 {
+		t.Errorf("stats = %+v", stats)
+	}
+	if stats.ByHour[3] != 3 || stats.ByHour[22] != 1 || stats.ByHour[17] != 1 {
+		t.Errorf("by_hour = %v", stats.ByHour)
+	}
+}
+
+func TestStatsHandlerEmptyHistory(t *testing.T) {
+	s := newTestServer(t, startFakeController(t))
+
+	rec := httptest.NewRecorder()
+	s.statsHandler(rec, httptest.NewRequest(http.MethodGet, "/stats", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body)
+	}
+	var stats detectionStats
+	if err := json.NewDecoder(rec.Body).Decode(&stats); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if stats.Total != 0 || stats.Today != 0 || stats.ByHour != [24]int{} {
+		t.Errorf("stats = %+v, want zeros", stats)
+	}
+}
+
+func TestStatsHandlerCaches(t *testing.T) {
+	s := newTestServer(t, startFakeController(t))
+
+	get := func() int {
+		rec := httptest.NewRecorder()
+		s.statsHandler(rec, httptest.NewRequest(http.MethodGet, "/stats", nil))
+		var stats detectionStats
+		json.NewDecoder(rec.Body).Decode(&stats)
+		return stats.Total
+	}
+
+	get()
+	if err := s.history.append(DetectionEvent{Timestamp: time.Now()}); err != nil {
+		t.Fatalf("append: %v", err)
+	}
+	if n := get(); n != 0 {
+		t.Errorf("total = %d within the TTL, want the cached 0", n)
+	}
+
+	s.statsCache.expires = time.Time{}
+	if n := get(); n != 1 {
+		t.Errorf("total = %d after expiry, want 1", n)
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Status is the structured /status response
+type Status struct {
+	Mode             string            `json:"mode"`
+	Maintenance      *Maintenance      `json:"maintenance"` // non-null while detections are disarmed
+	Locked           bool              `json:"locked"`      // a detection lock is active (locked_until is in the future)
+	LockedUntil      string            `json:"locked_until,omitempty"`
+	SecondsRemaining int               `json:"seconds_remaining"`
+	LastDetected     string            `json:"last_detected,omitempty"`
+	DetectionsToday  int64             `json:"detections_today"`         // since local midnight
+	DetectionQueue   int               `json:"detection_queue"`          // detections waiting for the controller to lock
+	CooldownUntil    string            `json:"cooldown_until,omitempty"` // detections don't lock until then
+	UnlockPending    bool              `json:"unlock_pending"`
+	UnlockTimers     int               `json:"unlock_timers"`             // auto-unlock timers armed or running
+	LastReconciled   string            `json:"last_reconciled,omitempty"` // last time the watchdog saw the intended mode
+	Degraded         bool              `json:"degraded"`                  // the last auto-unlock gave up
+	UnlockError      string            `json:"unlock_error,omitempty"`
+	UnlockFailedAt   string            `json:"unlock_failed_at,omitempty"`
+	Escalation       *EscalationStatus `json:"escalation,omitempty"` // last fallback unlock
+	Controller       string            `json:"controller"`           // the raw STATUS reply
+	ControllerStatus ControllerStatus  `json:"controller_status"`    // the same, parsed
+	Firmware         string            `json:"firmware"`             // controller firmware version, "unknown" if it didn't say
+	Breaker          *BreakerStatus    `json:"breaker,omitempty"`    // controller circuit breaker, when enabled
+}
+
+// parseModeReply extracts the mode from a controller STATUS reply such as
+// "MODE RED" or "mode=RED battery=87". It returns "UNKNOWN" when the reply
+// has none.
+func parseModeReply(resp string) string {
+	return parseControllerStatus(resp).Mode
+}
+
+// statusHandler handles /status. It returns JSON combining the controller's
+// mode with the lock state from config; ?format=text returns the raw reply.
+func (s *server) statusHandler(w http.ResponseWriter, r *http.Request) {
+	resp, err := s.controller.Send("STATUS")
+	if err != nil {
+		writeControllerError(w, r, "controller error: ", err)
+		return
+	}
+
+	if r.URL.Query().Get("format") == "text" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprint(w, resp)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.statusFrom(resp))
+}
+
+// statusFrom combines a controller STATUS reply with the lock state
+func (s *server) statusFrom(resp string) Status {
+	config, err := s.config.load()
+	if err != nil {
+		s.log.Warn("failed to load config", "error", err)
+		config = &Config{}
+	}
+
+	remaining := remainingUntil(config.LockedUntil, s.now())
+	var reconciled string
+	if t := s.watchdog.lastReconciled(); !t.IsZero() {
+		reconciled = t.Format(time.RFC3339)
+	}
+	var cooldownUntil string
+	if until, ok := s.cooldownUntil(s.now()); ok {
+		cooldownUntil = until.Format(time.RFC3339)
+	}
+	unlockErr, failedAt := s.unlockFailure.get()
+	var unlockFailedAt string
+	if unlockErr != "" {
+		unlockFailedAt = failedAt.Format(time.RFC3339)
+	}
+	return Status{
+		Mode:             parseModeReply(resp),
+		Maintenance:      s.maintenance(),
+		Locked:           remaining > 0,
+		LockedUntil:      s.inZone(config.LockedUntil),
+		SecondsRemaining: int(math.Ceil(remaining.Seconds())),
+		LastDetected:     s.inZone(config.LastDetected),
+		DetectionsToday:  s.detectionsToday.value(s.now()),
+		DetectionQueue:   len(config.PendingDetections),
+		CooldownUntil:    cooldownUntil,
+		UnlockPending:    s.unlock.pending(),
+		UnlockTimers:     s.unlock.inFlight(),
+		LastReconciled:   reconciled,
+		Degraded:         unlockErr != "",
+		UnlockError:      unlockErr,
+		UnlockFailedAt:   unlockFailedAt,
+		Escalation:       s.escalation.get(),
+		Controller:       strings.TrimSpace(resp),
+		ControllerStatus: parseControllerStatus(resp),
+		Firmware:         s.firmware.get(),
+		Breaker:          s.breakerStatus(),
+	}
+}
+
+// healthzHandler handles /healthz. It checks that the controller answers a
+// STATUS within the health timeout and never changes any state. While the
+// circuit breaker is open it reports unavailable without probing.
+func (s *server) healthzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	breaker := s.breakerStatus()
+	var err error
+	if breaker != nil && breaker.State == breakerOpen {
+		// No point probing what the breaker has given up on.
+		err = fmt.Errorf("%w until %s", errCircuitOpen, breaker.RetryAfter)
+	} else {
+		_, err = probeController(s.controller, s.healthTimeout)
+	}
+	body := map[string]interface{}{"status": "ok"}
+	if breaker != nil {
+		body["breaker"] = breaker
+	}
+	if err != nil {
+		body["status"] = "unavailable"
+		body["error"] = apiError{Code: errCodeUnavailable, Message: err.Error()}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(body)
+}
+
+// pingHandler handles /ping. Unlike /healthz it sends CATDOOR_PING_COMMAND
+// through the normal command path, so it proves the whole route from HTTP
+// to the controller and back, and reports how long that took.
+func (s *server) pingHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	resp, err := s.controller.Send(s.pingCommand)
+	latency := time.Since(start).Milliseconds()
+
+	w.Header().Set("Content-Type", "application/json")
+	if err != nil {
+		w.WriteHeader(controllerErrorStatus(err))
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"controller": "error",
+			"error":      apiError{Code: controllerErrorCode(err), Message: err.Error()},
+			"command":    s.pingCommand,
+			"latency_ms": latency,
+		})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"controller": "ok",
+		"reply":      strings.TrimSpace(resp),
+		"command":    s.pingCommand,
+		"latency_ms": latency,
+	})
+}
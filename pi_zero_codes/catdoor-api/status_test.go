@@ -0,0 +1,221 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseModeReply(t *testing.T) {
+	tests := map[string]string{
+		"MODE RED\n":    "RED",
+		"MODE green":    "GREEN",
+		"  MODE YELLOW": "YELLOW",
+		"OK RED":        "UNKNOWN",
+		"":              "UNKNOWN",
+		"MODE":          "UNKNOWN",
+		"mode=red":      "RED",
+	}
+	for resp, want := range tests {
+		if got := parseModeReply(resp); got != want {
+			t.Errorf("parseModeReply(%q) = %q, want %q", resp, got, want)
+		}
+	}
+}
+
+func TestStatusHandlerJSON(t *testing.T) {
+	s := newTestServer(t, startFakeController(t))
+	lockedUntil := time.Now().Add(time.Minute).Format(time.RFC3339)
+	writeConfig(t, s, &Config{LastDetected: "2025-01-01T00:00:00Z", LockedUntil: lockedUntil})
+	s.unlock.schedule(time.Hour, func() {})
+	defer s.unlock.stop()
+
+	rec := httptest.NewRecorder()
+	s.statusHandler(rec, httptest.NewRequest(http.MethodGet, "/status", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body)
+	}
+
+	var got Status
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if got.SecondsRemaining < 55 || got.SecondsRemaining > 60 {
+		t.Errorf("seconds_remaining = %d, want about 60", got.SecondsRemaining)
+	}
+	got.SecondsRemaining = 0
+	want := Status{
+		Mode:             "GREEN",
+		Locked:           true,
+		LockedUntil:      lockedUntil,
+		LastDetected:     "2025-01-01T00:00:00Z",
+		UnlockPending:    true,
+		UnlockTimers:     1,
+		Controller:       "MODE GREEN",
+		ControllerStatus: ControllerStatus{Mode: "GREEN"},
+		Firmware:         unknownFirmware,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("status = %+v, want %+v", got, want)
+	}
+}
+
+func TestStatusHandlerExpiredLock(t *testing.T) {
+	s := newTestServer(t, startFakeController(t))
+	writeConfig(t, s, &Config{LockedUntil: time.Now().Add(-time.Minute).Format(time.RFC3339)})
+
+	rec := httptest.NewRecorder()
+	s.statusHandler(rec, httptest.NewRequest(http.MethodGet, "/status", nil))
+	var got Status
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if got.Locked || got.SecondsRemaining != 0 {
+		t.Errorf("locked = %v, seconds_remaining = %d; want unlocked with 0", got.Locked, got.SecondsRemaining)
+	}
+}
+
+func TestStatusHandlerText(t *testing.T) {
+	s := newTestServer(t, startFakeController(t))
+
+	rec := httptest.NewRecorder()
+	s.statusHandler(rec, httptest.NewRequest(http.MethodGet, "/status?format=text", nil))
+	if got := rec.Body.String(); got != "MODE GREEN\n" {
+		t.Errorf("body = %q, want the raw controller reply", got)
+	}
+}
+
+func TestHealthzHandler(t *testing.T) {
+	s := newTestServer(t, startFakeController(t))
+
+	rec := httptest.NewRecorder()
+	s.healthzHandler(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("status = %d, body = %s", rec.Code, rec.Body)
+	}
+}
+
+func TestHealthzHandlerControllerDown(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	s := newTestServer(t, startFakeController(t))
+	s.controller = newTCPController(addr, 0, discardLogger())
+
+	rec := httptest.NewRecorder()
+	s.healthzHandler(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want 503", rec.Code)
+	}
+}
+
+func TestPingHandler(t *testing.T) {
+	fc := startFakeController(t)
+	s := newTestServer(t, fc)
+	s.pingCommand = "PING"
+
+	rec := httptest.NewRecorder()
+	s.pingHandler(rec, httptest.NewRequest(http.MethodGet, "/ping", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body)
+	}
+	var body map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if body["controller"] != "ok" || body["command"] != "PING" {
+		t.Errorf("body = %v", body)
+	}
+	if _, ok := body["latency_ms"].(float64); !ok {
+		t.Errorf("latency_ms missing: %v", body)
+	}
+	if got := fc.commands(); len(got) != 1 || got[0] != "PING" {
+		t.Errorf("controller commands = %v, want [PING]", got)
+	}
+}
+
+func TestPingHandlerControllerError(t *testing.T) {
+	fc := startFakeController(t)
+	fc.reply = "ERR UNKNOWN\n"
+	s := newTestServer(t, fc)
+
+	rec := httptest.NewRecorder()
+	s.pingHandler(rec, httptest.NewRequest(http.MethodGet, "/ping", nil))
+	if rec.Code != http.StatusBadGateway {
+		t.Fatalf("status = %d, want 502", rec.Code)
+	}
+	var body struct {
+		Controller string   `json:"controller"`
+		Error      apiError `json:"error"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if body.Controller != "error" || body.Error.Code != errCodeControllerError || !strings.Contains(body.Error.Message, "UNKNOWN") {
+		t.Errorf("body = %+v", body)
+	}
+}
+
+func TestVersionHandler(t *testing.T) {
+	s := newTestServer(t, startFakeController(t))
+
+	rec := httptest.NewRecorder()
+	s.versionHandler(rec, httptest.NewRequest(http.MethodGet, "/version", nil))
+	var body map[string]string
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if body["version"] != "dev" || body["commit"] != "unknown" || body["build_date"] != "unknown" {
+		t.Errorf("body = %v, want the unstamped defaults", body)
+	}
+	if body["firmware"] != unknownFirmware {
+		t.Errorf("firmware = %q before it was read, want %q", body["firmware"], unknownFirmware)
+	}
+}
+
+func TestReadFirmwareVersion(t *testing.T) {
+	tests := map[string]struct {
+		reply string
+		down  bool
+		want  string
+	}{
+		"version":     {reply: "VERSION 1.4.2\n", want: "1.4.2"},
+		"ok":          {reply: "OK 2.0.0-rc1\n", want: "2.0.0-rc1"},
+		"unsupported": {reply: "ERR UNKNOWN\n", want: unknownFirmware},
+		"down":        {down: true, want: unknownFirmware},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			fc := startFakeController(t)
+			fc.reply = tt.reply
+			s := newTestServer(t, fc)
+			s.versionCommand = defaultVersionCommand
+			if tt.down {
+				s.controller = &fakeClient{err: errors.New("cannot connect to controller")}
+			}
+
+			s.readFirmwareVersion()
+			if got := s.firmware.get(); got != tt.want {
+				t.Errorf("firmware = %q, want %q", got, tt.want)
+			}
+			if got := s.statusFrom("MODE GREEN\n").Firmware; got != tt.want {
+				t.Errorf("status firmware = %q, want %q", got, tt.want)
+			}
+			rec := httptest.NewRecorder()
+			s.versionHandler(rec, httptest.NewRequest(http.MethodGet, "/version", nil))
+			var body map[string]string
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body["firmware"] != tt.want {
+				t.Errorf("/version firmware = %q (%v), want %q", body["firmware"], err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+// defaultTelemetryFile is created next to the config file unless
+// CATDOOR_TELEMETRY_PATH says otherwise.
+const defaultTelemetryFile = "catdoor-telemetry.jsonl"
+
+// defaultTelemetryInterval is how often STATUS is sampled into the
+// telemetry history (CATDOOR_TELEMETRY_INTERVAL; 0 turns sampling off)
+const defaultTelemetryInterval = 5 * time.Minute
+
+// defaultTelemetryMaxBytes is the size at which the telemetry file is
+// rotated. One rotated file is kept; at one sample every five minutes that
+// is several months of history.
+const defaultTelemetryMaxBytes = 1 << 20
+
+const (
+	defaultTelemetryLimit = 500
+	maxTelemetryLimit     = 5000
+)
+
+// TelemetrySample is one STATUS reading as recorded in the telemetry file
+type TelemetrySample struct {
+	Timestamp time.Time         `json:"timestamp"`
+	Mode      string            `json:"mode"`
+	Battery   *int              `json:"battery,omitempty"`
+	Reed      string            `json:"reed,omitempty"`
+	Extra     map[string]string `json:"extra,omitempty"`
+}
+
+// telemetryStore appends samples, taken every interval, to a JSONL file,
+// rotating it to path+".1" once it reaches maxBytes.
+type telemetryStore struct {
+	path     string
+	maxBytes int64
+	interval time.Duration // 0 takes no samples
+	mu       sync.Mutex
+}
+
+func newTelemetryStore(path string, interval time.Duration) *telemetryStore {
+	return &telemetryStore{path: path, maxBytes: defaultTelemetryMaxBytes, interval: interval}
+}
+
+// append writes sample as one line, rotating the file first if it is full
+func (t *telemetryStore) append(sample TelemetrySample) error {
+	line, err := json.Marshal(sample)
+	if err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return appendLine(t.path, t.maxBytes, line)
+}
+
+// between returns the samples from from to to, both inclusive and either
+// open when zero, oldest first. Only the newest limit are kept. Missing
+// files are empty and malformed lines are skipped.
+func (t *telemetryStore) between(from, to time.Time, limit int) ([]TelemetrySample, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var samples []TelemetrySample
+	for _, path := range []string{t.path + ".1", t.path} {
+		f, err := os.Open(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			var sample TelemetrySample
+			if json.Unmarshal(scanner.Bytes(), &sample) != nil {
+				continue
+			}
+			if (!from.IsZero() && sample.Timestamp.Before(from)) || (!to.IsZero() && sample.Timestamp.After(to)) {
+				continue
+			}
+			samples = append(samples, sample)
+		}
+		err = scanner.Err()
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(samples) > limit {
+		samples = samples[len(samples)-limit:]
+	}
+	return samples, nil
+}
+
+// runTelemetry samples STATUS into the telemetry history every
+// s.telemetry.interval until ctx is cancelled
+func (s *server) runTelemetry(ctx context.Context) {
+	if s.telemetry.interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(s.telemetry.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		s.sampleTelemetry(s.now())
+	}
+}
+
+// sampleTelemetry records one STATUS reading. A controller that doesn't
+// answer just leaves a gap.
+func (s *server) sampleTelemetry(now time.Time) {
+	resp, err := s.controller.Send("STATUS")
+	if err != nil {
+		s.log.Debug("telemetry: controller didn't answer STATUS", "error", err)
+		return
+	}
+	st := parseControllerStatus(resp)
+	sample := TelemetrySample{
+		Timestamp: now.Truncate(time.Second),
+		Mode:      st.Mode,
+		Battery:   st.Battery,
+		Reed:      st.Reed,
+		Extra:     st.Extra,
+	}
+	if err := s.telemetry.append(sample); err != nil {
+		s.log.Warn("failed to record telemetry", "error", err)
+	}
+}
+
+// parseTimeRange reads the from and to query parameters as RFC 3339
+// timestamps. A missing bound is zero.
+func parseTimeRange(query url.Values) (from, to time.Time, err error) {
+	for _, p := range []struct {
+		name string
+		dst  *time.Time
+	}{
+		{"from", &from},
+		{"to", &to},
+	} {
+		value := query.Get(p.name)
+		if value == "" {
+			continue
+		}
+		if *p.dst, err = time.Parse(time.RFC3339, value); err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid %s %q (use RFC 3339)", p.name, value)
+		}
+	}
+	if !from.IsZero() && !to.IsZero() && to.Before(from) {
+		return time.Time{}, time.Time{}, fmt.Errorf("to is before from")
+	}
+	return from, to, nil
+}
+
+// telemetryHandler handles GET /telemetry?from=&to=&limit=N, returning the
+// recorded STATUS samples in the range oldest first.
+func (s *server) telemetryHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	from, to, err := parseTimeRange(query)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, errCodeInvalidRequest, err.Error())
+		return
+	}
+	limit, err := queryInt(query, "limit", defaultTelemetryLimit, 1, maxTelemetryLimit)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, errCodeInvalidRequest, err.Error())
+		return
+	}
+
+	samples, err := s.telemetry.between(from, to, limit)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, errCodeInternal, "failed to read telemetry: "+err.Error())
+		return
+	}
+	if samples == nil {
+		samples = []TelemetrySample{}
+	}
+	for i := range samples {
+		samples[i].Timestamp = samples[i].Timestamp.In(s.loc)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"interval": s.telemetry.interval.String(),
+		"count":    len(samples),
+		"samples":  samples,
+	})
+}
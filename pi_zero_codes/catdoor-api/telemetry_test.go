@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTelemetrySamplesAndQuery(t *testing.T) {
+	client := &slowLockClient{mode: "GREEN"}
+	s := newTestServer(t, startFakeController(t))
+	s.controller = stubStatus{"mode=RED battery=80 reed=closed temp=21"}
+	base := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		s.sampleTelemetry(base.Add(time.Duration(i) * time.Hour))
+	}
+	// A controller that doesn't answer leaves no sample.
+	s.controller = &fakeClient{fails: 1}
+	s.sampleTelemetry(base.Add(3 * time.Hour))
+	s.controller = client
+	s.sampleTelemetry(base.Add(4 * time.Hour))
+
+	query := func(target string) (int, []TelemetrySample) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		s.telemetryHandler(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		var body struct {
+			Count   int               `json:"count"`
+			Samples []TelemetrySample `json:"samples"`
+		}
+		if rec.Code == http.StatusOK {
+			if err := json.NewDecoder(rec.Body).Decode(&body); err != nil || body.Count != len(body.Samples) {
+				t.Fatalf("%s: body = %+v (%v)", target, body, err)
+			}
+		}
+		return rec.Code, body.Samples
+	}
+
+	code, samples := query("/telemetry")
+	if code != http.StatusOK || len(samples) != 4 {
+		t.Fatalf("all: status = %d, samples = %+v", code, samples)
+	}
+	first := samples[0]
+	if first.Mode != "RED" || first.Battery == nil || *first.Battery != 80 || first.Reed != "closed" || first.Extra["temp"] != "21" {
+		t.Errorf("first sample = %+v", first)
+	}
+	if last := samples[3]; last.Mode != "GREEN" || last.Battery != nil || !last.Timestamp.Equal(base.Add(4*time.Hour)) {
+		t.Errorf("last sample = %+v", last)
+	}
+
+	code, samples = query("/telemetry?from=2026-03-01T13:00:00Z&to=2026-03-01T14:00:00Z")
+	if code != http.StatusOK || len(samples) != 2 || !samples[0].Timestamp.Equal(base.Add(time.Hour)) {
+		t.Errorf("range: status = %d, samples = %+v", code, samples)
+	}
+	code, samples = query("/telemetry?limit=1")
+	if code != http.StatusOK || len(samples) != 1 || samples[0].Mode != "GREEN" {
+		t.Errorf("limit: status = %d, samples = %+v", code, samples)
+	}
+
+	for _, target := range []string{
+		"/telemetry?from=yesterday",
+		"/telemetry?from=2026-03-02T00:00:00Z&to=2026-03-01T00:00:00Z",
+		"/telemetry?limit=0",
+	} {
+		if code, _ := query(target); code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", target, code)
+		}
+	}
+}
+
+func TestTelemetryStoreRotates(t *testing.T) {
+	s := newTestServer(t, startFakeController(t))
+	s.telemetry.maxBytes = 200
+	s.controller = stubStatus{"mode=GREEN battery=50"}
+	base := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 20; i++ {
+		s.sampleTelemetry(base.Add(time.Duration(i) * time.Minute))
+	}
+	samples, err := s.telemetry.between(time.Time{}, time.Time{}, maxTelemetryLimit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(samples) == 0 || len(samples) >= 20 {
+		t.Fatalf("kept %d samples, want fewer than written", len(samples))
+	}
+	if last := samples[len(samples)-1]; !last.Timestamp.Equal(base.Add(19 * time.Minute)) {
+		t.Errorf("newest sample = %+v", last)
+	}
+}
+
+// stubStatus answers every command with reply
+type stubStatus struct{ reply string }
+
+func (c stubStatus) Send(string) (string, error) { return c.reply, nil }
@@ -0,0 +1,52 @@
+package main
+
+import "time"
+
+// defaultThresholdWindow is how far back detections count towards
+// CATDOOR_DETECT_THRESHOLD unless CATDOOR_DETECT_THRESHOLD_WINDOW says
+// otherwise
+const defaultThresholdWindow = 30 * time.Second
+
+// ThresholdStatus is the detection threshold as reported in /detected
+// responses
+type ThresholdStatus struct {
+	Count    int    `json:"count"`    // detections in the window, this one included
+	Required int    `json:"required"` // detections needed to lock
+	Window   string `json:"window"`
+}
+
+// detectionThreshold holds off locking until count detections have arrived
+// within window, so a single radar blip doesn't lock the flap. It is a
+// sliding window: each detection still counts for window after it arrived.
+// This is separate from the debounce window, which ignores repeats of a
+// detection that did lock. It is guarded by detectMu.
+type detectionThreshold struct {
+	count  int // 1 or less locks on every detection
+	window time.Duration
+
+	seen []time.Time // detections still inside the window, oldest first
+}
+
+// enabled reports whether more than one detection is needed to lock
+func (t *detectionThreshold) enabled() bool {
+	return t.count > 1
+}
+
+// add counts a detection at now and returns the status it leaves, and
+// whether the threshold is now met
+func (t *detectionThreshold) add(now time.Time) (*ThresholdStatus, bool) {
+	kept := t.seen[:0]
+	for _, at := range t.seen {
+		if now.Sub(at) < t.window {
+			kept = append(kept, at)
+		}
+	}
+	t.seen = append(kept, now)
+	st := &ThresholdStatus{Count: len(t.seen), Required: t.count, Window: t.window.String()}
+	return st, st.Count >= st.Required
+}
+
+// reset forgets the detections counted so far
+func (t *detectionThreshold) reset() {
+	t.seen = nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDetectionThresholdSlidingWindow(t *testing.T) {
+	th := detectionThreshold{count: 3, window: 30 * time.Second}
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	for _, step := range []struct {
+		after time.Duration
+		count int
+		met   bool
+	}{
+		{0, 1, false},
+		{10 * time.Second, 2, false},
+		{35 * time.Second, 2, false}, // the first has slid out
+		{38 * time.Second, 3, true},
+		{41 * time.Second, 3, true}, // and so has the second
+		{2 * time.Minute, 1, false},
+	} {
+		st, met := th.add(base.Add(step.after))
+		if st.Count != step.count || met != step.met || st.Required != 3 {
+			t.Errorf("after %s: status = %+v, met = %v, want count %d, met %v", step.after, st, met, step.count, step.met)
+		}
+	}
+
+	th.reset()
+	if st, _ := th.add(base.Add(2 * time.Minute)); st.Count != 1 {
+		t.Errorf("after reset: count = %d, want 1", st.Count)
+	}
+}
+
+func TestDetectedWaitsForThreshold(t *testing.T) {
+	fc := startFakeController(t)
+	s := newTestServer(t, fc)
+	s.threshold = detectionThreshold{count: 3, window: time.Minute}
+	defer s.unlock.stop()
+
+	for i, want := range []string{"pending", "pending", "locked"} {
+		rec := httptest.NewRecorder()
+		s.detectedHandler(rec, httptest.NewRequest(http.MethodPost, "/detected", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("detection %d: status = %d, body = %s", i+1, rec.Code, rec.Body)
+		}
+		var body struct {
+			Status    string           `json:"status"`
+			Acted     bool             `json:"acted"`
+			Threshold *ThresholdStatus `json:"threshold"`
+		}
+		if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+			t.Fatalf("detection %d: decode: %v", i+1, err)
+		}
+		if body.Status != want || body.Acted != (want == "locked") {
+			t.Errorf("detection %d: status = %q, acted = %v, want %q", i+1, body.Status, body.Acted, want)
+		}
+		if body.Threshold == nil || body.Threshold.Count != i+1 || body.Threshold.Required != 3 {
+			t.Errorf("detection %d: threshold = %+v, want count %d of 3", i+1, body.Threshold, i+1)
+		}
+		if locked := s.unlock.pending(); locked != (want == "locked") {
+			t.Errorf("detection %d: auto-unlock pending = %v", i+1, locked)
+		}
+	}
+
+	events, err := s.history.recent(10)
+	if err != nil {
+		t.Fatalf("history: %v", err)
+	}
+	below := 0
+	for _, ev := range events {
+		if ev.BelowThreshold {
+			below++
+		}
+	}
+	if len(events) != 3 || below != 2 {
+		t.Errorf("history has %d events, %d below the threshold; want 3 and 2", len(events), below)
+	}
+}
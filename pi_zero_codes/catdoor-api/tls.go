@@ -0,0 +1,44 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"os"
+)
+
+// tlsConfigFromEnv loads CATDOOR_TLS_CERT and CATDOOR_TLS_KEY. It returns nil
+// when neither is set, so the API serves plain HTTP.
+func tlsConfigFromEnv() (*tls.Config, error) {
+	certFile, certSet := os.LookupEnv("CATDOOR_TLS_CERT")
+	keyFile, keySet := os.LookupEnv("CATDOOR_TLS_KEY")
+	if !certSet && !keySet {
+		return nil, nil
+	}
+	if certSet != keySet {
+		return nil, fmt.Errorf("CATDOOR_TLS_CERT and CATDOOR_TLS_KEY must be set together")
+	}
+
+	certFile, err := envOrDefault("CATDOOR_TLS_CERT", "")
+	if err != nil {
+		return nil, err
+	}
+	keyFile, err = envOrDefault("CATDOOR_TLS_KEY", "")
+	if err != nil {
+		return nil, err
+	}
+	if certFile, err = expandHome(certFile); err != nil {
+		return nil, fmt.Errorf("invalid CATDOOR_TLS_CERT: %w", err)
+	}
+	if keyFile, err = expandHome(keyFile); err != nil {
+		return nil, fmt.Errorf("invalid CATDOOR_TLS_KEY: %w", err)
+	}
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load TLS certificate: %w", err)
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestCert writes a self-signed certificate for 127.0.0.1 and returns
+// the cert and key paths.
+func writeTestCert(t *testing.T) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "catdoor test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("marshal key: %v", err)
+	}
+
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		t.Fatalf("write cert: %v", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatalf("write key: %v", err)
+	}
+	return certFile, keyFile
+}
+
+func TestTLSConfigFromEnv(t *testing.T) {
+	certFile, keyFile := writeTestCert(t)
+
+	unsetEnv(t, "CATDOOR_TLS_CERT", "CATDOOR_TLS_KEY")
+	if cfg, err := tlsConfigFromEnv(); cfg != nil || err != nil {
+		t.Errorf("unset: got %v, %v; want plain HTTP", cfg, err)
+	}
+
+	t.Setenv("CATDOOR_TLS_CERT", certFile)
+	if _, err := tlsConfigFromEnv(); err == nil {
+		t.Error("cert without key: expected an error")
+	}
+
+	t.Setenv("CATDOOR_TLS_KEY", certFile)
+	if _, err := tlsConfigFromEnv(); err == nil {
+		t.Error("mismatched key: expected an error")
+	}
+
+	t.Setenv("CATDOOR_TLS_KEY", keyFile)
+	cfg, err := tlsConfigFromEnv()
+	if err != nil || cfg == nil || len(cfg.Certificates) != 1 {
+		t.Fatalf("got %v, %v; want the loaded certificate", cfg, err)
+	}
+}
+
+func TestRunServesTLS(t *testing.T) {
+	certFile, keyFile := writeTestCert(t)
+	t.Setenv("CATDOOR_TLS_CERT", certFile)
+	t.Setenv("CATDOOR_TLS_KEY", keyFile)
+	cfg, err := tlsConfigFromEnv()
+	if err != nil {
+		t.Fatalf("load TLS config: %v", err)
+	}
+
+	s := newTestServer(t, startFakeController(t))
+	s.tlsConfig = cfg
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", s.healthzHandler)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- s.run(ctx, ln, mux) }()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	resp, err := client.Get("https://" + ln.Addr().String() + "/healthz")
+	if err != nil {
+		t.Fatalf("HTTPS request: %v", err)
+	}
+	resp.Body.Close()
+	if resp.TLS == nil || resp.StatusCode != http.StatusOK {
+		t.Errorf("status = %d, tls = %v", resp.StatusCode, resp.TLS != nil)
+	}
+}
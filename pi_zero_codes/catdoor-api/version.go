@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// Build information, set at build time with e.g.
+//
+//	go build -ldflags "-X main.version=1.2.0 -X main.commit=$(git rev-parse --short HEAD) -X main.buildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+var (
+	version   = "dev"
+	commit    = "unknown"
+	buildDate = "unknown"
+)
+
+// defaultVersionCommand asks the controller for its firmware version
+const defaultVersionCommand = "VERSION"
+
+// unknownFirmware is reported until the controller has said which firmware
+// it runs, and from then on if it couldn't
+const unknownFirmware = "unknown"
+
+// firmwareVersion is the controller's firmware version, read at startup
+type firmwareVersion struct {
+	mu      sync.Mutex
+	version string
+}
+
+func (f *firmwareVersion) get() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.version == "" {
+		return unknownFirmware
+	}
+	return f.version
+}
+
+func (f *firmwareVersion) set(version string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.version = version
+}
+
+// parseVersionReply extracts the version from a reply such as
+// "VERSION 1.4.2" or "OK 1.4.2"
+func parseVersionReply(resp string) string {
+	word, rest, _ := strings.Cut(strings.TrimSpace(resp), " ")
+	rest = strings.TrimSpace(rest)
+	if (word != "VERSION" && word != "OK") || rest == "" {
+		return unknownFirmware
+	}
+	return rest
+}
+
+// readFirmwareVersion asks the controller for its firmware version with
+// CATDOOR_VERSION_COMMAND. It never holds up startup: a controller that
+// doesn't answer, or firmware without the command, leaves it unknown.
+func (s *server) readFirmwareVersion() {
+	resp, err := s.controller.Send(s.versionCommand)
+	if err != nil {
+		s.log.Warn("could not read the controller firmware version", "command", s.versionCommand, "error", err)
+		return
+	}
+	firmware := parseVersionReply(resp)
+	s.firmware.set(firmware)
+	s.log.Info("controller firmware", "version", firmware)
+}
+
+// versionHandler handles GET /version
+func (s *server) versionHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"version":    version,
+		"commit":     commit,
+		"build_date": buildDate,
+		"go":         runtime.Version(),
+		"firmware":   s.firmware.get(),
+	})
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// defaultWatchdogInterval is how often the watchdog polls the controller
+const defaultWatchdogInterval = time.Minute
+
+// watchdog records what the last reconciliation found, for /status
+type watchdog struct {
+	interval time.Duration // 0 disables the watchdog
+	correct  bool          // re-send the intended mode on a mismatch
+
+	mu         sync.Mutex
+	reconciled time.Time // last time the controller was seen in, or put back into, the intended mode
+}
+
+// lastReconciled returns when the controller last matched the intended mode,
+// or zero if it hasn't been checked yet
+func (wd *watchdog) lastReconciled() time.Time {
+	wd.mu.Lock()
+	defer wd.mu.Unlock()
+	return wd.reconciled
+}
+
+func (wd *watchdog) markReconciled(now time.Time) {
+	wd.mu.Lock()
+	defer wd.mu.Unlock()
+	wd.reconciled = now
+}
+
+// intendedMode is the mode the API last set, or "" before it has set one
+func (s *server) intendedMode() string {
+	if c, ok := s.controller.(*instrumentedController); ok {
+		return c.lastMode()
+	}
+	return ""
+}
+
+// forgetIntendedMode makes the watchdog stand down until the next mode change
+func (s *server) forgetIntendedMode() {
+	if c, ok := s.controller.(*instrumentedController); ok {
+		c.forgetMode()
+	}
+}
+
+// runWatchdog polls the controller every interval until ctx is done
+func (s *server) runWatchdog(ctx context.Context) {
+	if s.watchdog.interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(s.watchdog.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		s.checkMode(s.now())
+	}
+}
+
+// checkMode compares the controller's reported mode with the intended one,
+// logging a mismatch and, with auto-correct on, sending the intended mode
+// again.
+func (s *server) checkMode(now time.Time) {
+	intended := s.intendedMode()
+	if intended == "" {
+		return
+	}
+	resp, err := s.controller.Send("STATUS")
+	if err != nil {
+		s.log.Warn("watchdog: failed to read controller mode", "error", err)
+		return
+	}
+	actual := parseModeReply(resp)
+	if s.intendedMode() != intended {
+		return // a command went out while we were asking; check again next time
+	}
+	if actual == intended {
+		s.watchdog.markReconciled(now)
+		return
+	}
+
+	s.log.Warn("watchdog: controller mode differs from intended mode",
+		"intended", intended, "actual", actual, "correct", s.watchdog.correct)
+	if !s.watchdog.correct {
+		return
+	}
+	if _, err := s.setMode(intended, "watchdog"); err != nil {
+		s.log.Error("watchdog: failed to restore intended mode", "mode", intended, "error", err)
+		return
+	}
+	s.log.Info("watchdog: restored intended mode", "mode", intended)
+	s.watchdog.markReconciled(now)
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWatchdogCheckMode(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name           string
+		intended       string // sent before the check; the fake always reports GREEN
+		correct        bool
+		wantResent     bool
+		wantReconciled bool
+	}{
+		{"nothing set yet", "", true, false, false},
+		{"matching", "GREEN", true, false, true},
+		{"mismatch, log only", "RED", false, false, false},
+		{"mismatch, corrected", "RED", true, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fc := startFakeController(t)
+			s := newTestServer(t, fc)
+			s.controller = &instrumentedController{next: s.controller, metrics: s.metrics}
+			s.watchdog.correct = tt.correct
+			if tt.intended != "" {
+				if _, err := s.controller.Send(tt.intended); err != nil {
+					t.Fatalf("send: %v", err)
+				}
+			}
+
+			s.checkMode(now)
+
+			var resent int
+			for _, cmd := range fc.commands() {
+				if cmd == tt.intended {
+					resent++
+				}
+			}
+			if got := resent > 1; got != tt.wantResent {
+				t.Errorf("commands = %v, want resent %v", fc.commands(), tt.wantResent)
+			}
+			if got := s.watchdog.lastReconciled().Equal(now); got != tt.wantReconciled {
+				t.Errorf("lastReconciled = %v, want reconciled %v", s.watchdog.lastReconciled(), tt.wantReconciled)
+			}
+		})
+	}
+}
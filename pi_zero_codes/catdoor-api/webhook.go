@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// defaultWebhookTimeout bounds each webhook delivery attempt
+const defaultWebhookTimeout = 5 * time.Second
+
+// defaultWebhookAttempts is how many times a delivery is tried before it is
+// dropped (CATDOOR_WEBHOOK_MAX_ATTEMPTS)
+const defaultWebhookAttempts = 3
+
+// defaultWebhookBackoff is the delay before the first retry; it doubles after
+// each further attempt, up to maxWebhookBackoff.
+const defaultWebhookBackoff = time.Second
+const maxWebhookBackoff = time.Minute
+
+// webhookQueueSize bounds the deliveries waiting to be sent. A burst beyond
+// it is dropped rather than queueing without bound.
+const webhookQueueSize = 32
+
+// webhookNotifier POSTs JSON payloads to CATDOOR_WEBHOOK_URL in the
+// background so a slow receiver never delays the API response. A single
+// sender works through a bounded queue, so a flaky receiver can't pile up
+// goroutines, and retries are spread out with jitter.
+type webhookNotifier struct {
+	log      *slog.Logger
+	url      string
+	client   *http.Client
+	attempts int
+	backoff  time.Duration
+
+	// sleep waits between attempts and jitter picks each wait from its
+	// backoff step; tests replace both to check the schedule.
+	sleep  func(time.Duration)
+	jitter func(time.Duration) time.Duration
+
+	queue chan []byte
+	start sync.Once // starts the sender on the first notify
+}
+
+func newWebhookNotifier(url string, timeout time.Duration, log *slog.Logger) *webhookNotifier {
+	return &webhookNotifier{
+		log:      log,
+		url:      url,
+		client:   &http.Client{Timeout: timeout},
+		attempts: defaultWebhookAttempts,
+		backoff:  defaultWebhookBackoff,
+		sleep:    time.Sleep,
+		jitter:   equalJitter,
+		queue:    make(chan []byte, webhookQueueSize),
+	}
+}
+
+// equalJitter returns a delay between d/2 and d, so retries from several
+// deliveries that failed together don't all land at once
+func equalJitter(d time.Duration) time.Duration {
+	return d/2 + rand.N(d/2+1)
+}
+
+// notify queues payload for delivery and reports whether it was queued. A
+// full queue drops it with a logged error.
+func (n *webhookNotifier) notify(payload interface{}) bool {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		n.log.Error("webhook: failed to encode payload", "error", err)
+		return false
+	}
+	n.start.Do(func() { go n.send() })
+	select {
+	case n.queue <- body:
+		return true
+	default:
+		n.log.Error("webhook queue full, dropping delivery", "url", n.url, "queued", webhookQueueSize)
+		return false
+	}
+}
+
+// send delivers queued payloads one at a time. It runs for the life of the
+// process.
+func (n *webhookNotifier) send() {
+	for body := range n.queue {
+		if err := n.deliver(body); err != nil {
+			n.log.Error("webhook delivery failed, dropping it", "url", n.url, "attempts", n.attempts, "error", err)
+		}
+	}
+}
+
+// deliver POSTs body, retrying connection errors and 5xx responses until
+// it has made n.attempts attempts
+func (n *webhookNotifier) deliver(body []byte) error {
+	for attempt := 1; ; attempt++ {
+		retry, err := n.post(body)
+		if err == nil || !retry || attempt >= n.attempts {
+			return err
+		}
+		wait := n.jitter(n.backoffStep(attempt))
+		n.log.Warn("webhook delivery failed, retrying", "attempt", attempt, "attempts", n.attempts, "backoff", wait, "error", err)
+		n.sleep(wait)
+	}
+}
+
+// backoffStep returns the backoff after the given failed attempt, 1 being
+// the first: n.backoff doubled for each attempt after that, capped at
+// maxWebhookBackoff
+func (n *webhookNotifier) backoffStep(attempt int) time.Duration {
+	step := n.backoff
+	for i := 1; i < attempt && step < maxWebhookBackoff; i++ {
+		step *= 2
+	}
+	return min(step, maxWebhookBackoff)
+}
+
+// post makes one delivery attempt and reports whether a failure is worth
+// retrying.
+func (n *webhookNotifier) post(body []byte) (bool, error) {
+	resp, err := n.client.Post(n.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode >= 300 {
+		return resp.StatusCode >= 500, fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return false, nil
+}
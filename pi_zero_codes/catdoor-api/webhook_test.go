@@ -0,0 +1,341 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWebhookRetriesServerErrors(t *testing.T) {
+	var calls atomic.Int32
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer receiver.Close()
+
+	n := newWebhookNotifier(receiver.URL, time.Second, discardLogger())
+	n.backoff = time.Millisecond
+	if err := n.deliver([]byte(`{}`)); err != nil {
+		t.Fatalf("deliver: %v", err)
+	}
+	if got := calls.Load(); got != 3 {
+		t.Errorf("attempts = %d, want 3", got)
+	}
+}
+
+func TestWebhookDoesNotRetryClientErrors(t *testing.T) {
+	var calls atomic.Int32
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer receiver.Close()
+
+	n := newWebhookNotifier(receiver.URL, time.Second, discardLogger())
+	n.backoff = time.Millisecond
+	if err := n.deliver([]byte(`{}`)); err == nil {
+		t.Fatal("deliver succeeded, want error")
+	}
+	if got := calls.Load(); got != 1 {
+		t.Errorf("attempts = %d, want 1", got)
+	}
+}
+
+func TestWebhookBackoffSchedule(t *testing.T) {
+	var calls atomic.Int32
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer receiver.Close()
+
+	n := newWebhookNotifier(receiver.URL, time.Second, discardLogger())
+	n.attempts = 9
+	var waits []time.Duration
+	n.sleep = func(d time.Duration) { waits = append(waits, d) }
+	// Take the low end of each step so the schedule is exact.
+	n.jitter = func(d time.Duration) time.Duration { return d / 2 }
+
+	if err := n.deliver([]byte(`{}`)); err == nil {
+		t.Fatal("deliver succeeded, want error")
+	}
+	if got := calls.Load(); got != 9 {
+		t.Errorf("attempts = %d, want 9", got)
+	}
+	want := []time.Duration{
+		500 * time.Millisecond, time.Second, 2 * time.Second, 4 * time.Second,
+		8 * time.Second, 16 * time.Second, 30 * time.Second, 30 * time.Second,
+	}
+	if fmt.Sprint(waits) != fmt.Sprint(want) {
+		t.Errorf("waits = %v, want %v", waits, want)
+	}
+}
+
+func TestEqualJitter(t *testing.T) {
+	for i := 0; i < 1000; i++ {
+		if d := equalJitter(time.Second); d < 500*time.Millisecond || d > time.Second {
+			t.Fatalf("equalJitter(1s) = %s, want between 500ms and 1s", d)
+		}
+	}
+	if d := equalJitter(0); d != 0 {
+		t.Errorf("equalJitter(0) = %s", d)
+	}
+}
+
+func TestWebhookQueueBounded(t *testing.T) {
+	release := make(chan struct{})
+	var calls atomic.Int32
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		<-release
+	}))
+	defer receiver.Close()
+	defer close(release)
+
+	n := newWebhookNotifier(receiver.URL, 5*time.Second, discardLogger())
+	if !n.notify(map[string]string{"event": "first"}) {
+		t.Fatal("first notify was dropped")
+	}
+	// Wait until the sender is stuck on the first delivery, then fill the queue.
+	for deadline := time.Now().Add(2 * time.Second); calls.Load() == 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("webhook was not called")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	for i := 0; i < webhookQueueSize; i++ {
+		if !n.notify(map[string]int{"n": i}) {
+			t.Fatalf("notify %d was dropped with room in the queue", i)
+		}
+	}
+	if n.notify(map[string]string{"event": "overflow"}) {
+		t.Error("notify past the queue size was queued")
+	}
+}
+
+func TestDetectedHandlerSendsWebhook(t *testing.T) {
+	s := newTestServer(t, startFakeController(t))
+	s.controller = &fakeClient{}
+	var payloads chan map[string]interface{}
+	s.webhook, payloads = startWebhookReceiver(t)
+
+	rec := httptest.NewRecorder()
+	s.detectedHandler(rec, httptest.NewRequest(http.MethodPost, "/detected", nil))
+	s.unlock.stop()
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body)
+	}
+
+	payload := waitForPayload(t, payloads)
+	if payload["event"] != "prey_detected" || payload["locked_until"] == nil || payload["controller"] != "OK RED" {
+		t.Errorf("payload = %v", payload)
+	}
+}
+
+// startWebhookReceiver returns a notifier whose payloads arrive on the channel
+func startWebhookReceiver(t *testing.T) (*webhookNotifier, chan map[string]interface{}) {
+	t.Helper()
+	payloads := make(chan map[string]interface{}, 4)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&payload)
+		payloads <- payload
+	}))
+	t.Cleanup(receiver.Close)
+	return newWebhookNotifier(receiver.URL, time.Second, discardLogger()), payloads
+}
+
+func waitForPayload(t *testing.T, payloads chan map[string]interface{}) map[string]interface{} {
+	t.Helper()
+	select {
+	case payload := <-payloads:
+		return payload
+	case <-time.After(2 * time.Second):
+		t.Fatal("webhook was not called")
+		return nil
+	}
+}
+
+func TestAutoUnlockRetriesAndNotifies(t *testing.T) {
+	s := newTestServer(t, startFakeController(t))
+	client := &fakeClient{fails: 2}
+	s.controller = client
+	var payloads chan map[string]interface{}
+	s.webhook, payloads = startWebhookReceiver(t)
+	writeConfig(t, s, &Config{LockedUntil: "2024-01-01T12:00:00Z"})
+
+	s.autoUnlock()
+
+	if got := strings.Join(client.commands(), ","); got != "GREEN,GREEN,GREEN" {
+		t.Errorf("commands = %q, want two failures then success", got)
+	}
+	if payload := waitForPayload(t, payloads); payload["event"] != "auto_unlocked" {
+		t.Errorf("payload = %v", payload)
+	}
+	if config, _ := s.config.load(); config.LockedUntil != "" {
+		t.Errorf("locked_until = %q, want it cleared", config.LockedUntil)
+	}
+	if msg, _ := s.unlockFailure.get(); msg != "" {
+		t.Errorf("unlock failure = %q, want none", msg)
+	}
+}
+
+func TestAutoUnlockFailureDegradesStatus(t *testing.T) {
+	s := newTestServer(t, startFakeController(t))
+	client := &fakeClient{fails: defaultUnlockAttempts}
+	s.controller = client
+	var payloads chan map[string]interface{}
+	s.webhook, payloads = startWebhookReceiver(t)
+
+	s.autoUnlock()
+
+	if n := len(client.commands()); n != defaultUnlockAttempts {
+		t.Errorf("sent %d commands, want %d", n, defaultUnlockAttempts)
+	}
+	payload := waitForPayload(t, payloads)
+	if payload["event"] != "auto_unlock_failed" || !strings.Contains(fmt.Sprint(payload["error"]), "jammed") {
+		t.Errorf("payload = %v", payload)
+	}
+
+	status := func() Status {
+		rec := httptest.NewRecorder()
+		s.statusHandler(rec, httptest.NewRequest(http.MethodGet, "/status", nil))
+		var st Status
+		if err := json.Unmarshal(rec.Body.Bytes(), &st); err != nil {
+			t.Fatalf("decode status: %v (%s)", err, rec.Body)
+		}
+		return st
+	}
+	if st := status(); !st.Degraded || st.UnlockError == "" || st.UnlockFailedAt == "" {
+		t.Errorf("status = %+v, want degraded", st)
+	}
+
+	// A manual unlock that works clears the degraded state.
+	rec := httptest.NewRecorder()
+	s.unlockHandler(rec, httptest.NewRequest(http.MethodPost, "/unlock", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unlock status = %d", rec.Code)
+	}
+	if st := status(); st.Degraded {
+		t.Errorf("status = %+v, want degraded cleared", st)
+	}
+}
+
+// waitForEvents waits for n payloads, which may arrive in any order, and
+// returns them by event
+func waitForEvents(t *testing.T, payloads chan map[string]interface{}, n int) map[string]map[string]interface{} {
+	t.Helper()
+	events := map[string]map[string]interface{}{}
+	for i := 0; i < n; i++ {
+		payload := waitForPayload(t, payloads)
+		events[fmt.Sprint(payload["event"])] = payload
+	}
+	return events
+}
+
+func TestAutoUnlockEscalates(t *testing.T) {
+	s := newTestServer(t, startFakeController(t))
+	client := &fakeClient{}
+	s.controller = &instrumentedController{next: client, metrics: s.metrics}
+	s.unlockFallback = []string{"FORCE_OPEN"}
+	var payloads chan map[string]interface{}
+	s.webhook, payloads = startWebhookReceiver(t)
+	if _, err := s.setMode("RED", "detection"); err != nil {
+		t.Fatalf("setMode: %v", err)
+	}
+	writeConfig(t, s, &Config{LockedUntil: "2024-01-01T12:00:00Z"})
+	client.fails = defaultUnlockAttempts
+
+	s.autoUnlock()
+
+	want := strings.Repeat("GREEN,", defaultUnlockAttempts) + "FORCE_OPEN"
+	if got := strings.Join(client.commands()[1:], ","); got != want {
+		t.Errorf("commands = %q, want %q", got, want)
+	}
+	events := waitForEvents(t, payloads, 2)
+	if payload := events["auto_unlock_escalated"]; payload["priority"] != "high" || payload["ok"] != true {
+		t.Errorf("escalation payload = %v, want a successful high-priority escalation", payload)
+	}
+	if payload := events["auto_unlocked"]; payload["mode"] != "FORCE_OPEN" {
+		t.Errorf("unlock payload = %v", payload)
+	}
+	if config, _ := s.config.load(); config.LockedUntil != "" {
+		t.Errorf("locked_until = %q, want it cleared", config.LockedUntil)
+	}
+	if transitions := s.modes.recent(1); len(transitions) != 1 || transitions[0].Source != "escalation" || transitions[0].Mode != "FORCE_OPEN" {
+		t.Errorf("last transition = %+v, want FORCE_OPEN by escalation", transitions)
+	}
+	if st := s.escalation.get(); st == nil || !st.OK {
+		t.Errorf("escalation = %+v, want a successful one", st)
+	}
+	if mode := s.intendedMode(); mode != "" {
+		t.Errorf("intended mode = %q, want the watchdog to stand down", mode)
+	}
+}
+
+func TestAutoUnlockAttempts(t *testing.T) {
+	t.Setenv("CATDOOR_CONFIG_PATH", filepath.Join(t.TempDir(), "config.json"))
+	t.Setenv("CATDOOR_UNLOCK_ATTEMPTS", "2")
+	fromEnv, err := newServerFromEnv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if fromEnv.unlockAttempts != 2 {
+		t.Fatalf("unlockAttempts = %d, want 2", fromEnv.unlockAttempts)
+	}
+
+	s := newTestServer(t, startFakeController(t))
+	client := &fakeClient{fails: 2}
+	s.controller = client
+	s.unlockAttempts = fromEnv.unlockAttempts
+	s.unlockFallback = []string{"FORCE_OPEN"}
+	var payloads chan map[string]interface{}
+	s.webhook, payloads = startWebhookReceiver(t)
+
+	s.autoUnlock()
+
+	// The fallback follows the second failed GREEN, not the fourth.
+	if got := strings.Join(client.commands(), ","); got != "GREEN,GREEN,FORCE_OPEN" {
+		t.Errorf("commands = %q, want GREEN,GREEN,FORCE_OPEN", got)
+	}
+	if payload := waitForEvents(t, payloads, 2)["auto_unlock_escalated"]; payload["ok"] != true {
+		t.Errorf("escalation payload = %v", payload)
+	}
+}
+
+func TestAutoUnlockEscalationFails(t *testing.T) {
+	s := newTestServer(t, startFakeController(t))
+	client := &fakeClient{fails: defaultUnlockAttempts + 1} // every GREEN, then YELLOW
+	s.controller = client
+	s.unlockFallback = []string{"YELLOW", "GREEN"}
+	var payloads chan map[string]interface{}
+	s.webhook, payloads = startWebhookReceiver(t)
+
+	s.autoUnlock()
+
+	events := waitForEvents(t, payloads, 2)
+	if payload := events["auto_unlock_escalated"]; payload["ok"] != false {
+		t.Errorf("escalation payload = %v, want a failed escalation", payload)
+	}
+	if payload := events["auto_unlock_failed"]; payload["escalated"] != true {
+		t.Errorf("failure payload = %v", payload)
+	}
+
+	rec := httptest.NewRecorder()
+	s.statusHandler(rec, httptest.NewRequest(http.MethodGet, "/status", nil))
+	var st Status
+	if err := json.Unmarshal(rec.Body.Bytes(), &st); err != nil {
+		t.Fatalf("decode status: %v (%s)", err, rec.Body)
+	}
+	if !st.Degraded || st.Escalation == nil || st.Escalation.OK || !strings.Contains(st.Escalation.Error, "YELLOW") {
+		t.Errorf("status = %+v, escalation = %+v, want a failed YELLOW escalation", st, st.Escalation)
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// wsWriteWait bounds each write to a /ws client
+	wsWriteWait = 10 * time.Second
+	// wsPongWait is how long a client may stay silent, pongs included
+	wsPongWait = 60 * time.Second
+	// wsPingInterval must be shorter than wsPongWait
+	wsPingInterval = 30 * time.Second
+	// wsMaxMessage caps a client message; commands are tiny
+	wsMaxMessage = 4096
+	// wsSendBuffer is how many messages may queue for a slow client before
+	// further events are dropped for it
+	wsSendBuffer = 32
+)
+
+// wsMessage is one JSON message sent on /ws. Type is status, mode,
+// detection, result or error.
+type wsMessage struct {
+	Type string      `json:"type"`
+	Data interface{} `json:"data,omitempty"`
+}
+
+// wsCommand is a message a /ws client sends, e.g. {"cmd":"green"}
+type wsCommand struct {
+	Cmd string `json:"cmd"`
+}
+
+// wsResult answers a wsCommand
+type wsResult struct {
+	Cmd   string    `json:"cmd"`
+	OK    bool      `json:"ok"`
+	Reply string    `json:"reply,omitempty"`
+	Error *apiError `json:"error,omitempty"`
+}
+
+// eventHub fans mode changes and detections out to the /ws clients
+type eventHub struct {
+	mu   sync.Mutex
+	subs map[chan wsMessage]struct{}
+}
+
+// subscribe returns a channel receiving every published message and the
+// function that stops it
+func (h *eventHub) subscribe() (<-chan wsMessage, func()) {
+	ch := make(chan wsMessage, wsSendBuffer)
+	h.mu.Lock()
+	if h.subs == nil {
+		h.subs = make(map[chan wsMessage]struct{})
+	}
+	h.subs[ch] = struct{}{}
+	h.mu.Unlock()
+	return ch, func() {
+		h.mu.Lock()
+		delete(h.subs, ch)
+		h.mu.Unlock()
+	}
+}
+
+// publish sends msg to every subscriber without blocking; one that is too
+// slow to keep up misses it.
+func (h *eventHub) publish(msg wsMessage) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subs {
+		select {
+		case ch <- msg:
+		default:
+		}
+	}
+}
+
+// subscribers returns how many clients are listening
+func (h *eventHub) subscribers() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.subs)
+}
+
+// wsOriginAllowed accepts clients without an Origin, from the API's own
+// host, or from a CATDOOR_CORS_ORIGINS origin.
+func (s *server) wsOriginAllowed(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || s.corsAllowed(origin) {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+// wsHandler handles GET /ws, upgrading to a websocket that pushes the
+// current status, then every mode change and detection, and accepts
+// {"cmd":"green|yellow|red|status"} messages. Mode commands go through the
+// command lock and the rate limit like POST /mode/*; each is answered with a
+// result message.
+func (s *server) wsHandler(w http.ResponseWriter, r *http.Request) {
+	upgrader := websocket.Upgrader{CheckOrigin: s.wsOriginAllowed}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade has already replied with an HTTP error.
+		return
+	}
+	events, unsubscribe := s.events.subscribe()
+	defer unsubscribe()
+
+	// The reader runs until the connection fails or closes; closing it
+	// below unblocks the reader, which is waited for so nothing leaks.
+	replies := make(chan wsMessage, wsSendBuffer)
+	quit := make(chan struct{})
+	readDone := make(chan struct{})
+	go func() {
+		defer close(readDone)
+		s.wsRead(conn, r, replies, quit)
+	}()
+	defer func() {
+		close(quit)
+		conn.Close()
+		<-readDone
+	}()
+
+	s.log.Info("websocket client connected", "remote", r.RemoteAddr)
+	defer s.log.Info("websocket client disconnected", "remote", r.RemoteAddr)
+
+	ping := time.NewTicker(wsPingInterval)
+	defer ping.Stop()
+
+	send := func(msg wsMessage) bool {
+		conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+		return conn.WriteJSON(msg) == nil
+	}
+	if !send(s.wsStatus()) {
+		return
+	}
+	for {
+		var msg wsMessage
+		select {
+		case <-readDone:
+			return
+		case msg = <-events:
+		case msg = <-replies:
+		case <-ping.C:
+			if conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteWait)) != nil {
+				return
+			}
+			continue
+		}
+		if !send(msg) {
+			return
+		}
+	}
+}
+
+// wsRead handles the client's messages until the connection fails,
+// queueing the answers on replies unless quit is closed.
+func (s *server) wsRead(conn *websocket.Conn, r *http.Request, replies chan<- wsMessage, quit <-chan struct{}) {
+	conn.SetReadLimit(wsMaxMessage)
+	conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		conn.SetReadDeadline(time.Now().Add(wsPongWait))
+
+		var reply wsMessage
+		var cmd wsCommand
+		if err := json.Unmarshal(data, &cmd); err != nil {
+			reply = wsMessage{Type: "error", Data: apiError{Code: errCodeInvalidRequest, Message: "invalid JSON message: " + err.Error()}}
+		} else {
+			reply = s.wsRun(r, cmd)
+		}
+		select {
+		case replies <- reply:
+		case <-quit:
+			return
+		}
+	}
+}
+
+// wsRun carries out one client command of the connection upgraded from r.
+// Every command goes to the audit log, with the status the matching HTTP
+// request would have been answered with.
+func (s *server) wsRun(r *http.Request, cmd wsCommand) wsMessage {
+	start := time.Now()
+	status := http.StatusOK
+	if s.audit != nil {
+		defer func() { s.writeAudit(r, "WS", url.Values{"cmd": {cmd.Cmd}}.Encode(), status, start) }()
+	}
+
+	name := strings.ToUpper(strings.TrimSpace(cmd.Cmd))
+	if name == "STATUS" {
+		msg := s.wsStatus()
+		if msg.Type == "error" {
+			status = http.StatusBadGateway
+		}
+		return msg
+	}
+	result := wsResult{Cmd: name}
+	if !validMode(name) {
+		status = http.StatusBadRequest
+		result.Error = &apiError{Code: errCodeInvalidRequest, Message: fmt.Sprintf("unknown cmd %q (valid: %s, status)", cmd.Cmd, strings.Join(modeNames, ", "))}
+		return wsMessage{Type: "result", Data: result}
+	}
+	// Mode commands spend from the same bucket as POST /mode/*.
+	if s.limiter != nil {
+		if ok, wait := s.limiter.take(time.Now()); !ok {
+			status = http.StatusTooManyRequests
+			retryAfter := int(math.Ceil(wait.Seconds()))
+			s.log.Warn("rate limit exceeded", "path", "/ws", "cmd", name, "retry_after", retryAfter)
+			result.Error = &apiError{Code: errCodeRateLimited, Message: fmt.Sprintf("too many requests, retry after %ds", retryAfter)}
+			return wsMessage{Type: "result", Data: result}
+		}
+	}
+	resp, err := s.manualMode(name)
+	if err != nil {
+		status = controllerErrorStatus(err)
+		result.Error = &apiError{Code: controllerErrorCode(err), Message: "controller error: " + err.Error()}
+		return wsMessage{Type: "result", Data: result}
+	}
+	result.OK, result.Reply = true, strings.TrimSpace(resp)
+	return wsMessage{Type: "result", Data: result}
+}
+
+// wsStatus returns the current status as a message, or an error message
+// when the controller does not answer
+func (s *server) wsStatus() wsMessage {
+	resp, err := s.controller.Send("STATUS")
+	if err != nil {
+		return wsMessage{Type: "error", Data: apiError{Code: controllerErrorCode(err), Message: "controller error: " + err.Error()}}
+	}
+	return wsMessage{Type: "status", Data: s.statusFrom(resp)}
+}
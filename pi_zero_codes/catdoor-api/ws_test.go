@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// startWSServer serves s's routes the way main does
+func startWSServer(t *testing.T, s *server) string {
+	t.Helper()
+	ts := httptest.NewServer(s.newRouter())
+	t.Cleanup(ts.Close)
+	return "ws" + strings.TrimPrefix(ts.URL, "http") + "/ws"
+}
+
+func dialWS(t *testing.T, url string, header http.Header) *websocket.Conn {
+	t.Helper()
+	conn, resp, err := websocket.DefaultDialer.Dial(url, header)
+	if err != nil {
+		t.Fatalf("dial: %v (response %v)", err, resp)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// readWS reads the next message, decoding its data into data when non-nil
+func readWS(t *testing.T, conn *websocket.Conn, data interface{}) string {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var msg struct {
+		Type string          `json:"type"`
+		Data json.RawMessage `json:"data"`
+	}
+	if err := conn.ReadJSON(&msg); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if data != nil {
+		if err := json.Unmarshal(msg.Data, data); err != nil {
+			t.Fatalf("decode %s data %s: %v", msg.Type, msg.Data, err)
+		}
+	}
+	return msg.Type
+}
+
+// readWSTypes reads n messages, which may arrive in any order, keyed by type
+func readWSTypes(t *testing.T, conn *websocket.Conn, n int) map[string]json.RawMessage {
+	t.Helper()
+	got := map[string]json.RawMessage{}
+	for range n {
+		var data json.RawMessage
+		got[readWS(t, conn, &data)] = data
+	}
+	return got
+}
+
+func TestWSPushesStatusAndRunsCommands(t *testing.T) {
+	fc := startFakeController(t)
+	s := newTestServer(t, fc)
+	conn := dialWS(t, startWSServer(t, s), nil)
+
+	var status Status
+	if typ := readWS(t, conn, &status); typ != "status" || status.Controller == "" {
+		t.Fatalf("first message = %s %+v, want status", typ, status)
+	}
+
+	if err := conn.WriteJSON(map[string]string{"cmd": "yellow"}); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	got := readWSTypes(t, conn, 2)
+	var result wsResult
+	if err := json.Unmarshal(got["result"], &result); err != nil || !result.OK || result.Cmd != "YELLOW" {
+		t.Errorf("result = %s", got["result"])
+	}
+	var transition ModeTransition
+	if err := json.Unmarshal(got["mode"], &transition); err != nil || transition.Mode != "YELLOW" || transition.Source != "manual" {
+		t.Errorf("mode = %s", got["mode"])
+	}
+	if cmds := fc.commands(); strings.Join(cmds, ",") != "STATUS,YELLOW" {
+		t.Errorf("controller commands = %v", cmds)
+	}
+
+	// Detections are pushed along with the mode they set.
+	rec := httptest.NewRecorder()
+	s.detectedHandler(rec, httptest.NewRequest(http.MethodPost, "/detected?source=garden", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("detected status = %d, body = %s", rec.Code, rec.Body)
+	}
+	got = readWSTypes(t, conn, 2)
+	var ev DetectionEvent
+	if err := json.Unmarshal(got["detection"], &ev); err != nil || ev.Source != "garden" || ev.Duration != "10m0s" {
+		t.Errorf("detection = %s", got["detection"])
+	}
+	if !strings.Contains(string(got["mode"]), `"mode":"RED"`) {
+		t.Errorf("mode = %s", got["mode"])
+	}
+}
+
+func TestWSRejectsBadCommands(t *testing.T) {
+	fc := startFakeController(t)
+	s := newTestServer(t, fc)
+	conn := dialWS(t, startWSServer(t, s), nil)
+	readWS(t, conn, nil)
+
+	conn.WriteMessage(websocket.TextMessage, []byte("not json"))
+	var apiErr apiError
+	if typ := readWS(t, conn, &apiErr); typ != "error" || apiErr.Code != errCodeInvalidRequest {
+		t.Errorf("invalid JSON answered with %s %+v", typ, apiErr)
+	}
+
+	conn.WriteJSON(map[string]string{"cmd": "open"})
+	var result wsResult
+	if typ := readWS(t, conn, &result); typ != "result" || result.OK || result.Error == nil || result.Error.Code != errCodeInvalidRequest {
+		t.Errorf("unknown cmd answered with %s %+v", typ, result)
+	}
+
+	s.controller = &fakeClient{err: errFakeFailure}
+	conn.WriteJSON(map[string]string{"cmd": "red"})
+	result = wsResult{}
+	if typ := readWS(t, conn, &result); typ != "result" || result.OK || result.Error == nil || result.Error.Code != errCodeControllerError {
+		t.Errorf("failed cmd answered with %s %+v", typ, result)
+	}
+	if cmds := fc.commands(); len(cmds) != 1 {
+		t.Errorf("controller commands = %v, want only the initial STATUS", cmds)
+	}
+}
+
+func TestWSRateLimitsModeCommands(t *testing.T) {
+	fc := startFakeController(t)
+	s := newTestServer(t, fc)
+	s.limiter = newTokenBucket(1)
+	conn := dialWS(t, startWSServer(t, s), nil)
+	readWS(t, conn, nil)
+
+	for i, want := range []bool{true, false} {
+		conn.WriteJSON(map[string]string{"cmd": "green"})
+		var result wsResult
+		for readWS(t, conn, &result) != "result" {
+		}
+		if result.OK != want {
+			t.Errorf("command %d: result = %+v, want ok %v", i+1, result, want)
+		}
+		if !want && (result.Error == nil || result.Error.Code != errCodeRateLimited) {
+			t.Errorf("command %d: error = %+v, want rate_limited", i+1, result.Error)
+		}
+	}
+	if cmds := fc.commands(); strings.Join(cmds, ",") != "STATUS,GREEN" {
+		t.Errorf("controller commands = %v, want the limited command not sent", cmds)
+	}
+}
+
+func TestWSRequiresAuth(t *testing.T) {
+	s := newTestServer(t, startFakeController(t))
+	s.apiToken = "s3cret"
+	url := startWSServer(t, s)
+
+	_, resp, err := websocket.DefaultDialer.Dial(url, nil)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("dial without token: err = %v, response = %v, want 401", err, resp)
+	}
+	conn := dialWS(t, url, http.Header{"Authorization": {"Bearer s3cret"}})
+	if typ := readWS(t, conn, nil); typ != "status" {
+		t.Errorf("first message = %s, want status", typ)
+	}
+}
+
+func TestWSRejectsForeignOrigin(t *testing.T) {
+	s := newTestServer(t, startFakeController(t))
+	url := startWSServer(t, s)
+
+	_, resp, err := websocket.DefaultDialer.Dial(url, http.Header{"Origin": {"https://evil.example"}})
+	if err == nil || resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("dial from a foreign origin: err = %v, response = %v, want 403", err, resp)
+	}
+	s.corsOrigins = []string{"https://evil.example"}
+	dialWS(t, url, http.Header{"Origin": {"https://evil.example"}})
+}
+
+func TestWSDisconnectReleasesSubscription(t *testing.T) {
+	s := newTestServer(t, startFakeController(t))
+	conn := dialWS(t, startWSServer(t, s), nil)
+	readWS(t, conn, nil)
+	if n := s.events.subscribers(); n != 1 {
+		t.Fatalf("subscribers = %d, want 1", n)
+	}
+
+	conn.Close()
+	waitFor(t, func() bool { return s.events.subscribers() == 0 })
+}
+
+func TestEventHubDropsForSlowSubscriber(t *testing.T) {
+	var h eventHub
+	events, unsubscribe := h.subscribe()
+	defer unsubscribe()
+
+	for range wsSendBuffer + 5 {
+		h.publish(wsMessage{Type: "mode"})
+	}
+	if n := len(events); n != wsSendBuffer {
+		t.Errorf("queued = %d, want %d", n, wsSendBuffer)
+	}
+}
@@ -219,10 +219,8 @@ def on_ir_code(code):
 
 
 # ---- TCP control server (localhost:8765) ----
-def handle_client(conn, addr):
+def handle_command(conn, data):
     try:
-        data = conn.recv(1024).decode("utf-8").strip().upper()
-
         if data == "GREEN":
             mode_green()
             conn.sendall(b"OK GREEN\n")
@@ -235,6 +233,8 @@ def handle_client(conn, addr):
         elif data == "STATUS":
             with mode_lock:
                 conn.sendall(f"MODE {current_mode}\n".encode("utf-8"))
+        elif data == "PING":
+            conn.sendall(b"OK PONG\n")
         else:
             conn.sendall(b"ERR UNKNOWN\n")
 
@@ -243,6 +243,19 @@ def handle_client(conn, addr):
             conn.sendall(f"ERR {e}\n".encode("utf-8"))
         except Exception:
             pass
+
+
+def handle_client(conn, addr):
+    # One command per line; the connection stays open until the client
+    # closes it, so the API can keep a single connection for many commands.
+    try:
+        with conn.makefile("rb") as lines:
+            for line in lines:
+                data = line.decode("utf-8", "replace").strip().upper()
+                if data:
+                    handle_command(conn, data)
+    except Exception:
+        pass
     finally:
         conn.close()
 
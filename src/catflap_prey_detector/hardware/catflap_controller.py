@@ -156,7 +156,7 @@ async def handle_prey_detection() -> str:
 
         # Send HTTP request to /detected on the Pi Zero
         async with aiohttp.ClientSession() as session:
-            async with session.get(
+            async with session.post(
                 notification_config.catdoor_api_url,
                 timeout=aiohttp.ClientTimeout(total=5.0)
             ) as response:
@@ -214,17 +214,18 @@ async def handle_no_prey_detection() -> str:
                 timeout=aiohttp.ClientTimeout(total=5.0)
             ) as status_response:
                 if status_response.status == 200:
-                    current_status = await status_response.text()
-                    logger.info(f"No prey - current door status: {current_status}")
+                    current_status = await status_response.json()
+                    current_mode = str(current_status.get("mode", "")).upper()
+                    logger.info(f"No prey - current door mode: {current_mode}")
 
                     # If already RED (locked due to prey), don't unlock
-                    if "RED" in current_status.upper():
+                    if current_mode == "RED":
                         logger.info("Door is RED (locked) - keeping it locked, not unlocking")
                         return "🔒 Door remains LOCKED (prey detected earlier)"
 
             # Not RED, so unlock to GREEN
             logger.info(f"No prey detected - unlocking cat door (GREEN)")
-            async with session.get(
+            async with session.post(
                 f"{notification_config.catdoor_base_url}/mode/green",
                 timeout=aiohttp.ClientTimeout(total=5.0)
             ) as response:
@@ -266,11 +267,12 @@ async def _auto_lock_after_delay():
                 timeout=aiohttp.ClientTimeout(total=5.0)
             ) as status_response:
                 if status_response.status == 200:
-                    current_status = await status_response.text()
-                    logger.info(f"Current door status: {current_status}")
+                    current_status = await status_response.json()
+                    current_mode = str(current_status.get("mode", "")).upper()
+                    logger.info(f"Current door mode: {current_mode}")
 
                     # Check if already RED (locked due to prey detection)
-                    if "RED" in current_status.upper():
+                    if current_mode == "RED":
                         logger.info("Door is already RED (locked) - keeping it RED, not changing to YELLOW")
                         return
 
@@ -304,7 +306,7 @@ async def _auto_lock_after_delay():
                     # If reed is still open after retries, unlock to GREEN, wait, then lock to YELLOW
                     if not reed_is_closed:
                         logger.info("Reed still OPEN - setting to GREEN to let cat through")
-                        async with session.get(
+                        async with session.post(
                             f"{notification_config.catdoor_base_url}/mode/green",
                             timeout=aiohttp.ClientTimeout(total=5.0)
                         ) as green_response:
@@ -316,7 +318,7 @@ async def _auto_lock_after_delay():
 
                     # Set to YELLOW (only out)
                     logger.info("Setting cat door to YELLOW (only out)")
-                    async with session.get(
+                    async with session.post(
                         f"{notification_config.catdoor_base_url}/mode/yellow",
                         timeout=aiohttp.ClientTimeout(total=5.0)
                     ) as mode_response:
@@ -144,7 +144,7 @@ async def cmd_lock(update: Update, context: ContextTypes.DEFAULT_TYPE) -> None:
     try:
         # Send lock request to Pi Zero
         async with httpx.AsyncClient() as client:
-            response_api = await client.get(
+            response_api = await client.post(
                 f"{notification_config.catdoor_base_url}/mode/red",
                 timeout=5.0
             )
@@ -175,7 +175,7 @@ async def cmd_unlock(update: Update, context: ContextTypes.DEFAULT_TYPE) -> None
     try:
         # Send unlock request to Pi Zero
         async with httpx.AsyncClient() as client:
-            response_api = await client.get(
+            response_api = await client.post(
                 f"{notification_config.catdoor_base_url}/mode/green",
                 timeout=5.0
             )